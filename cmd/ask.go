@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	tui "github.com/gregriff/ducky/internal"
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// askCmd represents the ask command.
+var askCmd = &cobra.Command{
+	Use:   "ask [prompt]",
+	Short: "Ask a model a single question and print the answer",
+	Long: `Send a single prompt to a model and stream the answer to stdout.
+
+The prompt is made up of the positional arguments, any files attached with --file (text files are sent
+as code blocks, PNG, JPEG, GIF and WebP files as images, and PDFs as documents), and stdin if it is a pipe.
+
+Example:
+  ducky ask -m haiku -f main.go -f go.mod "why does this fail to build"
+  ducky ask -f screenshot.png "what is wrong with this layout"
+  ducky ask -m sonnet -f datasheet.pdf "what is the max supply voltage"
+  go test ./... 2>&1 | ducky ask "explain these failures"`,
+	PreRunE: func(cmd *cobra.Command, _ []string) error {
+		// bound here rather than in init, as other commands bind their own --model flag
+		_ = viper.BindPFlag("model", cmd.Flags().Lookup("model"))
+		bindOutputFlags(cmd)
+		modelName := viper.GetString("model")
+		if modelName == "" {
+			return fmt.Errorf("model must be specified via flag or config file")
+		}
+		if _, err := getOutputFormat(); err != nil {
+			return err
+		}
+		return validateModelName(modelName)
+	},
+	RunE: runAsk,
+}
+
+func init() {
+	rootCmd.AddCommand(askCmd)
+
+	askCmd.Flags().StringP("model", "m", "", "model to ask (default is the model in the config file)")
+
+	askCmd.Flags().StringArrayP("file", "f", nil, "attach a text file, image or PDF to the prompt (can be repeated)")
+	addOutputFlags(askCmd)
+}
+
+func runAsk(cmd *cobra.Command, args []string) error {
+	files, err := cmd.Flags().GetStringArray("file")
+	if err != nil {
+		return fmt.Errorf("error reading --file flag: %w", err)
+	}
+
+	var stdin string
+	if !term.IsTerminal(int(os.Stdin.Fd())) {
+		input, err := io.ReadAll(os.Stdin)
+		if err != nil {
+			return fmt.Errorf("error reading from stdin: %w", err)
+		}
+		stdin = strings.TrimSpace(string(input))
+	}
+
+	prompt, err := buildAskPrompt(strings.Join(args, " "), files, stdin)
+	if err != nil {
+		return err
+	}
+	if prompt.IsEmpty() {
+		return fmt.Errorf("prompt must be given as arguments, files or stdin")
+	}
+
+	exportAPIKeys()
+	model, err := tui.InitLLMClient(viper.GetString("model"), viper.GetString("system-prompt"), viper.GetInt("max-tokens"))
+	if err != nil {
+		return err //nolint:wrapcheck // names the model
+	}
+	if err := models.CheckParts(model, prompt); err != nil {
+		return err //nolint:wrapcheck // names the model and file
+	}
+	format, _ := getOutputFormat() // validated in PreRunE
+	os.Exit(streamToStdout(model, prompt, viper.GetBool("reasoning"), models.Uint8Ptr(viper.GetUint8("reasoning-effort")), pipeOptions{
+		format:         format,
+		printReasoning: viper.GetBool("print-reasoning"),
+		style:          viper.GetString("style"),
+		retryPolicy:    retryPolicy(),
+	}))
+	return nil
+}
+
+// buildAskPrompt combines the inputs of the ask command into one prompt. Attached content comes first, so that the question
+// is the last thing the model reads. If stdin is the only input, it is sent as-is rather than as a code block.
+func buildAskPrompt(question string, paths []string, stdin string) (models.Message, error) {
+	question = strings.TrimSpace(question)
+	if question == "" && len(paths) == 0 {
+		return models.UserMessage(stdin), nil
+	}
+
+	files := make([]attachments.File, 0, len(paths)+1)
+	for _, path := range paths {
+		file, err := attachments.LoadFile(path)
+		if err != nil {
+			return models.Message{}, err //nolint:wrapcheck // already describes the file
+		}
+		files = append(files, file)
+	}
+	if stdin != "" {
+		files = append(files, attachments.File{Path: "stdin", Text: stdin})
+	}
+	return attachments.Message(question, files), nil
+}
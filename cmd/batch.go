@@ -0,0 +1,113 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	tui "github.com/gregriff/ducky/internal"
+	"github.com/gregriff/ducky/internal/batch"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// batchCmd represents the batch command.
+var batchCmd = &cobra.Command{
+	Use:   "batch <input.jsonl>",
+	Short: "Run many prompts from a JSONL file",
+	Long: `Run every request in a JSONL file concurrently and write the results to another JSONL file.
+
+Each line of the input is a JSON object with a "prompt" and optionally an "id", "system_prompt", "model" and "max_tokens".
+Missing fields default to the config file and flags, and the id to the line number.
+
+Each line of the output contains the request's id, model, response, stop reason, token usage, cost and error (if any).
+Requests that fail before anything is streamed are retried like prompts in the TUI (see --retry-attempts and
+--retry-max-elapsed). Re-running a batch with the same output file only makes
+the requests that have not yet succeeded.`,
+	Args: cobra.ExactArgs(1),
+	PreRunE: func(cmd *cobra.Command, _ []string) error {
+		// bound here rather than in init, as other commands bind their own --model flag
+		_ = viper.BindPFlag("model", cmd.Flags().Lookup("model"))
+		return nil
+	},
+	RunE: runBatch,
+}
+
+func init() {
+	rootCmd.AddCommand(batchCmd)
+
+	batchCmd.Flags().StringP("model", "m", "", "model used by requests that don't specify one (default is the model in the config file)")
+	batchCmd.Flags().String("out", "", "output JSONL file (default is the input file with a .results.jsonl extension)")
+	batchCmd.Flags().IntP("workers", "w", 4, "number of requests to make concurrently")
+	batchCmd.Flags().Bool("no-resume", false, "make every request, even if it already succeeded in the output file")
+}
+
+func runBatch(cmd *cobra.Command, args []string) error {
+	inputPath := args[0]
+	outputPath, _ := cmd.Flags().GetString("out")
+	if outputPath == "" {
+		outputPath = strings.TrimSuffix(inputPath, ".jsonl") + ".results.jsonl"
+	}
+	workers, _ := cmd.Flags().GetInt("workers")
+	noResume, _ := cmd.Flags().GetBool("no-resume")
+
+	input, err := os.Open(inputPath) //nolint:gosec // path is chosen by the user
+	if err != nil {
+		return fmt.Errorf("error opening input file: %w", err)
+	}
+	requests, err := batch.ReadRequests(input)
+	_ = input.Close()
+	if err != nil {
+		return fmt.Errorf("error reading %s: %w", inputPath, err)
+	}
+
+	skip := map[string]bool{}
+	if !noResume {
+		if skip, err = batch.CompletedIDs(outputPath); err != nil {
+			return err //nolint:wrapcheck // already describes the file
+		}
+	}
+
+	output, err := os.OpenFile(outputPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0o600) //nolint:gosec // path is chosen by the user
+	if err != nil {
+		return fmt.Errorf("error opening output file: %w", err)
+	}
+	defer func() { _ = output.Close() }()
+
+	exportAPIKeys()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	summary, err := batch.Run(ctx, batch.Config{
+		Workers:         workers,
+		RetryPolicy:     retryPolicy(),
+		Model:           viper.GetString("model"),
+		SystemPrompt:    viper.GetString("system-prompt"),
+		MaxTokens:       viper.GetInt("max-tokens"),
+		EnableReasoning: viper.GetBool("reasoning"),
+		ReasoningEffort: models.Uint8Ptr(viper.GetUint8("reasoning-effort")),
+		NewLLM: func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+			if err := validateModelName(modelName); err != nil {
+				return nil, err
+			}
+			return tui.InitLLMClient(modelName, systemPrompt, maxTokens) //nolint:wrapcheck // names the model
+		},
+	}, requests, skip, output)
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the failure
+	}
+
+	fmt.Fprintf(os.Stderr, "%d succeeded, %d failed, %d skipped (already succeeded). results: %s\n",
+		summary.Succeeded, summary.Failed, summary.Skipped, outputPath)
+	switch {
+	case ctx.Err() != nil:
+		os.Exit(exitInterrupted)
+	case summary.Failed > 0:
+		os.Exit(exitStreamError)
+	}
+	return nil
+}
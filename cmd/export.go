@@ -0,0 +1,101 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// exportCmd represents the export command.
+var exportCmd = &cobra.Command{
+	Use:   "export [session]",
+	Short: "Export a saved conversation to Markdown, HTML or JSON",
+	Long: `Write a conversation saved by the TUI to stdout or a file.
+
+The session is its ID, a unique prefix of its ID, "last" for the most recent one, or the path of a session file.
+Without a session, the saved sessions are listed. Sessions are saved in $XDG_DATA_HOME/ducky/sessions.
+
+Formats:
+  md    prompts as quotes, reasoning in collapsed <details> blocks (default)
+  html  a self-contained page, with highlighted code
+  json  everything in the session, including token usage and model metadata
+
+Conversations can also be exported from the TUI by sending /export [md|html|json] [file].
+
+Example:
+  ducky export last --out review.html
+  ducky export 20261019 --format json | jq '.entries[].usage'`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: runExport,
+}
+
+func init() {
+	rootCmd.AddCommand(exportCmd)
+
+	exportCmd.Flags().StringP("format", "f", "", "md, html or json (default is the output file's extension, or md)")
+	exportCmd.Flags().String("out", "", "file to write to (default is stdout)")
+}
+
+func runExport(cmd *cobra.Command, args []string) error {
+	store, err := session.DefaultStore()
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	if len(args) == 0 {
+		return listSessions(cmd.OutOrStdout(), store)
+	}
+
+	outPath, _ := cmd.Flags().GetString("out")
+	formatName, _ := cmd.Flags().GetString("format")
+	format := session.FormatMarkdown
+	if formatName != "" {
+		if format, err = session.ParseFormat(formatName); err != nil {
+			return err //nolint:wrapcheck // lists the valid formats
+		}
+	} else if f, ok := session.FormatOf(outPath); ok {
+		format = f
+	}
+
+	s, err := store.Load(args[0])
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the problem
+	}
+
+	if outPath == "" {
+		return session.Export(cmd.OutOrStdout(), s, format) //nolint:wrapcheck // already describes the problem
+	}
+	out, err := os.Create(outPath) //nolint:gosec // path is chosen by the user
+	if err != nil {
+		return fmt.Errorf("error creating output file: %w", err)
+	}
+	if err := session.Export(out, s, format); err != nil {
+		_ = out.Close()
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	return out.Close() //nolint:wrapcheck // unlikely, and includes the path
+}
+
+// listSessions writes a table of the saved sessions, most recent first.
+func listSessions(w io.Writer, store *session.Store) error {
+	sessions, skipped, err := store.List()
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	for _, err := range skipped {
+		fmt.Fprintln(os.Stderr, "skipped:", err)
+	}
+	if len(sessions) == 0 {
+		_, err := fmt.Fprintln(w, "no saved sessions in", store.Dir)
+		return err //nolint:wrapcheck // writing to stdout
+	}
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	_, _ = fmt.Fprintln(tw, "ID\tUPDATED\tMODEL\tPROMPTS\tTITLE")
+	for _, s := range sessions {
+		_, _ = fmt.Fprintf(tw, "%s\t%s\t%s\t%d\t%s\n", s.ID, s.UpdatedAt.Format("2006-01-02 15:04"), s.Model, len(s.Entries), s.Title)
+	}
+	return tw.Flush() //nolint:wrapcheck // writing to stdout
+}
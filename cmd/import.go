@@ -0,0 +1,48 @@
+package cmd
+
+import (
+	"fmt"
+
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/spf13/cobra"
+)
+
+// importCmd represents the import command.
+var importCmd = &cobra.Command{
+	Use:   "import <export>...",
+	Short: "Import conversations from ChatGPT and Claude data exports",
+	Long: `Save the conversations in ChatGPT or Claude data exports as ducky sessions, so that they can be exported or resumed.
+
+Pass the zip file from the export's email, or the conversations.json in it. Prompts, responses, reasoning and the text of
+attached files are imported; images, tool calls and files without extracted text are left out. Importing an export again
+updates the conversations imported from it rather than duplicating them.
+
+Example:
+  ducky import ~/Downloads/data-2026-10-19.zip
+  ducky export             # lists the imported sessions
+  ducky run sonnet --resume=20251019-1400`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runImport,
+}
+
+func init() {
+	rootCmd.AddCommand(importCmd)
+}
+
+func runImport(cmd *cobra.Command, args []string) error {
+	store, err := session.DefaultStore()
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	for _, path := range args {
+		sessions, err := session.Import(path)
+		if err != nil {
+			return fmt.Errorf("%s: %w", path, err)
+		}
+		if err := store.SaveAll(sessions); err != nil {
+			return err //nolint:wrapcheck // already describes the problem
+		}
+		_, _ = fmt.Fprintf(cmd.OutOrStdout(), "imported %d conversations from %s\n", len(sessions), path)
+	}
+	return nil
+}
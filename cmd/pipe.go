@@ -0,0 +1,477 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/gregriff/ducky/internal/chat"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+	"golang.org/x/term"
+)
+
+// outputFormat determines how a response is written to stdout when ducky is not run interactively.
+type outputFormat string
+
+const (
+	outputRaw      outputFormat = "raw"      // response text exactly as it is streamed
+	outputMarkdown outputFormat = "markdown" // a Markdown document of the response, its reasoning and any error
+	outputRendered outputFormat = "rendered" // response rendered for the terminal with glamour, one block at a time
+	outputJSON     outputFormat = "json"     // a single JSON object, written once the response completes
+	outputNDJSON   outputFormat = "ndjson"   // one JSON event per line, written as the response is streamed
+)
+
+// exit codes of non-interactive mode.
+const (
+	exitOK          = 0
+	exitStreamError = 1
+	exitInterrupted = 130 // 128 + SIGINT, as shells report it
+)
+
+func parseOutputFormat(name string) (outputFormat, error) {
+	switch format := outputFormat(name); format {
+	case outputRaw, outputMarkdown, outputRendered, outputJSON, outputNDJSON:
+		return format, nil
+	}
+	return "", fmt.Errorf("invalid output format: %s (valid formats: raw, markdown, rendered, json, ndjson)", name)
+}
+
+// addOutputFlags adds the flags that control non-interactive output to a command that can write a response to stdout.
+func addOutputFlags(cmd *cobra.Command) {
+	cmd.Flags().StringP("output", "o", "", "format of non-interactive responses: raw, markdown, rendered, json or ndjson (default raw)")
+	viper.SetDefault("output", string(outputRaw))
+	cmd.Flags().Bool("json", false, "shorthand for --output=ndjson: stream JSON events (reasoning, text, error, done) for scripting")
+	cmd.Flags().Bool("print-reasoning", false, "also write reasoning/thinking text: to stderr, or before the response with --output=markdown")
+}
+
+// bindOutputFlags binds the flags added by addOutputFlags to viper. It is called in PreRunE rather than in init, as more than one
+// command has them.
+func bindOutputFlags(cmd *cobra.Command) {
+	for _, name := range []string{"output", "json", "print-reasoning"} {
+		_ = viper.BindPFlag(name, cmd.Flags().Lookup(name))
+	}
+}
+
+// getOutputFormat returns the output format chosen with the --output or --json flags.
+func getOutputFormat() (outputFormat, error) {
+	if viper.GetBool("json") {
+		return outputNDJSON, nil
+	}
+	return parseOutputFormat(viper.GetString("output"))
+}
+
+// pipeOptions configures how a response is written in non-interactive mode.
+type pipeOptions struct {
+	format         outputFormat
+	printReasoning bool   // write reasoning text to stderr
+	style          string // glamour style used by outputRendered
+	retryPolicy    models.RetryPolicy
+}
+
+// responseWriter writes a response stream to stdout/stderr in a specific outputFormat.
+type responseWriter interface {
+	writeChunk(chunk models.StreamChunk)
+	retrying(status models.RetryStatus)
+	finish(llm models.LLM, err error)
+}
+
+// streamToStdout prompts the model and writes its response as it is streamed. It returns the exit code of the process, which is
+// non-zero if the stream fails or is interrupted with SIGINT.
+func streamToStdout(llm models.LLM, prompt models.Message, reasoning bool, effort *uint8, opts pipeOptions) int {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	writer := newResponseWriter(opts)
+	responseChan := make(chan models.StreamChunk)
+	retryChan := make(chan models.RetryStatus, 1)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- models.StreamPromptCompletionWithRetry(ctx, llm, prompt, reasoning, effort, responseChan, opts.retryPolicy,
+			func(status models.RetryStatus) { retryChan <- status })
+	}()
+
+	for streaming := true; streaming; {
+		select {
+		case chunk, ok := <-responseChan:
+			if !ok {
+				streaming = false
+				break
+			}
+			writer.writeChunk(chunk)
+		case status := <-retryChan:
+			writer.retrying(status)
+		}
+	}
+	err := <-errChan
+
+	if ctx.Err() != nil {
+		writer.finish(llm, errors.New("stream cancelled"))
+		return exitInterrupted
+	}
+	writer.finish(llm, err)
+	if err != nil {
+		return exitStreamError
+	}
+	return exitOK
+}
+
+func newResponseWriter(opts pipeOptions) responseWriter {
+	switch opts.format {
+	case outputMarkdown:
+		return &markdownWriter{opts: opts}
+	case outputRendered:
+		width := 80
+		if w, _, err := term.GetSize(int(os.Stdout.Fd())); err == nil && w > 0 {
+			width = w
+		}
+		return &renderedWriter{opts: opts, renderer: chat.NewMarkdownRenderer(opts.style), width: width}
+	case outputJSON:
+		return &jsonWriter{}
+	case outputNDJSON:
+		return &ndjsonWriter{}
+	case outputRaw:
+	}
+	return &rawWriter{opts: opts}
+}
+
+// writeReasoning writes reasoning text to stderr if the user asked for it.
+func writeReasoning(opts pipeOptions, chunk models.StreamChunk) {
+	if opts.printReasoning {
+		fmt.Fprint(os.Stderr, chunk.Content)
+	}
+}
+
+// writeRetry tells the user on stderr that a failed request will be retried.
+func writeRetry(status models.RetryStatus) {
+	fmt.Fprintf(os.Stderr, "%v\nretrying in %s (attempt %d/%d)\n",
+		status.Err, time.Until(status.At).Round(100*time.Millisecond), status.Attempt, status.MaxAttempts)
+}
+
+// endLine writes a newline if the last text written did not end with one, so the shell prompt begins on a new line.
+func endLine(w io.Writer, lastText string) {
+	if lastText != "" && !strings.HasSuffix(lastText, "\n") {
+		fmt.Fprintln(w)
+	}
+}
+
+type rawWriter struct {
+	opts                    pipeOptions
+	lastText, lastReasoning string
+}
+
+func (w *rawWriter) writeChunk(chunk models.StreamChunk) {
+	if chunk.Reasoning {
+		writeReasoning(w.opts, chunk)
+		w.lastReasoning = chunk.Content
+		return
+	}
+	if w.lastText == "" && w.opts.printReasoning {
+		endLine(os.Stderr, w.lastReasoning)
+	}
+	fmt.Print(chunk.Content)
+	w.lastText = chunk.Content
+}
+
+func (w *rawWriter) retrying(status models.RetryStatus) { writeRetry(status) }
+
+func (w *rawWriter) finish(_ models.LLM, err error) {
+	if w.lastText == "" && w.opts.printReasoning {
+		endLine(os.Stderr, w.lastReasoning)
+	}
+	endLine(os.Stdout, w.lastText)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
+
+// markdownWriter writes a Markdown document to stdout: the reasoning as a blockquote if the user asked for it, then the response
+// with any code fence it left open closed, then errors and stop notes formatted the same way the TUI does.
+type markdownWriter struct {
+	opts                pipeOptions
+	lastText            string
+	inReasoning, inLine bool
+	fences              fenceTracker
+}
+
+func (w *markdownWriter) writeChunk(chunk models.StreamChunk) {
+	if chunk.Reasoning {
+		if !w.opts.printReasoning {
+			return
+		}
+		// prefix each line of reasoning with "> "
+		for i, line := range strings.Split(chunk.Content, "\n") {
+			if i > 0 {
+				fmt.Println()
+				w.inLine = false
+			}
+			if !w.inLine && line != "" {
+				fmt.Print("> ")
+				w.inLine = true
+			}
+			fmt.Print(line)
+		}
+		w.inReasoning = true
+		return
+	}
+	w.endReasoning()
+	fmt.Print(chunk.Content)
+	w.fences.write(chunk.Content)
+	w.lastText = chunk.Content
+}
+
+// endReasoning separates the reasoning blockquote from what follows it.
+func (w *markdownWriter) endReasoning() {
+	if w.inReasoning {
+		fmt.Print("\n\n")
+		w.inReasoning = false
+	}
+}
+
+func (w *markdownWriter) retrying(status models.RetryStatus) { writeRetry(status) }
+
+func (w *markdownWriter) finish(llm models.LLM, err error) {
+	w.endReasoning()
+	endLine(os.Stdout, w.lastText)
+	if fence := w.fences.open(); fence != "" {
+		fmt.Println(fence)
+	}
+	note := chat.StopNote(llm.DoGetLastResponseInfo().StopReason)
+	if err != nil {
+		note = chat.FormatError(err)
+	}
+	if note != "" {
+		if w.lastText != "" {
+			fmt.Println()
+		}
+		fmt.Println(note)
+	}
+}
+
+// fenceTracker follows the code fences of streamed Markdown, so that a fence left open by a response that was cut off can be
+// closed.
+type fenceTracker struct {
+	line  strings.Builder // the incomplete last line
+	fence string          // marker of the open fence, e.g. ``` or ~~~~, or empty outside of one
+}
+
+func (f *fenceTracker) write(text string) {
+	for {
+		newline := strings.IndexByte(text, '\n')
+		if newline == -1 {
+			f.line.WriteString(text)
+			return
+		}
+		f.line.WriteString(text[:newline])
+		f.endLine()
+		text = text[newline+1:]
+	}
+}
+
+func (f *fenceTracker) endLine() {
+	line := strings.TrimSpace(f.line.String())
+	f.line.Reset()
+	if line == "" || (line[0] != '`' && line[0] != '~') {
+		return
+	}
+	marker := line[:len(line)-len(strings.TrimLeft(line, line[:1]))]
+	switch {
+	case f.fence == "" && len(marker) >= 3:
+		f.fence = marker
+	case f.fence != "" && marker[0] == f.fence[0] && len(marker) >= len(f.fence) && marker == line:
+		f.fence = ""
+	}
+}
+
+// open returns the marker of the fence left open at the end of the text, or an empty string if there is none.
+func (f *fenceTracker) open() string {
+	if f.line.Len() > 0 {
+		f.endLine()
+	}
+	return f.fence
+}
+
+// renderedWriter renders the response with glamour. Markdown cannot be rendered until a block (paragraph, list, code block etc.)
+// is complete, so the response is written one block at a time.
+type renderedWriter struct {
+	opts          pipeOptions
+	renderer      *chat.MarkdownRenderer
+	width         int
+	pending       strings.Builder
+	lastReasoning string
+}
+
+func (w *renderedWriter) writeChunk(chunk models.StreamChunk) {
+	if chunk.Reasoning {
+		writeReasoning(w.opts, chunk)
+		w.lastReasoning = chunk.Content
+		return
+	}
+	if w.opts.printReasoning && w.lastReasoning != "" {
+		endLine(os.Stderr, w.lastReasoning)
+		w.lastReasoning = ""
+	}
+	w.pending.WriteString(chunk.Content)
+	text := w.pending.String()
+	if end := completeBlocksLen(text); end > 0 {
+		w.render(text[:end])
+		w.pending.Reset()
+		w.pending.WriteString(text[end:])
+	}
+}
+
+func (w *renderedWriter) retrying(status models.RetryStatus) { writeRetry(status) }
+
+func (w *renderedWriter) finish(llm models.LLM, err error) {
+	if w.opts.printReasoning {
+		endLine(os.Stderr, w.lastReasoning)
+	}
+	if err != nil {
+		w.pending.WriteString("\n\n" + chat.FormatError(err))
+	} else if note := chat.StopNote(llm.DoGetLastResponseInfo().StopReason); note != "" {
+		w.pending.WriteString("\n\n" + note)
+	}
+	if text := strings.TrimSpace(w.pending.String()); text != "" {
+		w.render(text)
+	}
+}
+
+func (w *renderedWriter) render(markdown string) {
+	_, _ = os.Stdout.Write(w.renderer.Render([]byte(markdown), w.width))
+}
+
+// completeBlocksLen returns the length of the prefix of markdown made up of complete blocks, which are separated by blank lines
+// outside of code fences.
+func completeBlocksLen(markdown string) int {
+	var (
+		end, pos int
+		inFence  bool
+	)
+	for {
+		newline := strings.IndexByte(markdown[pos:], '\n')
+		if newline == -1 {
+			return end
+		}
+		line := strings.TrimSpace(markdown[pos : pos+newline])
+		pos += newline + 1
+
+		switch {
+		case strings.HasPrefix(line, "```"), strings.HasPrefix(line, "~~~"):
+			inFence = !inFence
+		case line == "" && !inFence:
+			end = pos
+		}
+	}
+}
+
+// responseInfo is the metadata of a response included in JSON output.
+type responseInfo struct {
+	Model      string            `json:"model"`
+	StopReason models.StopReason `json:"stop_reason,omitempty"`
+	Usage      models.Usage      `json:"usage"`
+	Cost       float64           `json:"cost"` // in dollars
+}
+
+func newResponseInfo(llm models.LLM) *responseInfo {
+	info := models.GetLastResponseInfo(llm)
+	return &responseInfo{
+		Model:      models.GetModelId(llm),
+		StopReason: info.StopReason,
+		Usage:      info.Usage,
+		Cost:       info.Cost,
+	}
+}
+
+// jsonResponse is written by outputJSON once the response completes.
+type jsonResponse struct {
+	*responseInfo
+	Response  string `json:"response"`
+	Reasoning string `json:"reasoning,omitempty"`
+	Error     string `json:"error,omitempty"`
+	ErrorKind string `json:"error_kind,omitempty"` // see models.ErrorKind
+}
+
+type jsonWriter struct {
+	response, reasoning strings.Builder
+}
+
+func (w *jsonWriter) writeChunk(chunk models.StreamChunk) {
+	if chunk.Reasoning {
+		w.reasoning.WriteString(chunk.Content)
+	} else {
+		w.response.WriteString(chunk.Content)
+	}
+}
+
+func (w *jsonWriter) retrying(models.RetryStatus) {}
+
+func (w *jsonWriter) finish(llm models.LLM, err error) {
+	res := jsonResponse{
+		responseInfo: newResponseInfo(llm),
+		Response:     w.response.String(),
+		Reasoning:    w.reasoning.String(),
+	}
+	if err != nil {
+		res.Error = err.Error()
+		res.ErrorKind = models.KindOf(err).String()
+	}
+	writeJSONLine(res)
+}
+
+// ndjsonEvent is one line written by outputNDJSON. Reasoning and text events map directly to a models.StreamChunk. The last
+// event is always a done event, which contains the metadata of the response.
+type ndjsonEvent struct {
+	Type    string `json:"type"` // reasoning, text, retry, error or done
+	Content string `json:"content,omitempty"`
+	Error   string `json:"error,omitempty"`
+	Kind    string `json:"kind,omitempty"` // of the error, see models.ErrorKind
+	*retryInfo
+	*responseInfo
+}
+
+// retryInfo describes a retry event, which is written before a failed request is retried.
+type retryInfo struct {
+	Attempt     int     `json:"attempt"`
+	MaxAttempts int     `json:"max_attempts"`
+	Delay       float64 `json:"delay"` // in seconds
+}
+
+type ndjsonWriter struct{}
+
+func (w *ndjsonWriter) writeChunk(chunk models.StreamChunk) {
+	event := ndjsonEvent{Type: "text", Content: chunk.Content}
+	if chunk.Reasoning {
+		event.Type = "reasoning"
+	}
+	writeJSONLine(event)
+}
+
+func (w *ndjsonWriter) retrying(status models.RetryStatus) {
+	writeJSONLine(ndjsonEvent{Type: "retry", Error: status.Err.Error(), Kind: models.KindOf(status.Err).String(), retryInfo: &retryInfo{
+		Attempt:     status.Attempt,
+		MaxAttempts: status.MaxAttempts,
+		Delay:       time.Until(status.At).Seconds(),
+	}})
+}
+
+func (w *ndjsonWriter) finish(llm models.LLM, err error) {
+	if err != nil {
+		writeJSONLine(ndjsonEvent{Type: "error", Error: err.Error(), Kind: models.KindOf(err).String()})
+	}
+	writeJSONLine(ndjsonEvent{Type: "done", responseInfo: newResponseInfo(llm)})
+}
+
+// writeJSONLine writes v to stdout as a single line of JSON.
+func writeJSONLine(v any) {
+	if err := json.NewEncoder(os.Stdout).Encode(v); err != nil {
+		fmt.Fprintln(os.Stderr, err.Error())
+	}
+}
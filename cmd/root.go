@@ -23,6 +23,15 @@ Keybinds:
 - Quit : ctrl+d
 - Clear History/Quit : ctrl+c
 - Toggle Focus : esc
+- Find in Chat : ctrl+s, or / while unfocused
+- Continue Cut-off Response : ctrl+g
+- Edit/Remove Last Queued Prompt : ctrl+o/ctrl+x
+- Previous/Next Prompt : alt+up/alt+down
+- Start of Response : alt+r
+- Top/Bottom of Chat : alt+g/alt+G
+- Toggle Sidebar : alt+s
+- New/Close Chat : alt+n/alt+w
+- Switch Chat : alt+1-9
 - Text Input Controls : ctrl+a,u,k,e,n,p,b,f,h,m,t,w,d
 `,
 	// Uncomment the following line if your bare application
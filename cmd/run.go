@@ -1,7 +1,6 @@
 package cmd
 
 import (
-	"context"
 	"fmt"
 	"io"
 	"os"
@@ -10,7 +9,9 @@ import (
 	tui "github.com/gregriff/ducky/internal"
 	"github.com/gregriff/ducky/internal/models"
 	"github.com/gregriff/ducky/internal/models/anthropic"
+	"github.com/gregriff/ducky/internal/models/mock"
 	"github.com/gregriff/ducky/internal/models/openai"
+	"github.com/gregriff/ducky/internal/session"
 	"github.com/spf13/cobra"
 	"github.com/spf13/viper"
 	"golang.org/x/term"
@@ -23,9 +24,16 @@ import (
 var runCmd = &cobra.Command{
 	Use:   "run [model]",
 	Short: "Create a new prompt session with a model",
-	Long:  `Begin a prompt session with a specified model.`,
-	Args:  cobra.MaximumNArgs(1),
-	PreRunE: func(_ *cobra.Command, args []string) error {
+	Long: `Begin a prompt session with a specified model.
+
+Conversations are saved as you go. --resume continues the last one, and --resume=<session> a saved or imported one
+by the ID or ID prefix listed by ducky export, with the model given here.
+
+Open more chats with alt+n and switch between them in the sidebar (alt+s, or --sidebar). Chats that aren't shown keep
+streaming in the background.`,
+	Args: cobra.MaximumNArgs(1),
+	PreRunE: func(cmd *cobra.Command, args []string) error {
+		bindOutputFlags(cmd)
 		if len(args) > 0 {
 			viper.Set("model", args[0])
 		}
@@ -33,25 +41,18 @@ var runCmd = &cobra.Command{
 		if modelName == "" {
 			return fmt.Errorf("model must be specified via argument, flag, or config file")
 		}
-		anthropicErr := anthropic.ValidateModelName(modelName)
-		openAIErr := openai.ValidateModelName(modelName)
-		if anthropicErr == nil || openAIErr == nil {
-			return nil
+		if _, err := models.ParseContextStrategy(viper.GetString("context-strategy")); err != nil {
+			return err
 		}
-
-		// Neither model is valid, handle errors
-		switch {
-		case anthropicErr != nil && openAIErr != nil:
-			// Model is neither openai nor anthropic, combine error messages
-			return fmt.Errorf("invalid model name: %s\n%v\n%v", modelName, anthropicErr, openAIErr)
-		case anthropicErr != nil:
-			return fmt.Errorf("invalid model name: %s\n%v", modelName, anthropicErr)
-		case openAIErr != nil:
-			return fmt.Errorf("invalid model name: %s\n%v", modelName, openAIErr)
-		default:
-			// This shouldn't happen if validation functions are implemented correctly
-			return fmt.Errorf("invalid model name: %s", modelName)
+		if summaryModel := viper.GetString("summary-model"); summaryModel != "" {
+			if err := validateModelName(summaryModel); err != nil {
+				return fmt.Errorf("invalid --summary-model: %w", err)
+			}
 		}
+		if _, err := getOutputFormat(); err != nil {
+			return err
+		}
+		return validateModelName(modelName)
 	},
 	Run: runTUI,
 }
@@ -59,6 +60,12 @@ var runCmd = &cobra.Command{
 func init() {
 	rootCmd.AddCommand(runCmd)
 
+	runCmd.Flags().String("resume", "", "continue a saved session, given as --resume=<ID or ID prefix> (default is the last session)")
+	runCmd.Flags().Lookup("resume").NoOptDefVal = session.LastRef
+	runCmd.Flags().Bool("sidebar", false, "show the sidebar of open and recent sessions, which alt+s toggles")
+	_ = viper.BindPFlag("sidebar", runCmd.Flags().Lookup("sidebar"))
+	addOutputFlags(runCmd)
+
 	var flagName string
 
 	flagName = "system-prompt"
@@ -86,6 +93,25 @@ func init() {
 	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
 	viper.SetDefault(flagName, "tokyo-night")
 
+	flagName = "context-strategy"
+	rootCmd.PersistentFlags().String(flagName, "", "what to do when the chat outgrows the model's context window: truncate, summarize or refuse (default truncate)")
+	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
+	viper.SetDefault(flagName, string(models.ContextStrategyTruncate))
+
+	flagName = "summary-model"
+	rootCmd.PersistentFlags().String(flagName, "", "model used to summarize old chat history with --context-strategy=summarize (default is the provider's cheapest model)")
+	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
+
+	flagName = "retry-attempts"
+	rootCmd.PersistentFlags().Int(flagName, 0, "attempts made for each prompt if the API is rate limited, overloaded or unreachable. 1 disables retrying (default 4)")
+	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
+	viper.SetDefault(flagName, models.DefaultRetryPolicy.MaxAttempts)
+
+	flagName = "retry-max-elapsed"
+	rootCmd.PersistentFlags().Duration(flagName, 0, "stop retrying a prompt once this long has passed since its first attempt (default 2m0s)")
+	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
+	viper.SetDefault(flagName, models.DefaultRetryPolicy.MaxElapsed)
+
 	flagName = "force-interactive"
 	rootCmd.PersistentFlags().Bool(flagName, false, "if stdin is a pipe, setting this option loads the TUI instead of just printing to stdout")
 	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
@@ -100,16 +126,8 @@ func init() {
 	_ = viper.BindPFlag(flagName, rootCmd.PersistentFlags().Lookup(flagName))
 }
 
-func runTUI(_ *cobra.Command, _ []string) {
-	// note: x_API_KEY will override DUCKY_x_API_KEY here
-	_, exists := os.LookupEnv("OPENAI_API_KEY")
-	if !exists {
-		_ = os.Setenv("OPENAI_API_KEY", viper.GetString("openai-api-key"))
-	}
-	_, exists = os.LookupEnv("ANTHROPIC_API_KEY")
-	if !exists {
-		_ = os.Setenv("ANTHROPIC_API_KEY", viper.GetString("anthropic-api-key"))
-	}
+func runTUI(cmd *cobra.Command, _ []string) {
+	exportAPIKeys()
 
 	systemPrompt, modelName, reasoning, effort, maxTokens, style := viper.GetString("system-prompt"),
 		viper.GetString("model"),
@@ -135,41 +153,134 @@ func runTUI(_ *cobra.Command, _ []string) {
 			initialPrompt = prompt
 		} else {
 			// TODO: replace this with direct calls to anthropic,openai model constructors
-			model := tui.InitLLMClient(modelName, systemPrompt, maxTokens)
-			responseChan := make(chan models.StreamChunk)
-
-			var streamError error
-			streamFunc := func() {
-				streamError = model.DoStreamPromptCompletion(context.TODO(), prompt, reasoning, effortPtr, responseChan)
+			model, err := tui.InitLLMClient(modelName, systemPrompt, maxTokens)
+			if err != nil {
+				fmt.Println(err)
+				os.Exit(1)
 			}
-			go streamFunc()
+			format, _ := getOutputFormat() // validated in PreRunE
+			os.Exit(streamToStdout(model, models.UserMessage(prompt), reasoning, effortPtr, pipeOptions{
+				format:         format,
+				printReasoning: viper.GetBool("print-reasoning"),
+				style:          style,
+				retryPolicy:    retryPolicy(),
+			}))
+		}
+	}
 
-			var fullResponse strings.Builder
-			for chunk := range responseChan {
-				if !chunk.Reasoning {
-					fullResponse.WriteString(chunk.Content)
-				}
-			}
-			fmt.Println(fullResponse.String())
+	contextStrategy, _ := models.ParseContextStrategy(viper.GetString("context-strategy")) // validated in PreRunE
+	var summarizer models.LLM
+	if contextStrategy == models.ContextStrategySummarize {
+		summaryModel := viper.GetString("summary-model")
+		if summaryModel == "" {
+			summaryModel = defaultSummaryModel(modelName)
+		}
+		var err error
+		if summarizer, err = tui.InitLLMClient(summaryModel, models.SummarySystemPrompt, maxTokens); err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+	}
 
-			if streamError != nil {
-				fmt.Fprintln(os.Stderr, streamError.Error())
-			}
-			return
+	opts := []tui.Option{
+		tui.WithContextStrategy(contextStrategy, summarizer),
+		tui.WithRetryPolicy(retryPolicy()),
+	}
+	store, err := session.DefaultStore()
+	if err != nil {
+		fmt.Println(err, "(the session will not be saved)")
+	} else {
+		opts = append(opts, tui.WithSessionStore(store))
+	}
+	if viper.GetBool("sidebar") {
+		opts = append(opts, tui.WithSidebar())
+	}
+	if ref, _ := cmd.Flags().GetString("resume"); ref != "" {
+		if store == nil {
+			os.Exit(1) // the error was printed above
 		}
+		resumed, err := store.Load(ref)
+		if err != nil {
+			fmt.Println(err)
+			os.Exit(1)
+		}
+		opts = append(opts, tui.WithSession(resumed))
 	}
 
 	// Run TUI application
 	zone.NewGlobal()
-	tui := tui.NewTUI(
+	tui, err := tui.NewTUI(
 		systemPrompt,
 		modelName,
 		reasoning,
 		effortPtr,
 		maxTokens,
 		style,
+		opts...,
 	)
+	if err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
 	// runtime.SetCPUProfileRate(200)
 	// go func() { log.Println(http.ListenAndServe("localhost:6060", nil)) }()
 	tui.Start(initialPrompt)
 }
+
+// exportAPIKeys makes the API keys from the config available to the provider SDKs, which read them from the environment.
+func exportAPIKeys() {
+	// note: x_API_KEY will override DUCKY_x_API_KEY here
+	_, exists := os.LookupEnv("OPENAI_API_KEY")
+	if !exists {
+		_ = os.Setenv("OPENAI_API_KEY", viper.GetString("openai-api-key"))
+	}
+	_, exists = os.LookupEnv("ANTHROPIC_API_KEY")
+	if !exists {
+		_ = os.Setenv("ANTHROPIC_API_KEY", viper.GetString("anthropic-api-key"))
+	}
+}
+
+// retryPolicy returns the policy for retrying failed prompts, configured with the retry-attempts and retry-max-elapsed options.
+func retryPolicy() models.RetryPolicy {
+	policy := models.DefaultRetryPolicy
+	policy.MaxAttempts = max(1, viper.GetInt("retry-attempts"))
+	policy.MaxElapsed = viper.GetDuration("retry-max-elapsed")
+	return policy
+}
+
+// validateModelName returns an error listing all supported models if modelName is not one of them.
+func validateModelName(modelName string) error {
+	if mock.IsMockModel(modelName) {
+		return mock.ValidateModelName(modelName) //nolint:wrapcheck // already describes the problem
+	}
+	anthropicErr := anthropic.ValidateModelName(modelName)
+	openAIErr := openai.ValidateModelName(modelName)
+	if anthropicErr == nil || openAIErr == nil {
+		return nil
+	}
+
+	// Neither model is valid, handle errors
+	switch {
+	case anthropicErr != nil && openAIErr != nil:
+		// Model is neither openai nor anthropic, combine error messages
+		return fmt.Errorf("invalid model name: %s\n%v\n%v", modelName, anthropicErr, openAIErr)
+	case anthropicErr != nil:
+		return fmt.Errorf("invalid model name: %s\n%v", modelName, anthropicErr)
+	case openAIErr != nil:
+		return fmt.Errorf("invalid model name: %s\n%v", modelName, openAIErr)
+	default:
+		// This shouldn't happen if validation functions are implemented correctly
+		return fmt.Errorf("invalid model name: %s", modelName)
+	}
+}
+
+// defaultSummaryModel returns the cheap model of the same provider as modelName, so that summarizing needs no extra API key.
+func defaultSummaryModel(modelName string) string {
+	if mock.IsMockModel(modelName) {
+		return modelName
+	}
+	if anthropic.ValidateModelName(modelName) == nil {
+		return anthropic.SummaryModelName
+	}
+	return openai.SummaryModelName
+}
@@ -0,0 +1,74 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"charm.land/lipgloss/v2"
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/gregriff/ducky/internal/styles"
+	"github.com/spf13/cobra"
+)
+
+// searchCmd represents the search command.
+var searchCmd = &cobra.Command{
+	Use:   "search <query>",
+	Short: "Search the prompts and responses of saved conversations",
+	Long: `Search every saved and imported session for entries that contain all the words in the query. Words match the
+words they are the start of, so "gorout" matches "goroutines".
+
+Results show the session's ID, when it was last updated, its model and title, and the matching text. Continue a session
+with ducky run --resume=<ID>, or send /search <query> in the TUI to open a result scrolled to the matching entry.
+
+The search index is kept next to the sessions in $XDG_DATA_HOME/ducky/sessions. It is updated as sessions are saved and
+imported, and sessions changed in other ways are indexed before the next search.
+
+Example:
+  ducky search goroutine leak
+  ducky search --include-reasoning "context window"`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: runSearch,
+}
+
+func init() {
+	rootCmd.AddCommand(searchCmd)
+
+	searchCmd.Flags().Bool("include-reasoning", false, "search the models' reasoning too")
+	searchCmd.Flags().IntP("limit", "n", 20, "maximum number of results, or 0 for all")
+}
+
+func runSearch(cmd *cobra.Command, args []string) error {
+	store, err := session.DefaultStore()
+	if err != nil {
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	reasoning, _ := cmd.Flags().GetBool("include-reasoning")
+	limit, _ := cmd.Flags().GetInt("limit")
+
+	query := strings.Join(args, " ")
+	results, err := store.Search(query, session.SearchOptions{Reasoning: reasoning, Limit: limit})
+	if errors.Is(err, session.ErrEmptyQuery) {
+		return err //nolint:wrapcheck // already describes the problem
+	} else if err != nil {
+		return fmt.Errorf("error searching %s: %w", store.Dir, err)
+	}
+
+	out := cmd.OutOrStdout()
+	if len(results) == 0 {
+		_, err := fmt.Fprintf(out, "no saved sessions match %q\n", query)
+		return err //nolint:wrapcheck // writing to stdout
+	}
+	meta := styles.TUIStyles.Completer
+	for i := range results {
+		r := &results[i]
+		header := fmt.Sprintf("%s  %s  %s  %s", r.Session.ID, r.Session.UpdatedAt.Format("2006-01-02 15:04"), r.Session.Model,
+			r.Session.Title)
+		location := meta.Render(fmt.Sprintf("(entry %d, %s)", r.Entry+1, r.Field))
+		snippet := r.Highlight(func(match string) string { return styles.TUIStyles.SearchMatch.Render(match) })
+		if _, err := lipgloss.Fprintf(out, "%s %s\n  %s\n", header, location, snippet); err != nil {
+			return err //nolint:wrapcheck // writing to stdout
+		}
+	}
+	return nil
+}
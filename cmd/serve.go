@@ -0,0 +1,137 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"maps"
+	"net/http"
+	"os"
+	"os/signal"
+	"slices"
+	"syscall"
+	"time"
+
+	tui "github.com/gregriff/ducky/internal"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/anthropic"
+	"github.com/gregriff/ducky/internal/models/mock"
+	"github.com/gregriff/ducky/internal/models/openai"
+	"github.com/gregriff/ducky/internal/server"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+// serveCmd represents the serve command.
+var serveCmd = &cobra.Command{
+	Use:   "serve",
+	Short: "Serve all configured models behind a local OpenAI-compatible API",
+	Long: `Start a local HTTP server with an OpenAI-compatible API, so that other tools can use ducky's API keys,
+model names and cost tracking.
+
+Endpoints:
+- POST /v1/chat/completions : chat completions, with SSE streaming if "stream" is true
+- GET  /v1/models           : the model names that can be requested
+- GET  /v1/usage            : requests served and dollars spent since the server started
+
+Models can be requested by their ducky name (e.g. sonnet) or their provider ID (e.g. claude-sonnet-4-6). Mock models
+can't be requested, since their names are file paths, but a mock --model is served to requests that don't name a model.`,
+	Args: cobra.NoArgs,
+	RunE: runServe,
+}
+
+func init() {
+	rootCmd.AddCommand(serveCmd)
+
+	var flagName string
+
+	flagName = "addr"
+	serveCmd.Flags().String(flagName, "", "address to listen on (default 127.0.0.1:8787)")
+	_ = viper.BindPFlag("serve-"+flagName, serveCmd.Flags().Lookup(flagName))
+	viper.SetDefault("serve-"+flagName, "127.0.0.1:8787")
+
+	flagName = "budget"
+	serveCmd.Flags().Float64(flagName, 0, "refuse requests whose worst-case cost would take the dollars spent past this (default no limit)")
+	_ = viper.BindPFlag("serve-"+flagName, serveCmd.Flags().Lookup(flagName))
+
+	flagName = "token"
+	serveCmd.Flags().String(flagName, "", "require clients to send this bearer token")
+	_ = viper.BindPFlag("serve-"+flagName, serveCmd.Flags().Lookup(flagName))
+}
+
+func runServe(_ *cobra.Command, _ []string) error {
+	exportAPIKeys()
+
+	api := server.New(server.Config{
+		SystemPrompt:    viper.GetString("system-prompt"),
+		MaxTokens:       viper.GetInt("max-tokens"),
+		EnableReasoning: viper.GetBool("reasoning"),
+		ReasoningEffort: models.Uint8Ptr(viper.GetUint8("reasoning-effort")),
+		Budget:          viper.GetFloat64("serve-budget"),
+		Token:           viper.GetString("serve-token"),
+		RetryPolicy:     retryPolicy(),
+		ModelNames:      modelNames(),
+		NewLLM: func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+			if modelName == "" {
+				modelName = viper.GetString("model")
+			} else if mock.IsMockModel(modelName) {
+				// the rest of a mock model's name is a path, which clients must not be able to read through the mock provider
+				return nil, fmt.Errorf("%w: %s (mock models can only be served as the default --model)", server.ErrUnknownModel, modelName)
+			}
+			name, found := resolveModelName(modelName)
+			if !found {
+				return nil, fmt.Errorf("%w: %s", server.ErrUnknownModel, modelName)
+			}
+			return tui.InitLLMClient(name, systemPrompt, maxTokens) //nolint:wrapcheck // names the model
+		},
+	})
+
+	addr := viper.GetString("serve-addr")
+	srv := &http.Server{
+		Addr:              addr,
+		Handler:           api.Handler(),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+	go func() {
+		<-ctx.Done()
+		shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		_ = srv.Shutdown(shutdownCtx)
+	}()
+
+	log.Printf("serving OpenAI-compatible API at http://%s/v1", addr)
+	if err := srv.ListenAndServe(); err != nil && !errors.Is(err, http.ErrServerClosed) {
+		return fmt.Errorf("server error: %w", err)
+	}
+	return nil
+}
+
+// modelNames returns the ducky names of all supported models.
+func modelNames() []string {
+	names := slices.Collect(maps.Keys(anthropic.AnthropicModelConfigurations))
+	names = append(names, slices.Collect(maps.Keys(openai.OpenAIModelConfigurations))...)
+	slices.Sort(names)
+	return names
+}
+
+// resolveModelName returns the ducky name of a model, given either its ducky name or the ID used by its provider's API.
+func resolveModelName(nameOrID string) (string, bool) {
+	if validateModelName(nameOrID) == nil {
+		return nameOrID, true
+	}
+	for name, config := range anthropic.AnthropicModelConfigurations {
+		if config.ID == nameOrID {
+			return name, true
+		}
+	}
+	for name, config := range openai.OpenAIModelConfigurations {
+		if config.ID == nameOrID {
+			return name, true
+		}
+	}
+	return "", false
+}
@@ -0,0 +1,184 @@
+package internal
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/atotto/clipboard"
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+	styles "github.com/gregriff/ducky/internal/styles"
+)
+
+// clipboardImage is the result of reading the image on the system clipboard, or its text if it doesn't hold an image.
+type clipboardImage struct {
+	data []byte
+	text string
+	err  error
+}
+
+// attachFile reads a file and attaches it to the prompt being typed. A relative path is relative to the working directory,
+// and is kept as the label of the file that the model sees.
+func (m *model) attachFile(path string) {
+	fullPath := path
+	if !filepath.IsAbs(path) {
+		fullPath = filepath.Join(m.workDir, path)
+	}
+	file, err := attachments.LoadFile(fullPath)
+	if err != nil {
+		m.attachError = err.Error()
+		return
+	}
+	file.Path = path
+	m.addAttachment(file)
+}
+
+// addAttachment attaches a file to the prompt being typed, unless it is already attached. Images and PDFs are rejected up
+// front if the model can't read them.
+func (m *model) addAttachment(file attachments.File) {
+	m.attachError = ""
+	if slices.ContainsFunc(m.attachments, func(f attachments.File) bool { return f.Path == file.Path }) {
+		return
+	}
+	if file.IsPart() {
+		if err := models.CheckParts(m.llm, models.UserMessage("", file.Part())); err != nil {
+			m.attachError = err.Error()
+			return
+		}
+	}
+	m.attachments = append(m.attachments, file)
+}
+
+// dropFiles attaches the files of paths pasted into the terminal, which is how terminals drop files dragged onto them. It
+// returns false if the pasted text isn't only paths, so that it is pasted as text.
+func (m *model) dropFiles(text string) bool {
+	paths := attachments.DroppedPaths(text)
+	if len(paths) == 0 {
+		return false
+	}
+	prevHeight := m.accessoryHeight()
+	for _, path := range paths {
+		if rel, err := filepath.Rel(m.workDir, path); filepath.IsAbs(path) && err == nil && filepath.IsLocal(rel) {
+			path = rel // a shorter label for files in the working directory
+		}
+		m.attachFile(path)
+	}
+	m.fitAccessories(prevHeight)
+	return true
+}
+
+// pasteImage reads the image on the system clipboard in the background. ctrl+v is the textarea's paste key, so if there is
+// no image the clipboard's text is read instead, for terminals that don't paste text themselves.
+func (m *model) pasteImage() tea.Cmd {
+	return func() tea.Msg {
+		data, err := attachments.ReadClipboardImage(context.Background())
+		if err != nil {
+			if text, textErr := clipboard.ReadAll(); textErr == nil && text != "" {
+				return clipboardImage{text: text}
+			}
+		}
+		return clipboardImage{data: data, err: err}
+	}
+}
+
+// attachClipboardImage attaches an image read by pasteImage.
+func (m *model) attachClipboardImage(msg clipboardImage) tea.Cmd {
+	prevHeight := m.accessoryHeight()
+	defer m.fitAccessories(prevHeight)
+
+	if msg.err != nil {
+		m.attachError = msg.err.Error()
+		return nil
+	}
+	m.clipboardImages++
+	name := "clipboard.png"
+	if m.clipboardImages > 1 {
+		name = fmt.Sprintf("clipboard-%d.png", m.clipboardImages)
+	}
+	file, err := attachments.NewImage(name, msg.data)
+	if err != nil {
+		m.attachError = err.Error()
+		return nil
+	}
+	m.addAttachment(file)
+	return m.scheduleTokenCount()
+}
+
+// removeAttachment detaches the last file attached to the prompt being typed.
+func (m *model) removeAttachment() {
+	prevHeight := m.accessoryHeight()
+	m.attachments = m.attachments[:len(m.attachments)-1]
+	m.attachError = ""
+	m.fitAccessories(prevHeight)
+}
+
+// clearAttachments detaches every file once the prompt has been sent.
+func (m *model) clearAttachments() {
+	m.attachments = nil
+	m.attachError = ""
+	m.completer = nil
+}
+
+// accessoryView renders the find bar, the search results, the completer and the chips of the attached files, which are shown above the textarea. It returns an
+// empty string if there is nothing to show.
+func (m *model) accessoryView(width int) string {
+	var lines []string
+	if m.find != nil {
+		lines = append(lines, m.findLine(width))
+	}
+	if m.search != nil {
+		lines = append(lines, m.searchLines(width)...)
+	}
+	if c := m.completer; c != nil && !c.hidden {
+		if len(c.matches) == 0 {
+			lines = append(lines, styles.TUIStyles.Completer.Render("  no matching files"))
+		}
+		for i, match := range c.matches {
+			if i == c.selected {
+				lines = append(lines, styles.TUIStyles.CompleterSelected.Render("› "+match))
+			} else {
+				lines = append(lines, styles.TUIStyles.Completer.Render("  "+match))
+			}
+		}
+	}
+
+	var chips []string
+	for _, file := range m.attachments {
+		label := file.Path
+		if file.IsDocument() {
+			label += fmt.Sprintf(" %d pages", file.Pages)
+		}
+		chips = append(chips, fmt.Sprintf("[%s ~%s tokens]", label, models.FormatTokens(file.Tokens())))
+	}
+	if m.attachError != "" {
+		chips = append(chips, m.attachError)
+	}
+	if m.notice != "" {
+		chips = append(chips, m.notice)
+	}
+	if len(chips) > 0 {
+		lines = append(lines, styles.TUIStyles.AttachmentChip.Width(max(1, width)).Render(strings.Join(chips, " ")))
+	}
+	return strings.Join(lines, "\n")
+}
+
+// accessoryHeight returns the number of lines taken by accessoryView.
+func (m *model) accessoryHeight() int {
+	view := m.accessoryView(m.viewport.Width())
+	if view == "" {
+		return 0
+	}
+	return lipgloss.Height(view)
+}
+
+// fitAccessories resizes the viewport if the completer or the chips have changed height since prevHeight was measured.
+func (m *model) fitAccessories(prevHeight int) {
+	if m.ready && m.accessoryHeight() != prevHeight {
+		m.resizeTextarea(m.textarea.Height())
+	}
+}
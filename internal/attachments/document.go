@@ -0,0 +1,94 @@
+package attachments
+
+import (
+	"bytes"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/ledongthuc/pdf"
+)
+
+// IsDocument returns whether a path names a PDF, by its extension.
+func IsDocument(path string) bool {
+	return strings.EqualFold(filepath.Ext(path), ".pdf")
+}
+
+// LoadDocument reads a PDF to attach, see NewDocument.
+func LoadDocument(path string) (File, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // reading files chosen by the user is the point
+	if err != nil {
+		return File{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return NewDocument(path, data)
+}
+
+// NewDocument returns an attachment for PDF data, checking it against models.MaxDocumentSize and models.MaxDocumentPages. Its
+// text is extracted up front, as it is sent in place of the PDF to models that can't read PDFs, and is used to estimate its
+// tokens. A PDF without text, such as a scanned one, is still attached, as models that read PDFs natively can read it.
+func NewDocument(name string, data []byte) (File, error) {
+	if !bytes.HasPrefix(data, []byte("%PDF-")) {
+		return File{}, fmt.Errorf("%s: not a PDF", name)
+	}
+	if len(data) > models.MaxDocumentSize {
+		return File{}, fmt.Errorf("%s: PDF is larger than %d MB", name, models.MaxDocumentSize>>20)
+	}
+	text, pages, err := extractText(data)
+	if err != nil {
+		return File{}, fmt.Errorf("%s: error reading PDF: %w", name, err)
+	}
+	if pages > models.MaxDocumentPages {
+		return File{}, fmt.Errorf("%s: PDF has %d pages, more than the limit of %d", name, pages, models.MaxDocumentPages)
+	}
+	return File{Path: name, Text: text, MediaType: models.DocumentMediaType, Data: data, Pages: pages}, nil
+}
+
+// extractText returns the text of a PDF, with its pages separated by blank lines, and its number of pages.
+func extractText(data []byte) (text string, pages int, err error) {
+	defer func() {
+		// the parser panics on some malformed files
+		if r := recover(); r != nil {
+			err = fmt.Errorf("malformed PDF: %v", r)
+		}
+	}()
+
+	reader, err := pdf.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return "", 0, err //nolint:wrapcheck // wrapped by the caller
+	}
+	pages = reader.NumPage()
+	if pages > models.MaxDocumentPages {
+		return "", pages, nil // not worth extracting
+	}
+
+	var sb strings.Builder
+	for i := 1; i <= pages; i++ {
+		writePageText(&sb, reader.Page(i).Content().Text)
+		sb.WriteString("\n\n")
+	}
+	return strings.TrimSpace(sb.String()), pages, nil
+}
+
+// writePageText writes the text of a page, given its glyphs in the order they are drawn. A glyph on another line than the
+// previous one starts a new line, and one that isn't next to the previous one is separated from it by a space, as PDFs often
+// position words rather than spelling out the spaces between them.
+func writePageText(sb *strings.Builder, glyphs []pdf.Text) {
+	var prev pdf.Text
+	for i, glyph := range glyphs {
+		if i > 0 {
+			switch {
+			case math.Abs(glyph.Y-prev.Y) > glyph.FontSize/2:
+				sb.WriteByte('\n')
+			case glyph.X < prev.X || glyph.X-(prev.X+prev.W) > glyph.FontSize/4:
+				if prev.S != " " && glyph.S != " " {
+					sb.WriteByte(' ')
+				}
+			}
+		}
+		sb.WriteString(glyph.S)
+		prev = glyph
+	}
+}
@@ -0,0 +1,93 @@
+package attachments
+
+import (
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DroppedPaths returns the paths of the files in pasted text, if the text is nothing but paths of existing files. Terminals
+// paste the absolute paths of files dragged onto them, quoted or with their spaces escaped the way a shell would need them,
+// or as file:// URLs. Paths starting with ~ are expanded. It returns nil if the text is anything else, including relative
+// paths, so that pasted text that happens to name a file, such as "go.mod", is left alone.
+func DroppedPaths(text string) []string {
+	text = strings.TrimSpace(text)
+	if text == "" || strings.ContainsAny(text, "\n\r") && !strings.HasPrefix(text, "file://") {
+		return nil
+	}
+
+	var paths []string
+	for _, word := range splitShellWords(text) {
+		if strings.HasPrefix(word, "file://") {
+			u, err := url.Parse(word)
+			if err != nil {
+				return nil
+			}
+			word = u.Path
+		}
+		word = expandHome(word)
+		if !filepath.IsAbs(word) {
+			return nil
+		}
+		info, err := os.Stat(word)
+		if err != nil || !info.Mode().IsRegular() {
+			return nil
+		}
+		paths = append(paths, word)
+	}
+	return paths
+}
+
+// expandHome replaces the ~ at the start of a path with the user's home directory.
+func expandHome(path string) string {
+	if path != "~" && !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[1:])
+}
+
+// splitShellWords splits text into words the way a shell would, honoring single and double quotes and backslash escapes.
+func splitShellWords(text string) []string {
+	var (
+		words   []string
+		word    strings.Builder
+		inWord  bool
+		quote   rune
+		escaped bool
+	)
+	for _, r := range text {
+		switch {
+		case escaped:
+			word.WriteRune(r)
+			escaped = false
+		case r == '\\' && quote != '\'':
+			escaped, inWord = true, true
+		case quote != 0:
+			if r == quote {
+				quote = 0
+			} else {
+				word.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inWord = r, true
+		case r == ' ' || r == '\t' || r == '\n' || r == '\r':
+			if inWord {
+				words = append(words, word.String())
+				word.Reset()
+				inWord = false
+			}
+		default:
+			word.WriteRune(r)
+			inWord = true
+		}
+	}
+	if inWord {
+		words = append(words, word.String())
+	}
+	return words
+}
@@ -0,0 +1,124 @@
+// Package attachments turns local files into content that can be sent to a model alongside a prompt
+package attachments
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// ErrBinaryFile is returned when a file that should be sent as text contains binary data.
+var ErrBinaryFile = errors.New("file is not a text file")
+
+// languageTags maps file extensions to the language tags used by Markdown code fences (and therefore chroma's lexers).
+var languageTags = map[string]string{
+	".go":    "go",
+	".mod":   "go",
+	".sum":   "text",
+	".py":    "python",
+	".rs":    "rust",
+	".js":    "javascript",
+	".mjs":   "javascript",
+	".cjs":   "javascript",
+	".jsx":   "jsx",
+	".ts":    "typescript",
+	".tsx":   "tsx",
+	".java":  "java",
+	".kt":    "kotlin",
+	".swift": "swift",
+	".c":     "c",
+	".h":     "c",
+	".cc":    "cpp",
+	".cpp":   "cpp",
+	".hpp":   "cpp",
+	".cs":    "csharp",
+	".rb":    "ruby",
+	".php":   "php",
+	".lua":   "lua",
+	".zig":   "zig",
+	".sh":    "bash",
+	".bash":  "bash",
+	".zsh":   "zsh",
+	".fish":  "fish",
+	".ps1":   "powershell",
+	".sql":   "sql",
+	".html":  "html",
+	".css":   "css",
+	".scss":  "scss",
+	".json":  "json",
+	".jsonl": "json",
+	".yaml":  "yaml",
+	".yml":   "yaml",
+	".toml":  "toml",
+	".xml":   "xml",
+	".md":    "markdown",
+	".proto": "protobuf",
+	".tf":    "hcl",
+	".hcl":   "hcl",
+	".vim":   "vim",
+	".diff":  "diff",
+	".patch": "diff",
+}
+
+// languageTagsByName maps file names without a meaningful extension to language tags.
+var languageTagsByName = map[string]string{
+	"Makefile":   "makefile",
+	"Dockerfile": "dockerfile",
+	"go.mod":     "go",
+}
+
+// LanguageTag returns the code fence language tag for a file, inferred from its name. It returns an empty string if the
+// language is unknown.
+func LanguageTag(path string) string {
+	base := filepath.Base(path)
+	if tag, exists := languageTagsByName[base]; exists {
+		return tag
+	}
+	return languageTags[strings.ToLower(filepath.Ext(base))]
+}
+
+// ReadTextFile reads a file to be fenced, returning ErrBinaryFile if it does not contain text.
+func ReadTextFile(path string) (string, error) {
+	content, err := os.ReadFile(path) //nolint:gosec // reading files chosen by the user is the point
+	if err != nil {
+		return "", fmt.Errorf("error reading %s: %w", path, err)
+	}
+	if bytes.IndexByte(content, 0) != -1 {
+		return "", fmt.Errorf("%s: %w", path, ErrBinaryFile)
+	}
+	return string(content), nil
+}
+
+// FencedBlock formats text as a Markdown code block labelled with its name. The fence is made longer than any run of
+// backticks in the text, so that the text cannot close it early.
+func FencedBlock(name, language, text string) string {
+	fence := strings.Repeat("`", max(3, longestBacktickRun(text)+1))
+
+	var block strings.Builder
+	if name != "" {
+		fmt.Fprintf(&block, "`%s`:\n", name)
+	}
+	block.WriteString(fence + language + "\n")
+	block.WriteString(text)
+	if !strings.HasSuffix(text, "\n") {
+		block.WriteString("\n")
+	}
+	block.WriteString(fence)
+	return block.String()
+}
+
+func longestBacktickRun(text string) int {
+	longest, current := 0, 0
+	for i := range len(text) {
+		if text[i] == '`' {
+			current++
+			longest = max(longest, current)
+		} else {
+			current = 0
+		}
+	}
+	return longest
+}
@@ -0,0 +1,202 @@
+package attachments
+
+import (
+	"errors"
+	"io/fs"
+	"path"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// errFileLimit stops ListFiles once it has found enough files.
+var errFileLimit = errors.New("file limit reached")
+
+// File is a file attached to a prompt: either a text file, which is sent as a code block, or an image or PDF, which is sent
+// as a models.Part. Path is kept as the user chose it, relative to the working directory.
+type File struct {
+	Path string `json:"path"`
+	Text string `json:"text,omitempty"` // for PDFs, the text extracted from them
+
+	// set for images and PDFs
+	MediaType string `json:"media_type,omitempty"`
+	Data      []byte `json:"data,omitempty"`
+	Pages     int    `json:"pages,omitempty"` // set for PDFs
+}
+
+// LoadFile reads a file to attach. Images and PDFs are recognized by their extension, and anything else must be a text file,
+// or ErrBinaryFile is returned.
+func LoadFile(path string) (File, error) {
+	switch {
+	case IsImage(path):
+		return LoadImage(path)
+	case IsDocument(path):
+		return LoadDocument(path)
+	}
+	text, err := ReadTextFile(path)
+	if err != nil {
+		return File{}, err
+	}
+	return File{Path: path, Text: text}, nil
+}
+
+// IsImage returns whether the file is an image.
+func (f File) IsImage() bool {
+	return strings.HasPrefix(f.MediaType, "image/")
+}
+
+// IsDocument returns whether the file is a PDF.
+func (f File) IsDocument() bool {
+	return f.MediaType == models.DocumentMediaType
+}
+
+// IsPart returns whether the file is sent as a part of the message rather than in its text.
+func (f File) IsPart() bool {
+	return f.MediaType != ""
+}
+
+// Block returns a text file formatted as a Markdown code block, labelled with its path and language.
+func (f File) Block() string {
+	return FencedBlock(f.Path, LanguageTag(f.Path), f.Text)
+}
+
+// Part returns an image or PDF as a part of a message.
+func (f File) Part() models.Part {
+	if f.IsDocument() {
+		return models.Part{Kind: models.PartDocument, Name: f.Path, MediaType: f.MediaType, Data: f.Data, Text: f.Text, Pages: f.Pages}
+	}
+	return models.Part{Kind: models.PartImage, Name: f.Path, MediaType: f.MediaType, Data: f.Data}
+}
+
+// Tokens returns a rough token count for the file.
+func (f File) Tokens() int {
+	if f.IsPart() {
+		part := f.Part()
+		return models.EstimatePartTokens(&part)
+	}
+	return models.EstimateTokens(f.Block())
+}
+
+// Message returns the message sent for a prompt with files attached: images and PDFs become its parts, and its text is made up
+// of the text files' code blocks followed by the prompt, as `ducky ask -f` does.
+func Message(prompt string, files []File) models.Message {
+	var parts []models.Part
+	for _, file := range files {
+		if file.IsPart() {
+			parts = append(parts, file.Part())
+		}
+	}
+	return models.UserMessage(Expand(prompt, files), parts...)
+}
+
+// Expand returns the text sent for a prompt with files attached: the text files' code blocks followed by the prompt. Images
+// and PDFs are left out, see Message.
+func Expand(prompt string, files []File) string {
+	parts := make([]string, 0, len(files)+1)
+	for _, file := range files {
+		if !file.IsPart() {
+			parts = append(parts, file.Block())
+		}
+	}
+	if len(parts) == 0 {
+		return prompt
+	}
+	if prompt = strings.TrimSpace(prompt); prompt != "" {
+		parts = append(parts, prompt)
+	}
+	return strings.Join(parts, "\n\n")
+}
+
+// ListFiles walks root and returns the paths of the files in it, relative to root and using forward slashes, skipping .git
+// and everything ignored by .gitignore files. It stops after limit files, so that a huge tree can't stall the caller.
+func ListFiles(root string, limit int) ([]string, error) {
+	var ignore Ignore
+	var files []string
+	err := filepath.WalkDir(root, func(fullPath string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if d != nil && d.IsDir() && fullPath != root {
+				return fs.SkipDir // unreadable directory
+			}
+			return err
+		}
+		rel, err := filepath.Rel(root, fullPath)
+		if err != nil {
+			return err //nolint:wrapcheck // can't happen for paths under root
+		}
+		rel = filepath.ToSlash(rel)
+
+		if d.IsDir() {
+			if rel == "." {
+				return ignore.AddFile(root, "")
+			}
+			if d.Name() == ".git" || ignore.Match(rel, true) {
+				return fs.SkipDir
+			}
+			return ignore.AddFile(root, rel)
+		}
+		if !d.Type().IsRegular() || ignore.Match(rel, false) {
+			return nil
+		}
+		files = append(files, rel)
+		if len(files) >= limit {
+			return errFileLimit
+		}
+		return nil
+	})
+	if err != nil && !errors.Is(err, errFileLimit) {
+		return files, err //nolint:wrapcheck // includes the path
+	}
+	return files, nil
+}
+
+// MatchFiles returns up to limit of the files that match a query, best matches first. Files whose name starts with the query
+// rank first, then files whose path contains it, then files whose path contains its characters in order. Matching ignores
+// case, and ties go to the shorter path.
+func MatchFiles(files []string, query string, limit int) []string {
+	query = strings.ToLower(query)
+	type match struct {
+		path string
+		rank int
+	}
+	var matches []match
+	for _, file := range files {
+		lower := strings.ToLower(file)
+		switch {
+		case strings.HasPrefix(path.Base(lower), query):
+			matches = append(matches, match{file, 0})
+		case strings.Contains(lower, query):
+			matches = append(matches, match{file, 1})
+		case isSubsequence(query, lower):
+			matches = append(matches, match{file, 2})
+		}
+	}
+	slices.SortStableFunc(matches, func(a, b match) int {
+		if a.rank != b.rank {
+			return a.rank - b.rank
+		}
+		if len(a.path) != len(b.path) {
+			return len(a.path) - len(b.path)
+		}
+		return strings.Compare(a.path, b.path)
+	})
+
+	result := make([]string, 0, min(limit, len(matches)))
+	for _, m := range matches[:min(limit, len(matches))] {
+		result = append(result, m.path)
+	}
+	return result
+}
+
+// isSubsequence returns whether the characters of sub appear in s in order.
+func isSubsequence(sub, s string) bool {
+	for _, r := range sub {
+		i := strings.IndexRune(s, r)
+		if i == -1 {
+			return false
+		}
+		s = s[i+len(string(r)):]
+	}
+	return true
+}
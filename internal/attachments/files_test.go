@@ -0,0 +1,223 @@
+package attachments
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+func TestIgnoreMatch(t *testing.T) {
+	var ig Ignore
+	for _, line := range []string{"# comment", "*.log", "!keep.log", "build/", "/root.txt", "docs/**/*.tmp"} {
+		ig.AddPattern("", line)
+	}
+	ig.AddPattern("sub", "local.txt")
+
+	tests := []struct {
+		path  string
+		isDir bool
+		want  bool
+	}{
+		{"debug.log", false, true},
+		{"a/b/debug.log", false, true},
+		{"keep.log", false, false},
+		{"build", true, true},
+		{"a/build", true, true},
+		{"build", false, false}, // dir-only pattern
+		{"root.txt", false, true},
+		{"a/root.txt", false, false}, // anchored to the root
+		{"docs/x.tmp", false, true},
+		{"docs/a/b/x.tmp", false, true},
+		{"x.tmp", false, false},
+		{"sub/local.txt", false, true},
+		{"sub/a/local.txt", false, true},
+		{"local.txt", false, false}, // pattern belongs to sub/.gitignore
+		{"main.go", false, false},
+	}
+	for _, test := range tests {
+		if got := ig.Match(test.path, test.isDir); got != test.want {
+			t.Errorf("Match(%q, %v) = %v, want %v", test.path, test.isDir, got, test.want)
+		}
+	}
+}
+
+func TestListFiles(t *testing.T) {
+	root := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":        "*.log\nvendor/\n",
+		"main.go":           "package main\n",
+		"debug.log":         "",
+		"vendor/dep.go":     "",
+		".git/config":       "",
+		"pkg/util.go":       "",
+		"pkg/.gitignore":    "gen.go\n",
+		"pkg/gen.go":        "",
+		"pkg/sub/gen.go":    "",
+		"other/gen.go":      "",
+		"other/trace.log":   "",
+		"other/README.md":   "",
+		"other/nested/x.go": "",
+	} {
+		path := filepath.Join(root, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	files, err := ListFiles(root, 100)
+	if err != nil {
+		t.Fatal(err)
+	}
+	slices.Sort(files)
+	want := []string{".gitignore", "main.go", "other/README.md", "other/gen.go", "other/nested/x.go", "pkg/.gitignore", "pkg/util.go"}
+	if !slices.Equal(files, want) {
+		t.Errorf("ListFiles = %v, want %v", files, want)
+	}
+
+	if files, _ := ListFiles(root, 2); len(files) != 2 {
+		t.Errorf("ListFiles with a limit of 2 returned %v", files)
+	}
+}
+
+func TestMatchFiles(t *testing.T) {
+	files := []string{"internal/tui.go", "internal/tui_test.go", "cmd/run.go", "README.md", "internal/styles/tui.go"}
+	got := MatchFiles(files, "tui", 10)
+	want := []string{"internal/tui.go", "internal/tui_test.go", "internal/styles/tui.go"}
+	if !slices.Equal(got, want) {
+		t.Errorf("MatchFiles(tui) = %v, want %v", got, want)
+	}
+	if got := MatchFiles(files, "crn", 10); !slices.Equal(got, []string{"cmd/run.go"}) {
+		t.Errorf("MatchFiles(crn) = %v", got)
+	}
+	if got := MatchFiles(files, "", 2); len(got) != 2 {
+		t.Errorf("MatchFiles with a limit of 2 returned %v", got)
+	}
+}
+
+func TestExpand(t *testing.T) {
+	files := []File{{Path: "main.go", Text: "package main\n"}}
+	got := Expand("why?", files)
+	want := "`main.go`:\n```go\npackage main\n```\n\nwhy?"
+	if got != want {
+		t.Errorf("Expand = %q, want %q", got, want)
+	}
+	if got := Expand("why?", nil); got != "why?" {
+		t.Errorf("Expand without files = %q", got)
+	}
+}
+
+func TestDroppedPaths(t *testing.T) {
+	dir := t.TempDir()
+	plain, spaced := filepath.Join(dir, "cat.png"), filepath.Join(dir, "my notes.txt")
+	for _, path := range []string{plain, spaced} {
+		if err := os.WriteFile(path, nil, 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	escaped := strings.ReplaceAll(spaced, " ", `\ `)
+	t.Setenv("HOME", dir)
+	t.Chdir(dir)
+
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{plain, []string{plain}},
+		{"'" + spaced + "' " + plain + "\n", []string{spaced, plain}},
+		{escaped, []string{spaced}},
+		{"file://" + strings.ReplaceAll(spaced, " ", "%20"), []string{spaced}},
+		{"look at " + plain, nil},
+		{filepath.Join(dir, "missing.png"), nil},
+		{dir, nil},
+		{"~/cat.png", []string{plain}},
+		{"'~/my notes.txt'", []string{spaced}},
+		// names of files in the working directory are pasted as text
+		{"cat.png", nil},
+		{"cat.png " + plain, nil},
+	}
+	for _, test := range tests {
+		if got := DroppedPaths(test.text); !slices.Equal(got, test.want) {
+			t.Errorf("DroppedPaths(%q) = %q, want %q", test.text, got, test.want)
+		}
+	}
+}
+
+func TestNewImage(t *testing.T) {
+	if _, err := NewImage("fake.png", []byte("not an image")); err == nil {
+		t.Error("text was accepted as an image")
+	}
+	file, err := NewImage("dot.gif", []byte("GIF89a\x01\x00\x01\x00"))
+	if err != nil || file.MediaType != "image/gif" || !file.IsImage() {
+		t.Fatalf("file = %+v, err = %v", file, err)
+	}
+	if msg := Message("What is this?", []File{file}); msg.Content != "What is this?" || len(msg.Parts) != 1 {
+		t.Errorf("message = %+v", msg)
+	}
+}
+
+// testPDF returns a minimal PDF with a page for each text, whose lines are drawn one below the other.
+func testPDF(pages ...string) []byte {
+	objects := []string{"<< /Type /Catalog /Pages 2 0 R >>", "", "<< /Type /Font /Subtype /Type1 /BaseFont /Helvetica >>"}
+	kids := make([]string, len(pages))
+	for i, text := range pages {
+		kids[i] = fmt.Sprintf("%d 0 R", len(objects)+1)
+		var stream strings.Builder
+		stream.WriteString("BT /F1 12 Tf 72 720 Td\n")
+		for line := range strings.SplitSeq(text, "\n") {
+			fmt.Fprintf(&stream, "(%s) Tj 0 -14 Td\n", line)
+		}
+		stream.WriteString("ET")
+		objects = append(objects,
+			fmt.Sprintf("<< /Type /Page /Parent 2 0 R /MediaBox [0 0 612 792] /Resources << /Font << /F1 3 0 R >> >> /Contents %d 0 R >>",
+				len(objects)+2),
+			fmt.Sprintf("<< /Length %d >>\nstream\n%s\nendstream", stream.Len(), stream.String()))
+	}
+	objects[1] = fmt.Sprintf("<< /Type /Pages /Kids [%s] /Count %d >>", strings.Join(kids, " "), len(pages))
+
+	var pdf strings.Builder
+	pdf.WriteString("%PDF-1.4\n")
+	offsets := make([]int, len(objects))
+	for i, object := range objects {
+		offsets[i] = pdf.Len()
+		fmt.Fprintf(&pdf, "%d 0 obj\n%s\nendobj\n", i+1, object)
+	}
+	xref := pdf.Len()
+	fmt.Fprintf(&pdf, "xref\n0 %d\n0000000000 65535 f \n", len(objects)+1)
+	for _, offset := range offsets {
+		fmt.Fprintf(&pdf, "%010d 00000 n \n", offset)
+	}
+	fmt.Fprintf(&pdf, "trailer\n<< /Size %d /Root 1 0 R >>\nstartxref\n%d\n%%%%EOF\n", len(objects)+1, xref)
+	return []byte(pdf.String())
+}
+
+func TestNewDocument(t *testing.T) {
+	if _, err := NewDocument("fake.pdf", []byte("not a PDF")); err == nil {
+		t.Error("text was accepted as a PDF")
+	}
+	if _, err := NewDocument("broken.pdf", []byte("%PDF-1.4\ngarbage")); err == nil {
+		t.Error("a malformed PDF was accepted")
+	}
+	if _, err := NewDocument("long.pdf", testPDF(slices.Repeat([]string{"page"}, models.MaxDocumentPages+1)...)); err == nil {
+		t.Error("a PDF over the page limit was accepted")
+	}
+
+	file, err := NewDocument("spec.pdf", testPDF("Pin 1: VCC\nPin 2: GND", "Timing"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !file.IsDocument() || file.IsImage() || file.Pages != 2 || file.Text != "Pin 1: VCC\nPin 2: GND\n\nTiming" {
+		t.Fatalf("file = %+v", file)
+	}
+	msg := Message("Which pin is ground?", []File{file})
+	if msg.Content != "Which pin is ground?" || len(msg.Parts) != 1 || msg.Parts[0].Kind != models.PartDocument {
+		t.Errorf("message = %+v", msg)
+	}
+}
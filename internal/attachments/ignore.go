@@ -0,0 +1,127 @@
+package attachments
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// Ignore matches paths against the patterns of the .gitignore files in a directory tree
+// (https://git-scm.com/docs/gitignore#_pattern_format).
+type Ignore struct {
+	rules []ignoreRule
+}
+
+type ignoreRule struct {
+	dir      string // directory of the .gitignore, relative to the root, or "" for the root
+	pattern  string
+	negate   bool // the pattern started with !, so it re-includes paths
+	dirOnly  bool // the pattern ended with /
+	anchored bool // the pattern contains a / other than at its end, so it is relative to dir rather than matching any name
+}
+
+// AddFile adds the patterns of the .gitignore file in dir, which is relative to root. A missing file is not an error.
+func (ig *Ignore) AddFile(root, dir string) error {
+	f, err := os.Open(filepath.Join(root, dir, ".gitignore"))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err //nolint:wrapcheck // includes the path
+	}
+	defer f.Close() //nolint:errcheck // read-only
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		ig.AddPattern(filepath.ToSlash(dir), scanner.Text())
+	}
+	return scanner.Err() //nolint:wrapcheck // rare
+}
+
+// AddPattern adds one line of a .gitignore file in dir, which is relative to the root and uses forward slashes.
+func (ig *Ignore) AddPattern(dir, line string) {
+	line = strings.TrimRight(line, " \t\r")
+	if line == "" || strings.HasPrefix(line, "#") {
+		return
+	}
+	if dir == "." {
+		dir = ""
+	}
+	rule := ignoreRule{dir: dir}
+	if strings.HasPrefix(line, "!") {
+		rule.negate = true
+		line = line[1:]
+	}
+	line = strings.TrimPrefix(line, `\`) // escapes a leading # or !
+	if strings.HasSuffix(line, "/") {
+		rule.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if strings.Contains(line, "/") {
+		rule.anchored = true
+		line = strings.TrimPrefix(line, "/")
+	}
+	if line == "" {
+		return
+	}
+	rule.pattern = line
+	ig.rules = append(ig.rules, rule)
+}
+
+// Match returns whether a path, relative to the root and using forward slashes, is ignored. As in git, the last matching
+// pattern wins, so a negated pattern can re-include a path ignored by an earlier one.
+func (ig *Ignore) Match(relPath string, isDir bool) bool {
+	ignored := false
+	for i := range ig.rules {
+		rule := &ig.rules[i]
+		if rule.dirOnly && !isDir {
+			continue
+		}
+		rel := relPath
+		if rule.dir != "" {
+			if !strings.HasPrefix(relPath, rule.dir+"/") {
+				continue
+			}
+			rel = relPath[len(rule.dir)+1:]
+		}
+		var matched bool
+		if rule.anchored {
+			matched = matchGlob(rule.pattern, rel)
+		} else {
+			matched = matchGlob(rule.pattern, path.Base(rel))
+		}
+		if matched {
+			ignored = !rule.negate
+		}
+	}
+	return ignored
+}
+
+// matchGlob matches a slash-separated path against a pattern in which ** matches any number of directories.
+func matchGlob(pattern, name string) bool {
+	return matchSegments(strings.Split(pattern, "/"), strings.Split(name, "/"))
+}
+
+func matchSegments(pattern, name []string) bool {
+	for len(pattern) > 0 {
+		if pattern[0] == "**" {
+			rest := pattern[1:]
+			for i := 0; i <= len(name); i++ {
+				if matchSegments(rest, name[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(name) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pattern[0], name[0]); !ok {
+			return false
+		}
+		pattern, name = pattern[1:], name[1:]
+	}
+	return len(name) == 0
+}
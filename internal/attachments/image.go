@@ -0,0 +1,109 @@
+package attachments
+
+import (
+	"bytes"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// imageTypes maps the extensions of the image formats that every provider accepts to their media types.
+var imageTypes = map[string]string{
+	".png":  "image/png",
+	".jpg":  "image/jpeg",
+	".jpeg": "image/jpeg",
+	".gif":  "image/gif",
+	".webp": "image/webp",
+}
+
+// ErrNoClipboardImage is returned by ReadClipboardImage when the clipboard doesn't hold an image.
+var ErrNoClipboardImage = errors.New("the clipboard does not contain an image")
+
+// clipboardTimeout bounds how long the clipboard tool may take, in case it waits on a clipboard owner that doesn't respond.
+const clipboardTimeout = 3 * time.Second
+
+// IsImage returns whether a path names an image, by its extension.
+func IsImage(path string) bool {
+	_, ok := imageTypes[strings.ToLower(filepath.Ext(path))]
+	return ok
+}
+
+// LoadImage reads an image to attach, checking that its content is an image of a supported format and that it isn't larger
+// than models.MaxImageSize.
+func LoadImage(path string) (File, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // reading files chosen by the user is the point
+	if err != nil {
+		return File{}, fmt.Errorf("error reading %s: %w", path, err)
+	}
+	return NewImage(path, data)
+}
+
+// NewImage returns an attachment for image data, detecting its format from the data itself.
+func NewImage(name string, data []byte) (File, error) {
+	mediaType := http.DetectContentType(data)
+	if !isImageType(mediaType) {
+		return File{}, fmt.Errorf("%s: not a PNG, JPEG, GIF or WebP image", name)
+	}
+	if len(data) > models.MaxImageSize {
+		return File{}, fmt.Errorf("%s: image is larger than %d MB", name, models.MaxImageSize>>20)
+	}
+	return File{Path: name, MediaType: mediaType, Data: data}, nil
+}
+
+func isImageType(mediaType string) bool {
+	for _, t := range imageTypes {
+		if t == mediaType {
+			return true
+		}
+	}
+	return false
+}
+
+// ReadClipboardImage returns the image on the system clipboard as PNG data, using the clipboard tool of the platform: wl-paste
+// or xclip on Linux, and osascript on macOS.
+func ReadClipboardImage(ctx context.Context) ([]byte, error) {
+	ctx, cancel := context.WithTimeout(ctx, clipboardTimeout)
+	defer cancel()
+
+	switch runtime.GOOS {
+	case "darwin":
+		// prints the PNG data as «data PNGf89504E47...»
+		out, err := exec.CommandContext(ctx, "osascript", "-e", "the clipboard as «class PNGf»").Output()
+		if err != nil {
+			return nil, ErrNoClipboardImage
+		}
+		text := strings.TrimSpace(string(out))
+		text = strings.TrimSuffix(strings.TrimPrefix(text, "«data PNGf"), "»")
+		data, err := hex.DecodeString(text)
+		if err != nil {
+			return nil, ErrNoClipboardImage
+		}
+		return data, nil
+	case "linux", "freebsd", "openbsd", "netbsd":
+		var cmd *exec.Cmd
+		if os.Getenv("WAYLAND_DISPLAY") != "" {
+			cmd = exec.CommandContext(ctx, "wl-paste", "--no-newline", "--type", "image/png")
+		} else {
+			cmd = exec.CommandContext(ctx, "xclip", "-selection", "clipboard", "-target", "image/png", "-out")
+		}
+		out, err := cmd.Output()
+		if errors.Is(err, exec.ErrNotFound) {
+			return nil, fmt.Errorf("%s is needed to paste images: %w", cmd.Args[0], err)
+		}
+		if err != nil || !bytes.HasPrefix(out, []byte("\x89PNG")) {
+			return nil, ErrNoClipboardImage
+		}
+		return out, nil
+	}
+	return nil, fmt.Errorf("pasting images is not supported on %s", runtime.GOOS)
+}
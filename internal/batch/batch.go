@@ -0,0 +1,237 @@
+// Package batch runs many independent prompts concurrently and records their results
+package batch
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// max size of a line in an input or output file.
+const maxLineSize = 16 * 1024 * 1024
+
+// Request is one line of a batch input file, e.g. {"id": "q1", "prompt": "...", "model": "haiku"}. Fields other than the
+// prompt are optional: the ID defaults to the line number, and the others to the defaults in Config.
+type Request struct {
+	ID           string `json:"id"`
+	Prompt       string `json:"prompt"`
+	SystemPrompt string `json:"system_prompt,omitempty"`
+	Model        string `json:"model,omitempty"`
+	MaxTokens    int    `json:"max_tokens,omitempty"`
+}
+
+// Result is one line of a batch output file.
+type Result struct {
+	ID         string            `json:"id"`
+	Model      string            `json:"model"`
+	Response   string            `json:"response"`
+	Reasoning  string            `json:"reasoning,omitempty"`
+	StopReason models.StopReason `json:"stop_reason,omitempty"`
+	Usage      models.Usage      `json:"usage"`
+	Cost       float64           `json:"cost"` // in dollars
+	Error      string            `json:"error,omitempty"`
+	Attempts   int               `json:"attempts"`
+}
+
+// Config controls how a batch is run.
+type Config struct {
+	Workers     int                // number of requests made concurrently
+	RetryPolicy models.RetryPolicy // of requests that fail before anything is streamed
+
+	// defaults for requests that don't specify these fields
+	Model        string
+	SystemPrompt string
+	MaxTokens    int
+
+	EnableReasoning bool
+	ReasoningEffort *uint8
+
+	// NewLLM creates a model for a single request, so that requests don't share chat history.
+	NewLLM func(modelName, systemPrompt string, maxTokens int) (models.LLM, error)
+}
+
+// Summary counts the outcomes of a batch.
+type Summary struct {
+	Succeeded, Failed, Skipped int
+}
+
+// ReadRequests parses a JSONL file of requests. Requests without an ID are given one based on their line number.
+func ReadRequests(r io.Reader) ([]Request, error) {
+	var requests []Request
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" {
+			continue
+		}
+		var req Request
+		decoder := json.NewDecoder(strings.NewReader(line))
+		decoder.DisallowUnknownFields() // catches misspelled fields and files of another shape
+		if err := decoder.Decode(&req); err != nil {
+			return nil, fmt.Errorf("line %d: %w", lineNo, err)
+		}
+		if req.ID == "" {
+			req.ID = fmt.Sprintf("line-%d", lineNo)
+		}
+		if req.Prompt == "" {
+			return nil, fmt.Errorf("line %d: request %s has no prompt", lineNo, req.ID)
+		}
+		requests = append(requests, req)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading requests: %w", err)
+	}
+	return requests, nil
+}
+
+// CompletedIDs returns the IDs of requests that have a successful result in an existing output file, so that a failed or
+// interrupted batch can be resumed. A missing file has no completed requests.
+func CompletedIDs(path string) (map[string]bool, error) {
+	completed := make(map[string]bool)
+	f, err := os.Open(path) //nolint:gosec // path is chosen by the user
+	if errors.Is(err, os.ErrNotExist) {
+		return completed, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("error opening results: %w", err)
+	}
+	defer func() { _ = f.Close() }()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), maxLineSize)
+	for scanner.Scan() {
+		var res Result
+		if json.Unmarshal(scanner.Bytes(), &res) != nil {
+			continue // a line may be cut short if ducky was killed while writing it
+		}
+		// later lines take precedence, as retried requests are appended to the file
+		completed[res.ID] = res.Error == ""
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading results: %w", err)
+	}
+	for id, ok := range completed {
+		if !ok {
+			delete(completed, id)
+		}
+	}
+	return completed, nil
+}
+
+// Run makes every request that is not in skip, using cfg.Workers concurrent workers, and writes each result to out as a line
+// of JSON as soon as it completes.
+func Run(ctx context.Context, cfg Config, requests []Request, skip map[string]bool, out io.Writer) (Summary, error) {
+	var (
+		summary  Summary
+		jobs     = make(chan Request)
+		results  = make(chan Result)
+		workers  sync.WaitGroup
+		writeErr error
+	)
+
+	for range max(1, cfg.Workers) {
+		workers.Go(func() {
+			for req := range jobs {
+				results <- cfg.run(ctx, req)
+			}
+		})
+	}
+
+	go func() {
+		defer close(jobs)
+		for _, req := range requests {
+			if skip[req.ID] {
+				summary.Skipped++
+				continue
+			}
+			select {
+			case jobs <- req:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	go func() {
+		workers.Wait()
+		close(results)
+	}()
+
+	encoder := json.NewEncoder(out)
+	for res := range results {
+		if res.Error == "" {
+			summary.Succeeded++
+		} else {
+			summary.Failed++
+		}
+		if err := encoder.Encode(res); err != nil && writeErr == nil {
+			writeErr = fmt.Errorf("error writing result: %w", err)
+		}
+	}
+	return summary, writeErr
+}
+
+// run makes a single request, retrying it according to the retry policy if it fails with a transient error.
+func (cfg Config) run(ctx context.Context, req Request) Result {
+	modelName, systemPrompt, maxTokens := req.Model, req.SystemPrompt, req.MaxTokens
+	if modelName == "" {
+		modelName = cfg.Model
+	}
+	if systemPrompt == "" {
+		systemPrompt = cfg.SystemPrompt
+	}
+	if maxTokens == 0 {
+		maxTokens = cfg.MaxTokens
+	}
+
+	res := Result{ID: req.ID, Model: modelName, Attempts: 1}
+	llm, err := cfg.NewLLM(modelName, systemPrompt, maxTokens)
+	if err != nil {
+		res.Error = err.Error()
+		return res
+	}
+
+	// failed attempts may still be billed, so their usage is added up as they are retried
+	addUsage := func() {
+		info := models.GetLastResponseInfo(llm)
+		res.Model, res.StopReason = info.ModelID, info.StopReason
+		res.Usage.InputTokens += info.Usage.InputTokens
+		res.Usage.OutputTokens += info.Usage.OutputTokens
+		res.Cost += info.Cost
+	}
+
+	var response, reasoning strings.Builder
+	responseChan := make(chan models.StreamChunk)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- models.StreamPromptCompletionWithRetry(ctx, llm, models.UserMessage(req.Prompt), cfg.EnableReasoning,
+			cfg.ReasoningEffort, responseChan, cfg.RetryPolicy, func(status models.RetryStatus) {
+				addUsage()
+				res.Attempts = status.Attempt
+			})
+	}()
+	for chunk := range responseChan {
+		if chunk.Reasoning {
+			reasoning.WriteString(chunk.Content)
+		} else {
+			response.WriteString(chunk.Content)
+		}
+	}
+	err = <-errChan
+	addUsage()
+
+	res.Response, res.Reasoning = response.String(), reasoning.String()
+	if err != nil {
+		res.Error = err.Error()
+	}
+	return res
+}
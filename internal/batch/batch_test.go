@@ -0,0 +1,291 @@
+package batch
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/mock"
+)
+
+// newConfig returns a Config whose requests are answered by mock models replaying responses in order. Each request gets its
+// own model, so every request starts at the first response.
+func newConfig(responses ...mock.Response) Config {
+	return Config{
+		Workers:     2,
+		RetryPolicy: models.RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond},
+		Model:       "mock:instant",
+		NewLLM: func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+			llm, err := mock.NewModel(systemPrompt, maxTokens, modelName, nil)
+			if err != nil {
+				return nil, err
+			}
+			llm.Script = &mock.Script{ChunkSize: 1, ContextWindow: 200_000, Responses: responses}
+			return llm, nil
+		},
+	}
+}
+
+// run runs a batch and decodes the results it writes.
+func run(t *testing.T, cfg Config, requests []Request, skip map[string]bool) (Summary, []Result) {
+	t.Helper()
+	var out bytes.Buffer
+	summary, err := Run(context.Background(), cfg, requests, skip, &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var results []Result
+	decoder := json.NewDecoder(&out)
+	for decoder.More() {
+		var res Result
+		if err := decoder.Decode(&res); err != nil {
+			t.Fatal(err)
+		}
+		results = append(results, res)
+	}
+	slices.SortFunc(results, func(a, b Result) int { return strings.Compare(a.ID, b.ID) }) // written in order of completion
+	return summary, results
+}
+
+func requests(ids ...string) []Request {
+	reqs := make([]Request, len(ids))
+	for i, id := range ids {
+		reqs[i] = Request{ID: id, Prompt: "prompt " + id}
+	}
+	return reqs
+}
+
+func TestReadRequests(t *testing.T) {
+	input := `{"id": "q1", "prompt": "first", "model": "haiku", "max_tokens": 100}
+
+{"prompt": "second", "system_prompt": "be brief"}
+`
+	got, err := ReadRequests(strings.NewReader(input))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []Request{
+		{ID: "q1", Prompt: "first", Model: "haiku", MaxTokens: 100},
+		{ID: "line-3", Prompt: "second", SystemPrompt: "be brief"},
+	}
+	if !slices.Equal(got, want) {
+		t.Errorf("requests = %+v, want %+v", got, want)
+	}
+
+	for input, wantErr := range map[string]string{
+		`{"id": "q1"}`: "line 1: request q1 has no prompt",
+		`{"request_id": "q1", "title": "t", "body": "b"}`: `line 1: json: unknown field "request_id"`,
+		"\n" + `{"prompt": "x"`:                           "line 2: unexpected EOF",
+	} {
+		if _, err := ReadRequests(strings.NewReader(input)); err == nil || err.Error() != wantErr {
+			t.Errorf("ReadRequests(%q) error = %v, want %q", input, err, wantErr)
+		}
+	}
+}
+
+func TestRun(t *testing.T) {
+	summary, results := run(t, newConfig(mock.Response{Text: "an answer"}), requests("a", "b", "c"), nil)
+
+	if summary != (Summary{Succeeded: 3}) {
+		t.Errorf("summary = %+v", summary)
+	}
+	if len(results) != 3 {
+		t.Fatalf("results = %+v", results)
+	}
+	for _, res := range results {
+		if res.Response != "an answer" || res.Error != "" || res.Attempts != 1 || res.StopReason != models.StopReasonEndTurn {
+			t.Errorf("result = %+v", res)
+		}
+	}
+}
+
+func TestRetries(t *testing.T) {
+	overloaded := mock.Response{Error: "overloaded", ErrorStatus: 529, Usage: &models.Usage{InputTokens: 10}}
+	tests := []struct {
+		name         string
+		responses    []mock.Response
+		wantAttempts int
+		wantError    string
+		wantUsage    models.Usage
+	}{
+		{
+			name:         "succeeds after a retry",
+			responses:    []mock.Response{overloaded, {Text: "ok", Usage: &models.Usage{InputTokens: 10, OutputTokens: 1}}},
+			wantAttempts: 2,
+			wantUsage:    models.Usage{InputTokens: 20, OutputTokens: 1}, // failed attempts may be billed
+		},
+		{
+			name:         "gives up after the max attempts",
+			responses:    []mock.Response{overloaded},
+			wantAttempts: 3,
+			wantError:    "overloaded",
+			wantUsage:    models.Usage{InputTokens: 30},
+		},
+		{
+			name: "does not retry after part of the response was streamed",
+			responses: []mock.Response{
+				{Text: "half an answer", Error: "overloaded", ErrorStatus: 529, ErrorAfter: 1, Usage: &models.Usage{InputTokens: 10, OutputTokens: 1}},
+				{Text: "unreachable"},
+			},
+			wantAttempts: 1,
+			wantError:    "overloaded",
+			wantUsage:    models.Usage{InputTokens: 10, OutputTokens: 1},
+		},
+		{
+			name:         "does not retry errors that aren't transient",
+			responses:    []mock.Response{{Error: "bad request", ErrorStatus: 400, Usage: &models.Usage{}}, {Text: "unreachable"}},
+			wantAttempts: 1,
+			wantError:    "bad request",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			summary, results := run(t, newConfig(tt.responses...), requests("a"), nil)
+			if len(results) != 1 {
+				t.Fatalf("results = %+v", results)
+			}
+			res := results[0]
+			if res.Attempts != tt.wantAttempts || !strings.Contains(res.Error, tt.wantError) || (tt.wantError == "") != (res.Error == "") {
+				t.Errorf("attempts = %d, error = %q, want %d, %q", res.Attempts, res.Error, tt.wantAttempts, tt.wantError)
+			}
+			if res.Usage != tt.wantUsage {
+				t.Errorf("usage = %+v, want %+v", res.Usage, tt.wantUsage)
+			}
+			if failed := tt.wantError != ""; summary != (Summary{Succeeded: boolToInt(!failed), Failed: boolToInt(failed)}) {
+				t.Errorf("summary = %+v", summary)
+			}
+		})
+	}
+}
+
+func boolToInt(b bool) int {
+	if b {
+		return 1
+	}
+	return 0
+}
+
+func TestResume(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "results.jsonl")
+	lines := []string{
+		`{"id": "a", "response": "done"}`,
+		`{"id": "b", "error": "overloaded"}`, // failed, so it is made again
+		`{"id": "c", "response": "done"}`,
+		`{"id": "c", "error": "rate limited"}`, // a later line takes precedence
+		`{"id": "d", "error": "overloaded"}`,
+		`{"id": "d", "response": "done on retry"}`,
+		`{"id": "e", "response": "cut sh`, // written while ducky was killed
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	skip, err := CompletedIDs(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := map[string]bool{"a": true, "d": true}; len(skip) != len(want) || !skip["a"] || !skip["d"] {
+		t.Fatalf("completed = %v, want %v", skip, want)
+	}
+
+	summary, results := run(t, newConfig(mock.Response{Text: "ok"}), requests("a", "b", "c", "d", "e"), skip)
+	if summary != (Summary{Succeeded: 3, Skipped: 2}) {
+		t.Errorf("summary = %+v", summary)
+	}
+	var ids []string
+	for _, res := range results {
+		ids = append(ids, res.ID)
+	}
+	if want := []string{"b", "c", "e"}; !slices.Equal(ids, want) {
+		t.Errorf("requests made = %v, want %v", ids, want)
+	}
+
+	if skip, err := CompletedIDs(filepath.Join(t.TempDir(), "missing.jsonl")); err != nil || len(skip) != 0 {
+		t.Errorf("CompletedIDs of a missing file = %v, %v", skip, err)
+	}
+}
+
+// blockingLLM waits for the test to release it before responding, so that the requests in flight can be counted.
+type blockingLLM struct {
+	*mock.Model
+	pool *pool
+}
+
+// pool counts the requests in flight.
+type pool struct {
+	mu               sync.Mutex
+	active, maxCount int
+	started          chan struct{}
+	release          chan struct{}
+}
+
+func (llm blockingLLM) DoStreamPromptCompletion(ctx context.Context, prompt models.Message, enableReasoning bool, effort *uint8, responseChan chan models.StreamChunk) error {
+	p := llm.pool
+	p.mu.Lock()
+	p.active++
+	p.maxCount = max(p.maxCount, p.active)
+	p.mu.Unlock()
+	p.started <- struct{}{}
+
+	<-p.release
+	p.mu.Lock()
+	p.active--
+	p.mu.Unlock()
+	return llm.Model.DoStreamPromptCompletion(ctx, prompt, enableReasoning, effort, responseChan)
+}
+
+func TestWorkerPool(t *testing.T) {
+	const workers, count = 3, 8
+	p := &pool{started: make(chan struct{}, count), release: make(chan struct{})}
+	cfg := newConfig(mock.Response{Text: "ok"})
+	cfg.Workers = workers
+	newLLM := cfg.NewLLM
+	cfg.NewLLM = func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+		llm, err := newLLM(modelName, systemPrompt, maxTokens)
+		if err != nil {
+			return nil, err
+		}
+		return blockingLLM{llm.(*mock.Model), p}, nil
+	}
+
+	ids := make([]string, count)
+	for i := range ids {
+		ids[i] = string(rune('a' + i))
+	}
+	done := make(chan Summary)
+	go func() {
+		summary, _ := Run(context.Background(), cfg, requests(ids...), nil, io.Discard)
+		done <- summary
+	}()
+
+	// every worker picks up a request, and no more are started until one finishes
+	for range workers {
+		select {
+		case <-p.started:
+		case <-time.After(5 * time.Second):
+			t.Fatal("fewer requests than workers were started")
+		}
+	}
+	select {
+	case <-p.started:
+		t.Fatal("more requests than workers were started")
+	case <-time.After(50 * time.Millisecond):
+	}
+	close(p.release)
+
+	if summary := <-done; summary != (Summary{Succeeded: count}) {
+		t.Errorf("summary = %+v", summary)
+	}
+	if p.maxCount != workers {
+		t.Errorf("max requests in flight = %d, want %d", p.maxCount, workers)
+	}
+}
@@ -2,7 +2,11 @@
 package chat
 
 import (
+	"strings"
+
 	"charm.land/lipgloss/v2"
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
 	styles "github.com/gregriff/ducky/internal/styles"
 )
 
@@ -12,7 +16,21 @@ type Entry struct {
 	reasoning,
 	error string
 
-	response []byte
+	attachments []attachments.File // sent before the prompt, but kept apart so that only the typed prompt is shown and recalled
+	response    []byte
+	stopReason  models.StopReason // empty if the response failed
+}
+
+// StopNote explains why a response ended early, or returns an empty string if it ended normally.
+func StopNote(reason models.StopReason) string {
+	switch reason {
+	case models.StopReasonMaxTokens:
+		return "*The response was cut off by the max-tokens limit.*"
+	case models.StopReasonRefusal:
+		return "*The response was stopped by the provider's safety systems.*"
+	case models.StopReasonEndTurn:
+	}
+	return ""
 }
 
 // formattedPrompt creates a prompt string formatted with margin and padding.
@@ -24,3 +42,16 @@ func (c *Entry) formattedPrompt(marginText string, promptStyle lipgloss.Style, m
 		PaddingBottom(styles.PROMPT_V_PADDING)
 	return lipgloss.JoinHorizontal(lipgloss.Top, marginText, fullPromptStyle.Render(c.prompt))
 }
+
+// formattedAttachments lists the names of the entry's attachments under its prompt, aligned to the right like the prompt.
+func (c *Entry) formattedAttachments(vpWidth int) string {
+	chips := make([]string, len(c.attachments))
+	for i, file := range c.attachments {
+		chips[i] = "[" + file.Path + "]"
+	}
+	return styles.ChatStyles.Attachment.
+		Width(vpWidth).
+		Align(lipgloss.Right).
+		PaddingRight(styles.H_PADDING).
+		Render(strings.Join(chips, " "))
+}
@@ -0,0 +1,53 @@
+package chat
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// FormatError renders an error returned while streaming as Markdown, telling the user what went wrong and what they can do
+// about it. Errors of an unknown kind are shown as-is.
+func FormatError(err error) string {
+	provider := models.ProviderOf(err)
+	switch models.KindOf(err) {
+	case models.ErrorCancelled:
+		return ">Stream Cancelled"
+	case models.ErrorAuth:
+		if provider == "anthropic" || provider == "openai" {
+			return fmt.Sprintf("**Authentication failed:** the %s API key is missing or invalid. Set `%s-api-key` in ducky.toml, "+
+				"pass `--%s-api-key`, or set the `%s_API_KEY` environment variable.",
+				provider, provider, provider, strings.ToUpper(provider))
+		}
+		return "**Authentication failed:** the API key is missing or invalid."
+	case models.ErrorRateLimit:
+		return "**Rate limited:** too many requests were made, or your account's quota is used up. Wait a moment and try " +
+			"again, or check your plan and billing settings."
+	case models.ErrorOverloaded:
+		return "**Overloaded:** the provider can't serve this request right now. This is usually temporary, so try again " +
+			"in a moment."
+	case models.ErrorContextTooLong:
+		var limitErr *models.ContextLimitError
+		if !errors.As(err, &limitErr) { // the provider rejected a request that was estimated to fit
+			return "**Context too long:** this chat no longer fits in the model's context window. Start a new chat, or " +
+				"shorten the prompt."
+		}
+		if limitErr.PromptOnly {
+			return fmt.Sprintf("**Prompt too long:** this prompt doesn't fit in the model's context window by itself "+
+				"(~%d of %d tokens). Shorten it, or remove some attachments.", limitErr.Tokens, limitErr.Limit)
+		}
+		// only returned by the refuse strategy, as the others shrink the history instead
+		return fmt.Sprintf("**Context too long:** this chat no longer fits in the model's context window (~%d of %d "+
+			"tokens). Start a new chat, or set `context-strategy` to `truncate` or `summarize` in ducky.toml.",
+			limitErr.Tokens, limitErr.Limit)
+	case models.ErrorInvalidModel:
+		return "**Model not found:** check the model name, and that your API key has access to it."
+	case models.ErrorNetwork:
+		return fmt.Sprintf("**Network error:** the API could not be reached. Check your connection and proxy settings.\n\n"+
+			"`%v`", err)
+	case models.ErrorUnknown:
+	}
+	return fmt.Sprintf("**Error:** %v", err)
+}
@@ -0,0 +1,51 @@
+package chat
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+func TestFormatError(t *testing.T) {
+	tests := []struct {
+		name    string
+		err     error
+		want    string
+		notWant string
+	}{
+		{name: "cancelled", err: context.Canceled, want: "Stream Cancelled"},
+		{
+			name: "auth", err: &models.APIError{Provider: "openai", Kind: models.ErrorAuth, StatusCode: 401, Err: errors.New("401")},
+			want: "`OPENAI_API_KEY`",
+		},
+		{
+			name: "history too long", err: fmt.Errorf("wrapped: %w", &models.ContextLimitError{Tokens: 120, Limit: 100}),
+			want: "`context-strategy`",
+		},
+		{
+			name: "prompt too long", err: &models.ContextLimitError{Tokens: 120, Limit: 100, PromptOnly: true},
+			want: "remove some attachments", notWant: "context-strategy",
+		},
+		{
+			name: "provider context error",
+			err:  &models.APIError{Provider: "anthropic", Kind: models.ErrorContextTooLong, StatusCode: 400, Err: errors.New("prompt is too long")},
+			want: "Start a new chat", notWant: "context-strategy",
+		},
+		{name: "unknown", err: errors.New("something broke"), want: "**Error:** something broke"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := FormatError(tt.err)
+			if !strings.Contains(got, tt.want) {
+				t.Errorf("FormatError() = %q, want it to contain %q", got, tt.want)
+			}
+			if tt.notWant != "" && strings.Contains(got, tt.notWant) {
+				t.Errorf("FormatError() = %q, want it not to contain %q", got, tt.notWant)
+			}
+		})
+	}
+}
@@ -0,0 +1,226 @@
+package chat
+
+import (
+	"slices"
+	"strings"
+	"unicode"
+
+	"charm.land/lipgloss/v2"
+	"github.com/charmbracelet/x/ansi"
+	styles "github.com/gregriff/ducky/internal/styles"
+)
+
+// Match is an occurrence of the find query in the rendered chat history. A match may be wrapped onto several lines.
+type Match struct {
+	Line  int         // of the rendered chat history, where the match begins
+	Spans []MatchSpan // the parts of the match on each line, in order
+}
+
+// MatchSpan is the part of a Match on one line of the rendered chat history.
+type MatchSpan struct {
+	Line       int
+	Start, End int // columns, in cells
+}
+
+// finder finds a query in the chat history. Entries are matched against their raw text, so that the query can't match the
+// escape sequences or decorations of the rendered history, and the matching entries' rendered text is then searched to place
+// the matches on screen. Runs of whitespace, including line breaks, match any other run of whitespace, so that phrases wrapped
+// by the renderer are found. Markdown syntax isn't rendered, so a query that includes it (e.g. `**bold**`) matches the raw
+// text but is not found on screen.
+type finder struct {
+	query    string
+	matches  []Match
+	selected int // index of the match highlighted as the current one, or -1
+
+	// the rendered history the matches were found in, so that they are found again once it changes
+	renderedLen, width int
+}
+
+// SetFindQuery sets the text to find in the chat history, which is highlighted by Render. Case is ignored. An empty query
+// stops finding.
+func (c *Model) SetFindQuery(query string) {
+	c.find = finder{query: query, selected: -1, renderedLen: -1}
+}
+
+// FindMatches returns the matches of the find query in the chat history, as of the last Render.
+func (c *Model) FindMatches() []Match {
+	return c.find.matches
+}
+
+// SelectMatch highlights the match at index i as the current one.
+func (c *Model) SelectMatch(i int) {
+	c.find.selected = i
+}
+
+// SelectedMatch returns the index of the current match, or -1 if there is none.
+func (c *Model) SelectedMatch() int {
+	return c.find.selected
+}
+
+// updateMatches finds the query in the rendered history, if it changed since the matches were last found.
+func (c *Model) updateMatches() {
+	f := &c.find
+	if f.renderedLen == c.renderedHistory.Len() && f.width == c.Markdown.CurrentWidth {
+		return
+	}
+	f.renderedLen, f.width = c.renderedHistory.Len(), c.Markdown.CurrentWidth
+	f.matches = f.matches[:0]
+
+	query := foldText(f.query)
+	rendered := c.renderedHistory.Bytes()
+	for i := range c.numChatsRendered {
+		entry := &c.history[i]
+		raw := strings.Join([]string{entry.prompt, string(entry.response), entry.error, StopNote(entry.stopReason)}, "\n")
+		if indexRunes(foldText(raw).runes, query.runes) == -1 {
+			continue
+		}
+		start, end := c.entryStarts[i], len(rendered)
+		if i+1 < len(c.entryStarts) {
+			end = c.entryStarts[i+1].offset
+		}
+		text := foldRendered(string(rendered[start.offset:end]), start.line)
+		for k := 0; ; {
+			at := indexRunes(text.runes[k:], query.runes)
+			if at == -1 {
+				break
+			}
+			at += k
+			k = at + len(query.runes)
+			f.matches = append(f.matches, text.match(at, k))
+		}
+	}
+	if f.selected >= len(f.matches) {
+		f.selected = len(f.matches) - 1
+	}
+}
+
+// highlightMatches styles the matches in the rendered chat history.
+func (c *Model) highlightMatches(rendered string) string {
+	if len(c.find.matches) == 0 {
+		return rendered
+	}
+	lines := strings.Split(rendered, "\n")
+	ranges := make(map[int][]lipgloss.Range)
+	for i, match := range c.find.matches {
+		style := styles.ChatStyles.FindMatch
+		if i == c.find.selected {
+			style = styles.ChatStyles.FindMatchSelected
+		}
+		for _, span := range match.Spans {
+			ranges[span.Line] = append(ranges[span.Line], lipgloss.NewRange(span.Start, span.End, style))
+		}
+	}
+	for line, lineRanges := range ranges {
+		if line < len(lines) {
+			lines[line] = lipgloss.StyleRanges(lines[line], lineRanges...)
+		}
+	}
+	return strings.Join(lines, "\n")
+}
+
+// foldedText is text prepared for finding: case is folded and each run of whitespace is replaced by a single space. For
+// rendered text, the position of each rune is kept so that matches can be placed on screen.
+type foldedText struct {
+	runes     []rune
+	positions []runePosition // of each rune, if the text was rendered
+}
+
+// runePosition is where a rune of foldedText was rendered. A space that replaced a line break is not on any one line.
+type runePosition struct {
+	line, start, end int // start and end are columns, in cells
+	lineBreak        bool
+}
+
+// foldText folds the case and whitespace of raw text.
+func foldText(s string) foldedText {
+	var text foldedText
+	space := false
+	for _, r := range s {
+		if unicode.IsSpace(r) {
+			space = len(text.runes) > 0
+			continue
+		}
+		if space {
+			text.runes = append(text.runes, ' ')
+			space = false
+		}
+		text.runes = append(text.runes, foldRune(r))
+	}
+	return text
+}
+
+// foldRendered folds the case and whitespace of rendered text, after stripping its escape sequences, and records where each
+// rune was rendered. firstLine is the line of the rendered chat history that the text begins on.
+func foldRendered(s string, firstLine int) foldedText {
+	var text foldedText
+	var space *runePosition // of the pending run of whitespace, if any
+	for i, line := range strings.Split(s, "\n") {
+		if i > 0 && space != nil {
+			space.lineBreak = true
+		}
+		col := 0
+		for _, r := range ansi.Strip(line) {
+			width := ansi.StringWidth(string(r))
+			switch {
+			case unicode.IsSpace(r):
+				if space == nil && len(text.runes) > 0 {
+					space = &runePosition{line: firstLine + i, start: col}
+				}
+				if space != nil {
+					space.end = col + width
+				}
+			default:
+				if space != nil {
+					text.runes = append(text.runes, ' ')
+					text.positions = append(text.positions, *space)
+					space = nil
+				}
+				text.runes = append(text.runes, foldRune(r))
+				text.positions = append(text.positions, runePosition{line: firstLine + i, start: col, end: col + width})
+			}
+			col += width
+		}
+		if space == nil && len(text.runes) > 0 { // the line break is whitespace too
+			space = &runePosition{line: firstLine + i, start: col, end: col}
+		}
+	}
+	return text
+}
+
+// match returns the Match of the runes from start to end of rendered text, split into a span for each line.
+func (t *foldedText) match(start, end int) Match {
+	match := Match{Line: t.positions[start].line}
+	for _, pos := range t.positions[start:end] {
+		if pos.lineBreak {
+			continue
+		}
+		if n := len(match.Spans); n > 0 && match.Spans[n-1].Line == pos.line {
+			match.Spans[n-1].End = pos.end
+			continue
+		}
+		match.Spans = append(match.Spans, MatchSpan{Line: pos.line, Start: pos.start, End: pos.end})
+	}
+	return match
+}
+
+// foldRune returns the same rune for all runes that are equal under Unicode case folding, such as k, K and the Kelvin sign.
+func foldRune(r rune) rune {
+	folded := r
+	for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+		folded = min(folded, f)
+	}
+	return folded
+}
+
+// indexRunes returns the index of the first occurrence of substr in s, or -1 if there is none.
+func indexRunes(s, substr []rune) int {
+	if len(substr) == 0 {
+		return -1
+	}
+	for i := 0; i+len(substr) <= len(s); i++ {
+		if slices.Equal(s[i:i+len(substr)], substr) {
+			return i
+		}
+	}
+	return -1
+}
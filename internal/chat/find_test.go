@@ -0,0 +1,80 @@
+package chat
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// newFindTestModel returns a chat with one entry.
+func newFindTestModel(t *testing.T, prompt, response string) *Model {
+	t.Helper()
+	c := NewChatModel("notty")
+	c.AddPrompt(prompt, nil)
+	c.AccumulateStream(response, false, false)
+	c.AddResponse(models.StopReasonEndTurn)
+	return c
+}
+
+// spanText returns the text of the rendered lines that a span covers.
+func spanText(lines []string, span MatchSpan) string {
+	return ansi.Cut(lines[span.Line], span.Start, span.End)
+}
+
+func TestFindWrappedPhrase(t *testing.T) {
+	response := strings.Repeat("Ducks are birds. ", 6) + "They paddle across quiet ponds at dawn."
+	c := newFindTestModel(t, "Tell me about ducks", response)
+
+	c.SetFindQuery("PADDLE across   quiet ponds")
+	lines := strings.Split(ansi.Strip(c.Render(40)), "\n")
+	matches := c.FindMatches()
+	if len(matches) != 1 {
+		t.Fatalf("found %d matches, want 1: %+v", len(matches), matches)
+	}
+	match := matches[0]
+	if len(match.Spans) < 2 {
+		t.Fatalf("match isn't wrapped onto several lines, spans = %+v; widen the response", match.Spans)
+	}
+	if match.Line != match.Spans[0].Line {
+		t.Errorf("match line = %d, first span on line %d", match.Line, match.Spans[0].Line)
+	}
+	var words []string
+	for _, span := range match.Spans {
+		words = append(words, strings.Fields(spanText(lines, span))...)
+	}
+	if got := strings.Join(words, " "); got != "paddle across quiet ponds" {
+		t.Errorf("spans cover %q", got)
+	}
+}
+
+func TestFindFoldsCase(t *testing.T) {
+	tests := []struct {
+		name, response, query string
+		want                  int
+	}{
+		{name: "ascii", response: "Ducks and more DUCKS", query: "ducks", want: 2},
+		{name: "kelvin sign", response: "It was 300K outside, about 300k", query: "300K", want: 2},
+		{name: "long s", response: "The ſtory of a duck", query: "STORY", want: 1},
+		{name: "no match", response: "Geese", query: "ducks", want: 0},
+		{name: "empty query", response: "Ducks", query: "  ", want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newFindTestModel(t, "Hi", tt.response)
+			c.SetFindQuery(tt.query)
+			lines := strings.Split(ansi.Strip(c.Render(80)), "\n")
+			matches := c.FindMatches()
+			if len(matches) != tt.want {
+				t.Fatalf("found %d matches, want %d: %+v", len(matches), tt.want, matches)
+			}
+			for _, match := range matches {
+				if got := spanText(lines, match.Spans[0]); utf8.RuneCountInString(got) != utf8.RuneCountInString(tt.query) {
+					t.Errorf("span covers %q, want a match of %q", got, tt.query)
+				}
+			}
+		})
+	}
+}
@@ -2,8 +2,14 @@ package chat
 
 import (
 	"bytes"
+	"cmp"
+	"fmt"
+	"slices"
+	"strings"
 
 	"charm.land/lipgloss/v2"
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
 	styles "github.com/gregriff/ducky/internal/styles"
 )
 
@@ -11,12 +17,23 @@ import (
 type Model struct {
 	history    []Entry
 	stream     *ResponseStream
+	queue      []Entry // prompts submitted during streaming, sent in order once the response completes
 	Scrollback *Traverser
 	TotalCost  float64
 
 	renderedHistory  bytes.Buffer // stores accumulated chat history rendered in markdown and color for a specific width
 	Markdown         *MarkdownRenderer
 	numChatsRendered int
+	entryStarts      []entryStart // where each rendered entry begins in renderedHistory
+
+	find finder // the text being found in the rendered history, see find.go
+}
+
+// entryStart is where an entry begins in the rendered chat history.
+type entryStart struct {
+	offset       int // in bytes
+	line         int
+	responseLine int // where the entry's response begins, after its prompt and attachments
 }
 
 // ResponseStream is like a buffer for the text sent from an LLM API. Once a response ends this data is moved into a ChatEntry.
@@ -74,13 +91,13 @@ func (c *Model) AccumulateStream(chunk string, isReasoning, isError bool) {
 	}
 }
 
-// AddPrompt creates a new ChatEntry with prompt data.
-func (c *Model) AddPrompt(s string) {
-	c.history = append(c.history, Entry{prompt: s})
+// AddPrompt creates a new ChatEntry with prompt data and the files attached to the prompt.
+func (c *Model) AddPrompt(s string, files []attachments.File) {
+	c.history = append(c.history, Entry{prompt: s, attachments: files})
 }
 
 // AddResponse updates the latest ChatEntry with the data from ResponseStream. Must be called after AddPrompt.
-func (c *Model) AddResponse() {
+func (c *Model) AddResponse(stopReason models.StopReason) {
 	stream := c.stream
 
 	curEntry := &c.history[len(c.history)-1]
@@ -89,15 +106,61 @@ func (c *Model) AddResponse() {
 	curEntry.response = make([]byte, stream.response.Len())
 	copy(curEntry.response, stream.response.Bytes())
 	curEntry.error = stream.error
+	curEntry.stopReason = stopReason
 
 	stream.reasoning.Reset()
 	stream.response.Reset()
 	stream.error = ""
 }
 
-// Render returns a string of the entire chat history in markdown, wrapped to a certain width. If the vpWidth hasn't changed since the
-// last call to this func, the pre-rendered chat history will be reused. If streaming, only the streamed response is returned, for UX reasons.
+// LastResponse returns the response, reasoning and error message of the latest entry, once AddResponse has been called.
+func (c *Model) LastResponse() (response, reasoning, errMsg string) {
+	if len(c.history) == 0 {
+		return "", "", ""
+	}
+	entry := &c.history[len(c.history)-1]
+	return string(entry.response), entry.reasoning, entry.error
+}
+
+// CanContinue returns whether the last response was cut off by the max-tokens limit, and can be continued.
+func (c *Model) CanContinue() bool {
+	return len(c.history) > 0 && c.stream.Len() == 0 && c.history[len(c.history)-1].stopReason == models.StopReasonMaxTokens
+}
+
+// ContinueResponse moves the last response back into the response stream, so that its continuation is streamed onto the
+// end of it. AddResponse then stores the whole response in the same entry.
+func (c *Model) ContinueResponse() {
+	curEntry := &c.history[len(c.history)-1]
+	c.stream.reasoning.WriteString(curEntry.reasoning)
+	c.stream.response.Write(curEntry.response)
+	curEntry.response, curEntry.error, curEntry.stopReason = nil, "", ""
+
+	// the entry will be rendered again once it has been continued
+	if c.numChatsRendered == len(c.history) {
+		c.renderedHistory.Truncate(c.entryStarts[len(c.entryStarts)-1].offset)
+		c.numChatsRendered--
+	}
+}
+
+// Render returns a string of the entire chat history in markdown, wrapped to a certain width, followed by any queued prompts.
 func (c *Model) Render(vpWidth int) string {
+	rendered := c.renderChat(vpWidth)
+	if c.find.query != "" && c.stream.Len() == 0 {
+		c.updateMatches()
+		rendered = c.highlightMatches(rendered)
+	}
+	if c.CanContinue() {
+		rendered += styles.ChatStyles.Hint.Render("ctrl+g continue") + "\n"
+	}
+	if len(c.queue) > 0 {
+		rendered += c.renderQueue(vpWidth)
+	}
+	return rendered
+}
+
+// renderChat returns a string of the entire chat history in markdown, wrapped to a certain width. If the vpWidth hasn't changed since the
+// last call to this func, the pre-rendered chat history will be reused. If streaming, only the streamed response is returned, for UX reasons.
+func (c *Model) renderChat(vpWidth int) string {
 	numChatEntries := max(c.numPrompts(), c.numResponses())
 	if numChatEntries == 0 {
 		return ""
@@ -143,22 +206,129 @@ func (c *Model) renderChatHistory(startingIndex, vpWidth, resWidth int) (count i
 			c.history[i].response,
 			c.history[i].error
 
+		c.recordEntryStart(i)
 		c.renderedHistory.WriteString(prompt)
 		c.renderedHistory.WriteString("\n")
+		if len(c.history[i].attachments) > 0 {
+			c.renderedHistory.WriteString(c.history[i].formattedAttachments(vpWidth))
+			c.renderedHistory.WriteString("\n")
+		}
+		start := &c.entryStarts[i]
+		start.responseLine = start.line + bytes.Count(c.renderedHistory.Bytes()[start.offset:], []byte("\n"))
 		c.renderedHistory.Write(c.Markdown.Render(response, resWidth))
 
 		if len(err) > 0 {
 			c.renderedHistory.Write(c.Markdown.Render([]byte(err), resWidth))
 		}
+		if note := StopNote(c.history[i].stopReason); note != "" {
+			c.renderedHistory.Write(c.Markdown.Render([]byte(note), resWidth))
+		}
 	}
 	return count
 }
 
+// recordEntryStart records that the entry at index i begins at the end of renderedHistory.
+func (c *Model) recordEntryStart(i int) {
+	start := entryStart{offset: c.renderedHistory.Len()}
+	if i > 0 {
+		prev := c.entryStarts[i-1]
+		start.line = prev.line + bytes.Count(c.renderedHistory.Bytes()[prev.offset:], []byte("\n"))
+	}
+	c.entryStarts = append(c.entryStarts[:i], start)
+}
+
+// EntryLine returns the line of the rendered chat history that the entry at index i begins on, once it has been rendered.
+func (c *Model) EntryLine(i int) (line int, ok bool) {
+	if i < 0 || i >= len(c.entryStarts) || i >= c.numChatsRendered {
+		return 0, false
+	}
+	return c.entryStarts[i].line, true
+}
+
+// ResponseLine returns the line of the rendered chat history that the response of the entry at index i begins on, once it has
+// been rendered.
+func (c *Model) ResponseLine(i int) (line int, ok bool) {
+	if _, ok := c.EntryLine(i); !ok {
+		return 0, false
+	}
+	return c.entryStarts[i].responseLine, true
+}
+
+// EntryAt returns the index of the rendered entry that the line of the rendered chat history is part of, or -1 if it is
+// before the first entry.
+func (c *Model) EntryAt(line int) int {
+	n := min(c.numChatsRendered, len(c.entryStarts))
+	i, _ := slices.BinarySearchFunc(c.entryStarts[:n], line+1, func(start entryStart, target int) int {
+		return cmp.Compare(start.line, target)
+	})
+	return i - 1
+}
+
+// renderQueue renders the queued prompts as faded prompt bubbles, with a hint on how to edit them.
+func (c *Model) renderQueue(vpWidth int) string {
+	maxPromptWidth := int(float64(vpWidth) * styles.WIDTH_PROPORTION_PROMPT)
+	marginText := lipgloss.NewStyle().Width(vpWidth - maxPromptWidth).Render("")
+	promptStyle := lipgloss.NewStyle().Inherit(styles.ChatStyles.QueuedPromptText).Width(maxPromptWidth)
+
+	var b strings.Builder
+	for i := range c.queue {
+		b.WriteString("\n")
+		b.WriteString(c.queue[i].formattedPrompt(marginText, promptStyle, maxPromptWidth))
+		if len(c.queue[i].attachments) > 0 {
+			b.WriteString("\n")
+			b.WriteString(c.queue[i].formattedAttachments(vpWidth))
+		}
+	}
+	b.WriteString("\n")
+	b.WriteString(styles.ChatStyles.Hint.Render(
+		fmt.Sprintf("%d queued · ctrl+o edit · ctrl+x remove", len(c.queue)),
+	))
+	b.WriteString("\n")
+	return b.String()
+}
+
+// Enqueue adds a prompt and its attachments to be sent once the current response completes.
+func (c *Model) Enqueue(prompt string, files []attachments.File) {
+	c.queue = append(c.queue, Entry{prompt: prompt, attachments: files})
+}
+
+// Dequeue removes and returns the oldest queued prompt and its attachments.
+func (c *Model) Dequeue() (prompt string, files []attachments.File, ok bool) {
+	if len(c.queue) == 0 {
+		return "", nil, false
+	}
+	entry := c.queue[0]
+	c.queue = c.queue[1:]
+	return entry.prompt, entry.attachments, true
+}
+
+// Unqueue removes and returns the most recently queued prompt and its attachments, so that it can be edited or discarded.
+func (c *Model) Unqueue() (prompt string, files []attachments.File, ok bool) {
+	if len(c.queue) == 0 {
+		return "", nil, false
+	}
+	entry := c.queue[len(c.queue)-1]
+	c.queue = c.queue[:len(c.queue)-1]
+	return entry.prompt, entry.attachments, true
+}
+
+// ClearQueue discards all queued prompts.
+func (c *Model) ClearQueue() {
+	c.queue = nil
+}
+
+// QueueLen returns the number of queued prompts.
+func (c *Model) QueueLen() int {
+	return len(c.queue)
+}
+
 // Clear clears the chat history.
 func (c *Model) Clear() {
 	// TODO: save unsaved history in temporary sqlite DB or in-memory for accidental clears
 	c.history = make([]Entry, 0, 10)
+	c.queue = nil
 	c.numChatsRendered = 0
+	c.entryStarts = nil
 	c.renderedHistory.Reset()
 }
 
@@ -0,0 +1,195 @@
+package internal
+
+import (
+	tea "charm.land/bubbletea/v2"
+	"github.com/gregriff/ducky/internal/chat"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/gregriff/ducky/internal/stream"
+)
+
+// conversation is the state of one chat: its history, the model it is with, its request and its session. The shown
+// conversation's fields are promoted into model; the others keep streaming in the background until they are shown again.
+type conversation struct {
+	llm         models.LLM
+	chat        *chat.Model
+	stream      stream.Controller
+	retryStatus *models.RetryStatus // set while waiting to retry a failed request
+
+	preventScrollToBottom bool
+	contextUsage          float64 // fraction of the model's context window used by the chat history
+	chatCost              string  // the LLM is only read between requests, since the request's goroutine updates it
+
+	// recorded for /export and saved to the session store if there is one, see sessions.go
+	session *session.Session
+
+	// where the viewport was scrolled to when another conversation was shown
+	yOffset  int
+	atBottom bool
+
+	unseen bool // a response completed while the conversation was in the background
+}
+
+// newConversation returns an empty conversation with a new client of the model ducky was run with.
+func (m *model) newConversation() (*conversation, error) {
+	llm, err := InitLLMClient(m.modelName, m.systemPrompt, m.maxTokens)
+	if err != nil {
+		return nil, err
+	}
+	return &conversation{
+		llm:      llm,
+		chat:     chat.NewChatModel(m.glamourStyle),
+		atBottom: true,
+	}, nil
+}
+
+// empty returns whether nothing has been sent in the conversation yet.
+func (c *conversation) empty() bool {
+	return c.chat.HistoryLen() == 0 && !c.stream.Active()
+}
+
+// title names the conversation in the sidebar.
+func (c *conversation) title() string {
+	if c.session == nil || c.session.Title == "" {
+		return "new chat"
+	}
+	return c.session.Title
+}
+
+// showConversation shows c in place of the current conversation, scrolled to where it was left.
+func (m *model) showConversation(c *conversation) tea.Cmd {
+	if c == m.conversation {
+		return nil
+	}
+	m.closeFind()
+	m.yOffset, m.atBottom = m.viewport.YOffset(), m.viewport.AtBottom()
+	wasStreaming := m.stream.Active()
+
+	m.conversation = c
+	c.unseen = false
+	m.forceHeaderRefresh = true
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	if c.atBottom {
+		m.viewport.GotoBottom()
+	} else {
+		m.viewport.SetYOffset(c.yOffset)
+	}
+
+	cmds := []tea.Cmd{m.scheduleTokenCount(), m.redraw()} // the header's height may change with the retry status
+	if c.stream.Active() && !wasStreaming {
+		cmds = append(cmds, m.spinner.Tick) // the spinner stops ticking while the shown conversation isn't streaming
+	}
+	return tea.Batch(cmds...)
+}
+
+// newChat opens a new conversation and shows it, unless the shown one is still empty.
+func (m *model) newChat() tea.Cmd {
+	if m.empty() {
+		return nil
+	}
+	c, err := m.newConversation()
+	if err != nil {
+		m.setNotice(err.Error())
+		return nil
+	}
+	m.conversations = append(m.conversations, c)
+	return m.showConversation(c)
+}
+
+// closeChat closes the shown conversation, cancelling its request, and shows the one listed after it. Closing the last
+// conversation leaves an empty one.
+func (m *model) closeChat() tea.Cmd {
+	if len(m.conversations) == 1 {
+		if m.empty() {
+			return nil
+		}
+		c, err := m.newConversation()
+		if err != nil {
+			m.setNotice(err.Error())
+			return nil
+		}
+		m.conversations = append(m.conversations, c)
+	}
+	closed := m.conversation
+	closed.stream.Cancel() // its Result is dropped, since no conversation accepts it
+	i := m.conversationIndex(closed)
+	m.conversations = append(m.conversations[:i], m.conversations[i+1:]...)
+	cmd := m.showConversation(m.conversations[min(i, len(m.conversations)-1)])
+	return tea.Batch(cmd, m.loadRecent()) // the closed session is listed as a recent one
+}
+
+// conversationIndex returns the index of c in the sidebar, or -1 if it has been closed.
+func (m *model) conversationIndex(c *conversation) int {
+	for i, open := range m.conversations {
+		if open == c {
+			return i
+		}
+	}
+	return -1
+}
+
+// openSession shows a saved session: the conversation it is open in, the shown conversation if nothing has been sent in it
+// yet, or a new conversation that resumes it.
+func (m *model) openSession(s *session.Session) tea.Cmd {
+	for _, c := range m.conversations {
+		if c.session != nil && c.session.ID == s.ID {
+			return m.showConversation(c)
+		}
+	}
+	var cmd tea.Cmd
+	if !m.empty() {
+		c, err := m.newConversation()
+		if err != nil {
+			m.setNotice(err.Error())
+			return nil
+		}
+		m.conversations = append(m.conversations, c)
+		cmd = m.showConversation(c)
+	}
+	m.resumeSession(s)
+	m.forceHeaderRefresh = true
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	m.viewport.GotoBottom()
+	return tea.Batch(cmd, m.loadRecent())
+}
+
+// updateInBackground handles a message of a request of a conversation that isn't shown, and returns the command that waits
+// for the request's next message. Messages of requests that were replaced, or whose conversation was closed, are dropped.
+func (m *model) updateInBackground(msg tea.Msg) tea.Cmd {
+	for _, c := range m.conversations {
+		if c == m.conversation || !c.stream.Accept(msg) {
+			continue
+		}
+
+		// the chat history, the session and the request are the conversation's own, so the methods that update them are
+		// reused with the conversation in place of the shown one
+		shown := m.conversation
+		m.conversation = c
+		defer func() { m.conversation = shown }()
+
+		switch msg := msg.(type) {
+		case stream.Chunk:
+			m.retryStatus = nil
+			if m.stream.State() != stream.Cancelling {
+				m.chat.AccumulateStream(msg.Content, msg.Reasoning, false)
+			}
+			return m.stream.Wait()
+		case stream.Retry:
+			m.retryStatus = &msg.RetryStatus
+			return m.stream.Wait()
+		case stream.Result:
+			m.retryStatus = nil
+			m.preventScrollToBottom = false
+			cmds := []tea.Cmd{m.finishResponse(msg)}
+			c.unseen = true
+			if msg.Err == nil && !msg.Cancelled {
+				if queued, files, ok := m.chat.Dequeue(); ok {
+					cmds = append(cmds, m.startRequest(queued, files))
+				}
+			}
+			return tea.Batch(cmds...)
+		}
+		return nil
+	}
+	return nil
+}
@@ -0,0 +1,178 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/chat"
+	styles "github.com/gregriff/ducky/internal/styles"
+)
+
+// findBar is the query of ctrl+s, which finds text in the chat history. While the query is being edited, typing goes to it
+// rather than to the textarea; once it is entered, n and N jump between the matches.
+type findBar struct {
+	query   string
+	editing bool
+}
+
+// openFind shows the find bar, or goes back to editing its query.
+func (m *model) openFind() tea.Cmd {
+	if m.stream.Active() {
+		m.setNotice("wait for the response to finish to find text in the chat")
+		return nil
+	}
+	if m.chat.HistoryLen() == 0 {
+		return nil
+	}
+	prevHeight := m.accessoryHeight()
+	if m.find == nil {
+		m.find = &findBar{}
+	}
+	m.find.editing = true
+	m.textarea.Blur()
+	m.fitAccessories(prevHeight)
+	return nil
+}
+
+// closeFind hides the find bar and its highlights, and gives the focus back to the textarea.
+func (m *model) closeFind() tea.Cmd {
+	if m.find == nil {
+		return nil
+	}
+	prevHeight := m.accessoryHeight()
+	m.find = nil
+	m.chat.SetFindQuery("")
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	m.fitAccessories(prevHeight)
+	return m.textarea.Focus()
+}
+
+// handleFindKey edits the find query, or jumps between its matches, and returns whether the key was used.
+func (m *model) handleFindKey(msg tea.KeyPressMsg) (handled bool, cmd tea.Cmd) {
+	f := m.find
+	if f == nil {
+		return false, nil
+	}
+	keyString := msg.String()
+	switch keyString {
+	case "esc":
+		return true, m.closeFind()
+	case "ctrl+c", "ctrl+d":
+		return false, nil
+	}
+
+	if !f.editing {
+		switch keyString {
+		case "n":
+			m.jumpToMatch(true)
+		case "N", "shift+n":
+			m.jumpToMatch(false)
+		case "/", "ctrl+s":
+			f.editing = true
+		case "enter":
+			return true, m.closeFind()
+		default:
+			return false, nil
+		}
+		return true, nil
+	}
+
+	query := []rune(f.query)
+	switch {
+	case keyString == "enter":
+		f.editing = false
+		return true, nil
+	case keyString == "backspace":
+		if len(query) > 0 {
+			query = query[:len(query)-1]
+		}
+	case keyString == "ctrl+u":
+		query = nil
+	case msg.Text != "":
+		query = append(query, []rune(msg.Text)...)
+	default:
+		return true, nil
+	}
+	if string(query) != f.query {
+		f.query = string(query)
+		m.findQueryChanged()
+	}
+	return true, nil
+}
+
+// findQueryChanged highlights the matches of the new query, and jumps to the first one at or below the top of the viewport.
+func (m *model) findQueryChanged() {
+	m.chat.SetFindQuery(m.find.query)
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	m.jumpToMatch(true)
+}
+
+// jumpToMatch selects the next or previous match, scrolling the viewport to it if it is out of view. If the selected match
+// was scrolled out of view, the search continues from the top of the viewport, or from its bottom when going back.
+func (m *model) jumpToMatch(forward bool) {
+	matches := m.chat.FindMatches()
+	if len(matches) == 0 {
+		return
+	}
+	top, height := m.viewport.YOffset(), m.viewport.Height()
+	visible := func(match chat.Match) bool {
+		return match.Line >= top && match.Line < top+height
+	}
+
+	i := m.chat.SelectedMatch()
+	switch {
+	case i >= 0 && visible(matches[i]) && forward:
+		i = (i + 1) % len(matches)
+	case i >= 0 && visible(matches[i]):
+		i = (i - 1 + len(matches)) % len(matches)
+	case forward:
+		i = 0
+		for j, match := range matches {
+			if match.Line >= top {
+				i = j
+				break
+			}
+		}
+	default:
+		i = len(matches) - 1
+		for j := len(matches) - 1; j >= 0; j-- {
+			if matches[j].Line < top+height {
+				i = j
+				break
+			}
+		}
+	}
+
+	m.chat.SelectMatch(i)
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	if !visible(matches[i]) {
+		m.viewport.SetYOffset(matches[i].Line - height/3)
+	}
+}
+
+// findLine renders the find bar for accessoryView.
+func (m *model) findLine(width int) string {
+	f := m.find
+	query := f.query
+	if f.editing {
+		query += "▏"
+	}
+	var status []string
+	if f.query != "" {
+		if matches := m.chat.FindMatches(); len(matches) > 0 {
+			status = append(status, fmt.Sprintf("%d/%d", m.chat.SelectedMatch()+1, len(matches)))
+		} else {
+			status = append(status, "no matches")
+		}
+	}
+	if f.editing {
+		status = append(status, "enter done · esc close")
+	} else {
+		status = append(status, "n next · N previous · / edit · esc close")
+	}
+	line := styles.TUIStyles.CompleterSelected.Render("  find: "+query) + "  " +
+		styles.TUIStyles.Completer.Render(strings.Join(status, " · "))
+	return ansi.Truncate(line, max(1, width), "…")
+}
@@ -0,0 +1,56 @@
+package internal
+
+import "fmt"
+
+// currentEntry returns the index of the entry being read: the one at the top of the viewport, or the last one once the chat
+// is scrolled to the bottom. It returns -1 if there are no rendered entries.
+func (m *model) currentEntry() int {
+	if m.viewport.AtBottom() && m.viewport.YOffset() > 0 {
+		return m.chat.EntryAt(m.viewport.TotalLineCount())
+	}
+	return m.chat.EntryAt(m.viewport.YOffset())
+}
+
+// entryPosition describes which entry is being read, e.g. "entry 4/12", for the header. It is empty unless there is more than
+// one entry to move between.
+func (m *model) entryPosition() string {
+	if m.stream.Active() || m.chat.HistoryLen() < 2 {
+		return ""
+	}
+	return fmt.Sprintf("entry %d/%d", max(0, m.currentEntry())+1, m.chat.HistoryLen())
+}
+
+// jumpToPrompt scrolls the viewport to the start of the next or previous prompt. Going back from within an entry first
+// goes to the start of its own prompt, and going past the first or last entry goes to the top or bottom of the chat.
+func (m *model) jumpToPrompt(forward bool) {
+	if m.stream.Active() {
+		return
+	}
+	top := m.viewport.YOffset()
+	target := m.chat.EntryAt(top)
+	if forward {
+		target++
+	} else if line, ok := m.chat.EntryLine(target); !ok || line >= top {
+		target--
+	}
+
+	line, ok := m.chat.EntryLine(target)
+	switch {
+	case ok:
+		m.viewport.SetYOffset(line)
+	case forward:
+		m.viewport.GotoBottom()
+	default:
+		m.viewport.GotoTop()
+	}
+}
+
+// jumpToResponse scrolls the viewport to the start of the current entry's response.
+func (m *model) jumpToResponse() {
+	if m.stream.Active() {
+		return
+	}
+	if line, ok := m.chat.ResponseLine(m.currentEntry()); ok {
+		m.viewport.SetYOffset(line)
+	}
+}
@@ -0,0 +1,101 @@
+package internal
+
+import (
+	"strings"
+	"unicode"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/gregriff/ducky/internal/attachments"
+)
+
+const (
+	mentionFileLimit  = 20_000 // files listed for completion, so that a huge tree can't stall the TUI
+	mentionMatchLimit = 8      // completions shown at once
+)
+
+// mentionCompleter suggests files to attach for the @ mention being typed at the end of the prompt.
+type mentionCompleter struct {
+	start    int      // byte offset of the @ in the textarea's value
+	files    []string // the files under the working directory, listed when the @ was typed
+	matches  []string
+	selected int
+	hidden   bool // dismissed with esc, until a new mention is started
+}
+
+// mentionAt returns the @ mention at the end of text: an @ at the start of the text or after whitespace, followed by a query
+// without whitespace.
+func mentionAt(text string) (start int, query string, ok bool) {
+	start = strings.LastIndexByte(text, '@')
+	if start == -1 {
+		return 0, "", false
+	}
+	query = text[start+1:]
+	if strings.ContainsFunc(query, unicode.IsSpace) {
+		return 0, "", false
+	}
+	if start > 0 && !unicode.IsSpace(rune(text[start-1])) {
+		return 0, "", false // e.g. an email address
+	}
+	return start, query, true
+}
+
+// updateMention opens, filters or closes the completer to match the mention at the end of the prompt. It should be called
+// whenever the user edits the textarea.
+func (m *model) updateMention() {
+	start, query, ok := mentionAt(m.textarea.Value())
+	if !ok {
+		m.completer = nil
+		return
+	}
+	if m.completer == nil || m.completer.start != start {
+		files, err := attachments.ListFiles(m.workDir, mentionFileLimit)
+		if err != nil {
+			m.attachError = err.Error()
+		}
+		m.completer = &mentionCompleter{start: start, files: files}
+	}
+	m.completer.matches = attachments.MatchFiles(m.completer.files, query, mentionMatchLimit)
+	m.completer.selected = 0
+}
+
+// handleCompleterKey handles the keys that navigate the completer while it is open, and returns whether the key was used.
+func (m *model) handleCompleterKey(keyString string) (handled bool, cmd tea.Cmd) {
+	c := m.completer
+	if c == nil || c.hidden {
+		return false, nil
+	}
+	switch keyString {
+	case "up", "ctrl+p":
+		if len(c.matches) > 0 {
+			c.selected = (c.selected - 1 + len(c.matches)) % len(c.matches)
+		}
+	case "down", "ctrl+n":
+		if len(c.matches) > 0 {
+			c.selected = (c.selected + 1) % len(c.matches)
+		}
+	case "tab", "enter":
+		if len(c.matches) == 0 {
+			return keyString == "tab", nil // enter still sends the prompt
+		}
+		return true, m.acceptMention()
+	case "esc":
+		prevHeight := m.accessoryHeight()
+		c.hidden = true
+		m.fitAccessories(prevHeight)
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// acceptMention attaches the selected file and removes its mention from the prompt.
+func (m *model) acceptMention() tea.Cmd {
+	prevHeight := m.accessoryHeight()
+	c := m.completer
+	path := c.matches[c.selected]
+	m.completer = nil
+	m.textarea.SetValue(m.textarea.Value()[:c.start])
+	m.attachFile(path)
+	m.fitAccessories(prevHeight)
+	return m.scheduleTokenCount()
+}
@@ -13,10 +13,16 @@ type ModelConfig struct {
 	models.Pricing
 
 	// official ID from anthropic's API
-	ID       string
-	Thinking *bool
+	ID            string
+	Thinking      *bool
+	Vision        *bool // accepts image parts
+	Documents     *bool // accepts document parts, rather than only their extracted text
+	ContextWindow int   // total tokens (input + output) the model can attend to
 }
 
+// SummaryModelName is the cheap model used to summarize old chat history when it outgrows the context window.
+const SummaryModelName = "haiku"
+
 // AnthropicModelConfigurations is a map of Anthropic model names to properties about those models. Not to be modified.
 var AnthropicModelConfigurations = map[string]ModelConfig{
 	"sonnet": {
@@ -25,7 +31,10 @@ var AnthropicModelConfigurations = map[string]ModelConfig{
 			PromptCost:   3. / 1_000_000,
 			ResponseCost: 15. / 1_000_000,
 		},
-		Thinking: models.BoolPtr(true),
+		Thinking:      models.BoolPtr(true),
+		Vision:        models.BoolPtr(true),
+		Documents:     models.BoolPtr(true),
+		ContextWindow: 200_000,
 	},
 	"haiku": {
 		ID: "claude-haiku-4-5",
@@ -33,6 +42,9 @@ var AnthropicModelConfigurations = map[string]ModelConfig{
 			PromptCost:   1. / 1_000_000,
 			ResponseCost: 5. / 1_000_000,
 		},
+		Vision:        models.BoolPtr(true),
+		Documents:     models.BoolPtr(true),
+		ContextWindow: 200_000,
 	},
 	"opus": {
 		ID: "claude-opus-4-6",
@@ -40,7 +52,10 @@ var AnthropicModelConfigurations = map[string]ModelConfig{
 			PromptCost:   5. / 1_000_000,
 			ResponseCost: 25. / 1_000_000,
 		},
-		Thinking: models.BoolPtr(true),
+		Thinking:      models.BoolPtr(true),
+		Vision:        models.BoolPtr(true),
+		Documents:     models.BoolPtr(true),
+		ContextWindow: 200_000,
 	},
 }
 
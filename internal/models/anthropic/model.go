@@ -3,8 +3,11 @@ package anthropic
 import (
 	"context"
 	"errors"
+	"net/http"
+	"strings"
 
 	anthropic "github.com/anthropics/anthropic-sdk-go"
+	"github.com/anthropics/anthropic-sdk-go/option"
 	"github.com/gregriff/ducky/internal/models"
 )
 
@@ -19,11 +22,16 @@ type Model struct {
 	// price in dollars. getter should fmt it to cents if small enough. should
 	// be updated after each response stream completes, using current model's pricing.
 	// should be reset on clear
-	totalCost float64
+	totalCost    float64
+	lastResponse models.ResponseInfo
+	sideUsage    models.SideUsage // of summaries, added to the next response
 }
 
-// NewModel creates a new Anthropic Model to be used for response streaming.
-func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages *[]models.Message) *Model {
+// defaultOptions turns off the SDK's own retries, see models.StreamPromptCompletionWithRetry.
+var defaultOptions = []option.RequestOption{option.WithMaxRetries(0)}
+
+// NewModel creates a new Anthropic Model to be used for response streaming, with options for its SDK client.
+func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages *[]models.Message, opts ...option.RequestOption) *Model {
 	// allow message history to persist when user changes model being used
 	var messages []models.Message
 	if pastMessages != nil {
@@ -39,31 +47,26 @@ func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages
 			Messages:     messages,
 			PromptCount:  0, // TODO: ensure total usage cost is persisted between model changes
 		},
-		Client:             anthropic.NewClient(), // by default uses os.LookupEnv("ANTHROPIC_API_KEY") TODO: use viper config var
+		Client:             anthropic.NewClient(append(defaultOptions, opts...)...), // by default uses os.LookupEnv("ANTHROPIC_API_KEY") TODO: use viper config var
 		ModelConfig:        AnthropicModelConfigurations[modelName],
 		SystemPromptObject: []anthropic.TextBlockParam{{Text: systemPrompt}},
 	}
 }
 
-func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string, enableThinking bool, _ *uint8, responseChan chan models.StreamChunk) error {
+func (llm *Model) DoStreamPromptCompletion(ctx context.Context, prompt models.Message, enableThinking bool, _ *uint8, responseChan chan models.StreamChunk) error {
 	defer close(responseChan)
 
 	var (
 		// per-prompt properties (user will be able to change these at any time)
-		maxTokens         int64
-		thinking          anthropic.ThinkingConfigParamUnion
-		thinkingSupported *bool
+		maxTokens int64
+		thinking  anthropic.ThinkingConfigParamUnion
 	)
 
-	maxTokens = int64(llm.MaxTokens)
+	thinkingEnabled := llm.DoesSupportReasoning() && enableThinking
+	maxTokens = llm.outputTokenBudget(thinkingEnabled)
 	fullResponseText := ""
-	if thinkingSupported = llm.ModelConfig.Thinking; thinkingSupported != nil && *thinkingSupported && enableThinking {
-		thinking = anthropic.ThinkingConfigParamOfEnabled(maxTokens)
-		if maxTokens <= 1024 { // https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#max-tokens-and-context-window-size
-			maxTokens = 2048
-		} else {
-			maxTokens *= 2
-		}
+	if thinkingEnabled {
+		thinking = anthropic.ThinkingConfigParamOfEnabled(int64(llm.MaxTokens))
 	} else {
 		disabled := anthropic.NewThinkingConfigDisabledParam()
 		thinking = anthropic.ThinkingConfigParamUnion{OfDisabled: &disabled}
@@ -73,25 +76,38 @@ func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string,
 		Model:     anthropic.Model(llm.ModelConfig.ID),
 		System:    llm.SystemPromptObject,
 		MaxTokens: maxTokens,
-		Messages:  llm.buildMessages(content),
+		Messages:  llm.buildMessages(llm.Messages, prompt),
 		Thinking:  thinking,
 	})
 
 	message := anthropic.Message{}
 	message.Content = make([]anthropic.ContentBlockUnion, maxTokens/4) // preallocate cuz why not
-	inputTokens, outputTokens := 0., 0.
+	llm.lastResponse = models.ResponseInfo{ModelID: llm.ModelConfig.ID}
+	completed := false
+	defer func() {
+		// message has accumulated usage from message_start and message_delta events, even if the stream failed
+		llm.lastResponse.Usage = models.Usage{
+			InputTokens:  int(message.Usage.InputTokens),
+			OutputTokens: int(message.Usage.OutputTokens),
+		}
+		llm.lastResponse.Cost = llm.ModelConfig.Cost(llm.lastResponse.Usage)
+		llm.totalCost += llm.lastResponse.Cost
+		if completed { // after the response's own usage is set. Side usage is already in totalCost
+			llm.sideUsage.ApplyTo(&llm.lastResponse)
+		}
+	}()
+
 	for stream.Next() {
 		event := stream.Current()
 		err := message.Accumulate(event)
 		if err != nil {
-			return errors.New(stream.Err().Error())
+			return err //nolint:wrapcheck // a malformed event, which the user can't do anything about
 		}
 
 		switch eventVariant := event.AsAny().(type) {
 		case anthropic.ContentBlockDeltaEvent:
 			switch deltaVariant := eventVariant.Delta.AsAny().(type) {
 			case anthropic.ThinkingDelta:
-				fullResponseText += deltaVariant.Thinking
 				responseChan <- models.StreamChunk{Reasoning: true, Content: deltaVariant.Thinking}
 			case anthropic.TextDelta:
 				fullResponseText += deltaVariant.Text
@@ -101,56 +117,189 @@ func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string,
 				responseChan <- models.StreamChunk{Reasoning: false, Content: deltaVariant.Citation.CitedText}
 			}
 		case anthropic.MessageDeltaEvent:
-			inputTokens += float64(eventVariant.Usage.InputTokens)
-			outputTokens += float64(eventVariant.Usage.OutputTokens)
+			llm.lastResponse.StopReason = stopReason(eventVariant.Delta.StopReason)
 		}
 	}
 
 	if stream.Err() != nil {
-		return errors.New(stream.Err().Error())
+		return apiError(stream.Err())
 	}
 
-	inputCost := llm.ModelConfig.PromptCost * inputTokens
-	outputCost := llm.ModelConfig.ResponseCost * outputTokens
-	llm.totalCost += inputCost + outputCost
-
 	// update state
 	llm.PromptCount++
+	completed = true
 
 	if len(fullResponseText) > 0 {
-		llm.Messages = append(llm.Messages, models.Message{Role: "assistant", Content: fullResponseText})
+		llm.Messages = append(llm.Messages,
+			prompt,
+			models.Message{Role: "assistant", Content: fullResponseText},
+		)
 	}
 	return nil
 }
 
+// outputTokenBudget returns the max_tokens parameter of a request. Thinking tokens count towards this budget, so it is
+// increased when thinking is enabled.
+func (llm *Model) outputTokenBudget(thinkingEnabled bool) int64 {
+	maxTokens := int64(llm.MaxTokens)
+	if !thinkingEnabled {
+		return maxTokens
+	}
+	if maxTokens <= 1024 { // https://docs.anthropic.com/en/docs/build-with-claude/extended-thinking#max-tokens-and-context-window-size
+		return 2048
+	}
+	return maxTokens * 2
+}
+
+// errorTypes maps the error types of Anthropic's error responses and mid-stream error events to an ErrorKind, and the HTTP
+// status they are sent with (https://docs.anthropic.com/en/api/errors).
+var errorTypes = map[string]struct {
+	kind   models.ErrorKind
+	status int
+}{
+	"authentication_error": {models.ErrorAuth, http.StatusUnauthorized},
+	"permission_error":     {models.ErrorAuth, http.StatusForbidden},
+	"not_found_error":      {models.ErrorInvalidModel, http.StatusNotFound},
+	"request_too_large":    {models.ErrorContextTooLong, http.StatusRequestEntityTooLarge},
+	"rate_limit_error":     {models.ErrorRateLimit, http.StatusTooManyRequests},
+	"api_error":            {models.ErrorUnknown, http.StatusInternalServerError},
+	"overloaded_error":     {models.ErrorOverloaded, 529},
+}
+
+// apiError converts an error from the SDK into a models.APIError, so that callers can tell whether to retry and what to tell
+// the user. Errors that didn't come from the API, such as network errors, are returned as-is.
+func apiError(err error) error {
+	apiErr := &models.APIError{Provider: "anthropic", Err: err}
+	var sdkErr *anthropic.Error
+	if errors.As(err, &sdkErr) {
+		apiErr.StatusCode = sdkErr.StatusCode
+		apiErr.Kind = models.KindFromStatus(sdkErr.StatusCode)
+		if sdkErr.Response != nil {
+			apiErr.RetryAfter = models.ParseRetryAfter(sdkErr.Response.Header)
+		}
+	}
+
+	message := err.Error()
+	for errorType, e := range errorTypes {
+		if strings.Contains(message, `"type":"`+errorType+`"`) {
+			apiErr.Kind = e.kind
+			if apiErr.StatusCode == 0 { // error events sent mid-stream have no status
+				apiErr.StatusCode = e.status
+			}
+			break
+		}
+	}
+	if apiErr.StatusCode == 0 {
+		return err
+	}
+	if models.IsContextTooLongMessage(message) { // sent as an invalid_request_error
+		apiErr.Kind = models.ErrorContextTooLong
+	}
+	return apiErr
+}
+
+// stopReason converts Anthropic's stop reason into the provider-agnostic models.StopReason.
+func stopReason(reason anthropic.StopReason) models.StopReason {
+	switch reason {
+	case anthropic.StopReasonEndTurn, anthropic.StopReasonStopSequence:
+		return models.StopReasonEndTurn
+	case anthropic.StopReasonMaxTokens:
+		return models.StopReasonMaxTokens
+	case anthropic.StopReasonRefusal:
+		return models.StopReasonRefusal
+	case anthropic.StopReasonToolUse, anthropic.StopReasonPauseTurn:
+	}
+	return models.StopReason(reason)
+}
+
 // buildMessages takes the provider-agnostic []models.Message of the chat history and returns the Anthropic chat history data format.
-func (llm *Model) buildMessages(newContent string) []anthropic.MessageParam {
-	messages := make([]anthropic.MessageParam, 0, len(llm.Messages)+1)
+func (llm *Model) buildMessages(history []models.Message, prompt models.Message) []anthropic.MessageParam {
+	messages := make([]anthropic.MessageParam, 0, len(history)+1)
 	var msg models.Message
 
 	// Add conversation history
-	for i := range len(llm.Messages) {
-		msg = llm.Messages[i]
+	for i := range len(history) {
+		msg = history[i]
 		switch msg.Role {
 		case "user":
-			messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(msg.Content)))
+			messages = append(messages, anthropic.NewUserMessage(llm.contentBlocks(&msg)...))
 		case "assistant":
 			messages = append(messages, anthropic.NewAssistantMessage(anthropic.NewTextBlock(msg.Content)))
 		}
 	}
 
 	// Add current message
-	messages = append(messages, anthropic.NewUserMessage(anthropic.NewTextBlock(newContent)))
+	messages = append(messages, anthropic.NewUserMessage(llm.contentBlocks(&prompt)...))
 	return messages
 }
 
+// contentBlocks returns the content of a user message: a block for each of its parts, followed by its text, as Anthropic
+// recommends putting images and documents before the question about them. Documents are sent as their extracted text if the
+// model can't read them.
+func (llm *Model) contentBlocks(msg *models.Message) []anthropic.ContentBlockParamUnion {
+	blocks := make([]anthropic.ContentBlockParamUnion, 0, len(msg.Parts)+1)
+	for i := range msg.Parts {
+		part := &msg.Parts[i]
+		switch part.Kind {
+		case models.PartImage:
+			blocks = append(blocks, anthropic.NewImageBlockBase64(part.MediaType, part.Base64()))
+		case models.PartDocument:
+			if !llm.DoesSupportDocuments() {
+				blocks = append(blocks, anthropic.NewTextBlock(part.DocumentText()))
+				continue
+			}
+			block := anthropic.NewDocumentBlock(anthropic.Base64PDFSourceParam{Data: part.Base64()})
+			block.OfDocument.Title = anthropic.String(part.Name)
+			blocks = append(blocks, block)
+		}
+	}
+	if msg.Content != "" || len(blocks) == 0 { // text blocks can't be empty, but a message needs at least one block
+		blocks = append(blocks, anthropic.NewTextBlock(msg.Content))
+	}
+	return blocks
+}
+
+// DoCountTokens counts the tokens of the full request with Anthropic's token counting endpoint. The prompt is only estimated
+// locally to avoid a second request. Each count is a network request, so callers should debounce it, as the TUI does while
+// the user types.
+func (llm *Model) DoCountTokens(ctx context.Context, systemPrompt string, history []models.Message, prompt models.Message) (models.TokenCount, error) {
+	res, err := llm.Client.Messages.CountTokens(ctx, anthropic.MessageCountTokensParams{
+		Model:    anthropic.Model(llm.ModelConfig.ID),
+		System:   anthropic.MessageCountTokensParamsSystemUnion{OfTextBlockArray: []anthropic.TextBlockParam{{Text: systemPrompt}}},
+		Messages: llm.buildMessages(history, prompt),
+	})
+	if err != nil {
+		return models.TokenCount{}, apiError(err)
+	}
+	return models.TokenCount{Prompt: models.EstimateMessageTokens(&prompt), Request: int(res.InputTokens)}, nil
+}
+
 // given a cost in dollars, return a formatted string to be printed to screen
 func (llm *Model) DoGetCostOfCurrentChat() float64 {
 	return llm.totalCost
 }
 
+func (llm *Model) DoGetLastResponseInfo() models.ResponseInfo {
+	return llm.lastResponse
+}
+
+func (llm *Model) DoAddUsage(info models.ResponseInfo) {
+	llm.totalCost += info.Cost
+	llm.sideUsage.Add(info)
+}
+
+func (llm *Model) DoGetPricing() models.Pricing {
+	return llm.ModelConfig.Pricing
+}
+
+// DoGetMaxOutputTokens returns the max_tokens parameter a request is sent with, which is raised when thinking is enabled.
+func (llm *Model) DoGetMaxOutputTokens(enableReasoning bool) int {
+	return int(llm.outputTokenBudget(llm.DoesSupportReasoning() && enableReasoning))
+}
+
 func (llm *Model) DoClearChatHistory() {
 	llm.totalCost = 0
+	llm.sideUsage = models.SideUsage{}
 	llm.PromptCount = 0
 	llm.Messages = []models.Message{}
 	// TODO: reset usage
@@ -160,6 +309,19 @@ func (llm *Model) DoGetChatHistory() []models.Message {
 	return llm.Messages
 }
 
+func (llm *Model) DoSetChatHistory(messages []models.Message) {
+	llm.Messages = messages
+}
+
+func (llm *Model) DoGetSystemPrompt() string {
+	return llm.SystemPrompt
+}
+
+// DoGetContextLimit reserves room for the largest response the model could return, including thinking.
+func (llm *Model) DoGetContextLimit() int {
+	return llm.ModelConfig.ContextWindow - int(llm.outputTokenBudget(llm.DoesSupportReasoning()))
+}
+
 func (llm *Model) DoGetModelId() string {
 	return llm.ModelConfig.ID
 }
@@ -170,3 +332,17 @@ func (llm *Model) DoesSupportReasoning() bool {
 	}
 	return false
 }
+
+func (llm *Model) DoesSupportVision() bool {
+	if vision := llm.ModelConfig.Vision; vision != nil && *vision {
+		return true
+	}
+	return false
+}
+
+func (llm *Model) DoesSupportDocuments() bool {
+	if documents := llm.ModelConfig.Documents; documents != nil && *documents {
+		return true
+	}
+	return false
+}
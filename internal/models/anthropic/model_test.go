@@ -0,0 +1,227 @@
+package anthropic
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/cassette"
+)
+
+// newTestModel returns a Model whose requests are served by testdata/<name>.yaml, or recorded to it with ANTHROPIC_API_KEY set.
+func newTestModel(t *testing.T, name string) (*Model, *cassette.Recorder) {
+	t.Helper()
+	rec := cassette.NewForTest(t, name)
+	opts := []option.RequestOption{option.WithHTTPClient(rec.Client()), option.WithMaxRetries(0)}
+	if rec.Mode() == cassette.ModeReplay {
+		opts = append(opts, option.WithAPIKey("test"))
+	}
+	return NewModel("You are a test.", 1024, "sonnet", nil, opts...), rec
+}
+
+// stream prompts the model and collects the streamed chunks.
+func stream(llm *Model, prompt string, thinking bool) (reasoning, text string, err error) {
+	responseChan := make(chan models.StreamChunk)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- llm.DoStreamPromptCompletion(context.Background(), models.UserMessage(prompt), thinking, nil, responseChan)
+	}()
+
+	var reasoningBuilder, textBuilder strings.Builder
+	for chunk := range responseChan {
+		if chunk.Reasoning {
+			reasoningBuilder.WriteString(chunk.Content)
+		} else {
+			textBuilder.WriteString(chunk.Content)
+		}
+	}
+	return reasoningBuilder.String(), textBuilder.String(), <-errChan
+}
+
+func TestStreamThinking(t *testing.T) {
+	llm, rec := newTestModel(t, "thinking")
+
+	reasoning, text, err := stream(llm, "Say hello", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasoning != "The user wants a greeting." {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if text != "Hello, world!" {
+		t.Errorf("text = %q", text)
+	}
+
+	info := llm.DoGetLastResponseInfo()
+	if info.StopReason != models.StopReasonEndTurn {
+		t.Errorf("stop reason = %q", info.StopReason)
+	}
+	if info.Usage != (models.Usage{InputTokens: 21, OutputTokens: 40}) {
+		t.Errorf("usage = %+v", info.Usage)
+	}
+	if want := llm.ModelConfig.Cost(info.Usage); info.Cost != want || llm.DoGetCostOfCurrentChat() != want {
+		t.Errorf("cost = %v, total = %v, want %v", info.Cost, llm.DoGetCostOfCurrentChat(), want)
+	}
+
+	history := llm.DoGetChatHistory()
+	if len(history) != 2 || history[0].Content != "Say hello" || history[1].Content != "Hello, world!" {
+		t.Errorf("history = %+v", history)
+	}
+
+	// thinking must be enabled with a larger output budget than the thinking budget
+	var body struct {
+		MaxTokens int `json:"max_tokens"`
+		Thinking  struct {
+			Type         string `json:"type"`
+			BudgetTokens int    `json:"budget_tokens"`
+		} `json:"thinking"`
+	}
+	if err := json.Unmarshal([]byte(rec.Requests()[0].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Thinking.Type != "enabled" || body.MaxTokens <= body.Thinking.BudgetTokens {
+		t.Errorf("request max_tokens = %d, thinking = %+v", body.MaxTokens, body.Thinking)
+	}
+}
+
+func TestStreamCitations(t *testing.T) {
+	llm, _ := newTestModel(t, "citations")
+
+	_, text, err := stream(llm, "What does the document say?", false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if text != "The document says ducks can sleep with one eye open" {
+		t.Errorf("text = %q", text)
+	}
+}
+
+func TestStreamMaxTokens(t *testing.T) {
+	llm, _ := newTestModel(t, "max_tokens")
+
+	if _, _, err := stream(llm, "Tell me a story", false); err != nil {
+		t.Fatal(err)
+	}
+	if info := llm.DoGetLastResponseInfo(); info.StopReason != models.StopReasonMaxTokens {
+		t.Errorf("stop reason = %q", info.StopReason)
+	}
+}
+
+func TestStreamSideUsage(t *testing.T) {
+	llm, _ := newTestModel(t, "thinking")
+
+	// e.g. a summary of the history made before the prompt is sent
+	summary := models.ResponseInfo{Usage: models.Usage{InputTokens: 500, OutputTokens: 50}, Cost: 0.01}
+	llm.DoAddUsage(summary)
+	if _, _, err := stream(llm, "Say hello", true); err != nil {
+		t.Fatal(err)
+	}
+
+	info := llm.DoGetLastResponseInfo()
+	if info.Usage != (models.Usage{InputTokens: 21 + 500, OutputTokens: 40 + 50}) {
+		t.Errorf("usage = %+v", info.Usage)
+	}
+	responseCost := llm.ModelConfig.Cost(models.Usage{InputTokens: 21, OutputTokens: 40})
+	if want := responseCost + summary.Cost; info.Cost != want || llm.DoGetCostOfCurrentChat() != want {
+		t.Errorf("cost = %v, total = %v, want %v", info.Cost, llm.DoGetCostOfCurrentChat(), want)
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	llm, _ := newTestModel(t, "stream_error")
+
+	_, text, err := stream(llm, "Hi", false)
+	var apiErr *models.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 || apiErr.Kind != models.ErrorOverloaded {
+		t.Fatalf("err = %v", err)
+	}
+	if text != "Partial" {
+		t.Errorf("text = %q", text)
+	}
+	if len(llm.DoGetChatHistory()) != 0 {
+		t.Errorf("failed response was added to history: %+v", llm.DoGetChatHistory())
+	}
+	if usage := llm.DoGetLastResponseInfo().Usage; usage.InputTokens != 12 {
+		t.Errorf("usage of failed response = %+v", usage)
+	}
+}
+
+func TestStreamHTTPError(t *testing.T) {
+	llm, _ := newTestModel(t, "overloaded")
+
+	_, text, err := stream(llm, "Hi", false)
+	var apiErr *models.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 529 || apiErr.Kind != models.ErrorOverloaded ||
+		!models.IsRetryable(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if text != "" {
+		t.Errorf("text = %q", text)
+	}
+}
+
+func TestCountTokens(t *testing.T) {
+	llm, rec := newTestModel(t, "count_tokens")
+	llm.DoSetChatHistory([]models.Message{{Role: "user", Content: "Hi"}, {Role: "assistant", Content: "Hello, duck!"}})
+
+	// the history is counted as it was when the count was started, even if it is cleared while the count is in flight
+	counter := models.SnapshotTokenCounter(llm)
+	llm.DoClearChatHistory()
+	count, err := counter(context.Background(), models.UserMessage("How many tokens is this?"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count.Request != 42 {
+		t.Errorf("request tokens = %d", count.Request)
+	}
+	if requests := rec.Requests(); len(requests) != 1 || !strings.Contains(requests[0].Body, "Hello, duck!") {
+		t.Errorf("requests = %+v, want the history that was counted", requests)
+	}
+}
+
+func TestBuildMessagesImage(t *testing.T) {
+	llm := NewModel("", 1024, "sonnet", nil)
+	image := models.Part{Kind: models.PartImage, Name: "dot.png", MediaType: "image/png", Data: []byte("\x89PNG")}
+	body, err := json.Marshal(llm.buildMessages(nil, models.UserMessage("What is this?", image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"content":[{"source":{"data":"iVBORw==","media_type":"image/png","type":"base64"},"type":"image"},` +
+		`{"text":"What is this?","type":"text"}],"role":"user"}]`
+	if string(body) != want {
+		t.Errorf("messages = %s", body)
+	}
+}
+
+func TestBuildMessagesDocument(t *testing.T) {
+	document := models.Part{Kind: models.PartDocument, Name: "spec.pdf", MediaType: models.DocumentMediaType, Data: []byte("%PDF"),
+		Text: "Pin 1: VCC", Pages: 1}
+	prompt := models.UserMessage("Which pin is VCC?", document)
+
+	body, err := json.Marshal(NewModel("", 1024, "sonnet", nil).buildMessages(nil, prompt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"content":[{"source":{"data":"JVBERg==","media_type":"application/pdf","type":"base64"},"title":"spec.pdf","type":"document"},` +
+		`{"text":"Which pin is VCC?","type":"text"}],"role":"user"}]`
+	if string(body) != want {
+		t.Errorf("messages = %s", body)
+	}
+
+	// models that can't read documents are sent their text
+	llm := NewModel("", 1024, "haiku", nil)
+	llm.ModelConfig.Documents = nil
+	body, err = json.Marshal(llm.buildMessages(nil, prompt))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want = `[{"content":[{"text":"\u003cdocument name=\"spec.pdf\" pages=\"1\"\u003e\nPin 1: VCC\n\u003c/document\u003e","type":"text"},` +
+		`{"text":"Which pin is VCC?","type":"text"}],"role":"user"}]`
+	if string(body) != want {
+		t.Errorf("messages = %s", body)
+	}
+}
@@ -0,0 +1,229 @@
+// Package cassette records HTTP interactions with LLM APIs to YAML files, and replays them, so that providers can be tested
+// without network access or API keys.
+package cassette
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"go.yaml.in/yaml/v3"
+)
+
+// RecordEnv is the environment variable that switches tests from replaying cassettes to recording them against the real APIs.
+const RecordEnv = "DUCKY_RECORD_CASSETTES"
+
+// Mode determines whether a Recorder replays or records interactions.
+type Mode int
+
+const (
+	ModeReplay Mode = iota // serve responses from the cassette file, and fail on any request it doesn't contain
+	ModeRecord             // send requests to the real API and save the interactions to the cassette file
+)
+
+// ModeFromEnv returns ModeRecord if RecordEnv is set, and ModeReplay otherwise.
+func ModeFromEnv() Mode {
+	if os.Getenv(RecordEnv) != "" {
+		return ModeRecord
+	}
+	return ModeReplay
+}
+
+// recordedHeaders are the only response headers saved to cassettes. Everything else, which may identify an account, is dropped.
+var recordedHeaders = []string{"Content-Type", "Retry-After", "X-Should-Retry"}
+
+// Cassette is the contents of a cassette file.
+type Cassette struct {
+	Interactions []Interaction `yaml:"interactions"`
+}
+
+// Interaction is a single request and the response it received.
+type Interaction struct {
+	Request  Request  `yaml:"request"`
+	Response Response `yaml:"response"`
+}
+
+// Request is a recorded request. Its headers are never recorded, since they contain API keys.
+type Request struct {
+	Method string `yaml:"method"`
+	URL    string `yaml:"url"`
+	Body   string `yaml:"body,omitempty"`
+}
+
+// Response is a recorded response. SSE bodies are stored verbatim.
+type Response struct {
+	Status  int               `yaml:"status"`
+	Headers map[string]string `yaml:"headers,omitempty"`
+	Body    string            `yaml:"body"`
+}
+
+// Recorder is an http.RoundTripper that replays or records the interactions of a cassette file.
+type Recorder struct {
+	path string
+	mode Mode
+	real http.RoundTripper
+
+	mu       sync.Mutex
+	cassette Cassette
+	next     int       // index of the next interaction to replay
+	requests []Request // requests received, in replay and record mode
+}
+
+// New creates a Recorder for the cassette file at path. In ModeReplay the file must exist.
+func New(path string, mode Mode) (*Recorder, error) {
+	r := &Recorder{path: path, mode: mode, real: http.DefaultTransport}
+	if mode == ModeRecord {
+		return r, nil
+	}
+
+	data, err := os.ReadFile(path) //nolint:gosec // cassettes are test fixtures
+	if err != nil {
+		return nil, fmt.Errorf("reading cassette: %w", err)
+	}
+	if err := yaml.Unmarshal(data, &r.cassette); err != nil {
+		return nil, fmt.Errorf("invalid cassette %s: %w", path, err)
+	}
+	return r, nil
+}
+
+// Client returns an HTTP client that uses the Recorder as its transport.
+func (r *Recorder) Client() *http.Client {
+	return &http.Client{Transport: r}
+}
+
+// Mode returns whether the Recorder is replaying or recording.
+func (r *Recorder) Mode() Mode {
+	return r.mode
+}
+
+// Requests returns the requests the Recorder has received, so tests can check what a provider sent.
+func (r *Recorder) Requests() []Request {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return append([]Request(nil), r.requests...)
+}
+
+// RoundTrip serves the next interaction of the cassette, or records a new one.
+func (r *Recorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	recorded := Request{Method: req.Method, URL: req.URL.String()}
+	if req.Body != nil {
+		body, err := io.ReadAll(req.Body)
+		_ = req.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("reading request body: %w", err)
+		}
+		recorded.Body = string(body)
+		req.Body = io.NopCloser(bytes.NewReader(body))
+	}
+
+	r.mu.Lock()
+	r.requests = append(r.requests, recorded)
+	r.mu.Unlock()
+
+	if r.mode == ModeRecord {
+		return r.record(req, recorded)
+	}
+	return r.replay(req, recorded)
+}
+
+func (r *Recorder) replay(req *http.Request, recorded Request) (*http.Response, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.next >= len(r.cassette.Interactions) {
+		return nil, fmt.Errorf("cassette %s has no interaction left for %s %s", r.path, recorded.Method, recorded.URL)
+	}
+	interaction := r.cassette.Interactions[r.next]
+	r.next++
+	if interaction.Request.Method != recorded.Method || !sameEndpoint(interaction.Request.URL, recorded.URL) {
+		return nil, fmt.Errorf("cassette %s expected %s %s, got %s %s", r.path,
+			interaction.Request.Method, interaction.Request.URL, recorded.Method, recorded.URL)
+	}
+
+	header := make(http.Header, len(interaction.Response.Headers))
+	for key, value := range interaction.Response.Headers {
+		header.Set(key, value)
+	}
+	return &http.Response{
+		Status:        http.StatusText(interaction.Response.Status),
+		StatusCode:    interaction.Response.Status,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        header,
+		Body:          io.NopCloser(strings.NewReader(interaction.Response.Body)),
+		ContentLength: int64(len(interaction.Response.Body)),
+		Request:       req,
+	}, nil
+}
+
+func (r *Recorder) record(req *http.Request, recorded Request) (*http.Response, error) {
+	res, err := r.real.RoundTrip(req)
+	if err != nil {
+		return nil, err //nolint:wrapcheck // the provider SDK handles transport errors
+	}
+	body, err := io.ReadAll(res.Body)
+	_ = res.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("reading response body: %w", err)
+	}
+	res.Body = io.NopCloser(bytes.NewReader(body))
+
+	response := Response{Status: res.StatusCode, Headers: map[string]string{}, Body: string(body)}
+	for _, key := range recordedHeaders {
+		if value := res.Header.Get(key); value != "" {
+			response.Headers[key] = value
+		}
+	}
+
+	r.mu.Lock()
+	r.cassette.Interactions = append(r.cassette.Interactions, Interaction{Request: recorded, Response: response})
+	r.mu.Unlock()
+	return res, nil
+}
+
+// Stop saves the cassette file in ModeRecord. In ModeReplay, it returns an error if any interactions were not replayed.
+func (r *Recorder) Stop() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.mode == ModeReplay {
+		if unused := len(r.cassette.Interactions) - r.next; unused > 0 {
+			return fmt.Errorf("cassette %s has %d interaction(s) that were never requested", r.path, unused)
+		}
+		return nil
+	}
+
+	if len(r.cassette.Interactions) == 0 {
+		return errors.New("no interactions were recorded")
+	}
+	data, err := yaml.Marshal(r.cassette)
+	if err != nil {
+		return fmt.Errorf("encoding cassette: %w", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(r.path), 0o750); err != nil {
+		return fmt.Errorf("creating cassette directory: %w", err)
+	}
+	if err := os.WriteFile(r.path, data, 0o600); err != nil {
+		return fmt.Errorf("writing cassette: %w", err)
+	}
+	return nil
+}
+
+// sameEndpoint reports whether two URLs have the same path, ignoring the host and query so that cassettes still match when a
+// base URL is overridden.
+func sameEndpoint(a, b string) bool {
+	urlA, errA := url.Parse(a)
+	urlB, errB := url.Parse(b)
+	if errA != nil || errB != nil {
+		return a == b
+	}
+	return urlA.Path == urlB.Path
+}
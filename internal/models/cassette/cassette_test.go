@@ -0,0 +1,231 @@
+package cassette
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// writeCassette writes a cassette file and returns its path.
+func writeCassette(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "cassette.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+const twoInteractions = `
+interactions:
+  - request:
+      method: POST
+      url: https://api.example.com/v1/messages
+    response:
+      status: 200
+      headers:
+        Content-Type: text/event-stream
+      body: "data: first\n\n"
+  - request:
+      method: GET
+      url: https://api.example.com/v1/models
+    response:
+      status: 429
+      headers:
+        Retry-After: "2"
+      body: '{"error": "rate limited"}'
+`
+
+// do sends a request through the recorder and returns the response's body.
+func do(t *testing.T, client *http.Client, method, url, body string) (*http.Response, string) {
+	t.Helper()
+	req, err := http.NewRequest(method, url, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Set("Authorization", "Bearer secret-key")
+	res, err := client.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res, string(data)
+}
+
+func TestReplay(t *testing.T) {
+	rec, err := New(writeCassette(t, twoInteractions), ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	client := rec.Client()
+
+	// the host and query of the recorded URL don't have to match, so a base URL can be overridden
+	res, body := do(t, client, http.MethodPost, "http://localhost:8080/v1/messages?beta=true", `{"prompt": "hi"}`)
+	if res.StatusCode != http.StatusOK || res.Header.Get("Content-Type") != "text/event-stream" || body != "data: first\n\n" {
+		t.Errorf("first response = %d %v %q", res.StatusCode, res.Header, body)
+	}
+	res, body = do(t, client, http.MethodGet, "https://api.example.com/v1/models", "")
+	if res.StatusCode != http.StatusTooManyRequests || res.Header.Get("Retry-After") != "2" || body != `{"error": "rate limited"}` {
+		t.Errorf("second response = %d %v %q", res.StatusCode, res.Header, body)
+	}
+
+	requests := rec.Requests()
+	if len(requests) != 2 || requests[0].Body != `{"prompt": "hi"}` || requests[0].URL != "http://localhost:8080/v1/messages?beta=true" {
+		t.Errorf("requests = %+v", requests)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestReplayMismatch(t *testing.T) {
+	tests := []struct {
+		name    string
+		method  string
+		url     string
+		wantErr string
+	}{
+		{"method", http.MethodGet, "https://api.example.com/v1/messages", "expected POST https://api.example.com/v1/messages, got GET"},
+		{"path", http.MethodPost, "https://api.example.com/v1/responses", "expected POST https://api.example.com/v1/messages, got POST"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec, err := New(writeCassette(t, twoInteractions), ModeReplay)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req, err := http.NewRequest(tt.method, tt.url, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, err := rec.RoundTrip(req); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestReplayInteractionCount(t *testing.T) {
+	rec, err := New(writeCassette(t, twoInteractions), ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	do(t, rec.Client(), http.MethodPost, "https://api.example.com/v1/messages", "")
+	if err := rec.Stop(); err == nil || !strings.Contains(err.Error(), "1 interaction(s) that were never requested") {
+		t.Errorf("Stop with an unused interaction = %v", err)
+	}
+
+	do(t, rec.Client(), http.MethodGet, "https://api.example.com/v1/models", "")
+	req, err := http.NewRequest(http.MethodGet, "https://api.example.com/v1/models", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rec.RoundTrip(req); err == nil || !strings.Contains(err.Error(), "has no interaction left") {
+		t.Errorf("request past the end of the cassette = %v", err)
+	}
+}
+
+func TestNewErrors(t *testing.T) {
+	if _, err := New(filepath.Join(t.TempDir(), "missing.yaml"), ModeReplay); err == nil {
+		t.Error("New replayed a missing cassette")
+	}
+	if _, err := New(writeCassette(t, "interactions: {"), ModeReplay); err == nil || !strings.Contains(err.Error(), "invalid cassette") {
+		t.Errorf("New of invalid YAML = %v", err)
+	}
+	if _, err := New(filepath.Join(t.TempDir(), "new.yaml"), ModeRecord); err != nil {
+		t.Errorf("New in record mode needs no file: %v", err)
+	}
+}
+
+// TestRecordAndReplay records interactions with a local server, and checks that they replay the same without it.
+func TestRecordAndReplay(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-key" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		body, _ := io.ReadAll(r.Body)
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Set-Cookie", "session=account-cookie")
+		w.Header().Set("Request-Id", "req_account")
+		w.Header().Set("X-Should-Retry", "false")
+		_, _ = io.WriteString(w, "data: "+string(body)+"\n\n")
+	}))
+	defer server.Close()
+
+	path := filepath.Join(t.TempDir(), "testdata", "recorded.yaml")
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rec.Mode() != ModeRecord {
+		t.Fatalf("mode = %v", rec.Mode())
+	}
+	res, recordedBody := do(t, rec.Client(), http.MethodPost, server.URL+"/v1/messages", "hello")
+	if res.StatusCode != http.StatusOK || recordedBody != "data: hello\n\n" {
+		t.Fatalf("recorded response = %d %q", res.StatusCode, recordedBody)
+	}
+	if err := rec.Stop(); err != nil {
+		t.Fatal(err)
+	}
+
+	// API keys and headers that may identify an account are scrubbed
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, secret := range []string{"secret-key", "Authorization", "account-cookie", "req_account"} {
+		if strings.Contains(string(data), secret) {
+			t.Errorf("cassette contains %q:\n%s", secret, data)
+		}
+	}
+
+	server.Close() // replaying must not need the server
+	replay, err := New(path, ModeReplay)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, body := do(t, replay.Client(), http.MethodPost, server.URL+"/v1/messages", "hello")
+	if res.StatusCode != http.StatusOK || body != recordedBody {
+		t.Errorf("replayed response = %d %q, want %q", res.StatusCode, body, recordedBody)
+	}
+	if res.Header.Get("Content-Type") != "text/event-stream" || res.Header.Get("X-Should-Retry") != "false" || res.Header.Get("Set-Cookie") != "" {
+		t.Errorf("replayed headers = %v", res.Header)
+	}
+	if err := replay.Stop(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestRecordNothing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "empty.yaml")
+	rec, err := New(path, ModeRecord)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rec.Stop(); err == nil {
+		t.Error("Stop saved a cassette with no interactions")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("empty cassette was written: %v", err)
+	}
+}
+
+func TestModeFromEnv(t *testing.T) {
+	t.Setenv(RecordEnv, "")
+	if ModeFromEnv() != ModeReplay {
+		t.Error("mode without the environment variable is not replay")
+	}
+	t.Setenv(RecordEnv, "1")
+	if ModeFromEnv() != ModeRecord {
+		t.Error("mode with the environment variable is not record")
+	}
+}
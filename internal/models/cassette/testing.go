@@ -0,0 +1,23 @@
+package cassette
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+// NewForTest returns a Recorder for the cassette testdata/<name>.yaml, which is stopped when the test ends. It replays the
+// cassette, unless RecordEnv is set, in which case the cassette is re-recorded against the real API. The checked-in
+// cassettes are synthetic, written by hand from the API references, until they are re-recorded.
+func NewForTest(t testing.TB, name string) *Recorder {
+	t.Helper()
+	rec, err := New(filepath.Join("testdata", name+".yaml"), ModeFromEnv())
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := rec.Stop(); err != nil {
+			t.Error(err)
+		}
+	})
+	return rec
+}
@@ -0,0 +1,179 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"unicode/utf8"
+)
+
+// ContextStrategy determines what happens when a request would not fit in the model's context window.
+type ContextStrategy string
+
+const (
+	// ContextStrategyTruncate drops the oldest turns of the chat history until the request fits.
+	ContextStrategyTruncate ContextStrategy = "truncate"
+	// ContextStrategySummarize replaces the oldest turns of the chat history with a summary written by a cheaper model.
+	ContextStrategySummarize ContextStrategy = "summarize"
+	// ContextStrategyRefuse does not send the request, and tells the user to clear the chat history.
+	ContextStrategyRefuse ContextStrategy = "refuse"
+)
+
+// ContextStrategies lists all valid values of ContextStrategy.
+var ContextStrategies = []ContextStrategy{ContextStrategyTruncate, ContextStrategySummarize, ContextStrategyRefuse}
+
+// ParseContextStrategy validates a strategy name given by the user.
+func ParseContextStrategy(name string) (ContextStrategy, error) {
+	for _, strategy := range ContextStrategies {
+		if string(strategy) == name {
+			return strategy, nil
+		}
+	}
+	valid := make([]string, len(ContextStrategies))
+	for i, strategy := range ContextStrategies {
+		valid[i] = string(strategy)
+	}
+	return "", fmt.Errorf("invalid context strategy: %s (valid strategies: %s)", name, strings.Join(valid, ", "))
+}
+
+// SummarySystemPrompt is the system prompt given to the model that condenses old chat history.
+const SummarySystemPrompt = "You summarize conversations between a user and an assistant. " +
+	"Write a concise summary that preserves facts, decisions, code and open questions needed to continue the conversation."
+
+// tokens added to each message by the provider's chat format (role markers, separators).
+const messageOverheadTokens = 4
+
+// ContextLimitError is returned when a request cannot be made to fit in the model's context window.
+type ContextLimitError struct {
+	Tokens     int  // estimated input tokens of the request
+	Limit      int  // input tokens allowed by the model
+	PromptOnly bool // the prompt doesn't fit even without the chat history, so no context strategy can help
+}
+
+func (e *ContextLimitError) Error() string {
+	if e.PromptOnly {
+		return fmt.Sprintf("this prompt is too long for the model's context window (~%d of %d tokens). Shorten it or remove attachments and try again", e.Tokens, e.Limit)
+	}
+	return fmt.Sprintf("this prompt would exceed the model's context window (~%d of %d tokens). Clear the chat history with ctrl+c and try again", e.Tokens, e.Limit)
+}
+
+// EstimateTokens returns a rough token count for text, using the common heuristic of ~4 characters per token.
+func EstimateTokens(text string) int {
+	return (utf8.RuneCountInString(text) + 3) / 4
+}
+
+// EstimateMessageTokens returns a rough token count for the text and parts of a message.
+func EstimateMessageTokens(msg *Message) int {
+	total := EstimateTokens(msg.Content)
+	for i := range msg.Parts {
+		total += EstimatePartTokens(&msg.Parts[i])
+	}
+	return total
+}
+
+// EstimateMessagesTokens returns a rough token count for a list of messages.
+func EstimateMessagesTokens(messages []Message) int {
+	total := 0
+	for i := range messages {
+		total += EstimateMessageTokens(&messages[i]) + messageOverheadTokens
+	}
+	return total
+}
+
+// EstimateRequestTokens returns a rough count of the input tokens of a request made up of a system prompt, the chat history
+// and a new prompt.
+func EstimateRequestTokens(systemPrompt string, history []Message, prompt Message) int {
+	total := EstimateTokens(systemPrompt) + EstimateMessagesTokens(history)
+	if !prompt.IsEmpty() {
+		total += EstimateMessageTokens(&prompt) + messageOverheadTokens
+	}
+	return total
+}
+
+// ContextUsage returns the fraction (0-1) of the model's input token limit taken up by the current chat.
+func ContextUsage(llm LLM) float64 {
+	limit := llm.DoGetContextLimit()
+	if limit <= 0 {
+		return 0
+	}
+	used := EstimateRequestTokens(llm.DoGetSystemPrompt(), llm.DoGetChatHistory(), Message{})
+	return min(1., float64(used)/float64(limit))
+}
+
+// FitContext ensures that the chat history and the new prompt fit in the model's context window, using the given strategy to
+// shrink the history if they do not. The summarizer is only used by ContextStrategySummarize.
+func FitContext(ctx context.Context, llm LLM, prompt Message, strategy ContextStrategy, summarizer LLM) error {
+	limit := llm.DoGetContextLimit()
+	systemPrompt, history := llm.DoGetSystemPrompt(), llm.DoGetChatHistory()
+
+	required := EstimateRequestTokens(systemPrompt, history, prompt)
+	if limit <= 0 || required <= limit {
+		return nil
+	}
+
+	// no amount of trimming will help if the prompt is too long by itself
+	promptOnly := EstimateRequestTokens(systemPrompt, nil, prompt)
+	if promptOnly > limit {
+		return &ContextLimitError{Tokens: promptOnly, Limit: limit, PromptOnly: true}
+	}
+	historyBudget := limit - promptOnly
+
+	switch strategy {
+	case ContextStrategyRefuse:
+		return &ContextLimitError{Tokens: required, Limit: limit}
+	case ContextStrategySummarize:
+		if summarizer == nil {
+			break
+		}
+		// keep the most recent turns verbatim, using half of the budget, and condense the rest
+		recent := dropOldestTurns(history, historyBudget/2)
+		summary, err := summarizeMessages(ctx, summarizer, history[:len(history)-len(recent)])
+		if err != nil {
+			return fmt.Errorf("error summarizing chat history: %w", err)
+		}
+		llm.DoAddUsage(summarizer.DoGetLastResponseInfo()) // the summary is paid for by the chat
+		condensed := make([]Message, 0, len(recent)+2)
+		condensed = append(condensed,
+			Message{Role: "user", Content: "Here is a summary of our conversation so far:\n\n" + summary},
+			Message{Role: "assistant", Content: "Understood. Let's continue."},
+		)
+		condensed = append(condensed, recent...)
+		llm.DoSetChatHistory(dropOldestTurns(condensed, historyBudget))
+		return nil
+	case ContextStrategyTruncate:
+	}
+	llm.DoSetChatHistory(dropOldestTurns(history, historyBudget))
+	return nil
+}
+
+// dropOldestTurns returns the most recent part of the history that fits within the token budget. Whole turns are dropped so
+// that the returned history always begins with a user message.
+func dropOldestTurns(history []Message, budget int) []Message {
+	start := 0
+	for start < len(history) && EstimateMessagesTokens(history[start:]) > budget {
+		start++
+		for start < len(history) && history[start].Role != "user" {
+			start++
+		}
+	}
+	return history[start:]
+}
+
+// summarizeMessages asks the summarizer to condense a part of the chat history.
+func summarizeMessages(ctx context.Context, summarizer LLM, messages []Message) (string, error) {
+	var transcript strings.Builder
+	transcript.WriteString("Summarize this conversation:\n\n")
+	for i := range messages {
+		fmt.Fprintf(&transcript, "%s: %s\n\n", messages[i].Role, messages[i].Content)
+		for _, part := range messages[i].Parts { // the summarizer only reads text
+			fmt.Fprintf(&transcript, "(%s attached: %s)\n\n", part.Kind, part.Name)
+		}
+	}
+
+	summarizer.DoClearChatHistory() // each summary is independent of the last
+	summary, _, err := CollectResponse(ctx, summarizer, transcript.String(), false, nil)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(summary), nil
+}
@@ -0,0 +1,204 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+)
+
+// fakeLLM streams a fixed response and records its requests. The models package can't use the mock provider, which imports it.
+type fakeLLM struct {
+	BaseLLM
+	limit    int
+	response string
+	info     ResponseInfo // of each response
+	prompts  []Message
+	added    []ResponseInfo // by DoAddUsage
+}
+
+func (llm *fakeLLM) DoStreamPromptCompletion(_ context.Context, prompt Message, _ bool, _ *uint8, responseChan chan StreamChunk) error {
+	defer close(responseChan)
+	llm.prompts = append(llm.prompts, prompt)
+	responseChan <- StreamChunk{Content: llm.response}
+	llm.Messages = append(llm.Messages, prompt, Message{Role: "assistant", Content: llm.response})
+	return nil
+}
+
+func (llm *fakeLLM) DoGetCostOfCurrentChat() float64     { return 0 }
+func (llm *fakeLLM) DoGetLastResponseInfo() ResponseInfo { return llm.info }
+func (llm *fakeLLM) DoAddUsage(info ResponseInfo)        { llm.added = append(llm.added, info) }
+func (llm *fakeLLM) DoGetPricing() Pricing               { return Pricing{} }
+func (llm *fakeLLM) DoGetMaxOutputTokens(bool) int       { return llm.MaxTokens }
+func (llm *fakeLLM) DoClearChatHistory()                 { llm.Messages = nil }
+func (llm *fakeLLM) DoGetChatHistory() []Message         { return llm.Messages }
+func (llm *fakeLLM) DoSetChatHistory(messages []Message) { llm.Messages = messages }
+func (llm *fakeLLM) DoGetSystemPrompt() string           { return llm.SystemPrompt }
+func (llm *fakeLLM) DoGetContextLimit() int              { return llm.limit }
+func (llm *fakeLLM) DoGetModelId() string                { return "fake" }
+func (llm *fakeLLM) DoesSupportReasoning() bool          { return false }
+func (llm *fakeLLM) DoesSupportVision() bool             { return false }
+func (llm *fakeLLM) DoesSupportDocuments() bool          { return false }
+
+// turns returns a history of n prompts and responses, each estimated at 14 tokens (10 for the text and 4 of overhead).
+func turns(n int) []Message {
+	var history []Message
+	for i := 1; i <= n; i++ {
+		history = append(history,
+			Message{Role: "user", Content: fmt.Sprintf("%-40s", fmt.Sprintf("question %d", i))},
+			Message{Role: "assistant", Content: fmt.Sprintf("%-40s", fmt.Sprintf("answer %d", i))},
+		)
+	}
+	return history
+}
+
+func sameMessages(a, b []Message) bool {
+	return slices.EqualFunc(a, b, func(x, y Message) bool { return reflect.DeepEqual(x, y) })
+}
+
+func TestDropOldestTurns(t *testing.T) {
+	history := turns(3) // 84 tokens
+	tests := []struct {
+		name   string
+		budget int
+		want   int // index of the first message kept
+	}{
+		{"fits", 84, 0},
+		{"drops a whole turn", 83, 2},
+		{"never keeps a response without its prompt", 70, 2},
+		{"keeps the last turn", 28, 4},
+		{"drops everything", 27, 6},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := dropOldestTurns(history, tt.budget)
+			if !sameMessages(got, history[tt.want:]) {
+				t.Errorf("kept %d messages, want %d", len(got), len(history)-tt.want)
+			}
+			if len(got) > 0 && got[0].Role != "user" {
+				t.Errorf("history begins with a %s message", got[0].Role)
+			}
+		})
+	}
+}
+
+func TestFitContext(t *testing.T) {
+	prompt := UserMessage("hi") // 5 tokens
+	tests := []struct {
+		name         string
+		strategy     ContextStrategy
+		systemPrompt string
+		prompt       Message
+		limit        int
+		want         int // index of the first message of the original history that is kept
+		wantErr      *ContextLimitError
+	}{
+		{name: "fits", strategy: ContextStrategyRefuse, limit: 89, want: 0},
+		{name: "no limit", strategy: ContextStrategyRefuse, limit: 0, want: 0},
+		{name: "truncate", strategy: ContextStrategyTruncate, limit: 88, want: 2},
+		{name: "truncate to the last turn", strategy: ContextStrategyTruncate, limit: 40, want: 4},
+		{name: "system prompt is counted", strategy: ContextStrategyTruncate, systemPrompt: strings.Repeat("s", 40), limit: 98, want: 2},
+		{name: "summarize without a summarizer truncates", strategy: ContextStrategySummarize, limit: 88, want: 2},
+		{name: "refuse", strategy: ContextStrategyRefuse, limit: 88, want: 0, wantErr: &ContextLimitError{Tokens: 89, Limit: 88}},
+		{
+			name: "prompt too long by itself", strategy: ContextStrategyTruncate, prompt: UserMessage(strings.Repeat("x", 400)),
+			limit: 88, want: 0, wantErr: &ContextLimitError{Tokens: 104, Limit: 88, PromptOnly: true},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			history := turns(3)
+			llm := &fakeLLM{BaseLLM: BaseLLM{SystemPrompt: tt.systemPrompt, Messages: history}, limit: tt.limit}
+			if tt.prompt.IsEmpty() {
+				tt.prompt = prompt
+			}
+
+			err := FitContext(context.Background(), llm, tt.prompt, tt.strategy, nil)
+			var limitErr *ContextLimitError
+			switch {
+			case tt.wantErr == nil && err != nil:
+				t.Fatalf("error = %v", err)
+			case tt.wantErr != nil && (!errors.As(err, &limitErr) || *limitErr != *tt.wantErr):
+				t.Fatalf("error = %v, want %v", err, tt.wantErr)
+			}
+			if got := llm.DoGetChatHistory(); !sameMessages(got, history[tt.want:]) {
+				t.Errorf("kept %d messages, want %d", len(got), len(history)-tt.want)
+			}
+			if llm.DoGetSystemPrompt() != tt.systemPrompt {
+				t.Errorf("system prompt = %q", llm.DoGetSystemPrompt())
+			}
+		})
+	}
+}
+
+func TestFitContextSummarize(t *testing.T) {
+	history := turns(3)
+	llm := &fakeLLM{BaseLLM: BaseLLM{Messages: history}, limit: 88}
+	summaryInfo := ResponseInfo{ModelID: "fake", Usage: Usage{InputTokens: 60, OutputTokens: 3}, Cost: 0.01}
+	summarizer := &fakeLLM{BaseLLM: BaseLLM{Messages: turns(1)}, response: " the gist \n", info: summaryInfo}
+
+	if err := FitContext(context.Background(), llm, UserMessage("hi"), ContextStrategySummarize, summarizer); err != nil {
+		t.Fatal(err)
+	}
+
+	// the oldest turns are summarized, and the most recent one is kept verbatim after the summary
+	got := llm.DoGetChatHistory()
+	if len(got) != 4 || !sameMessages(got[2:], history[4:]) {
+		t.Fatalf("history = %+v", got)
+	}
+	if got[0].Role != "user" || !strings.HasSuffix(got[0].Content, "\n\nthe gist") || got[1].Role != "assistant" {
+		t.Errorf("summary = %+v", got[:2])
+	}
+
+	if len(summarizer.prompts) != 1 {
+		t.Fatalf("summarizer prompts = %+v", summarizer.prompts)
+	}
+	transcript := summarizer.prompts[0].Content
+	for i, msg := range history {
+		if summarized := i < 4; strings.Contains(transcript, msg.Content) != summarized {
+			t.Errorf("message %d in the transcript = %t, want %t", i, !summarized, summarized)
+		}
+	}
+	if len(summarizer.DoGetChatHistory()) != 2 {
+		t.Errorf("summarizer history was not cleared before the summary: %+v", summarizer.DoGetChatHistory())
+	}
+
+	if !slices.Equal(llm.added, []ResponseInfo{summaryInfo}) {
+		t.Errorf("usage added to the chat = %+v, want the summary's", llm.added)
+	}
+}
+
+func TestParseContextStrategy(t *testing.T) {
+	for _, strategy := range ContextStrategies {
+		if got, err := ParseContextStrategy(string(strategy)); got != strategy || err != nil {
+			t.Errorf("ParseContextStrategy(%q) = %q, %v", strategy, got, err)
+		}
+	}
+	_, err := ParseContextStrategy("compress")
+	if err == nil {
+		t.Fatal("no error for an invalid strategy")
+	}
+	for _, strategy := range ContextStrategies {
+		if !strings.Contains(err.Error(), string(strategy)) {
+			t.Errorf("error %q does not list %q", err, strategy)
+		}
+	}
+}
+
+func TestSideUsage(t *testing.T) {
+	var side SideUsage
+	side.Add(ResponseInfo{Usage: Usage{InputTokens: 10, OutputTokens: 2}, Cost: 0.5})
+	side.Add(ResponseInfo{Usage: Usage{InputTokens: 5, OutputTokens: 1}, Cost: 0.25})
+
+	info := ResponseInfo{Usage: Usage{InputTokens: 100, OutputTokens: 20}, Cost: 1}
+	side.ApplyTo(&info)
+	if want := (ResponseInfo{Usage: Usage{InputTokens: 115, OutputTokens: 23}, Cost: 1.75}); info != want {
+		t.Errorf("info = %+v, want %+v", info, want)
+	}
+	if side != (SideUsage{}) {
+		t.Errorf("usage not forgotten once applied: %+v", side)
+	}
+}
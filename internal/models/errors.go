@@ -0,0 +1,130 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrorKind is the provider-agnostic category of an error, which determines whether it is retried and what guidance the user
+// is given.
+type ErrorKind int
+
+const (
+	ErrorUnknown        ErrorKind = iota
+	ErrorAuth                     // missing, invalid or unauthorized API key
+	ErrorRateLimit                // too many requests, or the account's quota is used up
+	ErrorOverloaded               // the provider can't serve the request right now
+	ErrorContextTooLong           // the request doesn't fit in the model's context window
+	ErrorInvalidModel             // the model doesn't exist, or the API key can't use it
+	ErrorNetwork                  // the API could not be reached, or the connection dropped
+	ErrorCancelled                // the user cancelled the request
+)
+
+func (k ErrorKind) String() string {
+	switch k {
+	case ErrorAuth:
+		return "auth"
+	case ErrorRateLimit:
+		return "rate_limit"
+	case ErrorOverloaded:
+		return "overloaded"
+	case ErrorContextTooLong:
+		return "context_too_long"
+	case ErrorInvalidModel:
+		return "invalid_model"
+	case ErrorNetwork:
+		return "network"
+	case ErrorCancelled:
+		return "cancelled"
+	case ErrorUnknown:
+	}
+	return "unknown"
+}
+
+// APIError is an error response from a provider's API. Providers return it from DoStreamPromptCompletion so that callers can
+// decide whether to retry, and show the user what to do about it.
+type APIError struct {
+	Kind       ErrorKind
+	Provider   string        // anthropic or openai, which is also the prefix of the provider's config key and env var
+	StatusCode int           // HTTP status, or the status the provider uses for an error sent mid-stream (e.g. 529 for overloaded)
+	RetryAfter time.Duration // how long the provider asked us to wait before retrying, zero if it didn't say
+	Err        error         // the error returned by the provider's SDK
+}
+
+func (e *APIError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *APIError) Unwrap() error {
+	return e.Err
+}
+
+// KindFromStatus categorizes an error response by its HTTP status. Providers should prefer the error type in the response
+// body where it is more specific, e.g. a 400 caused by a prompt that is too long.
+func KindFromStatus(status int) ErrorKind {
+	switch {
+	case status == http.StatusUnauthorized, status == http.StatusForbidden:
+		return ErrorAuth
+	case status == http.StatusNotFound:
+		return ErrorInvalidModel
+	case status == http.StatusRequestEntityTooLarge:
+		return ErrorContextTooLong
+	case status == http.StatusTooManyRequests:
+		return ErrorRateLimit
+	case status == http.StatusServiceUnavailable, status == 529: // 529 is Anthropic's overloaded status
+		return ErrorOverloaded
+	}
+	return ErrorUnknown
+}
+
+// KindOf returns the ErrorKind of any error returned while streaming.
+func KindOf(err error) ErrorKind {
+	if err == nil {
+		return ErrorUnknown
+	}
+	if errors.Is(err, context.Canceled) {
+		return ErrorCancelled
+	}
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Kind
+	}
+	var limitErr *ContextLimitError
+	if errors.As(err, &limitErr) {
+		return ErrorContextTooLong
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) ||
+		errors.Is(err, io.ErrUnexpectedEOF) ||
+		errors.Is(err, syscall.ECONNRESET) ||
+		errors.Is(err, syscall.ECONNREFUSED) ||
+		errors.Is(err, context.DeadlineExceeded) {
+		return ErrorNetwork
+	}
+	return ErrorUnknown
+}
+
+// ProviderOf returns the provider of an APIError, or an empty string if err did not come from a provider's API.
+func ProviderOf(err error) string {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) {
+		return apiErr.Provider
+	}
+	return ""
+}
+
+// IsContextTooLongMessage reports whether an error message from a provider says the request exceeded the context window. The
+// providers return these as generic invalid request errors.
+func IsContextTooLongMessage(message string) bool {
+	message = strings.ToLower(message)
+	return strings.Contains(message, "prompt is too long") ||
+		strings.Contains(message, "context_length_exceeded") ||
+		strings.Contains(message, "maximum context length") ||
+		strings.Contains(message, "context window")
+}
@@ -4,34 +4,69 @@ package models
 import (
 	"context"
 	"fmt"
+	"strings"
 )
 
 // LLM defines fields and behavior of all supported LLMs.
 type LLM interface {
 	DoStreamPromptCompletion(
 		ctx context.Context,
-		prompt string,
+		prompt Message, // a user message, which is added to the chat history once the response completes
 		enableReasoning bool, // whether the user wants the model to think/reason if supported
 		reasoningEffort *uint8, // only to be used for gpt-5 models
 		responseChan chan StreamChunk,
 	) error
 	DoGetCostOfCurrentChat() float64
+	DoGetLastResponseInfo() ResponseInfo
+	DoAddUsage(info ResponseInfo) // adds a request made on the chat's behalf, such as a summary of its history, to its totals
+	DoGetPricing() Pricing
+	DoGetMaxOutputTokens(enableReasoning bool) int // the most tokens a response may use, including reasoning
 	DoClearChatHistory()
 	DoGetChatHistory() []Message
+	DoSetChatHistory(messages []Message)
+	DoGetSystemPrompt() string
+	DoGetContextLimit() int // max input tokens of a request, leaving room for the response
 	DoGetModelId() string
 	DoesSupportReasoning() bool
+	DoesSupportVision() bool    // whether the model can read image parts
+	DoesSupportDocuments() bool // whether the model can read document parts, rather than only their extracted text
 }
 
-func StreamPromptCompletion(ctx context.Context, llm LLM, prompt string, enableReasoning bool, reasoningEffort *uint8, responseChan chan StreamChunk) error {
+func StreamPromptCompletion(ctx context.Context, llm LLM, prompt Message, enableReasoning bool, reasoningEffort *uint8, responseChan chan StreamChunk) error {
 	if err := llm.DoStreamPromptCompletion(ctx, prompt, enableReasoning, reasoningEffort, responseChan); err != nil {
-		return StreamError{ErrMsg: err.Error()}
+		return StreamError{Err: err}
 	}
 	return nil
 }
 
+// CollectResponse streams a response and returns its full text and reasoning once the stream completes.
+func CollectResponse(ctx context.Context, llm LLM, prompt string, enableReasoning bool, reasoningEffort *uint8) (response, reasoning string, err error) {
+	var responseText, reasoningText strings.Builder
+	responseChan := make(chan StreamChunk)
+	done := make(chan struct{})
+	go func() {
+		for chunk := range responseChan {
+			if chunk.Reasoning {
+				reasoningText.WriteString(chunk.Content)
+			} else {
+				responseText.WriteString(chunk.Content)
+			}
+		}
+		close(done)
+	}()
+
+	err = llm.DoStreamPromptCompletion(ctx, UserMessage(prompt), enableReasoning, reasoningEffort, responseChan)
+	<-done
+	return responseText.String(), reasoningText.String(), err
+}
+
 // GetCostOfCurrentChat returns a formatted string of the chat's current cost
 func GetCostOfCurrentChat(llm LLM) string {
-	cost := llm.DoGetCostOfCurrentChat()
+	return FormatCost(llm.DoGetCostOfCurrentChat())
+}
+
+// FormatCost returns a formatted string of a cost in dollars, or an empty string if the cost is zero.
+func FormatCost(cost float64) string {
 	if cost == 0 {
 		return ""
 	}
@@ -53,6 +88,11 @@ func GetChatHistory(llm LLM) {
 	llm.DoGetChatHistory()
 }
 
+// GetLastResponseInfo returns the usage, cost and stop reason of the most recently completed response.
+func GetLastResponseInfo(llm LLM) ResponseInfo {
+	return llm.DoGetLastResponseInfo()
+}
+
 func GetModelId(llm LLM) string {
 	return llm.DoGetModelId()
 }
@@ -61,6 +101,11 @@ func SupportsReasoning(llm LLM) bool {
 	return llm.DoesSupportReasoning()
 }
 
+// SupportsVision returns whether the model can read images.
+func SupportsVision(llm LLM) bool {
+	return llm.DoesSupportVision()
+}
+
 // BaseLLM defines fields shared by all supported LLMs.
 type BaseLLM struct {
 	SystemPrompt string
@@ -70,10 +115,80 @@ type BaseLLM struct {
 	PromptCount int
 }
 
-// Message encapsulates a chunk of text data returned from the provider's API when streaming a response.
+// Message is a prompt or response in the chat history. Prompts may have parts, such as images, that are sent along with their
+// text.
 type Message struct {
 	Role    string
 	Content string
+	Parts   []Part
+}
+
+// Usage records the number of tokens used by a response.
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+// StopReason is the provider-agnostic reason a response ended.
+type StopReason string
+
+const (
+	StopReasonEndTurn   StopReason = "end_turn"   // the model finished its response
+	StopReasonMaxTokens StopReason = "max_tokens" // the response was cut off by the output token budget
+	StopReasonRefusal   StopReason = "refusal"    // the response was stopped by the provider's safety systems
+)
+
+// ContinuePrompt asks the model to resume a response that was cut off by the output token budget.
+const ContinuePrompt = "Your last response was cut off. Continue it exactly where it stopped, without repeating anything " +
+	"or adding a preamble."
+
+// MergeContinuation folds the ContinuePrompt exchange at the end of the chat history into the response it continued, so
+// that the history reads as one complete response. It does nothing if the history doesn't end with a continuation.
+func MergeContinuation(llm LLM) {
+	history := llm.DoGetChatHistory()
+	n := len(history)
+	if n < 3 || history[n-2].Role != "user" || history[n-2].Content != ContinuePrompt || history[n-3].Role != "assistant" {
+		return
+	}
+	merged := make([]Message, n-2)
+	copy(merged, history[:n-2])
+	merged[n-3].Content += history[n-1].Content
+	llm.DoSetChatHistory(merged)
+}
+
+// ResponseInfo describes a completed response.
+type ResponseInfo struct {
+	ModelID    string
+	StopReason StopReason // empty if the response did not complete
+	Usage      Usage
+	Cost       float64 // in dollars
+}
+
+// SideUsage accumulates the usage of requests made on a chat's behalf, such as summaries of its history, until it is added
+// to the info of the chat's next response. Their cost is added to the cost of the chat as they are made.
+type SideUsage struct {
+	usage Usage
+	cost  float64
+}
+
+// Add records the usage of a request made on the chat's behalf.
+func (s *SideUsage) Add(info ResponseInfo) {
+	s.usage.InputTokens += info.Usage.InputTokens
+	s.usage.OutputTokens += info.Usage.OutputTokens
+	s.cost += info.Cost
+}
+
+// ApplyTo adds the recorded usage to the info of a completed response, and forgets it.
+func (s *SideUsage) ApplyTo(info *ResponseInfo) {
+	info.Usage.InputTokens += s.usage.InputTokens
+	info.Usage.OutputTokens += s.usage.OutputTokens
+	info.Cost += s.cost
+	*s = SideUsage{}
+}
+
+// Cost returns the cost in dollars of the tokens used by a response.
+func (p Pricing) Cost(usage Usage) float64 {
+	return p.PromptCost*float64(usage.InputTokens) + p.ResponseCost*float64(usage.OutputTokens)
 }
 
 // Pricing defines costs per input or output token. They should be defined as `(cost per million) / 1,000,000`.
@@ -101,9 +216,17 @@ type StreamChunk struct {
 }
 
 // StreamError stores any error that occurred during response streaming.
-type StreamError struct{ ErrMsg string }
+type StreamError struct {
+	Err     error
+	Partial bool // part of the response was streamed before the error
+}
 
 // Error return the error message of a StreamError.
 func (e StreamError) Error() string {
-	return e.ErrMsg
+	return e.Err.Error()
+}
+
+// Unwrap returns the error that ended the stream, so that its ErrorKind can be found with KindOf.
+func (e StreamError) Unwrap() error {
+	return e.Err
 }
@@ -0,0 +1,115 @@
+// Package mock implements a deterministic LLM that replays scripted responses, for developing and testing the TUI offline.
+package mock
+
+import (
+	"embed"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// ModelPrefix begins the names of mock models. The rest of the name is the name of a built-in script, or the path to a script
+// file, e.g. mock:demo or mock:./testdata/stream.json.
+const ModelPrefix = "mock:"
+
+//go:embed scripts/*.json
+var builtinScripts embed.FS
+
+// Script defines the responses a mock model replays, and how they are streamed.
+type Script struct {
+	FirstChunkDelay Duration `json:"first_chunk_delay"` // simulates the time to the first token
+	ChunkDelay      Duration `json:"chunk_delay"`       // delay between chunks
+	ChunkSize       int      `json:"chunk_size"`        // words per chunk, default 1
+
+	ContextWindow   int            `json:"context_window"`
+	Vision          bool           `json:"vision"`    // accept prompts with images
+	Documents       bool           `json:"documents"` // accept documents, rather than only their extracted text
+	Pricing         models.Pricing `json:"-"`
+	PricePerMillion struct {
+		Prompt   float64 `json:"prompt"`
+		Response float64 `json:"response"`
+	} `json:"price_per_million"`
+
+	// replayed in order, one per prompt, starting over once all have been used
+	Responses []Response `json:"responses"`
+}
+
+// Response is a single scripted response.
+type Response struct {
+	Reasoning string `json:"reasoning"` // only streamed if reasoning is enabled
+	Text      string `json:"text"`
+
+	// if Error is set, the stream fails with this message after ErrorAfter chunks of text have been streamed
+	Error      string `json:"error"`
+	ErrorAfter int    `json:"error_after"`
+	// if set, the error is a models.APIError with this HTTP status, so it can be retried, e.g. 429 or 529
+	ErrorStatus int      `json:"error_status"`
+	RetryAfter  Duration `json:"retry_after"`
+
+	StopReason models.StopReason `json:"stop_reason"` // default end_turn
+	Usage      *models.Usage     `json:"usage"`       // default is an estimate from the prompt and response
+}
+
+// Duration is a time.Duration that is written in scripts as a string, e.g. "30ms".
+type Duration time.Duration
+
+// UnmarshalJSON parses a duration string.
+func (d *Duration) UnmarshalJSON(data []byte) error {
+	var s string
+	if err := json.Unmarshal(data, &s); err != nil {
+		return fmt.Errorf("duration must be a string such as \"30ms\": %w", err)
+	}
+	parsed, err := time.ParseDuration(s)
+	if err != nil {
+		return fmt.Errorf("invalid duration: %w", err)
+	}
+	*d = Duration(parsed)
+	return nil
+}
+
+// IsMockModel returns whether a model name selects the mock provider.
+func IsMockModel(modelName string) bool {
+	return strings.HasPrefix(modelName, ModelPrefix)
+}
+
+// ValidateModelName validates that a modelName refers to a built-in script or a valid script file.
+func ValidateModelName(modelName string) error {
+	if !IsMockModel(modelName) {
+		return fmt.Errorf("mock models must begin with %q, e.g. %sdemo", ModelPrefix, ModelPrefix)
+	}
+	_, err := LoadScript(strings.TrimPrefix(modelName, ModelPrefix))
+	return err
+}
+
+// LoadScript loads a built-in script by name, or a script file by path.
+func LoadScript(nameOrPath string) (*Script, error) {
+	data, err := builtinScripts.ReadFile("scripts/" + nameOrPath + ".json")
+	if err != nil {
+		data, err = os.ReadFile(nameOrPath) //nolint:gosec // path is chosen by the user
+		if err != nil {
+			return nil, fmt.Errorf("mock script %q is not built-in and could not be read: %w", nameOrPath, err)
+		}
+	}
+
+	var script Script
+	if err := json.Unmarshal(data, &script); err != nil {
+		return nil, fmt.Errorf("invalid mock script %q: %w", nameOrPath, err)
+	}
+	if len(script.Responses) == 0 {
+		return nil, errors.New("mock script has no responses")
+	}
+	script.ChunkSize = max(1, script.ChunkSize)
+	if script.ContextWindow == 0 {
+		script.ContextWindow = 200_000
+	}
+	script.Pricing = models.Pricing{
+		PromptCost:   script.PricePerMillion.Prompt / 1_000_000,
+		ResponseCost: script.PricePerMillion.Response / 1_000_000,
+	}
+	return &script, nil
+}
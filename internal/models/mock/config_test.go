@@ -0,0 +1,82 @@
+package mock
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+func writeScript(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "script.json")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestLoadScript(t *testing.T) {
+	for _, name := range []string{"demo", "error", "instant", "overloaded"} {
+		if _, err := LoadScript(name); err != nil {
+			t.Errorf("built-in script %s: %v", name, err)
+		}
+	}
+
+	demo, err := LoadScript("demo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if demo.ChunkSize != 2 || time.Duration(demo.FirstChunkDelay) != 400*time.Millisecond || time.Duration(demo.ChunkDelay) != 25*time.Millisecond {
+		t.Errorf("demo = chunk size %d, delays %v and %v", demo.ChunkSize, demo.FirstChunkDelay, demo.ChunkDelay)
+	}
+	if want := (models.Pricing{PromptCost: 1.0 / 1_000_000, ResponseCost: 5.0 / 1_000_000}); demo.Pricing != want {
+		t.Errorf("pricing = %+v, want %+v", demo.Pricing, want)
+	}
+
+	path := writeScript(t, `{"responses": [{"text": "hi", "error_status": 529, "retry_after": "2s"}]}`)
+	script, err := LoadScript(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if script.ChunkSize != 1 || script.ContextWindow != 200_000 || script.Pricing != (models.Pricing{}) {
+		t.Errorf("defaults = chunk size %d, context window %d, pricing %+v", script.ChunkSize, script.ContextWindow, script.Pricing)
+	}
+	if want := (Response{Text: "hi", ErrorStatus: 529, RetryAfter: Duration(2 * time.Second)}); script.Responses[0] != want {
+		t.Errorf("response = %+v, want %+v", script.Responses[0], want)
+	}
+}
+
+func TestLoadScriptErrors(t *testing.T) {
+	tests := []struct {
+		name    string
+		path    string
+		wantErr string
+	}{
+		{"missing", filepath.Join(t.TempDir(), "missing.json"), "is not built-in and could not be read"},
+		{"invalid json", writeScript(t, `{"responses": [`), "invalid mock script"},
+		{"invalid duration", writeScript(t, `{"chunk_delay": "soon", "responses": [{"text": "hi"}]}`), "invalid duration"},
+		{"numeric duration", writeScript(t, `{"chunk_delay": 30, "responses": [{"text": "hi"}]}`), "duration must be a string"},
+		{"no responses", writeScript(t, `{"chunk_size": 3}`), "mock script has no responses"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if _, err := LoadScript(tt.path); err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("error = %v, want %q", err, tt.wantErr)
+			}
+			if err := ValidateModelName(ModelPrefix + tt.path); err == nil {
+				t.Error("ValidateModelName accepted an invalid script")
+			}
+			if llm, err := NewModel("", 1024, ModelPrefix+tt.path, nil); err == nil || llm != nil {
+				t.Errorf("NewModel = %v, %v, want an error", llm, err)
+			}
+		})
+	}
+
+	if err := ValidateModelName("demo"); err == nil {
+		t.Error("ValidateModelName accepted a name without the mock: prefix")
+	}
+}
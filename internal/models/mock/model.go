@@ -0,0 +1,227 @@
+package mock
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"time"
+	"unicode"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// Model replays the responses of a Script and satisfies the models.LLM interface.
+type Model struct {
+	models.BaseLLM
+	Script *Script
+
+	name         string
+	next         int // index of the next response to replay
+	totalCost    float64
+	lastResponse models.ResponseInfo
+	sideUsage    models.SideUsage // of summaries, added to the next response
+}
+
+// NewModel creates a mock model from a model name such as mock:demo, loading its script. It returns an error if the script
+// can't be read or is invalid.
+func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages *[]models.Message) (*Model, error) {
+	var messages []models.Message
+	if pastMessages != nil {
+		messages = *pastMessages
+	} else {
+		messages = []models.Message{}
+	}
+
+	script, err := LoadScript(strings.TrimPrefix(modelName, ModelPrefix))
+	if err != nil {
+		return nil, err
+	}
+	return &Model{
+		BaseLLM: models.BaseLLM{
+			SystemPrompt: systemPrompt,
+			MaxTokens:    maxTokens,
+			Messages:     messages,
+		},
+		Script: script,
+		name:   modelName,
+	}, nil
+}
+
+func (llm *Model) DoStreamPromptCompletion(ctx context.Context, prompt models.Message, enableReasoning bool, _ *uint8, responseChan chan models.StreamChunk) error {
+	defer close(responseChan)
+
+	response := llm.Script.Responses[llm.next%len(llm.Script.Responses)]
+	llm.next++
+	llm.lastResponse = models.ResponseInfo{ModelID: llm.name}
+
+	if err := llm.wait(ctx, time.Duration(llm.Script.FirstChunkDelay)); err != nil {
+		return err
+	}
+
+	if enableReasoning && response.Reasoning != "" {
+		for _, chunk := range splitChunks(response.Reasoning, llm.Script.ChunkSize) {
+			responseChan <- models.StreamChunk{Reasoning: true, Content: chunk}
+			if err := llm.wait(ctx, time.Duration(llm.Script.ChunkDelay)); err != nil {
+				return err
+			}
+		}
+	}
+
+	var fullResponseText strings.Builder
+	for i, chunk := range splitChunks(response.Text, llm.Script.ChunkSize) {
+		if response.Error != "" && i == response.ErrorAfter {
+			break
+		}
+		fullResponseText.WriteString(chunk)
+		responseChan <- models.StreamChunk{Reasoning: false, Content: chunk}
+		if err := llm.wait(ctx, time.Duration(llm.Script.ChunkDelay)); err != nil {
+			return err
+		}
+	}
+
+	usage := models.Usage{
+		InputTokens:  models.EstimateRequestTokens(llm.SystemPrompt, llm.Messages, prompt),
+		OutputTokens: models.EstimateTokens(response.Reasoning + fullResponseText.String()),
+	}
+	if response.Usage != nil {
+		usage = *response.Usage
+	}
+	llm.lastResponse.Usage = usage
+	llm.lastResponse.Cost = llm.Script.Pricing.Cost(usage)
+	llm.totalCost += llm.lastResponse.Cost
+
+	if response.Error != "" {
+		if response.ErrorStatus != 0 {
+			return &models.APIError{
+				Kind:       models.KindFromStatus(response.ErrorStatus),
+				Provider:   "mock",
+				StatusCode: response.ErrorStatus,
+				RetryAfter: time.Duration(response.RetryAfter),
+				Err:        errors.New(response.Error),
+			}
+		}
+		return errors.New(response.Error)
+	}
+
+	llm.lastResponse.StopReason = response.StopReason
+	if llm.lastResponse.StopReason == "" {
+		llm.lastResponse.StopReason = models.StopReasonEndTurn
+	}
+	llm.PromptCount++
+	llm.sideUsage.ApplyTo(&llm.lastResponse)
+	if fullResponseText.Len() > 0 {
+		llm.Messages = append(llm.Messages,
+			prompt,
+			models.Message{Role: "assistant", Content: fullResponseText.String()},
+		)
+	}
+	return nil
+}
+
+// wait sleeps for the given duration, returning early with the context's error if it is cancelled.
+func (llm *Model) wait(ctx context.Context, d time.Duration) error {
+	if d <= 0 {
+		return ctx.Err() //nolint:wrapcheck // returned as-is, like the SDKs do
+	}
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err() //nolint:wrapcheck // returned as-is, like the SDKs do
+	case <-timer.C:
+		return nil
+	}
+}
+
+// splitChunks splits text into chunks of size words. Whitespace is kept with the word before it, so that the chunks join back
+// into the original text.
+func splitChunks(text string, size int) []string {
+	var (
+		chunks       []string
+		start, words int
+		inSpace      bool
+	)
+	for i, r := range text {
+		isSpace := unicode.IsSpace(r)
+		if inSpace && !isSpace { // a new word begins
+			words++
+			if words == size {
+				chunks = append(chunks, text[start:i])
+				start, words = i, 0
+			}
+		}
+		inSpace = isSpace
+	}
+	if start < len(text) {
+		chunks = append(chunks, text[start:])
+	}
+	return chunks
+}
+
+func (llm *Model) DoGetCostOfCurrentChat() float64 {
+	return llm.totalCost
+}
+
+func (llm *Model) DoGetLastResponseInfo() models.ResponseInfo {
+	return llm.lastResponse
+}
+
+func (llm *Model) DoAddUsage(info models.ResponseInfo) {
+	llm.totalCost += info.Cost
+	llm.sideUsage.Add(info)
+}
+
+func (llm *Model) DoGetPricing() models.Pricing {
+	return llm.Script.Pricing
+}
+
+func (llm *Model) DoGetMaxOutputTokens(bool) int {
+	return llm.MaxTokens
+}
+
+func (llm *Model) DoClearChatHistory() {
+	llm.totalCost = 0
+	llm.sideUsage = models.SideUsage{}
+	llm.PromptCount = 0
+	llm.Messages = []models.Message{}
+}
+
+func (llm *Model) DoGetChatHistory() []models.Message {
+	return llm.Messages
+}
+
+func (llm *Model) DoSetChatHistory(messages []models.Message) {
+	llm.Messages = messages
+}
+
+func (llm *Model) DoGetSystemPrompt() string {
+	return llm.SystemPrompt
+}
+
+func (llm *Model) DoGetContextLimit() int {
+	return llm.Script.ContextWindow - llm.MaxTokens
+}
+
+func (llm *Model) DoGetModelId() string {
+	return llm.name
+}
+
+// DoesSupportReasoning returns true if any scripted response has reasoning.
+func (llm *Model) DoesSupportReasoning() bool {
+	for i := range llm.Script.Responses {
+		if llm.Script.Responses[i].Reasoning != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// DoesSupportVision returns whether the script accepts images.
+func (llm *Model) DoesSupportVision() bool {
+	return llm.Script.Vision
+}
+
+// DoesSupportDocuments returns whether the script accepts documents, rather than only their extracted text.
+func (llm *Model) DoesSupportDocuments() bool {
+	return llm.Script.Documents
+}
@@ -0,0 +1,34 @@
+package mock
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestSplitChunks(t *testing.T) {
+	tests := []struct {
+		text string
+		size int
+		want []string
+	}{
+		{"", 1, nil},
+		{"hello", 1, []string{"hello"}},
+		{"hello world", 1, []string{"hello ", "world"}},
+		{"one two three four five", 2, []string{"one two ", "three four ", "five"}},
+		{"one two three", 5, []string{"one two three"}},
+		{"  leading space", 1, []string{"  ", "leading ", "space"}},
+		{"trailing space \n", 1, []string{"trailing ", "space \n"}},
+		{"# Title\n\n- item\n", 1, []string{"# ", "Title\n\n", "- ", "item\n"}},
+		{"naïve café", 1, []string{"naïve ", "café"}},
+	}
+	for _, tt := range tests {
+		got := splitChunks(tt.text, tt.size)
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("splitChunks(%q, %d) = %q, want %q", tt.text, tt.size, got, tt.want)
+		}
+		if joined := strings.Join(got, ""); joined != tt.text {
+			t.Errorf("splitChunks(%q, %d) rejoins to %q", tt.text, tt.size, joined)
+		}
+	}
+}
@@ -7,6 +7,7 @@ import (
 
 	"github.com/gregriff/ducky/internal/models"
 	"github.com/openai/openai-go/v3/shared"
+	"github.com/pkoukk/tiktoken-go"
 )
 
 // ModelConfig specifies fields unique to OpenAI models.
@@ -17,8 +18,14 @@ type ModelConfig struct {
 	ID                  string
 	SupportsTemperature *bool
 	SupportsReasoning   *bool
+	SupportsVision      *bool  // accepts image parts
+	ContextWindow       int    // total tokens (input + output) the model can attend to
+	Encoding            string // vocabulary of the model's tokenizer
 }
 
+// SummaryModelName is the cheap model used to summarize old chat history when it outgrows the context window.
+const SummaryModelName = "gpt-5-nano"
+
 // ReasoningEffortMap maps uints to strings used for the reasoningEffort parameter.
 // Allows easier migration to new effort levels.
 var ReasoningEffortMap = map[int]shared.ReasoningEffort{
@@ -44,6 +51,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 		},
 		SupportsReasoning:   models.BoolPtr(true),
 		SupportsTemperature: models.BoolPtr(false),
+		SupportsVision:      models.BoolPtr(true),
+		ContextWindow:       200_000,
+		Encoding:            tiktoken.MODEL_O200K_BASE,
 	},
 	"o4-mini": {
 		ID: "o4-mini",
@@ -53,6 +63,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 		},
 		SupportsReasoning:   models.BoolPtr(true),
 		SupportsTemperature: models.BoolPtr(false),
+		SupportsVision:      models.BoolPtr(true),
+		ContextWindow:       200_000,
+		Encoding:            tiktoken.MODEL_O200K_BASE,
 	},
 	"gpt-4o-mini": {
 		ID: "gpt-4o-mini",
@@ -60,6 +73,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 			PromptCost:   .15 / 1_000_000,
 			ResponseCost: .075 / 1_000_000,
 		},
+		SupportsVision: models.BoolPtr(true),
+		ContextWindow:  128_000,
+		Encoding:       tiktoken.MODEL_O200K_BASE,
 	},
 	"gpt-4o": {
 		ID: "gpt-4o",
@@ -67,6 +83,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 			PromptCost:   2.5 / 1_000_000,
 			ResponseCost: 10. / 1_000_000,
 		},
+		SupportsVision: models.BoolPtr(true),
+		ContextWindow:  128_000,
+		Encoding:       tiktoken.MODEL_O200K_BASE,
 	},
 	"gpt-5": {
 		ID: "gpt-5",
@@ -75,6 +94,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 			ResponseCost: 10. / 1_000_000,
 		},
 		SupportsReasoning: models.BoolPtr(true),
+		SupportsVision:    models.BoolPtr(true),
+		ContextWindow:     400_000,
+		Encoding:          tiktoken.MODEL_O200K_BASE,
 	},
 	"gpt-5-mini": {
 		ID: "gpt-5-mini",
@@ -83,6 +105,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 			ResponseCost: 2. / 1_000_000,
 		},
 		SupportsReasoning: models.BoolPtr(true),
+		SupportsVision:    models.BoolPtr(true),
+		ContextWindow:     400_000,
+		Encoding:          tiktoken.MODEL_O200K_BASE,
 	},
 	"gpt-5-nano": {
 		ID: "gpt-5-nano",
@@ -91,6 +116,9 @@ var OpenAIModelConfigurations = map[string]ModelConfig{
 			ResponseCost: .4 / 1_000_000,
 		},
 		SupportsReasoning: models.BoolPtr(true),
+		SupportsVision:    models.BoolPtr(true),
+		ContextWindow:     400_000,
+		Encoding:          tiktoken.MODEL_O200K_BASE,
 	},
 }
 
@@ -4,10 +4,12 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"net/http"
 
 	"github.com/gregriff/ducky/internal/math"
 	"github.com/gregriff/ducky/internal/models"
 	openai "github.com/openai/openai-go/v3"
+	"github.com/openai/openai-go/v3/option"
 	"github.com/openai/openai-go/v3/packages/param"
 	"github.com/openai/openai-go/v3/responses"
 	"github.com/openai/openai-go/v3/shared"
@@ -19,11 +21,17 @@ type Model struct {
 	Client       openai.Client
 	ModelConfig  ModelConfig
 	SystemPrompt string
-	// TODO: add usage field
+
+	totalCost    float64 // in dollars, reset on clear
+	lastResponse models.ResponseInfo
+	sideUsage    models.SideUsage // of summaries, added to the next response
 }
 
-// NewModel creates a new OpenAI model to be used for response streaming.
-func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages *[]models.Message) *Model {
+// defaultOptions leaves retries to models.StreamPromptCompletionWithRetry.
+var defaultOptions = []option.RequestOption{option.WithMaxRetries(0)}
+
+// NewModel creates a new OpenAI model to be used for response streaming. opts configure the client, e.g. its transport.
+func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages *[]models.Message, opts ...option.RequestOption) *Model {
 	// allow message history to persist when user changes model being used
 	var messages []models.Message
 	if pastMessages != nil {
@@ -39,13 +47,13 @@ func NewModel(systemPrompt string, maxTokens int, modelName string, pastMessages
 			Messages:     messages,
 			PromptCount:  0, // TODO: ensure total usage cost is persisted between model changes
 		},
-		Client:       openai.NewClient(), // by default uses os.LookupEnv("OPENAI_API_KEY") TODO: use viper config var
+		Client:       openai.NewClient(append(defaultOptions, opts...)...), // by default uses os.LookupEnv("OPENAI_API_KEY") TODO: use viper config var
 		ModelConfig:  OpenAIModelConfigurations[modelName],
 		SystemPrompt: systemPrompt,
 	}
 }
 
-func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string, enableReasoning bool, reasoningEffort *uint8, responseChan chan models.StreamChunk) error {
+func (llm *Model) DoStreamPromptCompletion(ctx context.Context, prompt models.Message, enableReasoning bool, reasoningEffort *uint8, responseChan chan models.StreamChunk) error {
 	defer close(responseChan)
 
 	var (
@@ -88,7 +96,7 @@ func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string,
 	// https://pkg.go.dev/github.com/openai/openai-go/v2/responses#ResponseNewParams
 	stream := llm.Client.Responses.NewStreaming(ctx, responses.ResponseNewParams{
 		Model:           llm.ModelConfig.ID,
-		Input:           llm.buildMessages(content),
+		Input:           llm.buildMessages(prompt),
 		Reasoning:       reasoning,
 		Instructions:    param.Opt[string]{Value: llm.SystemPrompt},
 		MaxOutputTokens: param.Opt[int64]{Value: maxTokens},
@@ -96,6 +104,7 @@ func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string,
 		// Include:         []responses.ResponseIncludable{"reasoning.encrypted_content"},
 	})
 
+	llm.lastResponse = models.ResponseInfo{ModelID: llm.ModelConfig.ID}
 	for stream.Next() {
 		chunk := stream.Current()
 		// responses.ResponseOutputText  // a helper
@@ -105,38 +114,118 @@ func (llm *Model) DoStreamPromptCompletion(ctx context.Context, content string,
 		// 	log.Println("response completed")
 		// case responses.ResponseCreatedEvent:
 		// 	log.Println("response created")
-		// case responses.ResponseErrorEvent:
-		// 	log.Println("response error")
-		// case responses.ResponseFailedEvent:
-		// 	log.Println("response failed")
 		// case responses.ResponseReasoningSummaryTextDoneEvent:
 		// 	log.Println("response reasoning summary text done event: ")
 		// case responses.ResponseReasoningTextDoneEvent:
 		// 	log.Println("response reasoning text done event: ")
 		case responses.ResponseReasoningTextDeltaEvent:
-			fullResponseText += eventVariant.Delta
 			responseChan <- models.StreamChunk{Reasoning: true, Content: chunk.Delta}
 		case responses.ResponseTextDeltaEvent:
 			fullResponseText += eventVariant.Delta
 			responseChan <- models.StreamChunk{Reasoning: false, Content: chunk.Delta}
+		case responses.ResponseCompletedEvent:
+			llm.recordResponse(eventVariant.Response, models.StopReasonEndTurn)
+		case responses.ResponseIncompleteEvent:
+			llm.recordResponse(eventVariant.Response, incompleteStopReason(eventVariant.Response.IncompleteDetails.Reason))
+		case responses.ResponseFailedEvent:
+			llm.recordResponse(eventVariant.Response, "")
+			code := string(eventVariant.Response.Error.Code)
+			return streamError(code, fmt.Errorf("response failed: %s: %s", code, eventVariant.Response.Error.Message))
+		case responses.ResponseErrorEvent:
+			return streamError(eventVariant.Code, fmt.Errorf("received error while streaming: %s: %s", eventVariant.Code, eventVariant.Message))
 		}
 	}
 
 	if stream.Err() != nil {
-		return errors.New(stream.Err().Error())
+		return apiError(stream.Err())
 	}
 
 	// update state
 	llm.PromptCount++
+	llm.sideUsage.ApplyTo(&llm.lastResponse)
 
 	if len(fullResponseText) > 0 {
-		llm.Messages = append(llm.Messages, models.Message{Role: "assistant", Content: fullResponseText})
+		llm.Messages = append(llm.Messages,
+			prompt,
+			models.Message{Role: "assistant", Content: fullResponseText},
+		)
 	}
 	return nil
 }
 
+// recordResponse updates the usage and cost of the chat once a response has finished.
+func (llm *Model) recordResponse(response responses.Response, stopReason models.StopReason) {
+	llm.lastResponse.StopReason = stopReason
+	llm.lastResponse.Usage = models.Usage{
+		InputTokens:  int(response.Usage.InputTokens),
+		OutputTokens: int(response.Usage.OutputTokens),
+	}
+	llm.lastResponse.Cost = llm.ModelConfig.Cost(llm.lastResponse.Usage)
+	llm.totalCost += llm.lastResponse.Cost
+}
+
+// errorCodes maps the codes of OpenAI's error responses and mid-stream error events to an ErrorKind, and the HTTP status
+// they are sent with (https://platform.openai.com/docs/guides/error-codes).
+var errorCodes = map[string]struct {
+	kind   models.ErrorKind
+	status int
+}{
+	"invalid_api_key":         {models.ErrorAuth, http.StatusUnauthorized},
+	"model_not_found":         {models.ErrorInvalidModel, http.StatusNotFound},
+	"context_length_exceeded": {models.ErrorContextTooLong, http.StatusBadRequest},
+	"rate_limit_exceeded":     {models.ErrorRateLimit, http.StatusTooManyRequests},
+	"insufficient_quota":      {models.ErrorRateLimit, http.StatusTooManyRequests},
+	"server_error":            {models.ErrorUnknown, http.StatusInternalServerError},
+	"vector_store_timeout":    {models.ErrorUnknown, http.StatusInternalServerError},
+}
+
+// apiError converts an error from the SDK into a models.APIError, so that callers can tell whether to retry and what to tell
+// the user. Errors that didn't come from the API, such as network errors, are returned as-is.
+func apiError(err error) error {
+	var sdkErr *openai.Error
+	if !errors.As(err, &sdkErr) {
+		return err
+	}
+	apiErr := &models.APIError{
+		Kind:       models.KindFromStatus(sdkErr.StatusCode),
+		Provider:   "openai",
+		StatusCode: sdkErr.StatusCode,
+		Err:        err,
+	}
+	if sdkErr.Response != nil {
+		apiErr.RetryAfter = models.ParseRetryAfter(sdkErr.Response.Header)
+	}
+	if e, ok := errorCodes[sdkErr.Code]; ok {
+		apiErr.Kind = e.kind
+	}
+	if models.IsContextTooLongMessage(sdkErr.Message) {
+		apiErr.Kind = models.ErrorContextTooLong
+	}
+	return apiErr
+}
+
+// streamError converts an error sent mid-stream into a models.APIError with the kind and HTTP status its code would have had.
+func streamError(code string, err error) error {
+	e, ok := errorCodes[code]
+	if !ok {
+		return err
+	}
+	return &models.APIError{Kind: e.kind, Provider: "openai", StatusCode: e.status, Err: err}
+}
+
+// incompleteStopReason converts the reason of a response.incomplete event into the provider-agnostic models.StopReason.
+func incompleteStopReason(reason string) models.StopReason {
+	switch reason {
+	case "max_output_tokens":
+		return models.StopReasonMaxTokens
+	case "content_filter":
+		return models.StopReasonRefusal
+	}
+	return models.StopReason(reason)
+}
+
 // buildMessages takes the provider-agnostic []models.Message of the chat history and returns the OpenAI chat history data format.
-func (llm *Model) buildMessages(newContent string) responses.ResponseNewParamsInputUnion {
+func (llm *Model) buildMessages(prompt models.Message) responses.ResponseNewParamsInputUnion {
 	messages := make([]responses.ResponseInputItemUnionParam, 0, len(llm.Messages)+1)
 	var (
 		currentResponseInputParam  responses.ResponseInputItemUnionParam
@@ -147,7 +236,7 @@ func (llm *Model) buildMessages(newContent string) responses.ResponseNewParamsIn
 	// Add conversation history
 	for i := range len(llm.Messages) {
 		msg = llm.Messages[i]
-		currentMessageContentParam = responses.EasyInputMessageContentUnionParam{OfString: param.Opt[string]{Value: msg.Content}}
+		currentMessageContentParam = messageContent(&msg)
 
 		switch msg.Role {
 		case "user":
@@ -161,17 +250,76 @@ func (llm *Model) buildMessages(newContent string) responses.ResponseNewParamsIn
 	}
 
 	// Add current message
-	currentMessageContentParam = responses.EasyInputMessageContentUnionParam{OfString: param.Opt[string]{Value: newContent}}
+	currentMessageContentParam = messageContent(&prompt)
 	messages = append(messages, responses.ResponseInputItemUnionParam{OfMessage: &responses.EasyInputMessageParam{Content: currentMessageContentParam, Role: "user"}})
 
 	return responses.ResponseNewParamsInputUnion{OfInputItemList: messages}
 }
 
+// messageContent returns the content of a message: its text, or if it has parts, an input_image item for each image followed
+// by an input_text item.
+func messageContent(msg *models.Message) responses.EasyInputMessageContentUnionParam {
+	if len(msg.Parts) == 0 {
+		return responses.EasyInputMessageContentUnionParam{OfString: param.Opt[string]{Value: msg.Content}}
+	}
+	content := make(responses.ResponseInputMessageContentListParam, 0, len(msg.Parts)+1)
+	for i := range msg.Parts {
+		part := &msg.Parts[i]
+		switch part.Kind {
+		case models.PartImage:
+			content = append(content, responses.ResponseInputContentUnionParam{OfInputImage: &responses.ResponseInputImageParam{
+				Detail:   responses.ResponseInputImageDetailAuto,
+				ImageURL: param.Opt[string]{Value: part.DataURL()},
+			}})
+		case models.PartDocument:
+			content = append(content, responses.ResponseInputContentParamOfInputText(part.DocumentText()))
+		}
+	}
+	if msg.Content != "" {
+		content = append(content, responses.ResponseInputContentParamOfInputText(msg.Content))
+	}
+	return responses.EasyInputMessageContentUnionParam{OfInputItemContentList: content}
+}
+
 func (llm *Model) DoGetCostOfCurrentChat() float64 {
-	return 0
+	return llm.totalCost
+}
+
+func (llm *Model) DoGetLastResponseInfo() models.ResponseInfo {
+	return llm.lastResponse
+}
+
+func (llm *Model) DoAddUsage(info models.ResponseInfo) {
+	llm.totalCost += info.Cost
+	llm.sideUsage.Add(info)
+}
+
+func (llm *Model) DoGetPricing() models.Pricing {
+	return llm.ModelConfig.Pricing
+}
+
+// DoGetMaxOutputTokens returns the max_output_tokens parameter of a request, which includes reasoning tokens.
+func (llm *Model) DoGetMaxOutputTokens(bool) int {
+	return llm.MaxTokens
+}
+
+// DoCountTokens counts tokens locally with the model's tokenizer, as OpenAI has no endpoint for this.
+func (llm *Model) DoCountTokens(_ context.Context, systemPrompt string, history []models.Message, prompt models.Message) (models.TokenCount, error) {
+	enc, err := tokenizer(llm.ModelConfig.Encoding)
+	if err != nil {
+		return models.TokenCount{}, err
+	}
+	request := countTokens(enc, systemPrompt) + messageOverheadTokens + replyPrimingTokens
+	for i := range history {
+		request += countMessageTokens(enc, &history[i]) + messageOverheadTokens
+	}
+	promptTokens := countMessageTokens(enc, &prompt)
+	return models.TokenCount{Prompt: promptTokens, Request: request + promptTokens + messageOverheadTokens}, nil
 }
 
 func (llm *Model) DoClearChatHistory() {
+	llm.totalCost = 0
+	llm.sideUsage = models.SideUsage{}
 	llm.PromptCount = 0
 	llm.Messages = []models.Message{}
 	// TODO: reset usage
@@ -181,6 +329,18 @@ func (llm *Model) DoGetChatHistory() []models.Message {
 	return llm.Messages
 }
 
+func (llm *Model) DoSetChatHistory(messages []models.Message) {
+	llm.Messages = messages
+}
+
+func (llm *Model) DoGetSystemPrompt() string {
+	return llm.SystemPrompt
+}
+
+func (llm *Model) DoGetContextLimit() int {
+	return llm.ModelConfig.ContextWindow - llm.MaxTokens
+}
+
 func (llm *Model) DoGetModelId() string {
 	return llm.ModelConfig.ID
 }
@@ -191,3 +351,15 @@ func (llm *Model) DoesSupportReasoning() bool {
 	}
 	return false
 }
+
+func (llm *Model) DoesSupportVision() bool {
+	if vision := llm.ModelConfig.SupportsVision; vision != nil && *vision {
+		return true
+	}
+	return false
+}
+
+// DoesSupportDocuments returns false, as documents are sent to OpenAI as the text extracted from them.
+func (llm *Model) DoesSupportDocuments() bool {
+	return false
+}
@@ -0,0 +1,177 @@
+package openai
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/cassette"
+	"github.com/openai/openai-go/v3/option"
+)
+
+// newTestModel returns a Model whose requests are served by testdata/<name>.yaml, or recorded to it with OPENAI_API_KEY set.
+func newTestModel(t *testing.T, name string) (*Model, *cassette.Recorder) {
+	t.Helper()
+	rec := cassette.NewForTest(t, name)
+	opts := []option.RequestOption{option.WithHTTPClient(rec.Client()), option.WithMaxRetries(0)}
+	if rec.Mode() == cassette.ModeReplay {
+		opts = append(opts, option.WithAPIKey("test"))
+	}
+	return NewModel("You are a test.", 1024, "o4-mini", nil, opts...), rec
+}
+
+// stream prompts the model and collects the streamed chunks.
+func stream(llm *Model, prompt string, reasoning bool) (reasoningText, text string, err error) {
+	responseChan := make(chan models.StreamChunk)
+	errChan := make(chan error, 1)
+	effort := uint8(2)
+	go func() {
+		errChan <- llm.DoStreamPromptCompletion(context.Background(), models.UserMessage(prompt), reasoning, &effort, responseChan)
+	}()
+
+	var reasoningBuilder, textBuilder strings.Builder
+	for chunk := range responseChan {
+		if chunk.Reasoning {
+			reasoningBuilder.WriteString(chunk.Content)
+		} else {
+			textBuilder.WriteString(chunk.Content)
+		}
+	}
+	return reasoningBuilder.String(), textBuilder.String(), <-errChan
+}
+
+func TestStreamReasoning(t *testing.T) {
+	llm, rec := newTestModel(t, "reasoning")
+
+	reasoning, text, err := stream(llm, "Say hello", true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reasoning != "The user wants a greeting." {
+		t.Errorf("reasoning = %q", reasoning)
+	}
+	if text != "Hello, world!" {
+		t.Errorf("text = %q", text)
+	}
+
+	info := llm.DoGetLastResponseInfo()
+	if info.StopReason != models.StopReasonEndTurn {
+		t.Errorf("stop reason = %q", info.StopReason)
+	}
+	if info.Usage != (models.Usage{InputTokens: 21, OutputTokens: 40}) {
+		t.Errorf("usage = %+v", info.Usage)
+	}
+	if want := llm.ModelConfig.Cost(info.Usage); info.Cost != want || llm.DoGetCostOfCurrentChat() != want {
+		t.Errorf("cost = %v, total = %v, want %v", info.Cost, llm.DoGetCostOfCurrentChat(), want)
+	}
+
+	history := llm.DoGetChatHistory()
+	if len(history) != 2 || history[0].Content != "Say hello" || history[1].Content != "Hello, world!" {
+		t.Errorf("history = %+v", history)
+	}
+
+	var body struct {
+		Instructions string `json:"instructions"`
+		Store        bool   `json:"store"`
+		Reasoning    struct {
+			Effort string `json:"effort"`
+		} `json:"reasoning"`
+	}
+	if err := json.Unmarshal([]byte(rec.Requests()[0].Body), &body); err != nil {
+		t.Fatal(err)
+	}
+	if body.Instructions != "You are a test." || body.Store || body.Reasoning.Effort == "" {
+		t.Errorf("request = %+v", body)
+	}
+}
+
+func TestStreamIncomplete(t *testing.T) {
+	llm, _ := newTestModel(t, "incomplete")
+
+	if _, _, err := stream(llm, "Tell me a story", false); err != nil {
+		t.Fatal(err)
+	}
+	info := llm.DoGetLastResponseInfo()
+	if info.StopReason != models.StopReasonMaxTokens {
+		t.Errorf("stop reason = %q", info.StopReason)
+	}
+	if info.Usage.OutputTokens != 1024 {
+		t.Errorf("usage = %+v", info.Usage)
+	}
+}
+
+func TestStreamFailed(t *testing.T) {
+	llm, _ := newTestModel(t, "failed")
+
+	_, text, err := stream(llm, "Hi", false)
+	if err == nil || !strings.Contains(err.Error(), "server_error") {
+		t.Fatalf("err = %v", err)
+	}
+	if text != "Partial" {
+		t.Errorf("text = %q", text)
+	}
+	if len(llm.DoGetChatHistory()) != 0 {
+		t.Errorf("failed response was added to history: %+v", llm.DoGetChatHistory())
+	}
+	if usage := llm.DoGetLastResponseInfo().Usage; usage.InputTokens != 12 {
+		t.Errorf("usage of failed response = %+v", usage)
+	}
+}
+
+func TestStreamErrorEvent(t *testing.T) {
+	llm, _ := newTestModel(t, "stream_error")
+
+	_, _, err := stream(llm, "Hi", false)
+	if err == nil || !strings.Contains(err.Error(), "server_error") || !models.IsRetryable(err) {
+		t.Fatalf("err = %v", err)
+	}
+	if len(llm.DoGetChatHistory()) != 0 {
+		t.Errorf("failed response was added to history: %+v", llm.DoGetChatHistory())
+	}
+}
+
+func TestStreamHTTPError(t *testing.T) {
+	llm, _ := newTestModel(t, "rate_limited")
+
+	_, text, err := stream(llm, "Hi", false)
+	var apiErr *models.APIError
+	if !errors.As(err, &apiErr) || apiErr.StatusCode != 429 || apiErr.Kind != models.ErrorRateLimit || apiErr.RetryAfter != 20*time.Second {
+		t.Fatalf("err = %v", err)
+	}
+	if text != "" {
+		t.Errorf("text = %q", text)
+	}
+}
+
+func TestBuildMessagesImage(t *testing.T) {
+	llm := NewModel("", 1024, "gpt-4o", nil)
+	image := models.Part{Kind: models.PartImage, Name: "dot.png", MediaType: "image/png", Data: []byte("\x89PNG")}
+	body, err := json.Marshal(llm.buildMessages(models.UserMessage("What is this?", image)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"content":[{"detail":"auto","image_url":"data:image/png;base64,iVBORw==","type":"input_image"},` +
+		`{"text":"What is this?","type":"input_text"}],"role":"user"}]`
+	if string(body) != want {
+		t.Errorf("input = %s", body)
+	}
+}
+
+func TestBuildMessagesDocument(t *testing.T) {
+	llm := NewModel("", 1024, "gpt-4o", nil)
+	document := models.Part{Kind: models.PartDocument, Name: "spec.pdf", MediaType: models.DocumentMediaType, Data: []byte("%PDF"),
+		Text: "Pin 1: VCC", Pages: 1}
+	body, err := json.Marshal(llm.buildMessages(models.UserMessage("Which pin is VCC?", document)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `[{"content":[{"text":"\u003cdocument name=\"spec.pdf\" pages=\"1\"\u003e\nPin 1: VCC\n\u003c/document\u003e","type":"input_text"},` +
+		`{"text":"Which pin is VCC?","type":"input_text"}],"role":"user"}]`
+	if string(body) != want {
+		t.Errorf("input = %s", body)
+	}
+}
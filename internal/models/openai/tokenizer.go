@@ -0,0 +1,62 @@
+package openai
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/pkoukk/tiktoken-go"
+	tiktoken_loader "github.com/pkoukk/tiktoken-go-loader"
+)
+
+// tokens added by the chat format, from https://cookbook.openai.com/examples/how_to_count_tokens_with_tiktoken
+const (
+	messageOverheadTokens = 3 // per message
+	replyPrimingTokens    = 3 // every reply is primed with <|start|>assistant<|message|>
+)
+
+func init() {
+	// the vocabularies are embedded in the binary, so that counting tokens never downloads them
+	tiktoken.SetBpeLoader(tiktoken_loader.NewOfflineLoader())
+}
+
+// tokenizers caches the tokenizer of each vocabulary, which takes a few hundred milliseconds to load.
+var (
+	tokenizersMu sync.Mutex
+	tokenizers   = map[string]*tiktoken.Tiktoken{}
+)
+
+// tokenizer returns the BPE tokenizer of a vocabulary such as o200k_base, loading it on first use.
+func tokenizer(encoding string) (*tiktoken.Tiktoken, error) {
+	tokenizersMu.Lock()
+	defer tokenizersMu.Unlock()
+	if enc, ok := tokenizers[encoding]; ok {
+		return enc, nil
+	}
+	enc, err := tiktoken.GetEncoding(encoding)
+	if err != nil {
+		return nil, fmt.Errorf("error loading the %s tokenizer: %w", encoding, err)
+	}
+	tokenizers[encoding] = enc
+	return enc, nil
+}
+
+// countTokens counts the tokens of text. Special tokens such as <|endoftext|> are counted as ordinary text, as the API does
+// for text sent by the user.
+func countTokens(enc *tiktoken.Tiktoken, text string) int {
+	return len(enc.EncodeOrdinary(text))
+}
+
+// countMessageTokens counts the text of a message and its documents, and estimates its images, whose cost depends on how the
+// API resizes them.
+func countMessageTokens(enc *tiktoken.Tiktoken, msg *models.Message) int {
+	tokens := countTokens(enc, msg.Content)
+	for i := range msg.Parts {
+		if part := &msg.Parts[i]; part.Kind == models.PartDocument {
+			tokens += countTokens(enc, part.DocumentText())
+		} else {
+			tokens += models.EstimatePartTokens(part)
+		}
+	}
+	return tokens
+}
@@ -0,0 +1,59 @@
+package openai
+
+import (
+	"context"
+	"testing"
+
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/pkoukk/tiktoken-go"
+)
+
+// counts from tiktoken's reference implementation and the examples of https://cookbook.openai.com/examples/how_to_count_tokens_with_tiktoken
+func TestCountTokens(t *testing.T) {
+	tests := []struct {
+		text          string
+		o200k, cl100k int
+	}{
+		{"", 0, 0},
+		{"hello world", 2, 2},
+		{"tiktoken is great!", 6, 6},
+		{"antidisestablishmentarianism", 6, 6},
+		{"2 + 2 = 4", 7, 7},
+		{"お誕生日おめでとう", 8, 9},
+		{"<|endoftext|>", 7, 7}, // counted as text, not as the special token
+	}
+	for _, encoding := range []string{tiktoken.MODEL_O200K_BASE, tiktoken.MODEL_CL100K_BASE} {
+		enc, err := tokenizer(encoding)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, tt := range tests {
+			want := tt.o200k
+			if encoding == tiktoken.MODEL_CL100K_BASE {
+				want = tt.cl100k
+			}
+			if got := countTokens(enc, tt.text); got != want {
+				t.Errorf("%s: countTokens(%q) = %d, want %d", encoding, tt.text, got, want)
+			}
+		}
+	}
+}
+
+func TestDoCountTokens(t *testing.T) {
+	history := []models.Message{
+		{Role: "user", Content: "hello world"},
+		{Role: "assistant", Content: "tiktoken is great!"},
+	}
+	llm := NewModel("2 + 2 = 4", 1024, "gpt-4o", &history)
+
+	counter := models.SnapshotTokenCounter(llm)
+	llm.DoClearChatHistory() // counted as the history was when the counter was created
+	count, err := counter(context.Background(), models.UserMessage("antidisestablishmentarianism"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	// each message, including the system prompt, adds 3 tokens, and the reply is primed with 3 more
+	if want := (models.TokenCount{Prompt: 6, Request: 7 + 2 + 6 + 6 + 4*3 + 3}); count != want {
+		t.Errorf("count = %+v, want %+v", count, want)
+	}
+}
@@ -0,0 +1,139 @@
+package models
+
+import (
+	"bytes"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"image"
+	_ "image/gif"  // registers the decoder used by EstimatePartTokens
+	_ "image/jpeg" // registers the decoder used by EstimatePartTokens
+	_ "image/png"  // registers the decoder used by EstimatePartTokens
+	"slices"
+	"strings"
+)
+
+// PartKind is the kind of a non-text part of a message.
+type PartKind string
+
+const (
+	PartImage    PartKind = "image"
+	PartDocument PartKind = "document"
+)
+
+// Part is a file sent with a message alongside its text, such as an image or a PDF. Providers send parts before the text.
+type Part struct {
+	Kind      PartKind
+	Name      string // file name, shown to the user
+	MediaType string // e.g. image/png
+	Data      []byte
+
+	// set for documents
+	Text  string // extracted locally, and sent in place of the document to models that can't read documents
+	Pages int
+}
+
+// ImageMediaTypes lists the image formats that every provider accepts.
+var ImageMediaTypes = []string{"image/png", "image/jpeg", "image/gif", "image/webp"}
+
+// MaxImageSize is the largest image, in bytes, that every provider accepts.
+const MaxImageSize = 5 << 20
+
+// DocumentMediaType is the only document format that is supported.
+const DocumentMediaType = "application/pdf"
+
+const (
+	// MaxDocumentSize is the largest document, in bytes, whose base64 encoding fits in Anthropic's 32 MB request limit.
+	MaxDocumentSize = 24 << 20
+	// MaxDocumentPages is the most pages Anthropic reads from a document.
+	MaxDocumentPages = 100
+)
+
+var (
+	// ErrVisionUnsupported is returned when an image is sent to a model that can't read images.
+	ErrVisionUnsupported = errors.New("model does not support images")
+	// ErrNoDocumentText is returned when a document without text, such as a scanned one, is sent to a model that can only be
+	// sent the text of documents.
+	ErrNoDocumentText = errors.New("document has no text")
+)
+
+// UserMessage returns a user message with the given text and parts.
+func UserMessage(text string, parts ...Part) Message {
+	return Message{Role: "user", Content: text, Parts: parts}
+}
+
+// IsEmpty returns whether a message has neither text nor parts.
+func (m Message) IsEmpty() bool {
+	return m.Content == "" && len(m.Parts) == 0
+}
+
+// CheckParts returns an error if the LLM can't read the parts of a message, so that the message is rejected before it is
+// sent.
+func CheckParts(llm LLM, msg Message) error {
+	for i := range msg.Parts {
+		part := &msg.Parts[i]
+		switch part.Kind {
+		case PartImage:
+			if !llm.DoesSupportVision() {
+				return fmt.Errorf("%w: %s can't read %s", ErrVisionUnsupported, llm.DoGetModelId(), part.Name)
+			}
+			if !slices.Contains(ImageMediaTypes, part.MediaType) {
+				return fmt.Errorf("%s: unsupported image format %s", part.Name, part.MediaType)
+			}
+		case PartDocument:
+			if part.MediaType != DocumentMediaType {
+				return fmt.Errorf("%s: unsupported document format %s", part.Name, part.MediaType)
+			}
+			if part.Pages > MaxDocumentPages {
+				return fmt.Errorf("%s: document has more than %d pages", part.Name, MaxDocumentPages)
+			}
+			if !llm.DoesSupportDocuments() && strings.TrimSpace(part.Text) == "" {
+				return fmt.Errorf("%w: %s can only read the text of %s, and none could be extracted", ErrNoDocumentText,
+					llm.DoGetModelId(), part.Name)
+			}
+		default:
+			return fmt.Errorf("%s: unsupported attachment kind %q", part.Name, part.Kind)
+		}
+	}
+	return nil
+}
+
+// Base64 returns the part's data encoded for a provider's API.
+func (p *Part) Base64() string {
+	return base64.StdEncoding.EncodeToString(p.Data)
+}
+
+// DataURL returns the part's data as a data URL, e.g. data:image/png;base64,...
+func (p *Part) DataURL() string {
+	return "data:" + p.MediaType + ";base64," + p.Base64()
+}
+
+// DocumentText returns the text sent in place of a document to models that can't read documents.
+func (p *Part) DocumentText() string {
+	return fmt.Sprintf("<document name=%q pages=\"%d\">\n%s\n</document>", p.Name, p.Pages, strings.TrimSpace(p.Text))
+}
+
+// tokens used by an image whose size can't be read, which is the most that Anthropic charges for one image.
+const maxImageTokens = 1600
+
+// tokens used by each page of a document read natively, which is sent as both its text and an image of the page
+// (https://docs.anthropic.com/en/docs/build-with-claude/pdf-support#estimate-your-costs).
+const documentPageTokens = 1500
+
+// EstimatePartTokens returns a rough token count for a part. Images cost about one token per 750 pixels, and are scaled down
+// by providers to about 1.15 megapixels (https://docs.anthropic.com/en/docs/build-with-claude/vision#evaluate-image-size).
+// Documents are estimated as if read natively, unless their text alone is larger.
+func EstimatePartTokens(part *Part) int {
+	switch part.Kind {
+	case PartImage:
+	case PartDocument:
+		return max(EstimateTokens(part.DocumentText()), part.Pages*documentPageTokens)
+	default:
+		return 0
+	}
+	config, _, err := image.DecodeConfig(bytes.NewReader(part.Data))
+	if err != nil {
+		return maxImageTokens
+	}
+	return min(maxImageTokens, max(1, config.Width*config.Height/750))
+}
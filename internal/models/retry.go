@@ -0,0 +1,162 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand/v2"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// ParseRetryAfter returns the delay requested by the retry-after-ms or retry-after headers of a response, or zero.
+func ParseRetryAfter(header http.Header) time.Duration {
+	if header == nil {
+		return 0
+	}
+	if ms, err := strconv.ParseFloat(header.Get("Retry-After-Ms"), 64); err == nil && ms > 0 {
+		return time.Duration(ms * float64(time.Millisecond))
+	}
+	value := header.Get("Retry-After")
+	if seconds, err := strconv.ParseFloat(value, 64); err == nil && seconds > 0 {
+		return time.Duration(seconds * float64(time.Second))
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return max(0, time.Until(date))
+	}
+	return 0
+}
+
+// IsRetryable returns whether a request that failed with err may succeed if it is made again: rate limits, overloaded or
+// failing servers, timeouts and dropped connections.
+func IsRetryable(err error) bool {
+	switch KindOf(err) {
+	case ErrorRateLimit, ErrorOverloaded, ErrorNetwork:
+		return true
+	case ErrorUnknown:
+		var apiErr *APIError
+		if errors.As(err, &apiErr) {
+			code := apiErr.StatusCode
+			return code == http.StatusRequestTimeout || code == http.StatusConflict || code >= http.StatusInternalServerError
+		}
+	case ErrorAuth, ErrorContextTooLong, ErrorInvalidModel, ErrorCancelled:
+	}
+	return false
+}
+
+// RetryPolicy determines how failed requests are retried.
+type RetryPolicy struct {
+	MaxAttempts int           // including the first attempt. 1 disables retrying
+	BaseDelay   time.Duration // delay before the first retry, doubled for each retry after that
+	MaxDelay    time.Duration // upper bound of the exponential backoff
+	MaxElapsed  time.Duration // no retry is started if it would begin this long after the first attempt
+}
+
+// DefaultRetryPolicy is used unless the user configures retries.
+var DefaultRetryPolicy = RetryPolicy{
+	MaxAttempts: 4,
+	BaseDelay:   time.Second,
+	MaxDelay:    30 * time.Second,
+	MaxElapsed:  2 * time.Minute,
+}
+
+// Delay returns how long to wait before retrying after the given failed attempt (starting at 1). The provider's retry-after is
+// honored, otherwise the delay backs off exponentially with jitter.
+func (p RetryPolicy) Delay(attempt int, err error) time.Duration {
+	var apiErr *APIError
+	if errors.As(err, &apiErr) && apiErr.RetryAfter > 0 {
+		return apiErr.RetryAfter
+	}
+	delay := p.BaseDelay << min(attempt-1, 16)
+	if p.MaxDelay > 0 && (delay > p.MaxDelay || delay <= 0) {
+		delay = p.MaxDelay
+	}
+	// jitter of ±20% so that concurrent clients don't retry in lockstep
+	return time.Duration(float64(delay) * (0.8 + 0.4*rand.Float64())) //nolint:gosec // not used for security
+}
+
+// RetryStatus describes a retry that is about to happen. It is sent to the TUI as a bubbletea message to show a countdown.
+type RetryStatus struct {
+	Attempt     int // the attempt that is about to start, starting at 2
+	MaxAttempts int
+	At          time.Time // when the attempt will start
+	Err         error     // the error of the failed attempt
+}
+
+// StreamPromptCompletionWithRetry is StreamPromptCompletion, retrying transient errors according to policy. onRetry, if not
+// nil, is called before waiting for each retry. A request is only retried if nothing has been streamed yet; otherwise the
+// returned StreamError is marked as partial, since the response can't be resumed. The providers' SDK clients are created
+// with their own retries disabled, so that every retry is made here and reported to the user.
+func StreamPromptCompletionWithRetry(
+	ctx context.Context,
+	llm LLM,
+	prompt Message,
+	enableReasoning bool,
+	reasoningEffort *uint8,
+	responseChan chan StreamChunk,
+	policy RetryPolicy,
+	onRetry func(RetryStatus),
+) error {
+	defer close(responseChan)
+	start := time.Now()
+
+	for attempt := 1; ; attempt++ {
+		streamed := false
+		attemptChan := make(chan StreamChunk)
+		done := make(chan struct{})
+		go func() {
+			for chunk := range attemptChan {
+				streamed = true
+				responseChan <- chunk
+			}
+			close(done)
+		}()
+		err := streamAttempt(ctx, llm, prompt, enableReasoning, reasoningEffort, attemptChan)
+		<-done
+
+		if err == nil {
+			return nil
+		}
+		if streamed {
+			return StreamError{Err: err, Partial: true}
+		}
+		if attempt >= policy.MaxAttempts || !IsRetryable(err) || ctx.Err() != nil {
+			return StreamError{Err: err}
+		}
+
+		delay := policy.Delay(attempt, err)
+		if policy.MaxElapsed > 0 && time.Since(start)+delay > policy.MaxElapsed {
+			return StreamError{Err: err}
+		}
+		if onRetry != nil {
+			onRetry(RetryStatus{Attempt: attempt + 1, MaxAttempts: policy.MaxAttempts, At: time.Now().Add(delay), Err: err})
+		}
+
+		timer := time.NewTimer(delay)
+		select {
+		case <-ctx.Done():
+			timer.Stop()
+			return StreamError{Err: ctx.Err()}
+		case <-timer.C:
+		}
+	}
+}
+
+// streamAttempt calls DoStreamPromptCompletion, turning a panic of the provider into an error so that a response it fails to
+// handle doesn't crash the app. Providers close responseChan with a defer, so it is closed either way.
+func streamAttempt(
+	ctx context.Context,
+	llm LLM,
+	prompt Message,
+	enableReasoning bool,
+	reasoningEffort *uint8,
+	responseChan chan StreamChunk,
+) (err error) {
+	defer func() {
+		if p := recover(); p != nil {
+			err = fmt.Errorf("panic while streaming: %v", p)
+		}
+	}()
+	return llm.DoStreamPromptCompletion(ctx, prompt, enableReasoning, reasoningEffort, responseChan)
+}
@@ -0,0 +1,298 @@
+package models
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func apiError(kind ErrorKind, status int, retryAfter time.Duration) error {
+	return &APIError{Kind: kind, Provider: "fake", StatusCode: status, RetryAfter: retryAfter, Err: fmt.Errorf("status %d", status)}
+}
+
+func TestDelay(t *testing.T) {
+	policy := RetryPolicy{MaxAttempts: 10, BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	overloaded := apiError(ErrorOverloaded, 529, 0)
+	tests := []struct {
+		name    string
+		policy  RetryPolicy
+		attempt int
+		err     error
+		want    time.Duration // before jitter
+		jitter  bool
+	}{
+		{"first retry", policy, 1, overloaded, time.Second, true},
+		{"doubles", policy, 2, overloaded, 2 * time.Second, true},
+		{"doubles again", policy, 4, overloaded, 8 * time.Second, true},
+		{"capped", policy, 5, overloaded, 10 * time.Second, true},
+		{"never overflows", policy, 100, overloaded, 10 * time.Second, true},
+		{"uncapped", RetryPolicy{BaseDelay: time.Second}, 6, overloaded, 32 * time.Second, true},
+		{"honors retry-after", policy, 1, apiError(ErrorRateLimit, 429, 3*time.Second), 3 * time.Second, false},
+		{"honors retry-after beyond the cap", policy, 1, apiError(ErrorRateLimit, 429, time.Minute), time.Minute, false},
+		{"honors wrapped retry-after", policy, 3, StreamError{Err: apiError(ErrorRateLimit, 429, 1500*time.Millisecond)}, 1500 * time.Millisecond, false},
+		{"network error", policy, 2, io.ErrUnexpectedEOF, 2 * time.Second, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			low, high := tt.want, tt.want
+			if tt.jitter {
+				low, high = time.Duration(float64(tt.want)*0.8), time.Duration(float64(tt.want)*1.2)
+			}
+			for range 100 {
+				if got := tt.policy.Delay(tt.attempt, tt.err); got < low || got > high {
+					t.Fatalf("Delay(%d) = %v, want between %v and %v", tt.attempt, got, low, high)
+				}
+			}
+		})
+	}
+}
+
+func TestDelayJitters(t *testing.T) {
+	policy := RetryPolicy{BaseDelay: time.Second, MaxDelay: 10 * time.Second}
+	seen := map[time.Duration]bool{}
+	for range 20 {
+		seen[policy.Delay(1, nil)] = true
+	}
+	if len(seen) < 2 {
+		t.Errorf("20 delays were all %v", seen)
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	date := time.Now().Add(time.Hour).UTC().Format(http.TimeFormat)
+	tests := []struct {
+		name      string
+		header    http.Header
+		low, high time.Duration
+	}{
+		{"none", http.Header{}, 0, 0},
+		{"nil", nil, 0, 0},
+		{"seconds", http.Header{"Retry-After": {"5"}}, 5 * time.Second, 5 * time.Second},
+		{"fractional seconds", http.Header{"Retry-After": {"0.5"}}, 500 * time.Millisecond, 500 * time.Millisecond},
+		{"milliseconds", http.Header{"Retry-After-Ms": {"250"}}, 250 * time.Millisecond, 250 * time.Millisecond},
+		{"milliseconds take precedence", http.Header{"Retry-After-Ms": {"250"}, "Retry-After": {"5"}}, 250 * time.Millisecond, 250 * time.Millisecond},
+		{"invalid milliseconds", http.Header{"Retry-After-Ms": {"soon"}, "Retry-After": {"5"}}, 5 * time.Second, 5 * time.Second},
+		{"http date", http.Header{"Retry-After": {date}}, 59 * time.Minute, time.Hour},
+		{"past http date", http.Header{"Retry-After": {"Mon, 02 Jan 2006 15:04:05 GMT"}}, 0, 0},
+		{"negative", http.Header{"Retry-After": {"-5"}}, 0, 0},
+		{"invalid", http.Header{"Retry-After": {"later"}}, 0, 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ParseRetryAfter(tt.header); got < tt.low || got > tt.high {
+				t.Errorf("ParseRetryAfter(%v) = %v, want between %v and %v", tt.header, got, tt.low, tt.high)
+			}
+		})
+	}
+}
+
+func TestIsRetryable(t *testing.T) {
+	tests := []struct {
+		name string
+		err  error
+		want bool
+	}{
+		{"rate limit", apiError(ErrorRateLimit, 429, 0), true},
+		{"overloaded", apiError(ErrorOverloaded, 529, 0), true},
+		{"network", io.ErrUnexpectedEOF, true},
+		{"timeout", context.DeadlineExceeded, true},
+		{"server error", apiError(ErrorUnknown, 500, 0), true},
+		{"bad gateway", apiError(ErrorUnknown, 502, 0), true},
+		{"request timeout", apiError(ErrorUnknown, 408, 0), true},
+		{"conflict", apiError(ErrorUnknown, 409, 0), true},
+		{"wrapped", StreamError{Err: apiError(ErrorOverloaded, 503, 0)}, true},
+		{"bad request", apiError(ErrorUnknown, 400, 0), false},
+		{"auth", apiError(ErrorAuth, 401, 0), false},
+		{"context too long", apiError(ErrorContextTooLong, 400, 0), false},
+		{"invalid model", apiError(ErrorInvalidModel, 404, 0), false},
+		{"cancelled", context.Canceled, false},
+		{"unknown error without a status", errors.New("something broke"), false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := IsRetryable(tt.err); got != tt.want {
+				t.Errorf("IsRetryable(%v) = %v, want %v", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// attempt is the scripted outcome of one request to a flakyLLM.
+type attempt struct {
+	chunks []string
+	err    error
+	panic  bool
+}
+
+// flakyLLM streams the chunks of each attempt in turn, then fails with its error.
+type flakyLLM struct {
+	fakeLLM
+	attempts []attempt
+	made     int
+}
+
+func (llm *flakyLLM) DoStreamPromptCompletion(_ context.Context, _ Message, _ bool, _ *uint8, responseChan chan StreamChunk) error {
+	defer close(responseChan)
+	a := llm.attempts[min(llm.made, len(llm.attempts)-1)]
+	llm.made++
+	for _, chunk := range a.chunks {
+		responseChan <- StreamChunk{Content: chunk}
+	}
+	if a.panic {
+		panic("unexpected event")
+	}
+	return a.err
+}
+
+func TestStreamPromptCompletionWithRetry(t *testing.T) {
+	overloaded := apiError(ErrorOverloaded, 529, 0)
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond}
+	tests := []struct {
+		name         string
+		attempts     []attempt
+		policy       RetryPolicy
+		wantResponse string
+		wantAttempts int
+		wantRetries  int
+		wantErr      string
+		wantPartial  bool
+	}{
+		{
+			name:         "succeeds",
+			attempts:     []attempt{{chunks: []string{"hello ", "world"}}},
+			policy:       policy,
+			wantResponse: "hello world",
+			wantAttempts: 1,
+		},
+		{
+			name:         "succeeds after retries",
+			attempts:     []attempt{{err: overloaded}, {err: io.ErrUnexpectedEOF}, {chunks: []string{"ok"}}},
+			policy:       policy,
+			wantResponse: "ok",
+			wantAttempts: 3,
+			wantRetries:  2,
+		},
+		{
+			name:         "gives up after the max attempts",
+			attempts:     []attempt{{err: overloaded}},
+			policy:       policy,
+			wantAttempts: 3,
+			wantRetries:  2,
+			wantErr:      "status 529",
+		},
+		{
+			name:         "does not retry errors that aren't transient",
+			attempts:     []attempt{{err: apiError(ErrorAuth, 401, 0)}, {chunks: []string{"unreachable"}}},
+			policy:       policy,
+			wantAttempts: 1,
+			wantErr:      "status 401",
+		},
+		{
+			name:         "does not retry after partial output",
+			attempts:     []attempt{{chunks: []string{"half an "}, err: overloaded}, {chunks: []string{"unreachable"}}},
+			policy:       policy,
+			wantResponse: "half an ",
+			wantAttempts: 1,
+			wantErr:      "status 529",
+			wantPartial:  true,
+		},
+		{
+			name:         "turns a panic of the provider into an error",
+			attempts:     []attempt{{panic: true}, {chunks: []string{"unreachable"}}},
+			policy:       policy,
+			wantAttempts: 1,
+			wantErr:      "panic while streaming: unexpected event",
+		},
+		{
+			name:         "does not retry when disabled",
+			attempts:     []attempt{{err: overloaded}, {chunks: []string{"unreachable"}}},
+			policy:       RetryPolicy{MaxAttempts: 1},
+			wantAttempts: 1,
+			wantErr:      "status 529",
+		},
+		{
+			name:         "does not retry past the max elapsed time",
+			attempts:     []attempt{{err: apiError(ErrorRateLimit, 429, time.Hour)}, {chunks: []string{"unreachable"}}},
+			policy:       RetryPolicy{MaxAttempts: 3, BaseDelay: time.Millisecond, MaxElapsed: time.Minute},
+			wantAttempts: 1,
+			wantErr:      "status 429",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			llm := &flakyLLM{attempts: tt.attempts}
+			responseChan := make(chan StreamChunk)
+			errChan := make(chan error, 1)
+			var retries []RetryStatus
+			go func() {
+				errChan <- StreamPromptCompletionWithRetry(context.Background(), llm, UserMessage("hi"), false, nil, responseChan, tt.policy,
+					func(status RetryStatus) { retries = append(retries, status) })
+			}()
+			var response strings.Builder
+			for chunk := range responseChan { // closed when the last attempt is done
+				response.WriteString(chunk.Content)
+			}
+			err := <-errChan
+
+			if response.String() != tt.wantResponse {
+				t.Errorf("response = %q, want %q", response.String(), tt.wantResponse)
+			}
+			if llm.made != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", llm.made, tt.wantAttempts)
+			}
+			if len(retries) != tt.wantRetries {
+				t.Errorf("retries = %+v, want %d", retries, tt.wantRetries)
+			}
+			for i, status := range retries {
+				if status.Attempt != i+2 || status.MaxAttempts != tt.policy.MaxAttempts || status.Err == nil {
+					t.Errorf("retry %d = %+v", i, status)
+				}
+			}
+
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Errorf("error = %v", err)
+				}
+				return
+			}
+			var streamErr StreamError
+			if !errors.As(err, &streamErr) || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want a StreamError containing %q", err, tt.wantErr)
+			}
+			if streamErr.Partial != tt.wantPartial {
+				t.Errorf("partial = %v, want %v", streamErr.Partial, tt.wantPartial)
+			}
+		})
+	}
+}
+
+func TestStreamPromptCompletionWithRetryCancelled(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	llm := &flakyLLM{attempts: []attempt{{err: apiError(ErrorOverloaded, 529, 0)}}}
+	policy := RetryPolicy{MaxAttempts: 3, BaseDelay: time.Hour}
+	responseChan := make(chan StreamChunk)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- StreamPromptCompletionWithRetry(ctx, llm, UserMessage("hi"), false, nil, responseChan, policy,
+			func(RetryStatus) { cancel() }) // cancelled while waiting to retry
+	}()
+	for range responseChan {
+	}
+
+	select {
+	case err := <-errChan:
+		if !errors.Is(err, context.Canceled) || KindOf(err) != ErrorCancelled {
+			t.Errorf("error = %v, want cancelled", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("the retry wait was not cancelled")
+	}
+	if llm.made != 1 {
+		t.Errorf("attempts = %d, want 1", llm.made)
+	}
+}
@@ -0,0 +1,55 @@
+package models
+
+import (
+	"context"
+	"fmt"
+	"slices"
+)
+
+// TokenCount is the number of input tokens a request will use, counted before it is sent.
+type TokenCount struct {
+	Prompt  int // tokens of the new prompt alone
+	Request int // tokens of the entire request: system prompt, chat history and new prompt
+}
+
+// TokenCounter is implemented by LLMs that can count tokens more accurately than EstimateTokens, either with a tokenizer or an
+// API endpoint. DoCountTokens is called concurrently with requests, so it must count the given system prompt and history
+// rather than the LLM's own.
+type TokenCounter interface {
+	DoCountTokens(ctx context.Context, systemPrompt string, history []Message, prompt Message) (TokenCount, error)
+}
+
+// TokenCountFunc counts the input tokens of a request with a prompt.
+type TokenCountFunc func(ctx context.Context, prompt Message) (TokenCount, error)
+
+// SnapshotTokenCounter returns a TokenCountFunc for requests with the LLM's current system prompt and a copy of its chat
+// history. It must be called where the LLM is used, e.g. bubbletea's Update func, but the TokenCountFunc it returns may be
+// called from any goroutine, as it doesn't read the LLM's history. It uses the LLM's TokenCounter, falling back to a
+// heuristic estimate if the LLM does not implement one.
+func SnapshotTokenCounter(llm LLM) TokenCountFunc {
+	systemPrompt, history := llm.DoGetSystemPrompt(), slices.Clone(llm.DoGetChatHistory())
+	if counter, ok := llm.(TokenCounter); ok {
+		return func(ctx context.Context, prompt Message) (TokenCount, error) {
+			return counter.DoCountTokens(ctx, systemPrompt, history, prompt)
+		}
+	}
+	return func(_ context.Context, prompt Message) (TokenCount, error) {
+		return TokenCount{
+			Prompt:  EstimateMessageTokens(&prompt),
+			Request: EstimateRequestTokens(systemPrompt, history, prompt),
+		}, nil
+	}
+}
+
+// EstimateInputCost returns the cost in dollars of sending a number of input tokens to the LLM.
+func EstimateInputCost(llm LLM, tokens int) float64 {
+	return llm.DoGetPricing().PromptCost * float64(tokens)
+}
+
+// FormatTokens formats a token count compactly, e.g. 950 or 12.3k.
+func FormatTokens(tokens int) string {
+	if tokens < 1000 {
+		return fmt.Sprintf("%d", tokens)
+	}
+	return fmt.Sprintf("%.1fk", float64(tokens)/1000)
+}
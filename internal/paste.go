@@ -0,0 +1,94 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/math"
+	"github.com/gregriff/ducky/internal/styles"
+)
+
+const (
+	pasteCollapseLines = 20    // pastes with more lines than this are collapsed into a placeholder
+	pasteCollapseChars = 2_000 // as are pastes longer than this, such as minified code
+)
+
+// pastedText is a large paste, which is shown in the textarea as its placeholder and expanded when the prompt is sent.
+type pastedText struct {
+	placeholder string
+	content     string
+}
+
+// paste inserts text pasted into the terminal into the textarea. Large pastes are collapsed into a placeholder, as the
+// textarea grinds to a halt with thousands of lines in it.
+func (m *model) paste(content string) (tea.Model, tea.Cmd) {
+	content = cleanPaste(content)
+	if isLargePaste(content) {
+		content = m.collapsePaste(content)
+	} else if wrappedLineCount := m.getNumLines(content); wrappedLineCount > m.textarea.Height() {
+		m.resizeTextarea(math.Clamp(wrappedLineCount, styles.TEXTAREA_HEIGHT_NORMAL, m.textarea.MaxHeight))
+	}
+	return m.updateTextarea(tea.PasteMsg{Content: content})
+}
+
+// setPrompt replaces the text in the textarea, collapsing it as if it were pasted if it is large.
+func (m *model) setPrompt(text string) {
+	if isLargePaste(text) {
+		text = m.collapsePaste(text)
+	}
+	m.textarea.SetValue(text)
+}
+
+// collapsePaste stores a large paste and returns the placeholder to show in its place, e.g. "[pasted 420 lines]".
+func (m *model) collapsePaste(content string) string {
+	for _, p := range m.pastes {
+		if p.content == content {
+			return p.placeholder
+		}
+	}
+	var placeholder string
+	if lines := strings.Count(strings.TrimRight(content, "\n"), "\n") + 1; lines > 1 {
+		placeholder = fmt.Sprintf("[pasted %d lines", lines)
+	} else {
+		placeholder = fmt.Sprintf("[pasted %d chars", len([]rune(content)))
+	}
+	if len(m.pastes) > 0 {
+		placeholder += fmt.Sprintf(" #%d", len(m.pastes)+1) // in case two pastes have the same size
+	}
+	placeholder += "]"
+	m.pastes = append(m.pastes, pastedText{placeholder: placeholder, content: content})
+	return placeholder
+}
+
+// expandPastes returns text with the placeholders of collapsed pastes replaced by the pasted text.
+func (m *model) expandPastes(text string) string {
+	if len(m.pastes) == 0 {
+		return text
+	}
+	pairs := make([]string, 0, 2*len(m.pastes))
+	for _, p := range m.pastes {
+		pairs = append(pairs, p.placeholder, p.content)
+	}
+	return strings.NewReplacer(pairs...).Replace(text)
+}
+
+// isLargePaste returns whether pasted text should be collapsed into a placeholder.
+func isLargePaste(text string) bool {
+	return len(text) > pasteCollapseChars || strings.Count(strings.TrimRight(text, "\n"), "\n") >= pasteCollapseLines
+}
+
+// cleanPaste normalizes the line endings of pasted text, and removes escape sequences and other control characters, which
+// would garble the textarea and mean nothing to the model.
+func cleanPaste(text string) string {
+	text = strings.ReplaceAll(text, "\r\n", "\n")
+	text = strings.ReplaceAll(text, "\r", "\n")
+	return strings.Map(func(r rune) rune {
+		if r == '\n' || r == '\t' || !unicode.IsControl(r) {
+			return r
+		}
+		return -1
+	}, ansi.Strip(text))
+}
@@ -0,0 +1,131 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/session"
+	styles "github.com/gregriff/ducky/internal/styles"
+)
+
+const searchResultsShown = 8 // results listed at once; the list scrolls to the selected one
+
+// searchView lists the saved sessions' entries that match a /search query, above the textarea.
+type searchView struct {
+	query    string
+	results  []session.Result
+	selected int
+}
+
+// searchResults is the result of searching the saved sessions in the background.
+type searchResults struct {
+	query   string
+	results []session.Result
+	err     error
+}
+
+// searchSessions searches the saved sessions in the background, for /search [-r] <query>. With -r, the models' reasoning is
+// searched too.
+func (m *model) searchSessions(args []string) tea.Cmd {
+	opts := session.SearchOptions{}
+	if len(args) > 0 && args[0] == "-r" {
+		opts.Reasoning, args = true, args[1:]
+	}
+	query := strings.Join(args, " ")
+	if query == "" {
+		m.setNotice("usage: /search [-r] <query>")
+		return nil
+	}
+	if m.sessionStore == nil {
+		m.setNotice("sessions aren't being saved, so there is nothing to search")
+		return nil
+	}
+
+	store := m.sessionStore
+	return func() tea.Msg {
+		results, err := store.Search(query, opts)
+		return searchResults{query: query, results: results, err: err}
+	}
+}
+
+// showSearchResults opens the list of results, or shows why there are none.
+func (m *model) showSearchResults(msg searchResults) {
+	switch {
+	case msg.err != nil:
+		m.setNotice(msg.err.Error())
+	case len(msg.results) == 0:
+		m.setNotice(fmt.Sprintf("no saved sessions match %q", msg.query))
+	default:
+		prevHeight := m.accessoryHeight()
+		m.search = &searchView{query: msg.query, results: msg.results}
+		m.fitAccessories(prevHeight)
+	}
+}
+
+// handleSearchKey handles the keys that navigate the search results while they are shown, and returns whether the key was
+// used.
+func (m *model) handleSearchKey(keyString string) (handled bool, cmd tea.Cmd) {
+	sv := m.search
+	if sv == nil {
+		return false, nil
+	}
+	switch keyString {
+	case "up", "ctrl+p":
+		sv.selected = (sv.selected - 1 + len(sv.results)) % len(sv.results)
+	case "down", "ctrl+n":
+		sv.selected = (sv.selected + 1) % len(sv.results)
+	case "enter":
+		if m.textarea.Length() > 0 {
+			return false, nil // send the prompt instead
+		}
+		return true, m.openResult(sv.results[sv.selected])
+	case "esc":
+		m.closeSearch()
+	default:
+		return false, nil
+	}
+	return true, nil
+}
+
+// closeSearch hides the search results.
+func (m *model) closeSearch() {
+	if m.search == nil {
+		return
+	}
+	prevHeight := m.accessoryHeight()
+	m.search = nil
+	m.fitAccessories(prevHeight)
+}
+
+// openResult shows the result's session, scrolled to the matching entry, so that the conversation can be read or continued.
+// The session is opened in a new conversation unless it is already open, or nothing has been sent in the shown one yet.
+func (m *model) openResult(result session.Result) tea.Cmd {
+	m.closeSearch()
+	cmd := m.openSession(result.Session)
+	if line, ok := m.chat.EntryLine(result.Entry); ok {
+		m.viewport.SetYOffset(line)
+	}
+	return tea.Batch(cmd, m.redraw())
+}
+
+// searchLines renders the search results for accessoryView.
+func (m *model) searchLines(width int) []string {
+	sv := m.search
+	lines := []string{styles.TUIStyles.Completer.Render(
+		fmt.Sprintf("  %d results for %q · enter open · esc close", len(sv.results), sv.query),
+	)}
+	first := max(0, sv.selected-searchResultsShown+1)
+	for i := first; i < min(len(sv.results), first+searchResultsShown); i++ {
+		r := &sv.results[i]
+		style, marker := styles.TUIStyles.Completer, "  "
+		if i == sv.selected {
+			style, marker = styles.TUIStyles.CompleterSelected, "› "
+		}
+		meta := fmt.Sprintf("%s%s · %s · %s · ", marker, r.Session.Title, r.Session.UpdatedAt.Format("2006-01-02"), r.Session.Model)
+		snippet := r.Highlight(func(match string) string { return styles.TUIStyles.SearchMatch.Render(match) })
+		lines = append(lines, ansi.Truncate(style.Render(meta)+snippet, max(1, width), "…"))
+	}
+	return lines
+}
@@ -0,0 +1,177 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// completion writes the response of a single chat completion request.
+type completion struct {
+	id          string
+	created     int64
+	llm         models.LLM
+	retryPolicy models.RetryPolicy
+}
+
+// run streams the response, retrying transient errors before anything has been streamed, and calls onChunk with each chunk
+// as it arrives.
+func (c *completion) run(ctx context.Context, prompt string, enableReasoning bool, effort *uint8, onChunk func(models.StreamChunk)) error {
+	responseChan := make(chan models.StreamChunk)
+	errChan := make(chan error, 1)
+	go func() {
+		errChan <- models.StreamPromptCompletionWithRetry(ctx, c.llm, models.UserMessage(prompt), enableReasoning, effort,
+			responseChan, c.retryPolicy, func(status models.RetryStatus) {
+				log.Printf("%s: %v, retrying (attempt %d/%d)", models.GetModelId(c.llm), status.Err, status.Attempt, status.MaxAttempts)
+			})
+	}()
+	for chunk := range responseChan {
+		onChunk(chunk)
+	}
+	return <-errChan
+}
+
+// respond waits for the entire response and writes it as a single chat.completion object.
+func (c *completion) respond(ctx context.Context, w http.ResponseWriter, prompt string, enableReasoning bool, effort *uint8) error {
+	var response, reasoning strings.Builder
+	err := c.run(ctx, prompt, enableReasoning, effort, func(chunk models.StreamChunk) {
+		if chunk.Reasoning {
+			reasoning.WriteString(chunk.Content)
+		} else {
+			response.WriteString(chunk.Content)
+		}
+	})
+	if err != nil {
+		writeStreamError(w, err)
+		return err //nolint:wrapcheck // logged by the caller
+	}
+
+	info := models.GetLastResponseInfo(c.llm)
+	writeJSON(w, http.StatusOK, chatCompletion{
+		ID:      c.id,
+		Object:  "chat.completion",
+		Created: c.created,
+		Model:   info.ModelID,
+		Choices: []choice{{
+			Message:      &message{Role: "assistant", Content: response.String(), ReasoningContent: reasoning.String()},
+			FinishReason: finishReason(info.StopReason),
+		}},
+		Usage: newUsage(info.Usage),
+	})
+	return nil
+}
+
+// stream writes the response as server-sent events as it arrives, in the format of OpenAI's chat.completion.chunk objects.
+// Reasoning is sent in the reasoning_content field of each delta, as other OpenAI-compatible APIs do. The headers are only
+// written once the first chunk arrives, so that a request that fails before then gets an error status.
+func (c *completion) stream(ctx context.Context, w http.ResponseWriter, prompt string, enableReasoning bool, effort *uint8, includeUsage bool) error {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, http.StatusInternalServerError, "server_error", "streaming is not supported")
+		return fmt.Errorf("response writer cannot flush")
+	}
+
+	started := false
+	start := func() {
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Set("Cache-Control", "no-cache")
+		w.Header().Set("Connection", "keep-alive")
+		w.WriteHeader(http.StatusOK)
+		started = true
+	}
+
+	err := c.run(ctx, prompt, enableReasoning, effort, func(chunk models.StreamChunk) {
+		d := delta{}
+		if !started {
+			start()
+			d.Role = "assistant"
+		}
+		if chunk.Reasoning {
+			d.ReasoningContent = chunk.Content
+		} else {
+			d.Content = chunk.Content
+		}
+		c.writeEvent(w, chatCompletion{Choices: []choice{{Delta: &d}}})
+		flusher.Flush()
+	})
+	if err != nil {
+		if !started {
+			writeStreamError(w, err)
+			return err //nolint:wrapcheck // logged by the caller
+		}
+		_, apiErr := streamAPIError(err)
+		c.writeEvent(w, errorResponse{Error: apiErr})
+		flusher.Flush()
+		return err //nolint:wrapcheck // logged by the caller
+	}
+
+	if !started { // the response was empty
+		start()
+	}
+	info := models.GetLastResponseInfo(c.llm)
+	c.writeEvent(w, chatCompletion{Choices: []choice{{Delta: &delta{}, FinishReason: finishReason(info.StopReason)}}})
+	if includeUsage {
+		c.writeEvent(w, chatCompletion{Choices: []choice{}, Usage: newUsage(info.Usage)})
+	}
+	_, _ = fmt.Fprint(w, "data: [DONE]\n\n")
+	flusher.Flush()
+	return nil
+}
+
+// writeEvent writes a server-sent event, filling in the fields shared by every chunk.
+func (c *completion) writeEvent(w http.ResponseWriter, event any) {
+	if chunk, ok := event.(chatCompletion); ok {
+		chunk.ID, chunk.Object, chunk.Created = c.id, "chat.completion.chunk", c.created
+		chunk.Model = models.GetModelId(c.llm)
+		event = chunk
+	}
+	data, err := json.Marshal(event)
+	if err != nil {
+		return
+	}
+	_, _ = fmt.Fprintf(w, "data: %s\n\n", data)
+}
+
+// writeStreamError writes the error of a failed completion with the HTTP status of its models.ErrorKind.
+func writeStreamError(w http.ResponseWriter, err error) {
+	status, apiErr := streamAPIError(err)
+	writeJSON(w, status, errorResponse{Error: apiErr})
+}
+
+// statusClientClosedRequest is nginx's status for requests the client cancelled before the response was written.
+const statusClientClosedRequest = 499
+
+// streamAPIError describes the error of a failed completion, along with the HTTP status of its models.ErrorKind. Errors caused
+// by the provider, including its API key being rejected, are reported as bad gateways rather than as errors of the client's
+// request.
+func streamAPIError(err error) (int, apiError) {
+	kind := models.KindOf(err)
+	status, errType := http.StatusBadGateway, "upstream_error"
+	switch kind {
+	case models.ErrorRateLimit:
+		status, errType = http.StatusTooManyRequests, "rate_limit_error"
+	case models.ErrorOverloaded:
+		status, errType = http.StatusServiceUnavailable, "overloaded_error"
+	case models.ErrorContextTooLong:
+		status, errType = http.StatusBadRequest, "invalid_request_error"
+	case models.ErrorInvalidModel:
+		status, errType = http.StatusNotFound, "model_not_found"
+	case models.ErrorCancelled:
+		status, errType = statusClientClosedRequest, "cancelled"
+	case models.ErrorAuth, models.ErrorNetwork, models.ErrorUnknown:
+	}
+	return status, apiError{Message: err.Error(), Type: errType, Code: kind.String()}
+}
+
+func newUsage(u models.Usage) *usage {
+	return &usage{
+		PromptTokens:     u.InputTokens,
+		CompletionTokens: u.OutputTokens,
+		TotalTokens:      u.InputTokens + u.OutputTokens,
+	}
+}
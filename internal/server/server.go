@@ -0,0 +1,235 @@
+// Package server exposes the supported LLMs behind a local, OpenAI-compatible HTTP API
+package server
+
+import (
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// ErrUnknownModel should be returned by Config.NewLLM when a request names a model that is not supported.
+var ErrUnknownModel = errors.New("unknown model")
+
+// Config configures the API server.
+type Config struct {
+	// defaults for requests that don't specify these fields
+	SystemPrompt    string
+	MaxTokens       int
+	EnableReasoning bool
+	ReasoningEffort *uint8
+
+	Budget float64 // max dollars spent by all requests since the server started. 0 means no limit
+	Token  string  // if set, clients must send it as a bearer token
+
+	RetryPolicy models.RetryPolicy // of requests that fail before anything is streamed
+
+	// ModelNames are listed by /v1/models.
+	ModelNames []string
+	// NewLLM creates a model for a single request, given a model name or alias.
+	NewLLM func(modelName, systemPrompt string, maxTokens int) (models.LLM, error)
+}
+
+// Server handles API requests. Each request gets its own models.LLM, and the cost of every response is added to a running
+// total that is checked against the budget.
+type Server struct {
+	cfg Config
+
+	mu       sync.Mutex
+	spent    float64 // in dollars
+	reserved float64 // in dollars, the most that requests in progress may cost
+	requests int
+}
+
+// New creates an API server.
+func New(cfg Config) *Server {
+	return &Server{cfg: cfg}
+}
+
+// Handler returns the HTTP handler of the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /v1/chat/completions", s.handleChatCompletions)
+	mux.HandleFunc("GET /v1/models", s.handleModels)
+	mux.HandleFunc("GET /v1/usage", s.handleUsage)
+	return s.authenticate(mux)
+}
+
+// authenticate rejects requests without the configured bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s.cfg.Token != "" {
+			token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+			if subtle.ConstantTimeCompare([]byte(token), []byte(s.cfg.Token)) != 1 {
+				writeError(w, http.StatusUnauthorized, "invalid_request_error", "invalid or missing bearer token")
+				return
+			}
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// reserve holds the most a request may cost against the budget while it is made, so that concurrent requests can't spend
+// more than the budget between them. It returns an error if the budget can't cover it. The reservation must be settled once
+// the request completes.
+func (s *Server) reserve(cost float64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.cfg.Budget > 0 && s.spent+s.reserved+cost > s.cfg.Budget {
+		return fmt.Errorf("budget of $%.2f has been spent, or is held by requests in progress ($%.4f spent, $%.4f held, "+
+			"this request may cost up to $%.4f)", s.cfg.Budget, s.spent, s.reserved, cost)
+	}
+	s.reserved += cost
+	return nil
+}
+
+// settle replaces the reservation of a completed request with its actual cost.
+func (s *Server) settle(reserved, cost float64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reserved -= reserved
+	s.spent += cost
+	s.requests++
+}
+
+// maxCost estimates the most a request may cost: its input tokens, and a response that uses all of the output tokens the
+// provider is sent, which some raise for reasoning.
+func maxCost(llm models.LLM, prompt string, enableReasoning bool) float64 {
+	pricing := llm.DoGetPricing()
+	input := models.EstimateRequestTokens(llm.DoGetSystemPrompt(), llm.DoGetChatHistory(), models.UserMessage(prompt))
+	return pricing.Cost(models.Usage{InputTokens: input, OutputTokens: llm.DoGetMaxOutputTokens(enableReasoning)})
+}
+
+func (s *Server) handleModels(w http.ResponseWriter, _ *http.Request) {
+	list := modelList{Object: "list", Data: make([]modelObject, 0, len(s.cfg.ModelNames))}
+	for _, name := range s.cfg.ModelNames {
+		list.Data = append(list.Data, modelObject{ID: name, Object: "model", OwnedBy: "ducky"})
+	}
+	writeJSON(w, http.StatusOK, list)
+}
+
+func (s *Server) handleUsage(w http.ResponseWriter, _ *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	writeJSON(w, http.StatusOK, usageResponse{Requests: s.requests, Spent: s.spent, Reserved: s.reserved, Budget: s.cfg.Budget})
+}
+
+func (s *Server) handleChatCompletions(w http.ResponseWriter, r *http.Request) {
+	var req chatCompletionRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", "invalid JSON body: "+err.Error())
+		return
+	}
+
+	systemPrompt, history, prompt, err := req.conversation()
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "invalid_request_error", err.Error())
+		return
+	}
+	if systemPrompt == "" {
+		systemPrompt = s.cfg.SystemPrompt
+	}
+	maxTokens := s.cfg.MaxTokens
+	if req.MaxCompletionTokens > 0 {
+		maxTokens = req.MaxCompletionTokens
+	} else if req.MaxTokens > 0 {
+		maxTokens = req.MaxTokens
+	}
+
+	llm, err := s.cfg.NewLLM(req.Model, systemPrompt, maxTokens)
+	if errors.Is(err, ErrUnknownModel) {
+		writeError(w, http.StatusNotFound, "model_not_found", err.Error())
+		return
+	} else if err != nil {
+		writeError(w, http.StatusInternalServerError, "server_error", err.Error())
+		return
+	}
+	llm.DoSetChatHistory(history)
+
+	enableReasoning, effort := s.cfg.EnableReasoning, s.cfg.ReasoningEffort
+	if req.ReasoningEffort != "" {
+		effort, enableReasoning = reasoningEffort(req.ReasoningEffort)
+	}
+
+	reserved := maxCost(llm, prompt, enableReasoning)
+	if err := s.reserve(reserved); err != nil {
+		writeError(w, http.StatusPaymentRequired, "insufficient_quota", err.Error())
+		return
+	}
+
+	started := time.Now()
+	completion := completion{
+		id:          newCompletionID(),
+		created:     started.Unix(),
+		llm:         llm,
+		retryPolicy: s.cfg.RetryPolicy,
+	}
+	if req.Stream {
+		err = completion.stream(r.Context(), w, prompt, enableReasoning, effort, req.StreamOptions.IncludeUsage)
+	} else {
+		err = completion.respond(r.Context(), w, prompt, enableReasoning, effort)
+	}
+
+	info := models.GetLastResponseInfo(llm)
+	cost := llm.DoGetCostOfCurrentChat() // includes attempts that failed, which may still be billed
+	s.settle(reserved, cost)
+	status := "ok"
+	if err != nil {
+		status = err.Error()
+	}
+	log.Printf("%s %s in=%d out=%d cost=%s took=%s: %s", req.Model, info.StopReason, info.Usage.InputTokens,
+		info.Usage.OutputTokens, models.FormatCost(cost), time.Since(started).Round(time.Millisecond), status)
+}
+
+// reasoningEffort converts OpenAI's reasoning_effort parameter into ducky's effort levels.
+func reasoningEffort(effort string) (*uint8, bool) {
+	switch effort {
+	case "none":
+		return nil, false
+	case "minimal":
+		return models.Uint8Ptr(1), true
+	case "low":
+		return models.Uint8Ptr(2), true
+	case "medium":
+		return models.Uint8Ptr(3), true
+	}
+	return models.Uint8Ptr(4), true
+}
+
+// finishReason converts a models.StopReason into the finish_reason of OpenAI's API.
+func finishReason(reason models.StopReason) *string {
+	finish := "stop"
+	switch reason {
+	case models.StopReasonMaxTokens:
+		finish = "length"
+	case models.StopReasonRefusal:
+		finish = "content_filter"
+	case models.StopReasonEndTurn:
+	}
+	return &finish
+}
+
+func newCompletionID() string {
+	b := make([]byte, 12)
+	_, _ = rand.Read(b)
+	return "chatcmpl-" + hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, errType, message string) {
+	writeJSON(w, status, errorResponse{Error: apiError{Message: message, Type: errType}})
+}
@@ -0,0 +1,356 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/anthropics/anthropic-sdk-go/option"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/anthropic"
+	"github.com/gregriff/ducky/internal/models/mock"
+)
+
+// prices of the mock model: a request of 5 input tokens and 10 max tokens may cost up to $0.105.
+var testPricing = models.Pricing{PromptCost: 0.001, ResponseCost: 0.01}
+
+// mockLLM returns a Config.NewLLM that serves the mock model as "mock". Each request gets its own model, which replays
+// responses from the first.
+func mockLLM(responses ...mock.Response) func(string, string, int) (models.LLM, error) {
+	return func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+		if modelName != "mock" {
+			return nil, fmt.Errorf("%w: %s", ErrUnknownModel, modelName)
+		}
+		llm, err := mock.NewModel(systemPrompt, maxTokens, "mock:instant", nil)
+		if err != nil {
+			return nil, err
+		}
+		llm.Script = &mock.Script{ChunkSize: 1, ContextWindow: 200_000, Pricing: testPricing, Responses: responses}
+		return llm, nil
+	}
+}
+
+// newTestServer serves the API with 10 max tokens, and without retries unless cfg sets them.
+func newTestServer(t *testing.T, cfg Config) (*httptest.Server, *Server) {
+	t.Helper()
+	cfg.MaxTokens = 10
+	cfg.RetryPolicy = models.RetryPolicy{MaxAttempts: max(1, cfg.RetryPolicy.MaxAttempts), BaseDelay: time.Millisecond}
+	api := New(cfg)
+	srv := httptest.NewServer(api.Handler())
+	t.Cleanup(srv.Close)
+	return srv, api
+}
+
+func post(t *testing.T, srv *httptest.Server, body string) (int, []byte) {
+	t.Helper()
+	res, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	data, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return res.StatusCode, data
+}
+
+func TestChatCompletion(t *testing.T) {
+	var systemPrompt string
+	newLLM := mockLLM(mock.Response{Reasoning: "hmm", Text: "Hello there", Usage: &models.Usage{InputTokens: 5, OutputTokens: 2}})
+	srv, _ := newTestServer(t, Config{NewLLM: func(modelName, sp string, maxTokens int) (models.LLM, error) {
+		systemPrompt = sp
+		return newLLM(modelName, sp, maxTokens)
+	}})
+
+	status, body := post(t, srv, `{"model": "mock", "reasoning_effort": "low", "messages": [
+		{"role": "system", "content": "Be brief."},
+		{"role": "user", "content": [{"type": "text", "text": "Hi"}]}
+	]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	var res chatCompletion
+	if err := json.Unmarshal(body, &res); err != nil {
+		t.Fatal(err)
+	}
+	if res.Object != "chat.completion" || !strings.HasPrefix(res.ID, "chatcmpl-") || res.Model != "mock:instant" || len(res.Choices) != 1 {
+		t.Fatalf("response = %s", body)
+	}
+	choice := res.Choices[0]
+	if choice.Message.Content != "Hello there" || choice.Message.ReasoningContent != "hmm" || choice.FinishReason == nil || *choice.FinishReason != "stop" {
+		t.Errorf("choice = %s", body)
+	}
+	if *res.Usage != (usage{PromptTokens: 5, CompletionTokens: 2, TotalTokens: 7}) {
+		t.Errorf("usage = %+v", res.Usage)
+	}
+	if systemPrompt != "Be brief." {
+		t.Errorf("system prompt = %q", systemPrompt)
+	}
+}
+
+// events parses the data of the server-sent events of a stream.
+func events(t *testing.T, body []byte) []string {
+	t.Helper()
+	var data []string
+	for event := range strings.SplitSeq(strings.TrimSpace(string(body)), "\n\n") {
+		payload, ok := strings.CutPrefix(event, "data: ")
+		if !ok {
+			t.Fatalf("invalid event %q", event)
+		}
+		data = append(data, payload)
+	}
+	return data
+}
+
+func TestChatCompletionStream(t *testing.T) {
+	srv, _ := newTestServer(t, Config{NewLLM: mockLLM(
+		mock.Response{Text: "Hello there", StopReason: models.StopReasonMaxTokens, Usage: &models.Usage{InputTokens: 5, OutputTokens: 2}},
+	)})
+
+	status, body := post(t, srv, `{"model": "mock", "stream": true, "stream_options": {"include_usage": true},
+		"messages": [{"role": "user", "content": "Hi"}]}`)
+	if status != http.StatusOK {
+		t.Fatalf("status = %d: %s", status, body)
+	}
+	data := events(t, body)
+	if len(data) != 5 || data[len(data)-1] != "[DONE]" {
+		t.Fatalf("events = %q", data)
+	}
+
+	var text strings.Builder
+	for i, payload := range data[:2] {
+		// finish_reason must be null rather than missing until the last chunk
+		if !strings.Contains(payload, `"finish_reason":null`) {
+			t.Errorf("chunk %d = %s", i, payload)
+		}
+		var chunk chatCompletion
+		if err := json.Unmarshal([]byte(payload), &chunk); err != nil {
+			t.Fatal(err)
+		}
+		if chunk.Object != "chat.completion.chunk" || (i == 0) != (chunk.Choices[0].Delta.Role == "assistant") {
+			t.Errorf("chunk %d = %s", i, payload)
+		}
+		text.WriteString(chunk.Choices[0].Delta.Content)
+	}
+	if text.String() != "Hello there" {
+		t.Errorf("streamed text = %q", text.String())
+	}
+
+	var last, usageChunk chatCompletion
+	if err := json.Unmarshal([]byte(data[2]), &last); err != nil || *last.Choices[0].FinishReason != "length" {
+		t.Errorf("last chunk = %s", data[2])
+	}
+	if err := json.Unmarshal([]byte(data[3]), &usageChunk); err != nil || usageChunk.Usage == nil || usageChunk.Usage.TotalTokens != 7 {
+		t.Errorf("usage chunk = %s", data[3])
+	}
+}
+
+func TestBadRequests(t *testing.T) {
+	srv, _ := newTestServer(t, Config{NewLLM: mockLLM(mock.Response{Text: "unreachable"})})
+	tests := []struct {
+		name       string
+		body       string
+		wantStatus int
+		wantType   string
+	}{
+		{"invalid JSON", `{"model": "mock",`, http.StatusBadRequest, "invalid_request_error"},
+		{"no messages", `{"model": "mock", "messages": []}`, http.StatusBadRequest, "invalid_request_error"},
+		{"last message is not the user's", `{"model": "mock", "messages": [{"role": "user", "content": "Hi"}, {"role": "assistant", "content": "Hello"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"unsupported role", `{"model": "mock", "messages": [{"role": "tool", "content": "42"}, {"role": "user", "content": "Hi"}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"unsupported content", `{"model": "mock", "messages": [{"role": "user", "content": [{"type": "image_url"}]}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"empty prompt", `{"model": "mock", "messages": [{"role": "user", "content": " "}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"null prompt", `{"model": "mock", "messages": [{"role": "user", "content": null}]}`, http.StatusBadRequest, "invalid_request_error"},
+		{"unknown model", `{"model": "gpt-1", "messages": [{"role": "user", "content": "Hi"}]}`, http.StatusNotFound, "model_not_found"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			status, body := post(t, srv, tt.body)
+			var res errorResponse
+			if err := json.Unmarshal(body, &res); err != nil {
+				t.Fatal(err)
+			}
+			if status != tt.wantStatus || res.Error.Type != tt.wantType || res.Error.Message == "" {
+				t.Errorf("status = %d, body = %s", status, body)
+			}
+		})
+	}
+}
+
+func TestConversationSkipsEmptyMessages(t *testing.T) {
+	var req chatCompletionRequest
+	if err := json.Unmarshal([]byte(`{"messages": [
+		{"role": "user", "content": "What's the weather?"},
+		{"role": "assistant", "content": null, "tool_calls": [{"id": "call_1"}]},
+		{"role": "assistant"},
+		{"role": "assistant", "content": ""},
+		{"role": "user", "content": "Never mind"}
+	]}`), &req); err != nil {
+		t.Fatal(err)
+	}
+	_, history, prompt, err := req.conversation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(history) != 1 || history[0].Content != "What's the weather?" || prompt != "Never mind" {
+		t.Errorf("history = %+v, prompt = %q", history, prompt)
+	}
+}
+
+func TestAuthentication(t *testing.T) {
+	srv, _ := newTestServer(t, Config{Token: "secret", NewLLM: mockLLM(mock.Response{Text: "ok"})})
+	for token, wantStatus := range map[string]int{"": http.StatusUnauthorized, "wrong": http.StatusUnauthorized, "secret": http.StatusOK} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/v1/models", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+token)
+		res, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = res.Body.Close()
+		if res.StatusCode != wantStatus {
+			t.Errorf("status with token %q = %d, want %d", token, res.StatusCode, wantStatus)
+		}
+	}
+}
+
+func TestUpstreamErrors(t *testing.T) {
+	rateLimited := mock.Response{Error: "slow down", ErrorStatus: 429}
+	overloaded := mock.Response{Error: "overloaded", ErrorStatus: 529}
+	tests := []struct {
+		name       string
+		stream     bool
+		attempts   int
+		responses  []mock.Response
+		wantStatus int
+		wantBody   string
+	}{
+		{"rate limited", false, 1, []mock.Response{rateLimited}, http.StatusTooManyRequests, `"code":"rate_limit"`},
+		{"streaming rate limited", true, 1, []mock.Response{rateLimited}, http.StatusTooManyRequests, `"type":"rate_limit_error"`},
+		{"overloaded", false, 1, []mock.Response{overloaded}, http.StatusServiceUnavailable, `"code":"overloaded"`},
+		{"invalid request", false, 1, []mock.Response{{Error: "bad", ErrorStatus: 400}}, http.StatusBadGateway, `"type":"upstream_error"`},
+		{"retried", false, 2, []mock.Response{overloaded, {Text: "ok"}}, http.StatusOK, `"content":"ok"`},
+		{"streaming retried", true, 2, []mock.Response{overloaded, {Text: "ok"}}, http.StatusOK, `"content":"ok"`},
+		{"not retried after partial output", true, 2, []mock.Response{{Text: "cut off", Error: "reset", ErrorStatus: 503, ErrorAfter: 1}, {Text: "ok"}}, http.StatusOK, `"code":"overloaded"`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _ := newTestServer(t, Config{RetryPolicy: models.RetryPolicy{MaxAttempts: tt.attempts}, NewLLM: mockLLM(tt.responses...)})
+			status, body := post(t, srv, fmt.Sprintf(`{"model": "mock", "stream": %t, "messages": [{"role": "user", "content": "Hi"}]}`, tt.stream))
+			if status != tt.wantStatus || !strings.Contains(string(body), tt.wantBody) {
+				t.Errorf("status = %d, body = %s", status, body)
+			}
+		})
+	}
+}
+
+// gatedLLM waits for its gate to open before responding, to keep its request in progress.
+type gatedLLM struct {
+	*mock.Model
+	gate chan struct{}
+}
+
+func (llm gatedLLM) DoStreamPromptCompletion(ctx context.Context, prompt models.Message, enableReasoning bool, effort *uint8, responseChan chan models.StreamChunk) error {
+	<-llm.gate
+	return llm.Model.DoStreamPromptCompletion(ctx, prompt, enableReasoning, effort, responseChan)
+}
+
+func TestBudget(t *testing.T) {
+	gate := make(chan struct{})
+	newLLM := mockLLM(mock.Response{Text: "ok", Usage: &models.Usage{InputTokens: 5, OutputTokens: 1}})
+	srv, api := newTestServer(t, Config{Budget: 0.15, NewLLM: func(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
+		llm, err := newLLM(modelName, systemPrompt, maxTokens)
+		return gatedLLM{llm.(*mock.Model), gate}, err
+	}})
+	const body = `{"model": "mock", "messages": [{"role": "user", "content": "Hi"}]}`
+
+	// the first request holds $0.105 of the budget while it is in progress, which leaves too little for another
+	first := make(chan int)
+	go func() {
+		res, err := http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(body))
+		if err != nil {
+			first <- 0
+			return
+		}
+		_ = res.Body.Close()
+		first <- res.StatusCode
+	}()
+	waitFor(t, func() bool {
+		api.mu.Lock()
+		defer api.mu.Unlock()
+		return api.reserved > 0
+	})
+	if status, res := post(t, srv, body); status != http.StatusPaymentRequired || !strings.Contains(string(res), "insufficient_quota") {
+		t.Errorf("status of a request over the budget = %d: %s", status, res)
+	}
+
+	// once it completes, only its actual cost of $0.015 is spent
+	close(gate)
+	if status := <-first; status != http.StatusOK {
+		t.Fatalf("status of the first request = %d", status)
+	}
+	if status, res := post(t, srv, body); status != http.StatusOK {
+		t.Errorf("status of a request within the budget = %d: %s", status, res)
+	}
+
+	res, err := http.Get(srv.URL + "/v1/usage")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() { _ = res.Body.Close() }()
+	var u usageResponse
+	if err := json.NewDecoder(res.Body).Decode(&u); err != nil {
+		t.Fatal(err)
+	}
+	if u.Requests != 2 || u.Reserved != 0 || fmt.Sprintf("%.3f", u.Spent) != "0.030" || u.Budget != 0.15 {
+		t.Errorf("usage = %+v", u)
+	}
+}
+
+func waitFor(t *testing.T, condition func() bool) {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); !condition(); time.Sleep(time.Millisecond) {
+		if time.Now().After(deadline) {
+			t.Fatal("condition not met in time")
+		}
+	}
+}
+
+// TestBudgetReasoning checks that a request with thinking enabled reserves the larger output budget Anthropic is sent.
+func TestBudgetReasoning(t *testing.T) {
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(529)
+		_, _ = io.WriteString(w, `{"type": "error", "error": {"type": "overloaded_error", "message": "Overloaded"}}`)
+	}))
+	defer upstream.Close()
+	newLLM := func(_, systemPrompt string, maxTokens int) (models.LLM, error) {
+		return anthropic.NewModel(systemPrompt, maxTokens, "sonnet", nil,
+			option.WithBaseURL(upstream.URL), option.WithAPIKey("test"), option.WithMaxRetries(0)), nil
+	}
+
+	llm, _ := newLLM("", "", 10)
+	withoutThinking, withThinking := maxCost(llm, "Hi", false), maxCost(llm, "Hi", true)
+	// 10 max tokens are raised to Anthropic's minimum of 2048 when thinking is enabled
+	if want := withoutThinking + float64(2048-10)*15/1_000_000; fmt.Sprintf("%.6f", withThinking) != fmt.Sprintf("%.6f", want) {
+		t.Errorf("max cost with thinking = %f, want %f", withThinking, want)
+	}
+
+	// the budget covers a response of 10 tokens, but not one of 2048
+	srv, _ := newTestServer(t, Config{Budget: 0.01, EnableReasoning: true, NewLLM: newLLM})
+	if status, res := post(t, srv, `{"model": "sonnet", "messages": [{"role": "user", "content": "Hi"}]}`); status != http.StatusPaymentRequired {
+		t.Errorf("status of a request with thinking = %d: %s", status, res)
+	}
+	body := `{"model": "sonnet", "reasoning_effort": "none", "messages": [{"role": "user", "content": "Hi"}]}`
+	if status, res := post(t, srv, body); status != http.StatusServiceUnavailable {
+		t.Errorf("status of a request without thinking = %d, want it to reach the provider: %s", status, res)
+	}
+}
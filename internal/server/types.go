@@ -0,0 +1,151 @@
+package server
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// chatCompletionRequest is the subset of OpenAI's chat completion request that ducky supports.
+type chatCompletionRequest struct {
+	Model               string        `json:"model"`
+	Messages            []chatMessage `json:"messages"`
+	Stream              bool          `json:"stream"`
+	MaxTokens           int           `json:"max_tokens"`
+	MaxCompletionTokens int           `json:"max_completion_tokens"`
+	ReasoningEffort     string        `json:"reasoning_effort"`
+	StreamOptions       struct {
+		IncludeUsage bool `json:"include_usage"`
+	} `json:"stream_options"`
+}
+
+type chatMessage struct {
+	Role    string          `json:"role"`
+	Content json.RawMessage `json:"content"` // a string, or an array of content parts
+}
+
+// text returns the text of a message's content, joining text parts if the content is an array. Missing or null content, as
+// in the assistant messages of tool calls, has no text.
+func (m chatMessage) text() (string, error) {
+	if len(m.Content) == 0 {
+		return "", nil
+	}
+	var text string
+	if err := json.Unmarshal(m.Content, &text); err == nil {
+		return text, nil
+	}
+	var parts []struct {
+		Type string `json:"type"`
+		Text string `json:"text"`
+	}
+	if err := json.Unmarshal(m.Content, &parts); err != nil {
+		return "", fmt.Errorf("invalid content of %s message", m.Role)
+	}
+	texts := make([]string, 0, len(parts))
+	for _, part := range parts {
+		if part.Type != "text" {
+			return "", fmt.Errorf("unsupported content part: %s", part.Type)
+		}
+		texts = append(texts, part.Text)
+	}
+	return strings.Join(texts, "\n"), nil
+}
+
+// conversation splits the request's messages into a system prompt, the chat history and the new prompt, which must be the
+// last message. Messages of the history without text are skipped, since the providers reject empty messages.
+func (r chatCompletionRequest) conversation() (systemPrompt string, history []models.Message, prompt string, err error) {
+	if len(r.Messages) == 0 || r.Messages[len(r.Messages)-1].Role != "user" {
+		return "", nil, "", errors.New("the last message must have the user role")
+	}
+
+	var systemPrompts []string
+	for i, msg := range r.Messages {
+		text, err := msg.text()
+		if err != nil {
+			return "", nil, "", err
+		}
+		switch msg.Role {
+		case "system", "developer":
+			systemPrompts = append(systemPrompts, text)
+		case "user", "assistant":
+			switch {
+			case i == len(r.Messages)-1:
+				if strings.TrimSpace(text) == "" {
+					return "", nil, "", fmt.Errorf("the last message (index %d) has no text", i)
+				}
+				prompt = text
+			case strings.TrimSpace(text) != "":
+				history = append(history, models.Message{Role: msg.Role, Content: text})
+			}
+		default:
+			return "", nil, "", fmt.Errorf("unsupported message role: %s", msg.Role)
+		}
+	}
+	return strings.Join(systemPrompts, "\n\n"), history, prompt, nil
+}
+
+// chatCompletion is both a chat.completion object, and a chat.completion.chunk object when streaming.
+type chatCompletion struct {
+	ID      string   `json:"id"`
+	Object  string   `json:"object"`
+	Created int64    `json:"created"`
+	Model   string   `json:"model"`
+	Choices []choice `json:"choices"`
+	Usage   *usage   `json:"usage,omitempty"`
+}
+
+type choice struct {
+	Index        int      `json:"index"`
+	Message      *message `json:"message,omitempty"`
+	Delta        *delta   `json:"delta,omitempty"`
+	FinishReason *string  `json:"finish_reason"` // null until the last chunk of a stream
+}
+
+type message struct {
+	Role             string `json:"role"`
+	Content          string `json:"content"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+type delta struct {
+	Role             string `json:"role,omitempty"`
+	Content          string `json:"content,omitempty"`
+	ReasoningContent string `json:"reasoning_content,omitempty"`
+}
+
+type usage struct {
+	PromptTokens     int `json:"prompt_tokens"`
+	CompletionTokens int `json:"completion_tokens"`
+	TotalTokens      int `json:"total_tokens"`
+}
+
+type modelList struct {
+	Object string        `json:"object"`
+	Data   []modelObject `json:"data"`
+}
+
+type modelObject struct {
+	ID      string `json:"id"`
+	Object  string `json:"object"`
+	OwnedBy string `json:"owned_by"`
+}
+
+type usageResponse struct {
+	Requests int     `json:"requests"`
+	Spent    float64 `json:"spent"`    // in dollars
+	Reserved float64 `json:"reserved"` // in dollars, held for requests in progress
+	Budget   float64 `json:"budget"`   // 0 means no limit
+}
+
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+	Code    string `json:"code,omitempty"` // the models.ErrorKind of errors from the provider
+}
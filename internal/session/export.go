@@ -0,0 +1,278 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"html"
+	"io"
+	"path/filepath"
+	"strings"
+
+	"github.com/alecthomas/chroma/v2"
+	chromahtml "github.com/alecthomas/chroma/v2/formatters/html"
+	"github.com/alecthomas/chroma/v2/lexers"
+	chromastyles "github.com/alecthomas/chroma/v2/styles"
+	"github.com/gregriff/ducky/internal/chat"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/yuin/goldmark"
+	"github.com/yuin/goldmark/ast"
+	"github.com/yuin/goldmark/extension"
+	"github.com/yuin/goldmark/renderer"
+	"github.com/yuin/goldmark/util"
+)
+
+// Format is a file format that sessions are exported to.
+type Format string
+
+const (
+	FormatMarkdown Format = "md"   // prompts as quotes, and reasoning in collapsed <details> blocks
+	FormatHTML     Format = "html" // a self-contained page, with code highlighted by chroma
+	FormatJSON     Format = "json" // the session file itself, with usage and model metadata
+)
+
+// codeStyle is the chroma style of code blocks in HTML exports, which have a light background.
+const codeStyle = "github"
+
+// ParseFormat returns the format with the given name. The file extensions markdown and htm are accepted too.
+func ParseFormat(name string) (Format, error) {
+	switch strings.ToLower(strings.TrimPrefix(name, ".")) {
+	case "md", "markdown":
+		return FormatMarkdown, nil
+	case "html", "htm":
+		return FormatHTML, nil
+	case "json":
+		return FormatJSON, nil
+	}
+	return "", fmt.Errorf("invalid export format: %s (valid formats: md, html, json)", name)
+}
+
+// FormatOf returns the format of a file, by its extension.
+func FormatOf(path string) (Format, bool) {
+	format, err := ParseFormat(filepath.Ext(path))
+	return format, err == nil
+}
+
+// Export writes a session to w in the given format.
+func Export(w io.Writer, s *Session, format Format) error {
+	var err error
+	switch format {
+	case FormatMarkdown:
+		_, err = io.WriteString(w, Markdown(s))
+	case FormatHTML:
+		err = writeHTML(w, s)
+	case FormatJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		err = encoder.Encode(s)
+	default:
+		return fmt.Errorf("invalid export format: %s", format)
+	}
+	if err != nil {
+		return fmt.Errorf("error exporting session: %w", err)
+	}
+	return nil
+}
+
+// Markdown returns a session as a Markdown document: a heading with the session's metadata, then each prompt as a quote
+// followed by its response.
+func Markdown(s *Session) string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "# %s\n\n", s.title())
+	for _, line := range metadata(s) {
+		fmt.Fprintf(&b, "- %s\n", line)
+	}
+
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		b.WriteString("\n---\n\n")
+		b.WriteString(quote(entry.Prompt))
+		if names := attachmentNames(entry); names != "" {
+			fmt.Fprintf(&b, "\n*Attached: %s*\n", names)
+		}
+		if entry.Reasoning != "" {
+			fmt.Fprintf(&b, "\n<details>\n<summary>Reasoning</summary>\n\n%s\n\n</details>\n", strings.TrimSpace(entry.Reasoning))
+		}
+		for _, text := range entry.responseParts() {
+			fmt.Fprintf(&b, "\n%s\n", text)
+		}
+	}
+	return b.String()
+}
+
+// title returns the session's title, or a placeholder if it has none.
+func (s *Session) title() string {
+	if s.Title == "" {
+		return "Untitled session"
+	}
+	return s.Title
+}
+
+// metadata returns lines describing the session, in Markdown.
+func metadata(s *Session) []string {
+	model := s.Model
+	if s.ModelID != "" && s.ModelID != s.Model {
+		model += fmt.Sprintf(" (`%s`)", s.ModelID)
+	}
+	lines := []string{
+		"**Model:** " + model,
+		"**Date:** " + s.CreatedAt.Format("2006-01-02 15:04"),
+	}
+	if usage := s.Usage(); usage != (models.Usage{}) {
+		lines = append(lines, fmt.Sprintf("**Tokens:** %s in, %s out",
+			models.FormatTokens(usage.InputTokens), models.FormatTokens(usage.OutputTokens)))
+	}
+	if cost := models.FormatCost(s.Cost()); cost != "" {
+		lines = append(lines, "**Cost:** "+cost)
+	}
+	return lines
+}
+
+// responseParts returns the Markdown shown for an entry's response: the response itself, then its error and the reason it
+// stopped early, as the TUI shows them.
+func (e *Entry) responseParts() []string {
+	var parts []string
+	if response := strings.TrimSpace(e.Response); response != "" {
+		parts = append(parts, response)
+	}
+	if e.Error != "" {
+		parts = append(parts, e.Error)
+	}
+	if note := chat.StopNote(e.StopReason); note != "" {
+		parts = append(parts, note)
+	}
+	return parts
+}
+
+// quote returns text as a Markdown block quote.
+func quote(text string) string {
+	var b strings.Builder
+	for line := range strings.SplitSeq(strings.TrimSpace(text), "\n") {
+		if line == "" {
+			b.WriteString(">\n")
+		} else {
+			b.WriteString("> " + line + "\n")
+		}
+	}
+	return b.String()
+}
+
+func attachmentNames(e *Entry) string {
+	names := make([]string, len(e.Attachments))
+	for i, file := range e.Attachments {
+		names[i] = "`" + file.Path + "`"
+	}
+	return strings.Join(names, ", ")
+}
+
+// htmlHead is the start of an HTML export, up to the session's title. The styles keep the page readable without any external
+// resources.
+const htmlHead = `<!DOCTYPE html>
+<html lang="en">
+<head>
+<meta charset="utf-8">
+<meta name="viewport" content="width=device-width, initial-scale=1">
+<style>
+body { max-width: 50rem; margin: 2rem auto; padding: 0 1rem; font: 16px/1.5 system-ui, sans-serif; color: #1f2328; }
+header ul { list-style: none; padding: 0; color: #59636e; }
+header li p { margin: 0; }
+article { border-top: 1px solid #d1d9e0; padding: 1rem 0; }
+.prompt { margin: 0 0 1rem auto; max-width: 80%; padding: .5rem 1rem; border-radius: 1rem; background: #ddf4ff; white-space: pre-wrap; font: inherit; }
+.attachments { text-align: right; color: #59636e; font-size: .875rem; }
+details { color: #59636e; margin-bottom: 1rem; }
+pre { padding: 1rem; border-radius: .5rem; overflow-x: auto; }
+code { font-family: ui-monospace, monospace; font-size: .875rem; }
+:not(pre) > code { background: #eff1f3; padding: .1rem .3rem; border-radius: .3rem; }
+table { border-collapse: collapse; }
+th, td { border: 1px solid #d1d9e0; padding: .25rem .5rem; }
+</style>
+`
+
+// writeHTML writes a session as a self-contained HTML page. Prompts are shown as they were typed, and responses are rendered
+// from Markdown, with any raw HTML in them left out so that a response can't inject scripts into the page.
+func writeHTML(w io.Writer, s *Session) error {
+	md := goldmark.New(
+		goldmark.WithExtensions(extension.GFM),
+		goldmark.WithRendererOptions(renderer.WithNodeRenderers(
+			util.Prioritized(&codeBlockRenderer{style: chromastyles.Get(codeStyle)}, 100),
+		)),
+	)
+	render := func(b *bytes.Buffer, markdown string) error {
+		return md.Convert([]byte(markdown), b) //nolint:wrapcheck // wrapped by Export
+	}
+
+	var b bytes.Buffer
+	b.WriteString(htmlHead)
+	fmt.Fprintf(&b, "<title>%s</title>\n</head>\n<body>\n<header>\n<h1>%s</h1>\n<ul>\n", html.EscapeString(s.title()),
+		html.EscapeString(s.title()))
+	for _, line := range metadata(s) {
+		b.WriteString("<li>")
+		if err := render(&b, line); err != nil {
+			return err
+		}
+		b.WriteString("</li>\n")
+	}
+	b.WriteString("</ul>\n</header>\n")
+
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		b.WriteString("<article>\n")
+		fmt.Fprintf(&b, "<pre class=\"prompt\">%s</pre>\n", html.EscapeString(strings.TrimSpace(entry.Prompt)))
+		if len(entry.Attachments) > 0 {
+			names := make([]string, len(entry.Attachments))
+			for i, file := range entry.Attachments {
+				names[i] = "[" + html.EscapeString(file.Path) + "]"
+			}
+			fmt.Fprintf(&b, "<p class=\"attachments\">%s</p>\n", strings.Join(names, " "))
+		}
+		if entry.Reasoning != "" {
+			b.WriteString("<details>\n<summary>Reasoning</summary>\n")
+			if err := render(&b, entry.Reasoning); err != nil {
+				return err
+			}
+			b.WriteString("</details>\n")
+		}
+		for _, text := range entry.responseParts() {
+			if err := render(&b, text); err != nil {
+				return err
+			}
+		}
+		b.WriteString("</article>\n")
+	}
+	b.WriteString("</body>\n</html>\n")
+
+	_, err := w.Write(b.Bytes())
+	return err //nolint:wrapcheck // wrapped by Export
+}
+
+// codeBlockRenderer renders fenced code blocks highlighted by chroma, with inline styles so that the page needs no stylesheet.
+type codeBlockRenderer struct {
+	style *chroma.Style
+}
+
+func (r *codeBlockRenderer) RegisterFuncs(reg renderer.NodeRendererFuncRegisterer) {
+	reg.Register(ast.KindFencedCodeBlock, r.renderFencedCodeBlock)
+}
+
+func (r *codeBlockRenderer) renderFencedCodeBlock(w util.BufWriter, source []byte, node ast.Node, entering bool) (ast.WalkStatus, error) {
+	if !entering {
+		return ast.WalkContinue, nil
+	}
+	block, _ := node.(*ast.FencedCodeBlock)
+	var code strings.Builder
+	for i := range block.Lines().Len() {
+		line := block.Lines().At(i)
+		code.Write(line.Value(source))
+	}
+
+	lexer := lexers.Get(string(block.Language(source)))
+	if lexer == nil {
+		lexer = lexers.Fallback
+	}
+	tokens, err := chroma.Coalesce(lexer).Tokenise(nil, code.String())
+	if err != nil {
+		return ast.WalkStop, err //nolint:wrapcheck // wrapped by Export
+	}
+	return ast.WalkSkipChildren, chromahtml.New(chromahtml.WithClasses(false)).Format(w, r.style, tokens) //nolint:wrapcheck // wrapped by Export
+}
@@ -0,0 +1,381 @@
+package session
+
+import (
+	"archive/zip"
+	"bytes"
+	"cmp"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"os"
+	"path"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/gregriff/ducky/internal/attachments"
+)
+
+// ErrUnknownExport is returned by Import for files that are neither a ChatGPT nor a Claude data export.
+var ErrUnknownExport = errors.New("not a ChatGPT or Claude data export")
+
+// conversationsFile is the file in both ChatGPT and Claude data exports that holds the conversations.
+const conversationsFile = "conversations.json"
+
+// Import reads the conversations in a ChatGPT or Claude data export, either the zip file that was downloaded or the
+// conversations.json in it. Conversations with no prompts are left out. Importing the same conversation again returns a
+// session with the same ID, so that it replaces the one imported before.
+func Import(filePath string) ([]*Session, error) {
+	data, err := os.ReadFile(filePath) //nolint:gosec // exports are chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("error reading export: %w", err)
+	}
+	if bytes.HasPrefix(data, []byte("PK")) {
+		if data, err = readConversationsFile(data); err != nil {
+			return nil, err
+		}
+	}
+	return ParseExport(data)
+}
+
+// readConversationsFile returns the conversations.json in a zipped data export.
+func readConversationsFile(zipData []byte) ([]byte, error) {
+	archive, err := zip.NewReader(bytes.NewReader(zipData), int64(len(zipData)))
+	if err != nil {
+		return nil, fmt.Errorf("error reading export: %w", err)
+	}
+	for _, file := range archive.File {
+		if path.Base(file.Name) != conversationsFile {
+			continue
+		}
+		r, err := file.Open()
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file.Name, err)
+		}
+		defer func() { _ = r.Close() }()
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, fmt.Errorf("error reading %s: %w", file.Name, err)
+		}
+		return data, nil
+	}
+	return nil, fmt.Errorf("%w: there is no %s in it", ErrUnknownExport, conversationsFile)
+}
+
+// ParseExport returns the conversations in the conversations.json of a ChatGPT or Claude data export, telling them apart
+// by their fields.
+func ParseExport(data []byte) ([]*Session, error) {
+	var conversations []map[string]json.RawMessage
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrUnknownExport, err)
+	}
+	if len(conversations) == 0 {
+		return nil, nil
+	}
+	if _, ok := conversations[0]["mapping"]; ok {
+		return parseChatGPT(data)
+	}
+	if _, ok := conversations[0]["chat_messages"]; ok {
+		return parseClaude(data)
+	}
+	return nil, ErrUnknownExport
+}
+
+// importedID returns the ID of an imported conversation: like that of a new session, but with a suffix derived from the
+// conversation's ID in the export rather than a random one, so that it is the same each time the conversation is imported.
+func importedID(created time.Time, source string) string {
+	sum := sha256.Sum256([]byte(source))
+	return created.Format("20060102-150405") + "-" + hex.EncodeToString(sum[:2])
+}
+
+// newImported returns a session for an imported conversation, titled by its title in the export or else its first prompt.
+func newImported(source, name, model string, created, updated time.Time, entries []Entry) *Session {
+	if updated.Before(created) {
+		updated = created
+	}
+	s := &Session{
+		Version:   Version,
+		ID:        importedID(created, source),
+		Source:    source,
+		Title:     strings.TrimSpace(name),
+		Model:     model,
+		ModelID:   model,
+		CreatedAt: created,
+		UpdatedAt: updated,
+		Entries:   entries,
+	}
+	if s.Title == "" {
+		s.Title = title(entries[0].Prompt, entries[0].Attachments)
+	}
+	return s
+}
+
+// chatGPTConversation is a conversation in a ChatGPT export. Its messages form a tree, as prompts can be edited and
+// responses regenerated: the conversation as it was last shown is the path from the root to CurrentNode.
+type chatGPTConversation struct {
+	ID             string                 `json:"id"`
+	ConversationID string                 `json:"conversation_id"` // the same as ID, in older exports only
+	Title          string                 `json:"title"`
+	CreateTime     float64                `json:"create_time"`
+	UpdateTime     float64                `json:"update_time"`
+	DefaultModel   string                 `json:"default_model_slug"`
+	CurrentNode    string                 `json:"current_node"`
+	Mapping        map[string]chatGPTNode `json:"mapping"`
+}
+
+type chatGPTNode struct {
+	Parent  string          `json:"parent"`
+	Message *chatGPTMessage `json:"message"`
+}
+
+type chatGPTMessage struct {
+	Author struct {
+		Role string `json:"role"`
+	} `json:"author"`
+	CreateTime *float64 `json:"create_time"`
+	Content    struct {
+		ContentType string            `json:"content_type"`
+		Parts       []json.RawMessage `json:"parts"` // strings, or objects such as images
+		Text        string            `json:"text"`
+		Language    string            `json:"language"`
+		Thoughts    []struct {
+			Content string `json:"content"`
+		} `json:"thoughts"`
+	} `json:"content"`
+	Metadata struct {
+		ModelSlug string `json:"model_slug"`
+		Hidden    bool   `json:"is_visually_hidden_from_conversation"`
+	} `json:"metadata"`
+}
+
+// chatGPTModel is the model of sessions imported from ChatGPT exports that don't record one.
+const chatGPTModel = "chatgpt"
+
+// parseChatGPT returns the conversations in a ChatGPT export. Prompts and responses keep their text and code, and the
+// model's thoughts become reasoning. Tool calls, images and other content without text are left out, though a prompt of
+// only images is kept as a placeholder so that its response isn't added to the previous prompt's.
+func parseChatGPT(data []byte) ([]*Session, error) {
+	var conversations []chatGPTConversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("error reading ChatGPT export: %w", err)
+	}
+	sessions := make([]*Session, 0, len(conversations))
+	for i := range conversations {
+		c := &conversations[i]
+		var entries []Entry
+		skipReplies := false // to a prompt that was left out
+		for _, msg := range c.path() {
+			if msg.Metadata.Hidden {
+				continue
+			}
+			switch msg.Author.Role {
+			case "user":
+				text := cmp.Or(msg.text(), msg.placeholder())
+				skipReplies = text == ""
+				if text != "" {
+					entries = append(entries, Entry{Prompt: text, Time: unixTime(msg.CreateTime, c.CreateTime)})
+				}
+			case "assistant":
+				if len(entries) == 0 || skipReplies {
+					continue
+				}
+				entry := &entries[len(entries)-1]
+				if msg.Metadata.ModelSlug != "" {
+					entry.ModelID = msg.Metadata.ModelSlug
+				}
+				if msg.Content.ContentType == "thoughts" {
+					for _, thought := range msg.Content.Thoughts {
+						entry.Reasoning = joinParagraphs(entry.Reasoning, thought.Content)
+					}
+				} else {
+					entry.Response = joinParagraphs(entry.Response, msg.text())
+				}
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		source, model := "chatgpt:"+cmp.Or(c.ID, c.ConversationID), cmp.Or(c.DefaultModel, chatGPTModel)
+		sessions = append(sessions, newImported(source, c.Title, model, unixTime(nil, c.CreateTime), unixTime(nil, c.UpdateTime), entries))
+	}
+	return sessions, nil
+}
+
+// path returns the messages from the root of the conversation to its current node.
+func (c *chatGPTConversation) path() []*chatGPTMessage {
+	var messages []*chatGPTMessage
+	seen := make(map[string]bool)
+	for id := c.CurrentNode; id != "" && !seen[id]; id = c.Mapping[id].Parent {
+		seen[id] = true // a malformed export could loop
+		if msg := c.Mapping[id].Message; msg != nil {
+			messages = append(messages, msg)
+		}
+	}
+	slices.Reverse(messages)
+	return messages
+}
+
+// text returns the text of a message: its text parts, or its code as a fenced code block.
+func (m *chatGPTMessage) text() string {
+	switch m.Content.ContentType {
+	case "text", "multimodal_text":
+		var text string
+		for _, raw := range m.Content.Parts {
+			var part string
+			if json.Unmarshal(raw, &part) == nil {
+				text = joinParagraphs(text, part)
+			}
+		}
+		return text
+	case "code":
+		if code := strings.TrimSpace(m.Content.Text); code != "" {
+			return fmt.Sprintf("```%s\n%s\n```", strings.TrimPrefix(m.Content.Language, "unknown"), code)
+		}
+	}
+	return ""
+}
+
+// placeholder returns the prompt of a message of only images and other files, which have no text to import.
+func (m *chatGPTMessage) placeholder() string {
+	if m.Content.ContentType != "multimodal_text" || len(m.Content.Parts) == 0 {
+		return ""
+	}
+	for _, raw := range m.Content.Parts {
+		var part struct {
+			ContentType string `json:"content_type"`
+		}
+		if json.Unmarshal(raw, &part) == nil && part.ContentType == "image_asset_pointer" {
+			return "[image]"
+		}
+	}
+	return "[attachment]"
+}
+
+// claudeConversation is a conversation in a Claude export.
+type claudeConversation struct {
+	UUID      string          `json:"uuid"`
+	Name      string          `json:"name"`
+	CreatedAt time.Time       `json:"created_at"`
+	UpdatedAt time.Time       `json:"updated_at"`
+	Messages  []claudeMessage `json:"chat_messages"`
+}
+
+type claudeMessage struct {
+	Sender    string    `json:"sender"` // human or assistant
+	Text      string    `json:"text"`
+	CreatedAt time.Time `json:"created_at"`
+	Content   []struct {
+		Type     string `json:"type"`
+		Text     string `json:"text"`
+		Thinking string `json:"thinking"`
+	} `json:"content"`
+	Attachments []struct {
+		FileName         string `json:"file_name"`
+		ExtractedContent string `json:"extracted_content"`
+	} `json:"attachments"`
+	Files []struct {
+		FileName string `json:"file_name"`
+	} `json:"files"` // images and other files Claude didn't extract text from
+}
+
+// claudeModel is the model of sessions imported from Claude exports, which don't record the model of each conversation.
+const claudeModel = "claude.ai"
+
+// parseClaude returns the conversations in a Claude export. Responses keep their text and thinking, and attachments that
+// Claude extracted text from are attached to their prompts. Tool use and files without extracted text are left out, though a
+// prompt of only such files is kept as a placeholder naming them so that its response isn't added to the previous prompt's.
+func parseClaude(data []byte) ([]*Session, error) {
+	var conversations []claudeConversation
+	if err := json.Unmarshal(data, &conversations); err != nil {
+		return nil, fmt.Errorf("error reading Claude export: %w", err)
+	}
+	sessions := make([]*Session, 0, len(conversations))
+	for i := range conversations {
+		c := &conversations[i]
+		var entries []Entry
+		skipReplies := false // to a prompt that was left out
+		for j := range c.Messages {
+			msg := &c.Messages[j]
+			text, reasoning := msg.text()
+			switch msg.Sender {
+			case "human":
+				var (
+					files   []attachments.File
+					leftOut []string // names of files without text
+				)
+				for _, a := range msg.Attachments {
+					if a.ExtractedContent != "" {
+						files = append(files, attachments.File{Path: a.FileName, Text: a.ExtractedContent})
+					} else if a.FileName != "" {
+						leftOut = append(leftOut, a.FileName)
+					}
+				}
+				for _, f := range msg.Files {
+					if f.FileName != "" && !slices.ContainsFunc(files, func(file attachments.File) bool { return file.Path == f.FileName }) {
+						leftOut = append(leftOut, f.FileName)
+					}
+				}
+				if text == "" && len(files) == 0 && len(leftOut) > 0 {
+					text = "[" + strings.Join(leftOut, ", ") + "]"
+				}
+				skipReplies = text == "" && len(files) == 0
+				if !skipReplies {
+					entries = append(entries, Entry{Prompt: text, Attachments: files, Time: msg.CreatedAt})
+				}
+			case "assistant":
+				if len(entries) == 0 || skipReplies {
+					continue
+				}
+				entry := &entries[len(entries)-1]
+				entry.Response = joinParagraphs(entry.Response, text)
+				entry.Reasoning = joinParagraphs(entry.Reasoning, reasoning)
+			}
+		}
+		if len(entries) == 0 {
+			continue
+		}
+		sessions = append(sessions, newImported("claude:"+c.UUID, c.Name, claudeModel, c.CreatedAt, c.UpdatedAt, entries))
+	}
+	return sessions, nil
+}
+
+// text returns the text and thinking of a message, from its content blocks if it has them.
+func (m *claudeMessage) text() (text, reasoning string) {
+	if len(m.Content) == 0 {
+		return strings.TrimSpace(m.Text), ""
+	}
+	for _, block := range m.Content {
+		switch block.Type {
+		case "text":
+			text = joinParagraphs(text, block.Text)
+		case "thinking":
+			reasoning = joinParagraphs(reasoning, block.Thinking)
+		}
+	}
+	return text, reasoning
+}
+
+// joinParagraphs appends a paragraph to text, separated by a blank line.
+func joinParagraphs(text, paragraph string) string {
+	paragraph = strings.TrimSpace(paragraph)
+	switch {
+	case paragraph == "":
+		return text
+	case text == "":
+		return paragraph
+	}
+	return text + "\n\n" + paragraph
+}
+
+// unixTime converts a time in seconds since the epoch, as in ChatGPT exports, falling back to another time if it is missing.
+func unixTime(seconds *float64, fallback float64) time.Time {
+	if seconds == nil {
+		seconds = &fallback
+	}
+	whole, frac := math.Modf(*seconds)
+	return time.Unix(int64(whole), int64(frac*1e9)).UTC()
+}
@@ -0,0 +1,140 @@
+package session
+
+import (
+	"archive/zip"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+)
+
+func TestImportChatGPT(t *testing.T) {
+	sessions, err := Import("testdata/chatgpt.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("imported %d sessions, want 1 (the empty one is left out)", len(sessions))
+	}
+	s := sessions[0]
+	if s.Title != "Goroutine leaks" || s.Model != "gpt-4o" || s.Source != "chatgpt:6712ab34-0000-8000-9000-chatgpt00001" {
+		t.Errorf("session = %+v", s)
+	}
+	if want := time.Date(2025, 10, 19, 13, 20, 0, 500_000_000, time.UTC); !s.CreatedAt.Equal(want) {
+		t.Errorf("CreatedAt = %v, want %v", s.CreatedAt, want)
+	}
+
+	// the edited prompt replaces the original, and code and responses split by tool calls are joined
+	want := []Entry{
+		{
+			Prompt:    "How do I find a goroutine leak?",
+			Reasoning: "Think about pprof.",
+			Response:  "Use the goroutine profile.",
+			ModelID:   "o3",
+			Time:      time.Unix(1760880001, 0).UTC(),
+		},
+		{
+			Prompt:   "What does this trace show?",
+			Response: "```python\nprint(trace)\n```\n\nA blocked channel send.",
+			ModelID:  "gpt-4o",
+			Time:     time.Unix(1760880100, 0).UTC(),
+		},
+		{
+			Prompt:   "[image]", // its reply stays with it, rather than being added to the previous entry
+			Response: "That is a cat.",
+			ModelID:  "gpt-4o",
+			Time:     time.Unix(1760880110, 0).UTC(),
+		},
+	}
+	if !reflect.DeepEqual(s.Entries, want) {
+		t.Errorf("entries = %+v\nwant %+v", s.Entries, want)
+	}
+}
+
+func TestImportClaude(t *testing.T) {
+	sessions, err := Import("testdata/claude.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sessions) != 1 {
+		t.Fatalf("imported %d sessions, want 1", len(sessions))
+	}
+	s := sessions[0]
+	if s.Title != "Review this config" || s.Model != claudeModel || s.Source != "claude:0b9f6c1e-claude-0001" {
+		t.Errorf("session = %+v", s)
+	}
+	want := []Entry{
+		{
+			Prompt:      "Review this config",
+			Attachments: []attachments.File{{Path: "ducky.toml", Text: `model = "sonnet"`}},
+			Reasoning:   "The model is set.",
+			Response:    "It sets the default model to sonnet.",
+			Time:        time.Date(2025, 10, 19, 14, 0, 1, 0, time.UTC),
+		},
+		{
+			Prompt: "Thanks",
+			Time:   time.Date(2025, 10, 19, 14, 4, 0, 0, time.UTC),
+		},
+		{
+			Prompt:   "[cat.jpg]", // a file without extracted text is named
+			Response: "That is a cat.",
+			Time:     time.Date(2025, 10, 19, 14, 4, 30, 0, time.UTC),
+		},
+		// a turn with nothing to import is left out with its reply
+	}
+	if !reflect.DeepEqual(s.Entries, want) {
+		t.Errorf("entries = %+v\nwant %+v", s.Entries, want)
+	}
+
+	// the unanswered prompt is left out of the history
+	messages := s.Messages()
+	if len(messages) != 4 || messages[0].Role != "user" || !reflect.DeepEqual(messages[1], models.Message{Role: "assistant", Content: s.Entries[0].Response}) ||
+		messages[2].Content != "[cat.jpg]" {
+		t.Fatalf("messages = %+v", messages)
+	}
+	if messages[0].Content != "`ducky.toml`:\n```toml\nmodel = \"sonnet\"\n```\n\nReview this config" {
+		t.Errorf("prompt message = %q", messages[0].Content)
+	}
+}
+
+func TestImportZip(t *testing.T) {
+	data, err := os.ReadFile("testdata/claude.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	zipPath := filepath.Join(t.TempDir(), "data-2025-10-19.zip")
+	file, err := os.Create(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	archive := zip.NewWriter(file)
+	w, err := archive.Create("data-2025-10-19/conversations.json")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := archive.Close(); err != nil {
+		t.Fatal(err)
+	}
+	_ = file.Close()
+
+	fromZip, err := Import(zipPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	fromJSON, _ := Import("testdata/claude.json")
+	if len(fromZip) != 1 || fromZip[0].ID != fromJSON[0].ID {
+		t.Errorf("imported %+v from the zip, want the same session as from its JSON", fromZip)
+	}
+
+	if _, err := ParseExport([]byte(`[{"id": "not an export"}]`)); !errors.Is(err, ErrUnknownExport) {
+		t.Errorf("err = %v, want ErrUnknownExport", err)
+	}
+}
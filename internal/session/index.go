@@ -0,0 +1,569 @@
+package session
+
+import (
+	"bytes"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// indexFile is the search index in a store's directory, and journalFile the sessions indexed since it was written. Neither
+// is JSON, so that they aren't listed as sessions.
+const (
+	indexFile   = "index.gob"
+	journalFile = "index.log"
+)
+
+// indexVersion is the version of the index format. An index or journal record of another version is ignored, and the
+// sessions it covered are indexed again.
+const indexVersion = 2
+
+// maxJournalSize is the size in bytes at which a search merges the journal into the index file.
+const maxJournalSize = 1 << 20
+
+// Field is a part of an entry that is searched.
+type Field uint8
+
+const (
+	FieldPrompt Field = iota
+	FieldResponse
+	FieldReasoning
+)
+
+func (f Field) String() string {
+	switch f {
+	case FieldPrompt:
+		return "prompt"
+	case FieldResponse:
+		return "response"
+	case FieldReasoning:
+		return "reasoning"
+	}
+	return "unknown"
+}
+
+// index maps the terms in stored sessions to where they occur. Saving a session appends its postings to the journal, so
+// that the whole index isn't rewritten after every response, and searches merge the journal into the index file once it
+// grows. The index is also synced with the session files before each search, so that sessions changed by another process
+// are found too.
+type index struct {
+	Version  int
+	Sessions map[string]indexedSession // by ID
+	Postings map[string][]posting      // by term
+}
+
+type indexedSession struct {
+	ModTime   time.Time // of the session's file when it was indexed
+	UpdatedAt time.Time // of the session, which orders results
+	Terms     []string  // so that its postings can be removed when it is indexed again
+}
+
+// posting is an occurrence of a term in a field of an entry.
+type posting struct {
+	Session string
+	Entry   int
+	Field   Field
+	Count   int
+}
+
+// journalRecord is a line of the journal: the postings of a saved session.
+type journalRecord struct {
+	Version  int
+	ID       string
+	Session  indexedSession
+	Postings map[string][]posting // by term
+}
+
+func newIndex() *index {
+	return &index{Version: indexVersion, Sessions: make(map[string]indexedSession), Postings: make(map[string][]posting)}
+}
+
+// newRecord returns the postings of a session whose file has the given modification time.
+func newRecord(s *Session, modTime time.Time) journalRecord {
+	counts := make(map[string]map[posting]int)
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		for field, text := range entry.fields() {
+			for _, tok := range tokenize(text) {
+				key := posting{Session: s.ID, Entry: i, Field: field}
+				if counts[tok.term] == nil {
+					counts[tok.term] = make(map[posting]int)
+				}
+				counts[tok.term][key]++
+			}
+		}
+	}
+	rec := journalRecord{
+		Version:  indexVersion,
+		ID:       s.ID,
+		Session:  indexedSession{ModTime: modTime, UpdatedAt: s.UpdatedAt, Terms: make([]string, 0, len(counts))},
+		Postings: make(map[string][]posting, len(counts)),
+	}
+	for term, keys := range counts {
+		rec.Session.Terms = append(rec.Session.Terms, term)
+		for key, count := range keys {
+			key.Count = count
+			rec.Postings[term] = append(rec.Postings[term], key)
+		}
+	}
+	return rec
+}
+
+// add indexes a session, replacing its previous postings.
+func (idx *index) add(s *Session, modTime time.Time) {
+	idx.put(newRecord(s, modTime))
+}
+
+// put replaces a session's postings with those of a record.
+func (idx *index) put(rec journalRecord) {
+	idx.remove(rec.ID)
+	for term, postings := range rec.Postings {
+		idx.Postings[term] = append(idx.Postings[term], postings...)
+	}
+	idx.Sessions[rec.ID] = rec.Session
+}
+
+// remove drops a session's postings from the index.
+func (idx *index) remove(id string) {
+	indexed, ok := idx.Sessions[id]
+	if !ok {
+		return
+	}
+	for _, term := range indexed.Terms {
+		postings := slices.DeleteFunc(idx.Postings[term], func(p posting) bool { return p.Session == id })
+		if len(postings) == 0 {
+			delete(idx.Postings, term)
+		} else {
+			idx.Postings[term] = postings
+		}
+	}
+	delete(idx.Sessions, id)
+}
+
+// fields returns the searched text of an entry by field.
+func (e *Entry) fields() map[Field]string {
+	return map[Field]string{FieldPrompt: e.Prompt, FieldResponse: e.Response, FieldReasoning: e.Reasoning}
+}
+
+// token is a term in text, and the bytes of text it was read from.
+type token struct {
+	term       string
+	start, end int
+}
+
+// tokenize splits text into lowercase terms of letters and digits.
+func tokenize(text string) []token {
+	var tokens []token
+	start := -1
+	for i, r := range text {
+		isTermRune := unicode.IsLetter(r) || unicode.IsDigit(r)
+		switch {
+		case isTermRune && start == -1:
+			start = i
+		case !isTermRune && start != -1:
+			tokens = append(tokens, token{strings.ToLower(text[start:i]), start, i})
+			start = -1
+		}
+	}
+	if start != -1 {
+		tokens = append(tokens, token{strings.ToLower(text[start:]), start, len(text)})
+	}
+	return tokens
+}
+
+func (st *Store) indexPath() string {
+	return filepath.Join(st.Dir, indexFile)
+}
+
+func (st *Store) journalPath() string {
+	return filepath.Join(st.Dir, journalFile)
+}
+
+// readIndex returns the store's index file, or an empty index if it doesn't exist or can't be read, in which case it is
+// rebuilt.
+func (st *Store) readIndex() *index {
+	file, err := os.Open(st.indexPath())
+	if err != nil {
+		return newIndex()
+	}
+	defer func() { _ = file.Close() }()
+	var idx index
+	if err := gob.NewDecoder(file).Decode(&idx); err != nil || idx.Version != indexVersion {
+		return newIndex()
+	}
+	return &idx
+}
+
+// loadIndex returns the cached index, after reading the index file again if another process replaced it, and applying the
+// records appended to the journal since it was last read. Must be called with indexMu held.
+func (st *Store) loadIndex() *index {
+	info, err := os.Stat(st.indexPath())
+	if err != nil {
+		info = nil
+	}
+	journalSize := int64(0)
+	if journalInfo, err := os.Stat(st.journalPath()); err == nil {
+		journalSize = journalInfo.Size()
+	}
+	if st.index == nil || !sameFile(st.indexInfo, info) || journalSize < st.journalRead {
+		st.index, st.indexInfo, st.journalRead = st.readIndex(), info, 0
+	}
+	if journalSize > st.journalRead {
+		st.readJournal()
+	}
+	return st.index
+}
+
+// sameFile reports whether two results of os.Stat are of the same, unchanged file, or are both of a missing file.
+func sameFile(a, b os.FileInfo) bool {
+	if a == nil || b == nil {
+		return a == b
+	}
+	return os.SameFile(a, b) && a.ModTime().Equal(b.ModTime()) && a.Size() == b.Size()
+}
+
+// readJournal applies the journal's records from where it was last read to the cached index. Records that can't be read
+// are skipped, as syncIndex indexes their sessions again.
+func (st *Store) readJournal() {
+	file, err := os.Open(st.journalPath())
+	if err != nil {
+		return
+	}
+	defer func() { _ = file.Close() }()
+	if _, err := file.Seek(st.journalRead, io.SeekStart); err != nil {
+		return
+	}
+	data, err := io.ReadAll(file)
+	if err != nil {
+		return
+	}
+	complete := bytes.LastIndexByte(data, '\n') + 1 // a record may be being appended
+	for line := range bytes.SplitSeq(data[:complete], []byte("\n")) {
+		var rec journalRecord
+		if json.Unmarshal(line, &rec) == nil && rec.Version == indexVersion {
+			st.index.put(rec)
+		}
+	}
+	st.journalRead += int64(complete)
+}
+
+// appendJournal appends records to the journal, in a single write so that records of other processes aren't interleaved.
+func (st *Store) appendJournal(records []journalRecord) error {
+	var buf bytes.Buffer
+	encoder := json.NewEncoder(&buf) // Encode ends each record with a newline
+	for _, rec := range records {
+		if err := encoder.Encode(rec); err != nil {
+			return fmt.Errorf("error saving search index: %w", err)
+		}
+	}
+	file, err := os.OpenFile(st.journalPath(), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	if _, err := file.Write(buf.Bytes()); err != nil {
+		_ = file.Close()
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	return nil
+}
+
+// writeIndex replaces the store's index atomically, like Save.
+func (st *Store) writeIndex(idx *index) error {
+	tmp, err := os.CreateTemp(st.Dir, indexFile+".*.tmp")
+	if err != nil {
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails once renamed
+	if err := gob.NewEncoder(tmp).Encode(idx); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	if err := os.Rename(tmp.Name(), st.indexPath()); err != nil {
+		return fmt.Errorf("error saving search index: %w", err)
+	}
+	return nil
+}
+
+// updateIndex indexes sessions that were just saved, whose files have the given modification times, by appending them to
+// the journal. It is best-effort: if the journal can't be written, syncIndex indexes the sessions before the next search
+// instead.
+func (st *Store) updateIndex(sessions []*Session, modTimes []time.Time) {
+	if len(sessions) == 0 {
+		return
+	}
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	idx := st.loadIndex()
+	records := make([]journalRecord, len(sessions))
+	for i, s := range sessions {
+		records[i] = newRecord(s, modTimes[i])
+		idx.put(records[i])
+	}
+	// the records are read back with the journal next time, which is harmless, since the cache already has them
+	_ = st.appendJournal(records)
+}
+
+// syncIndex returns the index after indexing the session files that changed since they were indexed, and removing the
+// sessions whose files were deleted or can't be read. Store.List reports the files that can't be read. The index file is
+// rewritten, and the journal merged into it, if the sync changed the index or the journal has grown large.
+func (st *Store) syncIndex() (*index, error) {
+	st.indexMu.Lock()
+	defer st.indexMu.Unlock()
+	idx := st.loadIndex()
+	paths, err := filepath.Glob(filepath.Join(st.Dir, "*.json"))
+	if err != nil {
+		return nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+
+	changed := false
+	onDisk := make(map[string]bool, len(paths))
+	for _, path := range paths {
+		id := strings.TrimSuffix(filepath.Base(path), ".json")
+		onDisk[id] = true
+		info, err := os.Stat(path)
+		if err != nil {
+			continue // deleted since it was listed
+		}
+		if indexed, ok := idx.Sessions[id]; ok && indexed.ModTime.Equal(info.ModTime()) {
+			continue
+		}
+		s, err := ReadFile(path)
+		if err != nil {
+			if _, ok := idx.Sessions[id]; ok {
+				idx.remove(id)
+				changed = true
+			}
+			continue
+		}
+		idx.add(s, info.ModTime())
+		changed = true
+	}
+	for id := range idx.Sessions {
+		if !onDisk[id] {
+			idx.remove(id)
+			changed = true
+		}
+	}
+
+	if changed || st.journalRead > maxJournalSize {
+		if err := os.MkdirAll(st.Dir, 0o750); err != nil {
+			return nil, fmt.Errorf("error creating sessions directory: %w", err)
+		}
+		if err := st.writeIndex(idx); err != nil {
+			return nil, err
+		}
+		// records appended by another process since the journal was read are lost, and indexed by the next sync instead
+		if err := os.Remove(st.journalPath()); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("error saving search index: %w", err)
+		}
+		info, err := os.Stat(st.indexPath())
+		if err != nil {
+			info = nil
+		}
+		st.indexInfo, st.journalRead = info, 0
+	}
+	return idx, nil
+}
+
+// SearchOptions change what Store.Search matches.
+type SearchOptions struct {
+	Reasoning bool // search the models' reasoning too, not only prompts and responses
+	Limit     int  // of results, or 0 for all
+}
+
+// Result is an entry that matches a search.
+type Result struct {
+	Session *Session
+	Entry   int   // the index of the entry in Session.Entries
+	Field   Field // where the snippet is from
+	Snippet string
+	Matches [][2]int // byte ranges of the query's terms in Snippet
+}
+
+// snippetContext is roughly how many bytes of text are shown before the first match in a snippet, and snippetLength the
+// length of a snippet.
+const (
+	snippetContext = 40
+	snippetLength  = 160
+)
+
+// ErrEmptyQuery is returned by Store.Search for queries without any letters or digits.
+var ErrEmptyQuery = errors.New("the search query has no words")
+
+// Search returns the entries of stored sessions that contain every term in query, where a term matches the words it is a
+// prefix of. Results are ordered by how often the terms occur in them, then by how recently their session was updated.
+func (st *Store) Search(query string, opts SearchOptions) ([]Result, error) {
+	var terms []string
+	for _, tok := range tokenize(query) {
+		terms = append(terms, tok.term)
+	}
+	if len(terms) == 0 {
+		return nil, ErrEmptyQuery
+	}
+	idx, err := st.syncIndex()
+	if err != nil {
+		return nil, err
+	}
+
+	type entryKey struct {
+		session string
+		entry   int
+	}
+	var matches map[entryKey]int // to the number of occurrences of the terms
+	for _, term := range terms {
+		termMatches := make(map[entryKey]int)
+		for indexed, postings := range idx.Postings {
+			if !strings.HasPrefix(indexed, term) {
+				continue
+			}
+			for _, p := range postings {
+				if p.Field == FieldReasoning && !opts.Reasoning {
+					continue
+				}
+				termMatches[entryKey{p.Session, p.Entry}] += p.Count
+			}
+		}
+		if matches == nil {
+			matches = termMatches
+			continue
+		}
+		for key, count := range matches {
+			if termCount, ok := termMatches[key]; ok {
+				matches[key] = count + termCount
+			} else {
+				delete(matches, key)
+			}
+		}
+	}
+
+	// rank the matches on the index, so that only the sessions of the results that are returned are read
+	keys := make([]entryKey, 0, len(matches))
+	for key := range matches {
+		keys = append(keys, key)
+	}
+	slices.SortFunc(keys, func(a, b entryKey) int {
+		if matches[a] != matches[b] {
+			return matches[b] - matches[a]
+		}
+		if c := idx.Sessions[b.session].UpdatedAt.Compare(idx.Sessions[a.session].UpdatedAt); c != 0 {
+			return c
+		}
+		if c := strings.Compare(a.session, b.session); c != 0 {
+			return c
+		}
+		return a.entry - b.entry
+	})
+
+	sessions := make(map[string]*Session)
+	results := make([]Result, 0, len(keys))
+	for _, key := range keys {
+		if opts.Limit > 0 && len(results) == opts.Limit {
+			break
+		}
+		s, ok := sessions[key.session]
+		if !ok {
+			if s, err = ReadFile(st.path(key.session)); err != nil {
+				continue // deleted or changed since the index was synced
+			}
+			sessions[key.session] = s
+		}
+		if key.entry >= len(s.Entries) {
+			continue // the index is out of date
+		}
+		results = append(results, Result{Session: s, Entry: key.entry})
+	}
+	for i := range results {
+		results[i].snip(terms, opts.Reasoning)
+	}
+	return results, nil
+}
+
+// snip sets the result's snippet to the text around the first match in its entry, searching the prompt, then the response,
+// then the reasoning.
+func (r *Result) snip(terms []string, reasoning bool) {
+	entry := &r.Session.Entries[r.Entry]
+	fields := []Field{FieldPrompt, FieldResponse}
+	if reasoning {
+		fields = append(fields, FieldReasoning)
+	}
+	for _, field := range fields {
+		text := entry.fields()[field]
+		var matches [][2]int
+		for _, tok := range tokenize(text) {
+			for _, term := range terms {
+				if strings.HasPrefix(tok.term, term) {
+					matches = append(matches, [2]int{tok.start, tok.end})
+					break
+				}
+			}
+		}
+		if len(matches) == 0 {
+			continue
+		}
+
+		start := max(0, matches[0][0]-snippetContext)
+		for start > 0 && !utf8.RuneStart(text[start]) {
+			start--
+		}
+		if i := strings.IndexAny(text[start:matches[0][0]], " \n\t"); i != -1 && start > 0 {
+			start += i + 1 // start at a word
+		}
+		end := min(len(text), start+snippetLength)
+		for end < len(text) && !utf8.RuneStart(text[end]) {
+			end++
+		}
+
+		var prefix, suffix string
+		if start > 0 {
+			prefix = "…"
+		}
+		if end < len(text) {
+			suffix = "…"
+		}
+		r.Field = field
+		r.Snippet = prefix + strings.Map(flattenWhitespace, text[start:end]) + suffix
+		r.Matches = nil
+		for _, m := range matches {
+			if m[0] >= start && m[1] <= end {
+				r.Matches = append(r.Matches, [2]int{m[0] - start + len(prefix), m[1] - start + len(prefix)})
+			}
+		}
+		return
+	}
+}
+
+// flattenWhitespace keeps snippets on one line. It only replaces single-byte runes, so that matches keep their offsets.
+func flattenWhitespace(r rune) rune {
+	if r == '\n' || r == '\r' || r == '\t' {
+		return ' '
+	}
+	return r
+}
+
+// Highlight returns the result's snippet with each match passed through highlight, e.g. to style it.
+func (r *Result) Highlight(highlight func(string) string) string {
+	var b strings.Builder
+	prev := 0
+	for _, m := range r.Matches {
+		b.WriteString(r.Snippet[prev:m[0]])
+		b.WriteString(highlight(r.Snippet[m[0]:m[1]]))
+		prev = m[1]
+	}
+	b.WriteString(r.Snippet[prev:])
+	return b.String()
+}
@@ -0,0 +1,138 @@
+// Package session records conversations from the TUI and stores them on disk, so that they can be exported and revisited.
+package session
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+	"time"
+
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// Version is the version of the session file format, stored in each session so that old files can be migrated.
+const Version = 1
+
+// titleLength is the length, in runes, that the first prompt is cut to to title a session.
+const titleLength = 60
+
+// Session is a conversation with a model. It is stored as JSON, and holds everything needed to reproduce the conversation.
+type Session struct {
+	Version      int       `json:"version"`
+	ID           string    `json:"id"`
+	Source       string    `json:"source,omitempty"` // where an imported conversation came from, e.g. chatgpt:<its ID>
+	Title        string    `json:"title"`
+	Model        string    `json:"model"`    // the name ducky was run with, e.g. sonnet
+	ModelID      string    `json:"model_id"` // the provider's ID of the model, e.g. claude-sonnet-4-6
+	SystemPrompt string    `json:"system_prompt,omitempty"`
+	CreatedAt    time.Time `json:"created_at"`
+	UpdatedAt    time.Time `json:"updated_at"`
+	Entries      []Entry   `json:"entries"`
+}
+
+// Entry is a prompt and the response to it.
+type Entry struct {
+	Prompt      string             `json:"prompt"`
+	Attachments []attachments.File `json:"attachments,omitempty"`
+	Reasoning   string             `json:"reasoning,omitempty"`
+	Response    string             `json:"response"`
+	Error       string             `json:"error,omitempty"` // formatted as it is shown in the TUI
+	StopReason  models.StopReason  `json:"stop_reason,omitempty"`
+	ModelID     string             `json:"model_id,omitempty"` // the model that responded, as reported by the provider
+	Usage       models.Usage       `json:"usage"`
+	Cost        float64            `json:"cost"` // in dollars
+	Time        time.Time          `json:"time"` // when the prompt was sent
+}
+
+// New returns an empty session with a new ID.
+func New(model, modelID, systemPrompt string) *Session {
+	now := time.Now()
+	return &Session{
+		Version:      Version,
+		ID:           newID(now),
+		Model:        model,
+		ModelID:      modelID,
+		SystemPrompt: systemPrompt,
+		CreatedAt:    now,
+		UpdatedAt:    now,
+	}
+}
+
+// newID returns an ID that sorts by creation time, e.g. 20261019-153045-a1b2.
+func newID(now time.Time) string {
+	suffix := make([]byte, 2)
+	_, _ = rand.Read(suffix)
+	return now.Format("20060102-150405") + "-" + hex.EncodeToString(suffix)
+}
+
+// AddPrompt starts a new entry with a prompt and the files attached to it. The first prompt titles the session.
+func (s *Session) AddPrompt(prompt string, files []attachments.File) {
+	if s.Title == "" {
+		s.Title = title(prompt, files)
+	}
+	s.Entries = append(s.Entries, Entry{Prompt: prompt, Attachments: files, Time: time.Now()})
+}
+
+// Last returns the latest entry, or nil if there are none.
+func (s *Session) Last() *Entry {
+	if len(s.Entries) == 0 {
+		return nil
+	}
+	return &s.Entries[len(s.Entries)-1]
+}
+
+// Usage returns the tokens used by all responses in the session.
+func (s *Session) Usage() models.Usage {
+	var usage models.Usage
+	for i := range s.Entries {
+		usage.InputTokens += s.Entries[i].Usage.InputTokens
+		usage.OutputTokens += s.Entries[i].Usage.OutputTokens
+	}
+	return usage
+}
+
+// Cost returns the cost in dollars of all responses in the session.
+func (s *Session) Cost() float64 {
+	var cost float64
+	for i := range s.Entries {
+		cost += s.Entries[i].Cost
+	}
+	return cost
+}
+
+// Messages returns the session's prompts and responses as chat history, so that the conversation can be continued with
+// any model. Prompts that weren't responded to are left out, as they are from a model's own history.
+func (s *Session) Messages() []models.Message {
+	messages := make([]models.Message, 0, 2*len(s.Entries))
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		if entry.Response == "" {
+			continue
+		}
+		messages = append(messages,
+			attachments.Message(entry.Prompt, entry.Attachments),
+			models.Message{Role: "assistant", Content: entry.Response},
+		)
+	}
+	return messages
+}
+
+// Clone returns a copy of the session that can be read while the original is modified.
+func (s *Session) Clone() *Session {
+	clone := *s
+	clone.Entries = append([]Entry(nil), s.Entries...)
+	return &clone
+}
+
+// title returns the first line of a prompt, cut to titleLength, or the name of the first attachment if the prompt is empty.
+func title(prompt string, files []attachments.File) string {
+	line, _, _ := strings.Cut(strings.TrimSpace(prompt), "\n")
+	if line == "" && len(files) > 0 {
+		line = files[0].Path
+	}
+	if runes := []rune(line); len(runes) > titleLength {
+		line = strings.TrimSpace(string(runes[:titleLength-1])) + "…"
+	}
+	return line
+}
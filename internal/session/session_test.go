@@ -0,0 +1,365 @@
+package session
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// testSession returns a session with one entry, whose response has a code block and raw HTML.
+func testSession() *Session {
+	s := New("sonnet", "claude-sonnet-4-6", "be brief")
+	s.CreatedAt = time.Date(2026, 10, 19, 15, 4, 0, 0, time.UTC)
+	s.AddPrompt("Why does this fail?\n\n<script>alert(1)</script>", []attachments.File{{Path: "main.go", Text: "package main"}})
+	entry := s.Last()
+	entry.Reasoning = "Think about **it**"
+	entry.Response = "Use this:\n\n```go\nfmt.Println(\"quack\")\n```\n\n<b>raw</b>"
+	entry.StopReason = models.StopReasonMaxTokens
+	entry.Usage = models.Usage{InputTokens: 1200, OutputTokens: 300}
+	entry.Cost = 0.0081
+	entry.Time = s.CreatedAt
+	return s
+}
+
+func TestTitle(t *testing.T) {
+	tests := []struct {
+		prompt string
+		files  []attachments.File
+		want   string
+	}{
+		{"  Explain this\nlong question", nil, "Explain this"},
+		{"", []attachments.File{{Path: "cat.png"}}, "cat.png"},
+		{strings.Repeat("quack ", 20), nil, strings.TrimSpace(strings.Repeat("quack ", 10)) + "…"},
+	}
+	for _, tt := range tests {
+		if got := title(tt.prompt, tt.files); got != tt.want {
+			t.Errorf("title(%q) = %q, want %q", tt.prompt, got, tt.want)
+		}
+	}
+}
+
+func TestStore(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	if _, err := store.Load(LastRef); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("empty store: err = %v", err)
+	}
+
+	older, newer := testSession(), testSession()
+	older.ID, newer.ID = "20261018-090000-aaaa", "20261019-090000-bbbb"
+	older.UpdatedAt, newer.UpdatedAt = time.Now().Add(-time.Hour), time.Now()
+	for _, s := range []*Session{older, newer} {
+		if err := store.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sessions, skipped, err := store.List()
+	if err != nil || len(skipped) != 0 || len(sessions) != 2 || sessions[0].ID != newer.ID {
+		t.Fatalf("List() = %v, %v, %v", sessions, skipped, err)
+	}
+	loaded, err := store.Load(newer.ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(loaded.Entries, newer.Entries) || loaded.Title != newer.Title {
+		t.Errorf("loaded %+v", loaded)
+	}
+
+	for ref, want := range map[string]string{
+		LastRef:                     newer.ID,
+		"20261018":                  older.ID,
+		store.path(older.ID):        older.ID,
+		"20261019-090000-bbbb":      newer.ID,
+		"20261019-090000-bbbb.json": "",
+		"2026":                      "",
+		"20261020":                  "",
+	} {
+		s, err := store.Load(ref)
+		switch {
+		case want == "" && err == nil:
+			t.Errorf("Load(%q) = %s, want an error", ref, s.ID)
+		case want != "" && (err != nil || s.ID != want):
+			t.Errorf("Load(%q) = %v, %v, want %s", ref, s, err, want)
+		}
+	}
+
+	// files that can't be read are skipped and reported, rather than hiding every session
+	for name, content := range map[string]string{"corrupt": "{", "future": `{"version": 999}`} {
+		if err := os.WriteFile(store.path(name), []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	sessions, skipped, err = store.List()
+	if err != nil || len(sessions) != 2 || len(skipped) != 2 {
+		t.Errorf("List() with unreadable files = %d sessions, %v, %v", len(sessions), skipped, err)
+	}
+	if s, err := store.Load(LastRef); err != nil || s.ID != newer.ID {
+		t.Errorf("Load(last) with unreadable files = %v, %v", s, err)
+	}
+}
+
+func TestExportMarkdown(t *testing.T) {
+	want := `# Why does this fail?
+
+- **Model:** sonnet (` + "`claude-sonnet-4-6`" + `)
+- **Date:** 2026-10-19 15:04
+- **Tokens:** 1.2k in, 300 out
+- **Cost:** 0.8¢
+
+---
+
+> Why does this fail?
+>
+> <script>alert(1)</script>
+
+*Attached: ` + "`main.go`" + `*
+
+<details>
+<summary>Reasoning</summary>
+
+Think about **it**
+
+</details>
+
+Use this:
+
+` + "```go\nfmt.Println(\"quack\")\n```" + `
+
+<b>raw</b>
+
+*The response was cut off by the max-tokens limit.*
+`
+	if got := Markdown(testSession()); got != want {
+		t.Errorf("Markdown() =\n%s", got)
+	}
+}
+
+func TestExportHTML(t *testing.T) {
+	var b bytes.Buffer
+	if err := Export(&b, testSession(), FormatHTML); err != nil {
+		t.Fatal(err)
+	}
+	page := b.String()
+	for _, want := range []string{
+		"<title>Why does this fail?</title>",
+		`<pre class="prompt">Why does this fail?` + "\n\n" + `&lt;script&gt;alert(1)&lt;/script&gt;</pre>`,
+		`<p class="attachments">[main.go]</p>`,
+		"<summary>Reasoning</summary>\n<p>Think about <strong>it</strong></p>",
+		`<span style="color:#6639ba">Println</span>`, // highlighted by chroma
+		"<p><em>The response was cut off by the max-tokens limit.</em></p>",
+	} {
+		if !strings.Contains(page, want) {
+			t.Errorf("page does not contain %q", want)
+		}
+	}
+	if strings.Contains(page, "<b>") || strings.Contains(page, "<script>") {
+		t.Error("raw HTML was copied into the page")
+	}
+}
+
+func TestExportJSON(t *testing.T) {
+	s := testSession()
+	var b bytes.Buffer
+	if err := Export(&b, s, FormatJSON); err != nil {
+		t.Fatal(err)
+	}
+	var decoded Session
+	if err := json.Unmarshal(b.Bytes(), &decoded); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(decoded.Entries, s.Entries) || decoded.ModelID != s.ModelID || decoded.Usage() != s.Usage() {
+		t.Errorf("decoded %+v", decoded)
+	}
+}
+
+func TestParseFormat(t *testing.T) {
+	for name, want := range map[string]Format{"md": FormatMarkdown, "Markdown": FormatMarkdown, ".htm": FormatHTML, "json": FormatJSON} {
+		if got, err := ParseFormat(name); err != nil || got != want {
+			t.Errorf("ParseFormat(%q) = %q, %v", name, got, err)
+		}
+	}
+	if _, err := ParseFormat("pdf"); err == nil {
+		t.Error("pdf was accepted")
+	}
+}
+
+func TestSearch(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	if _, err := store.Search("quack", SearchOptions{}); err != nil {
+		t.Fatalf("empty store: %v", err)
+	}
+
+	ducks, geese := testSession(), New("haiku", "claude-haiku-4-5", "")
+	ducks.ID, geese.ID = "20261018-090000-aaaa", "20261019-090000-bbbb"
+	geese.AddPrompt("Do geese quack?", nil)
+	geese.Last().Response = "No, geese honk.\nDucks quack."
+	geese.Last().Reasoning = "Geese are loud birds."
+	for _, s := range []*Session{ducks, geese} {
+		if err := store.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if sessions, _, _ := store.List(); len(sessions) != 2 {
+		t.Fatalf("listed %d sessions, the index shouldn't be listed", len(sessions))
+	}
+
+	search := func(query string, opts SearchOptions) []string {
+		t.Helper()
+		results, err := store.Search(query, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		got := make([]string, len(results))
+		for i := range results {
+			r := &results[i]
+			got[i] = fmt.Sprintf("%s %s %s", r.Session.ID, r.Field, r.Highlight(func(s string) string { return "[" + s + "]" }))
+		}
+		return got
+	}
+
+	// geese has two occurrences, and prefixes match
+	want := []string{
+		"20261019-090000-bbbb prompt Do geese [quack]?",
+		`20261018-090000-aaaa response Use this:  ` + "```go fmt.Println(\"[quack]\") ```  <b>raw</b>",
+	}
+	if got := search("QUA", SearchOptions{}); !reflect.DeepEqual(got, want) {
+		t.Errorf("search(QUA) =\n%q\nwant\n%q", got, want)
+	}
+	if got := search("quack honk", SearchOptions{}); len(got) != 1 || !strings.HasPrefix(got[0], geese.ID) {
+		t.Errorf("search(quack honk) = %q, want only geese", got)
+	}
+	if got := search("loud", SearchOptions{}); len(got) != 0 {
+		t.Errorf("search(loud) = %q, reasoning shouldn't be searched", got)
+	}
+	if got := search("loud", SearchOptions{Reasoning: true}); len(got) != 1 || !strings.Contains(got[0], "reasoning Geese are [loud] birds.") {
+		t.Errorf("search(loud) with reasoning = %q", got)
+	}
+	if _, err := store.Search("  ?! ", SearchOptions{}); !errors.Is(err, ErrEmptyQuery) {
+		t.Errorf("err = %v, want ErrEmptyQuery", err)
+	}
+
+	// saving a session updates the index, and deleted sessions drop out of it
+	geese.AddPrompt("What about swans?", nil)
+	if err := store.Save(geese); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("swans", SearchOptions{}); len(got) != 1 || !strings.Contains(got[0], "What about [swans]?") {
+		t.Errorf("search(swans) = %q", got)
+	}
+	if err := os.Remove(store.path(geese.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("quack", SearchOptions{}); len(got) != 1 || !strings.HasPrefix(got[0], ducks.ID) {
+		t.Errorf("search(quack) after deleting geese = %q", got)
+	}
+
+	// a session file that can't be read drops out of the index instead of failing every search
+	if err := os.WriteFile(store.path(ducks.ID), []byte("{"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if got := search("quack", SearchOptions{}); len(got) != 0 {
+		t.Errorf("search(quack) after corrupting ducks = %q", got)
+	}
+}
+
+func TestSaveIndexes(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	ducks, geese := testSession(), New("haiku", "claude-haiku-4-5", "")
+	geese.ID = "20261019-090000-bbbb"
+	geese.AddPrompt("Do geese honk?", nil)
+	if err := store.Save(ducks); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.SaveAll([]*Session{geese}); err != nil {
+		t.Fatal(err)
+	}
+
+	// saving appends to the journal rather than rewriting the index file
+	if _, err := os.Stat(store.indexPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("Save wrote the index file: %v", err)
+	}
+
+	// the sessions are indexed at their files' modification times, so the next search doesn't read them again, even in
+	// another process
+	other := &Store{Dir: store.Dir}
+	idx := other.loadIndex()
+	for _, s := range []*Session{ducks, geese} {
+		info, err := os.Stat(store.path(s.ID))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if indexed, ok := idx.Sessions[s.ID]; !ok || !indexed.ModTime.Equal(info.ModTime()) {
+			t.Errorf("session %s indexed = %v at %v, file modified at %v", s.ID, ok, indexed.ModTime, info.ModTime())
+		}
+	}
+	if len(idx.Postings["quack"]) == 0 || len(idx.Postings["honk"]) != 1 {
+		t.Errorf("postings of quack = %v, honk = %v", idx.Postings["quack"], idx.Postings["honk"])
+	}
+	if results, err := other.Search("honk", SearchOptions{}); err != nil || len(results) != 1 {
+		t.Fatalf("Search() = %v, %v", results, err)
+	}
+
+	// each store picks up what the other saved since it cached the index
+	geese.AddPrompt("And swans?", nil)
+	if err := other.Save(geese); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := store.Search("swans", SearchOptions{}); err != nil || len(results) != 1 {
+		t.Fatalf("Search(swans) = %v, %v", results, err)
+	}
+
+	// a search that changes the index merges the journal into the index file
+	if err := os.Remove(store.path(ducks.ID)); err != nil {
+		t.Fatal(err)
+	}
+	if results, err := store.Search("quack", SearchOptions{}); err != nil || len(results) != 0 {
+		t.Fatalf("Search(quack) = %v, %v", results, err)
+	}
+	if _, err := os.Stat(store.journalPath()); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("the journal wasn't merged: %v", err)
+	}
+	if results, err := (&Store{Dir: store.Dir}).Search("swans", SearchOptions{}); err != nil || len(results) != 1 {
+		t.Fatalf("Search(swans) after merging = %v, %v", results, err)
+	}
+}
+
+func TestSearchLimit(t *testing.T) {
+	store := &Store{Dir: t.TempDir()}
+	for i, quacks := range []int{1, 3, 2} {
+		s := New("haiku", "claude-haiku-4-5", "")
+		s.ID = fmt.Sprintf("20261019-09000%d-aaaa", i)
+		s.AddPrompt(strings.Repeat("quack ", quacks), nil)
+		if err := store.Save(s); err != nil {
+			t.Fatal(err)
+		}
+	}
+	results, err := store.Search("quack", SearchOptions{Limit: 2})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 2 || results[0].Session.ID != "20261019-090001-aaaa" || results[1].Session.ID != "20261019-090002-aaaa" {
+		t.Errorf("results = %+v, want the two with the most quacks", results)
+	}
+}
+
+func TestSnippet(t *testing.T) {
+	s := New("sonnet", "", "")
+	s.AddPrompt(strings.Repeat("filler words ", 10)+"the needle\nis here "+strings.Repeat("more words ", 20), nil)
+	r := Result{Session: s}
+	r.snip([]string{"needle"}, false)
+	if !strings.HasPrefix(r.Snippet, "…words filler words filler words the needle is here more") || !strings.HasSuffix(r.Snippet, "…") {
+		t.Errorf("snippet = %q", r.Snippet)
+	}
+	if len(r.Matches) != 1 || r.Snippet[r.Matches[0][0]:r.Matches[0][1]] != "needle" {
+		t.Errorf("matches = %v in %q", r.Matches, r.Snippet)
+	}
+}
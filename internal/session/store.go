@@ -0,0 +1,192 @@
+package session
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotFound is returned by Store.Load when no session matches.
+var ErrNotFound = errors.New("session not found")
+
+// LastRef refers to the most recently updated session in Store.Load.
+const LastRef = "last"
+
+// Store saves sessions as JSON files in a directory, one per session, named by their ID.
+type Store struct {
+	Dir string
+
+	indexMu     sync.Mutex  // serializes updates of the search index, and guards the fields below. See index.go
+	index       *index      // cached, as of indexInfo and journalRead
+	indexInfo   os.FileInfo // of the index file the cache was read from, or nil if there was none
+	journalRead int64       // bytes of the journal applied to the cache
+}
+
+// DefaultDir returns the directory sessions are stored in: $XDG_DATA_HOME/ducky/sessions, or ~/.local/share/ducky/sessions.
+func DefaultDir() (string, error) {
+	dataHome := os.Getenv("XDG_DATA_HOME")
+	if dataHome == "" {
+		homeDir, err := os.UserHomeDir()
+		if err != nil {
+			return "", fmt.Errorf("error finding the sessions directory: %w", err)
+		}
+		dataHome = filepath.Join(homeDir, ".local", "share")
+	}
+	return filepath.Join(dataHome, "ducky", "sessions"), nil
+}
+
+// DefaultStore returns a store in DefaultDir.
+func DefaultStore() (*Store, error) {
+	dir, err := DefaultDir()
+	if err != nil {
+		return nil, err
+	}
+	return &Store{Dir: dir}, nil
+}
+
+func (st *Store) path(id string) string {
+	return filepath.Join(st.Dir, id+".json")
+}
+
+// Save writes a session to its file, replacing the previous version of it, and updates the search index. The file is
+// replaced atomically, so that a crash mid-write can't lose the session.
+func (st *Store) Save(s *Session) error {
+	modTime, err := st.write(s)
+	if err != nil {
+		return err
+	}
+	st.updateIndex([]*Session{s}, []time.Time{modTime})
+	return nil
+}
+
+// SaveAll saves several sessions like Save, updating the search index once rather than once per session.
+func (st *Store) SaveAll(sessions []*Session) error {
+	modTimes := make([]time.Time, 0, len(sessions))
+	for _, s := range sessions {
+		modTime, err := st.write(s)
+		if err != nil {
+			st.updateIndex(sessions[:len(modTimes)], modTimes)
+			return err
+		}
+		modTimes = append(modTimes, modTime)
+	}
+	st.updateIndex(sessions, modTimes)
+	return nil
+}
+
+// write writes a session to its file atomically, and returns the file's modification time.
+func (st *Store) write(s *Session) (time.Time, error) {
+	data, err := json.Marshal(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error encoding session %s: %w", s.ID, err)
+	}
+	if err := os.MkdirAll(st.Dir, 0o750); err != nil {
+		return time.Time{}, fmt.Errorf("error creating sessions directory: %w", err)
+	}
+	tmp, err := os.CreateTemp(st.Dir, s.ID+".*.tmp")
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error saving session %s: %w", s.ID, err)
+	}
+	defer func() { _ = os.Remove(tmp.Name()) }() // fails once renamed
+	if _, err := tmp.Write(data); err != nil {
+		_ = tmp.Close()
+		return time.Time{}, fmt.Errorf("error saving session %s: %w", s.ID, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return time.Time{}, fmt.Errorf("error saving session %s: %w", s.ID, err)
+	}
+	// stat the file before it's renamed into place, so that the time can't be of a version saved by another process
+	info, err := os.Stat(tmp.Name())
+	if err != nil {
+		return time.Time{}, fmt.Errorf("error saving session %s: %w", s.ID, err)
+	}
+	if err := os.Rename(tmp.Name(), st.path(s.ID)); err != nil {
+		return time.Time{}, fmt.Errorf("error saving session %s: %w", s.ID, err)
+	}
+	return info.ModTime(), nil
+}
+
+// List returns the stored sessions, most recently updated first. Session files that can't be read, e.g. because they are
+// corrupt or were saved by a newer version of ducky, are skipped, and their errors are returned in skipped.
+func (st *Store) List() (sessions []*Session, skipped []error, err error) {
+	paths, err := filepath.Glob(filepath.Join(st.Dir, "*.json"))
+	if err != nil {
+		return nil, nil, fmt.Errorf("error listing sessions: %w", err)
+	}
+	sessions = make([]*Session, 0, len(paths))
+	for _, path := range paths {
+		s, err := ReadFile(path)
+		if err != nil {
+			skipped = append(skipped, err)
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	slices.SortFunc(sessions, func(a, b *Session) int {
+		return b.UpdatedAt.Compare(a.UpdatedAt)
+	})
+	return sessions, skipped, nil
+}
+
+// Load returns the session that ref refers to: a session ID, a prefix of exactly one session ID, LastRef, or the path of a
+// session file.
+func (st *Store) Load(ref string) (*Session, error) {
+	if strings.HasSuffix(ref, ".json") {
+		if _, err := os.Stat(ref); err == nil {
+			return ReadFile(ref)
+		}
+	}
+	if s, err := ReadFile(st.path(ref)); err == nil {
+		return s, nil
+	}
+
+	sessions, _, err := st.List() // a session that can't be read can't be loaded either
+	if err != nil {
+		return nil, err
+	}
+	if ref == LastRef {
+		if len(sessions) == 0 {
+			return nil, fmt.Errorf("%w: there are no saved sessions", ErrNotFound)
+		}
+		return sessions[0], nil
+	}
+	var matches []*Session
+	for _, s := range sessions {
+		if strings.HasPrefix(s.ID, ref) {
+			matches = append(matches, s)
+		}
+	}
+	switch len(matches) {
+	case 0:
+		return nil, fmt.Errorf("%w: %s", ErrNotFound, ref)
+	case 1:
+		return matches[0], nil
+	}
+	ids := make([]string, len(matches))
+	for i, s := range matches {
+		ids[i] = s.ID
+	}
+	return nil, fmt.Errorf("%s matches %d sessions: %s", ref, len(matches), strings.Join(ids, ", "))
+}
+
+// ReadFile reads a session file.
+func ReadFile(path string) (*Session, error) {
+	data, err := os.ReadFile(path) //nolint:gosec // sessions are chosen by the user
+	if err != nil {
+		return nil, fmt.Errorf("error reading session: %w", err)
+	}
+	var s Session
+	if err := json.Unmarshal(data, &s); err != nil {
+		return nil, fmt.Errorf("error reading session %s: %w", path, err)
+	}
+	if s.Version > Version {
+		return nil, fmt.Errorf("session %s was saved by a newer version of ducky", path)
+	}
+	return &s, nil
+}
@@ -0,0 +1,162 @@
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/gregriff/ducky/internal/attachments"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/gregriff/ducky/internal/stream"
+)
+
+// sessionSaved is the result of saving the session in the background.
+type sessionSaved struct {
+	err error
+}
+
+// sessionExported is the result of exporting the session with /export.
+type sessionExported struct {
+	path string // as the user gave it
+	err  error
+}
+
+// resumeSession shows a saved session's entries as the chat history, and gives them to the model as its history, so that
+// the conversation continues where it left off. The session continues with the model ducky was run with.
+func (m *model) resumeSession(s *session.Session) {
+	for i := range s.Entries {
+		entry := &s.Entries[i]
+		m.chat.AddPrompt(entry.Prompt, entry.Attachments)
+		m.chat.AccumulateStream(entry.Reasoning, true, false)
+		m.chat.AccumulateStream(entry.Response, false, false)
+		if entry.Error != "" {
+			m.chat.AccumulateStream(entry.Error, false, true)
+		}
+		m.chat.AddResponse(entry.StopReason)
+	}
+	m.llm.DoSetChatHistory(s.Messages())
+	s.Model, s.ModelID = m.modelName, m.llm.DoGetModelId()
+	m.session = s
+}
+
+// recordPrompt adds a prompt sent to the model to the session, starting a new session if it is the first prompt since the
+// chat was cleared.
+func (m *model) recordPrompt(prompt string, files []attachments.File) {
+	if m.session == nil {
+		m.session = session.New(m.modelName, m.llm.DoGetModelId(), m.systemPrompt)
+	}
+	m.session.AddPrompt(prompt, files)
+}
+
+// recordResponse stores the response that just completed in the session, and saves the session. A continued response
+// replaces the one it continued, and adds to its usage.
+func (m *model) recordResponse(result stream.Result, stopReason models.StopReason) tea.Cmd {
+	if m.session == nil || m.session.Last() == nil {
+		return nil
+	}
+	entry := m.session.Last()
+	entry.Response, entry.Reasoning, entry.Error = m.chat.LastResponse()
+	entry.StopReason = stopReason
+	if result.Err == nil && !result.Cancelled {
+		info := m.llm.DoGetLastResponseInfo()
+		entry.ModelID = info.ModelID
+		entry.Usage.InputTokens += info.Usage.InputTokens
+		entry.Usage.OutputTokens += info.Usage.OutputTokens
+		entry.Cost += info.Cost
+	}
+	m.session.UpdatedAt = time.Now()
+	return m.saveSession()
+}
+
+// saveSession writes the session to the session store in the background, if there is one.
+func (m *model) saveSession() tea.Cmd {
+	if m.sessionStore == nil || m.session == nil {
+		return nil
+	}
+	store, snapshot := m.sessionStore, m.session.Clone()
+	return func() tea.Msg {
+		return sessionSaved{err: store.Save(snapshot)}
+	}
+}
+
+// runCommand runs a slash command typed as a prompt. It returns false if the prompt isn't a command, so that it is sent to
+// the model instead.
+func (m *model) runCommand(input string) (ok bool, cmd tea.Cmd) {
+	fields := strings.Fields(input)
+	if len(fields) == 0 {
+		return false, nil
+	}
+	switch fields[0] {
+	case "/export":
+		return true, m.exportSession(fields[1:])
+	case "/search":
+		return true, m.searchSessions(fields[1:])
+	}
+	return false, nil
+}
+
+// exportSession writes the session to a file in the background, for /export [md|html|json] [file]. The format defaults to
+// the file's extension, or Markdown, and the file to one named after the session in the working directory.
+func (m *model) exportSession(args []string) tea.Cmd {
+	if m.session == nil || len(m.session.Entries) == 0 {
+		m.setNotice("nothing to export yet")
+		return nil
+	}
+	if len(args) > 2 {
+		m.setNotice("usage: /export [md|html|json] [file]")
+		return nil
+	}
+
+	format, formatGiven := session.FormatMarkdown, false
+	if len(args) > 0 {
+		if f, err := session.ParseFormat(args[0]); err == nil {
+			format, formatGiven = f, true
+			args = args[1:]
+		}
+	}
+	var path string
+	switch len(args) {
+	case 0:
+		path = fmt.Sprintf("ducky-%s.%s", m.session.ID, format)
+	case 1:
+		path = args[0]
+		if f, ok := session.FormatOf(path); ok && !formatGiven {
+			format = f
+		}
+	default:
+		m.setNotice(fmt.Sprintf("invalid export format: %s (valid formats: md, html, json)", args[0]))
+		return nil
+	}
+	fullPath := path
+	if !filepath.IsAbs(path) {
+		fullPath = filepath.Join(m.workDir, path)
+	}
+
+	snapshot := m.session.Clone()
+	return func() tea.Msg {
+		return sessionExported{path: path, err: exportToFile(fullPath, snapshot, format)}
+	}
+}
+
+func exportToFile(path string, s *session.Session, format session.Format) error {
+	file, err := os.Create(path) //nolint:gosec // path is chosen by the user
+	if err != nil {
+		return fmt.Errorf("error creating %s: %w", path, err)
+	}
+	if err := session.Export(file, s, format); err != nil {
+		_ = file.Close()
+		return err //nolint:wrapcheck // already describes the problem
+	}
+	return file.Close() //nolint:wrapcheck // unlikely, and includes the path
+}
+
+// setNotice shows a message with the attachments above the textarea until the prompt is next edited.
+func (m *model) setNotice(notice string) {
+	prevHeight := m.accessoryHeight()
+	m.notice = notice
+	m.fitAccessories(prevHeight)
+}
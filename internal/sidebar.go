@@ -0,0 +1,177 @@
+package internal
+
+import (
+	"fmt"
+	"strings"
+
+	tea "charm.land/bubbletea/v2"
+	"charm.land/lipgloss/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/session"
+	styles "github.com/gregriff/ducky/internal/styles"
+	zone "github.com/lrstanley/bubblezone/v2"
+)
+
+// recentLimit is the number of saved sessions kept for the sidebar, as many of which are listed as fit.
+const recentLimit = 50
+
+// recentSessions is the result of listing the saved sessions in the background.
+type recentSessions struct {
+	sessions []*session.Session
+	skipped  []error // of session files that couldn't be read
+	err      error
+}
+
+// sidebarItem is an entry of the sidebar: an open conversation, or a saved session that isn't open.
+type sidebarItem struct {
+	conversation *conversation
+	session      *session.Session
+}
+
+// sidebarShown returns whether the sidebar is shown: it is hidden while the window is too narrow for it.
+func (m *model) sidebarShown() bool {
+	return m.showSidebar && m.windowSize.Width >= styles.SIDEBAR_WIDTH+styles.SIDEBAR_MIN_CHAT_WIDTH
+}
+
+// chatWidth returns the width of the chat in a window of the given width, next to the sidebar if it is shown.
+func (m *model) chatWidth(windowWidth int) int {
+	if m.sidebarShown() {
+		return windowWidth - styles.SIDEBAR_WIDTH
+	}
+	return windowWidth
+}
+
+// toggleSidebar shows or hides the sidebar, resizing the chat to fit.
+func (m *model) toggleSidebar() tea.Cmd {
+	m.showSidebar = !m.showSidebar
+	if m.showSidebar && !m.sidebarShown() {
+		m.showSidebar = false
+		m.setNotice("the window is too narrow for the sidebar")
+		return nil
+	}
+	return tea.Batch(m.redraw(), m.loadRecent())
+}
+
+// loadRecent lists the saved sessions in the background, if the sidebar is shown.
+func (m *model) loadRecent() tea.Cmd {
+	if !m.showSidebar || m.sessionStore == nil {
+		return nil
+	}
+	store := m.sessionStore
+	return func() tea.Msg {
+		sessions, skipped, err := store.List()
+		return recentSessions{sessions: sessions, skipped: skipped, err: err}
+	}
+}
+
+// showRecent lists the most recently updated saved sessions in the sidebar.
+func (m *model) showRecent(msg recentSessions) {
+	if msg.err != nil {
+		m.setNotice(msg.err.Error())
+		return
+	}
+	m.recent = msg.sessions[:min(len(msg.sessions), recentLimit)]
+	if len(msg.skipped) > 0 {
+		m.setNotice(fmt.Sprintf("skipped %d unreadable session file(s): %v", len(msg.skipped), msg.skipped[0]))
+	}
+}
+
+// sidebarItems returns the entries of a sidebar of the given height: the open conversations, then the saved sessions that
+// aren't open, as many as fit.
+func (m *model) sidebarItems(height int) []sidebarItem {
+	items := make([]sidebarItem, 0, len(m.conversations)+len(m.recent))
+	open := make(map[string]bool, len(m.conversations))
+	for _, c := range m.conversations {
+		items = append(items, sidebarItem{conversation: c})
+		if c.session != nil {
+			open[c.session.ID] = true
+		}
+	}
+	fit := height - 4 // the headings, the space between the lists, and the key hints
+	for _, s := range m.recent {
+		if len(items) >= fit {
+			break
+		}
+		if !open[s.ID] {
+			items = append(items, sidebarItem{session: s})
+		}
+	}
+	return items[:max(0, min(len(items), fit))]
+}
+
+// selectSidebarItem shows the conversation at index i of the sidebar, resuming it if it is a saved session. Only the first
+// nine have keys, alt+1 to alt+9, but any of them can be clicked.
+func (m *model) selectSidebarItem(i int) tea.Cmd {
+	items := m.sidebarItems(m.windowSize.Height)
+	if !m.sidebarShown() {
+		items = items[:min(len(items), len(m.conversations))] // the saved sessions aren't listed
+	}
+	if i < 0 || i >= len(items) {
+		return nil
+	}
+	if c := items[i].conversation; c != nil {
+		return m.showConversation(c)
+	}
+	return m.openSession(items[i].session)
+}
+
+// sidebarItemAt returns the index of the sidebar entry that was clicked, if one was.
+func (m *model) sidebarItemAt(msg tea.MouseClickMsg) (int, bool) {
+	if !m.sidebarShown() {
+		return 0, false
+	}
+	for i := range m.sidebarItems(m.windowSize.Height) {
+		if zone.Get(sidebarZone(i)).InBounds(msg) {
+			return i, true
+		}
+	}
+	return 0, false
+}
+
+func sidebarZone(i int) string {
+	return fmt.Sprintf("sidebar%d", i)
+}
+
+// sidebarView renders the sidebar: the open conversations, marked while they stream and once a response completes in the
+// background, then the recent sessions.
+func (m *model) sidebarView(height int) string {
+	style := styles.TUIStyles.Sidebar
+	width := styles.SIDEBAR_WIDTH - style.GetHorizontalFrameSize()
+
+	lines := []string{styles.TUIStyles.SidebarHeading.Render("chats")}
+	for i, item := range m.sidebarItems(height) {
+		if i == len(m.conversations) {
+			lines = append(lines, "", styles.TUIStyles.SidebarHeading.Render("recent"))
+		}
+
+		key := "  "
+		if i < 9 {
+			key = fmt.Sprintf("%d ", i+1)
+		}
+		var line string
+		if c := item.conversation; c != nil {
+			itemStyle, badge := styles.TUIStyles.Completer, ""
+			if c == m.conversation {
+				itemStyle = styles.TUIStyles.CompleterSelected
+			}
+			switch {
+			case c.stream.Active():
+				badge = "…"
+			case c.unseen:
+				badge = "●"
+			}
+			title := ansi.Truncate(c.title(), width-len(key)-lipgloss.Width(badge)-1, "…")
+			line = itemStyle.Render(key+title) +
+				strings.Repeat(" ", max(1, width-len(key)-lipgloss.Width(title)-lipgloss.Width(badge))) +
+				styles.TUIStyles.SidebarBadge.Render(badge)
+		} else {
+			line = styles.TUIStyles.Completer.Render(ansi.Truncate(key+item.session.Title, width, "…"))
+		}
+		lines = append(lines, zone.Mark(sidebarZone(i), line))
+	}
+
+	hints := styles.TUIStyles.Completer.Render(ansi.Truncate("alt+n new · alt+w close", width, "…"))
+	padding := max(0, height-len(lines)-1)
+	lines = append(lines, strings.Repeat("\n", padding)+hints)
+	return style.Width(styles.SIDEBAR_WIDTH).Height(height).MaxHeight(height).Render(strings.Join(lines, "\n"))
+}
@@ -0,0 +1,265 @@
+// Package stream manages the lifecycle of the TUI's LLM requests: starting a request, forwarding its chunks to bubbletea as
+// messages, cancelling it and reporting its result.
+package stream
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/gregriff/ducky/internal/models"
+)
+
+// State is the stage of the current request.
+type State int
+
+const (
+	Idle       State = iota // no request has been made
+	Requesting              // waiting for the first chunk, which includes fitting the history into the context window
+	Reasoning               // receiving reasoning chunks
+	Streaming               // receiving response chunks
+	Cancelling              // cancelled, waiting for the request to return
+	Done                    // the last request completed, failed or was cancelled
+)
+
+func (s State) String() string {
+	switch s {
+	case Idle:
+		return "idle"
+	case Requesting:
+		return "requesting"
+	case Reasoning:
+		return "reasoning"
+	case Streaming:
+		return "streaming"
+	case Cancelling:
+		return "cancelling"
+	case Done:
+		return "done"
+	}
+	return fmt.Sprintf("State(%d)", int(s))
+}
+
+// ID identifies a request. Every message is tagged with the ID of the request that sent it, so that messages of a request that
+// has since been replaced are recognized and dropped. IDs are unique across Controllers, so that the messages of several
+// Controllers running side by side can be told apart.
+type ID uint64
+
+// lastID is the ID of the most recently started request of any Controller.
+var lastID atomic.Uint64
+
+// Bubbletea messages sent while a request is active. Result is always the last message of a request.
+type (
+	Chunk struct {
+		ID ID
+		models.StreamChunk
+	}
+	Retry struct {
+		ID ID
+		models.RetryStatus
+	}
+	Result struct {
+		ID        ID
+		Err       error // a models.StreamError, or nil if the response completed
+		Cancelled bool  // the request was cancelled with Controller.Cancel
+		Continued bool  // the request continued the previous response, see Request.Continue
+	}
+)
+
+// Request holds everything needed to prompt an LLM.
+type Request struct {
+	LLM             models.LLM
+	Prompt          models.Message
+	Continue        bool // resume the last response instead of sending Prompt, merging the two in the LLM's history
+	EnableReasoning bool
+	ReasoningEffort *uint8
+	ContextStrategy models.ContextStrategy
+	Summarizer      models.LLM // used by models.ContextStrategySummarize
+	RetryPolicy     models.RetryPolicy
+}
+
+// Controller runs one request at a time. Its methods must be called from bubbletea's Update loop; the request itself runs in its
+// own goroutine, which only communicates with the Controller through the request's channels.
+type Controller struct {
+	state  State
+	lastID ID
+	active *request
+}
+
+// request is the state shared with the goroutine of a single request.
+type request struct {
+	id     ID
+	cancel context.CancelFunc
+	events chan tea.Msg // Chunk and Retry messages, unbuffered so that the request can't get ahead of the TUI
+	done   chan Result  // receives the result once, then is closed
+}
+
+// State returns the stage of the current request.
+func (c *Controller) State() State {
+	return c.state
+}
+
+// Active returns whether a request is in progress, including one that is being cancelled.
+func (c *Controller) Active() bool {
+	switch c.state {
+	case Requesting, Reasoning, Streaming, Cancelling:
+		return true
+	case Idle, Done:
+	}
+	return false
+}
+
+// ID returns the ID of the current or last request, or zero if no request has been made.
+func (c *Controller) ID() ID {
+	return c.lastID
+}
+
+// Start begins a request and returns the command that waits for its first message. It returns nil if a request is already
+// active.
+func (c *Controller) Start(req Request) tea.Cmd {
+	if c.Active() {
+		return nil
+	}
+	c.lastID = ID(lastID.Add(1))
+	ctx, cancel := context.WithCancel(context.Background())
+	r := &request{
+		id:     c.lastID,
+		cancel: cancel,
+		events: make(chan tea.Msg),
+		done:   make(chan Result, 1),
+	}
+	c.active = r
+	c.state = Requesting
+
+	go r.run(ctx, req)
+	return c.Wait()
+}
+
+// Cancel stops the current request. Its Result is still sent, and the Controller stays active until it is handled.
+func (c *Controller) Cancel() {
+	if !c.Active() {
+		return
+	}
+	c.state = Cancelling
+	c.active.cancel()
+}
+
+// Wait returns the command that waits for the next message of the current request, or nil if there is none.
+func (c *Controller) Wait() tea.Cmd {
+	if !c.Active() {
+		return nil
+	}
+	r := c.active
+	return func() tea.Msg {
+		select {
+		case msg := <-r.events:
+			return msg
+		case done := <-r.done:
+			return done
+		}
+	}
+}
+
+// Accept updates the state for a message of a request and returns whether it belongs to the current request. Messages of
+// older requests must be dropped.
+func (c *Controller) Accept(msg tea.Msg) bool {
+	switch msg := msg.(type) {
+	case Chunk:
+		if !c.isCurrent(msg.ID) {
+			return false
+		}
+		if c.state == Cancelling {
+			return true
+		}
+		if msg.Reasoning {
+			c.state = Reasoning
+		} else {
+			c.state = Streaming
+		}
+		return true
+	case Retry:
+		if !c.isCurrent(msg.ID) {
+			return false
+		}
+		if c.state != Cancelling {
+			c.state = Requesting
+		}
+		return true
+	case Result:
+		if !c.isCurrent(msg.ID) {
+			return false
+		}
+		c.state = Done
+		c.active.cancel() // releases the context's resources
+		c.active = nil
+		return true
+	}
+	return false
+}
+
+func (c *Controller) isCurrent(id ID) bool {
+	return c.active != nil && c.active.id == id
+}
+
+// run makes the request, forwards its chunks and retries as messages, then sends the Result. Chunks are dropped once the request
+// is cancelled, since nothing will be waiting for them.
+func (r *request) run(ctx context.Context, req Request) {
+	result := Result{ID: r.id, Continued: req.Continue}
+	prompt := req.Prompt
+	if req.Continue {
+		prompt = models.UserMessage(models.ContinuePrompt)
+	}
+	defer func() {
+		if p := recover(); p != nil {
+			result.Err = models.StreamError{Err: fmt.Errorf("panic while streaming: %v", p)}
+		}
+		if ctx.Err() != nil {
+			result.Cancelled = true
+		}
+		r.done <- result
+		close(r.done)
+	}()
+
+	send := func(msg tea.Msg) {
+		select {
+		case r.events <- msg:
+		case <-ctx.Done():
+		}
+	}
+
+	responseChan := make(chan models.StreamChunk)
+	forwarded := make(chan struct{})
+	go func() {
+		defer close(forwarded)
+		for chunk := range responseChan {
+			send(Chunk{ID: r.id, StreamChunk: chunk})
+		}
+	}()
+	ownsResponseChan := true
+	defer func() { // runs before the Result is sent, even if the LLM panics
+		if ownsResponseChan {
+			close(responseChan)
+		}
+		<-forwarded
+	}()
+
+	if err := models.CheckParts(req.LLM, prompt); err != nil {
+		result.Err = models.StreamError{Err: err}
+		return
+	}
+	if err := models.FitContext(ctx, req.LLM, prompt, req.ContextStrategy, req.Summarizer); err != nil {
+		result.Err = models.StreamError{Err: err}
+		return
+	}
+	ownsResponseChan = false // StreamPromptCompletionWithRetry closes it
+	err := models.StreamPromptCompletionWithRetry(ctx, req.LLM, prompt, req.EnableReasoning, req.ReasoningEffort,
+		responseChan, req.RetryPolicy, func(status models.RetryStatus) { send(Retry{ID: r.id, RetryStatus: status}) })
+	if err != nil {
+		result.Err = err
+		return
+	}
+	if req.Continue {
+		models.MergeContinuation(req.LLM)
+	}
+}
@@ -0,0 +1,329 @@
+package stream
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	tea "charm.land/bubbletea/v2"
+	"github.com/gregriff/ducky/internal/models"
+	"github.com/gregriff/ducky/internal/models/mock"
+)
+
+const timeout = 5 * time.Second
+
+// newLLM returns a mock model that replays responses in order.
+func newLLM(t *testing.T, chunkDelay time.Duration, responses ...mock.Response) *mock.Model {
+	t.Helper()
+	llm, err := mock.NewModel("", 1024, "mock:instant", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	llm.Script = &mock.Script{
+		ChunkDelay:    mock.Duration(chunkDelay),
+		ChunkSize:     1,
+		ContextWindow: 200_000,
+		Responses:     responses,
+	}
+	return llm
+}
+
+func newRequest(llm models.LLM, prompt string) Request {
+	return Request{
+		LLM:             llm,
+		Prompt:          models.UserMessage(prompt),
+		ContextStrategy: models.ContextStrategyTruncate,
+		RetryPolicy:     models.RetryPolicy{MaxAttempts: 1},
+	}
+}
+
+// next runs a command the way bubbletea does, failing the test if it doesn't return in time.
+func next(t *testing.T, cmd tea.Cmd) tea.Msg {
+	t.Helper()
+	if cmd == nil {
+		t.Fatal("no command to wait for the next message")
+	}
+	msgChan := make(chan tea.Msg, 1)
+	go func() { msgChan <- cmd() }()
+	select {
+	case msg := <-msgChan:
+		return msg
+	case <-time.After(timeout):
+		t.Fatalf("no message within %v", timeout)
+	}
+	return nil
+}
+
+// drain handles the messages of the current request until its Result, as the TUI does, and returns the streamed text.
+func drain(t *testing.T, c *Controller, cmd tea.Cmd) (string, Result) {
+	t.Helper()
+	var text strings.Builder
+	for {
+		msg := next(t, cmd)
+		if !c.Accept(msg) {
+			t.Fatalf("message of the current request was not accepted: %#v", msg)
+		}
+		switch msg := msg.(type) {
+		case Chunk:
+			text.WriteString(msg.Content)
+		case Result:
+			return text.String(), msg
+		}
+		cmd = c.Wait()
+	}
+}
+
+func TestComplete(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "Hello there"})
+
+	cmd := c.Start(newRequest(llm, "Hi"))
+	if c.State() != Requesting || !c.Active() {
+		t.Fatalf("state after Start = %v", c.State())
+	}
+	text, result := drain(t, &c, cmd)
+	if text != "Hello there" || result.Err != nil || result.Cancelled {
+		t.Fatalf("text = %q, result = %+v", text, result)
+	}
+	if c.State() != Done || c.Active() || c.Wait() != nil {
+		t.Errorf("state after Result = %v", c.State())
+	}
+	if len(llm.DoGetChatHistory()) != 2 {
+		t.Errorf("history = %+v", llm.DoGetChatHistory())
+	}
+}
+
+func TestStates(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Reasoning: "hmm", Text: "ok"})
+	req := newRequest(llm, "Hi")
+	req.EnableReasoning = true
+
+	cmd := c.Start(req)
+	var states []State
+	for {
+		msg := next(t, cmd)
+		c.Accept(msg)
+		if len(states) == 0 || states[len(states)-1] != c.State() {
+			states = append(states, c.State())
+		}
+		if _, ok := msg.(Result); ok {
+			break
+		}
+		cmd = c.Wait()
+	}
+	if want := []State{Reasoning, Streaming, Done}; !slices.Equal(states, want) {
+		t.Errorf("states = %v, want %v", states, want)
+	}
+}
+
+func TestStartWhileActive(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, time.Hour, mock.Response{Text: "slow"})
+
+	cmd := c.Start(newRequest(llm, "Hi"))
+	if c.Start(newRequest(llm, "again")) != nil {
+		t.Fatal("a second request was started while the first was active")
+	}
+	c.Cancel()
+	if _, result := drain(t, &c, cmd); !result.Cancelled {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+// Controllers running side by side, one per chat, must only accept the messages of their own requests.
+func TestSideBySide(t *testing.T) {
+	var a, b Controller
+	cmdA := a.Start(newRequest(newLLM(t, 0, mock.Response{Text: "first chat"}), "Hi"))
+	cmdB := b.Start(newRequest(newLLM(t, 0, mock.Response{Text: "second chat"}), "Hi"))
+	if a.ID() == b.ID() {
+		t.Fatal("requests of different Controllers have the same ID")
+	}
+
+	msg := next(t, cmdA)
+	if b.Accept(msg) {
+		t.Fatalf("message of another Controller accepted: %#v", msg)
+	}
+	if !a.Accept(msg) {
+		t.Fatalf("first chunk not accepted: %#v", msg)
+	}
+	if text, result := drain(t, &a, a.Wait()); text != "chat" || result.Err != nil {
+		t.Fatalf("text = %q, result = %+v", text, result)
+	}
+	if text, result := drain(t, &b, cmdB); text != "second chat" || result.Err != nil {
+		t.Fatalf("text = %q, result = %+v", text, result)
+	}
+}
+
+// A cancelled request must not leak its error or chunks into the next one.
+func TestCancelThenPrompt(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "first response"}, mock.Response{Text: "second"})
+	llm.Script.ChunkDelay = mock.Duration(20 * time.Millisecond)
+
+	cmd := c.Start(newRequest(llm, "first"))
+	msg := next(t, cmd)
+	if !c.Accept(msg) {
+		t.Fatalf("first chunk not accepted: %#v", msg)
+	}
+	staleCmd := c.Wait()
+	c.Cancel()
+	if c.State() != Cancelling || !c.Active() {
+		t.Fatalf("state after Cancel = %v", c.State())
+	}
+	_, result := drain(t, &c, c.Wait())
+	if !result.Cancelled || !errors.Is(result.Err, context.Canceled) {
+		t.Fatalf("result of cancelled request = %+v", result)
+	}
+	firstID := result.ID
+
+	llm.Script.ChunkDelay = 0
+	cmd = c.Start(newRequest(llm, "second"))
+	if c.ID() == firstID {
+		t.Fatal("requests have the same ID")
+	}
+	// a command left over from the first request returns its zero Result once the request is done
+	if msg := next(t, staleCmd); c.Accept(msg) {
+		t.Fatalf("message of the cancelled request accepted: %#v", msg)
+	}
+	if c.Accept(Chunk{ID: firstID}) || c.Accept(Result{ID: firstID}) {
+		t.Fatal("message of the cancelled request accepted")
+	}
+
+	text, result := drain(t, &c, cmd)
+	if text != "second" || result.Err != nil || result.Cancelled {
+		t.Fatalf("text = %q, result = %+v", text, result)
+	}
+}
+
+// Cancelling at any point must always end with a Result, without blocking the request's goroutine.
+func TestCancelAnywhere(t *testing.T) {
+	llm := newLLM(t, 0, mock.Response{Reasoning: "thinking it over", Text: "a response of a few chunks"})
+	for i := range 30 {
+		var c Controller
+		req := newRequest(llm, "Hi")
+		req.EnableReasoning = true
+		cmd := c.Start(req)
+		for range i {
+			msg := next(t, cmd)
+			c.Accept(msg)
+			if _, ok := msg.(Result); ok {
+				break
+			}
+			cmd = c.Wait()
+		}
+		c.Cancel()
+		if c.Active() {
+			drain(t, &c, c.Wait())
+		}
+		if c.Active() {
+			t.Fatalf("still active after cancelling at message %d", i)
+		}
+	}
+}
+
+func TestContinue(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0,
+		mock.Response{Text: "cut off in the", StopReason: models.StopReasonMaxTokens},
+		mock.Response{Text: " middle"},
+	)
+	drain(t, &c, c.Start(newRequest(llm, "Hi")))
+
+	req := newRequest(llm, "")
+	req.Continue = true
+	text, result := drain(t, &c, c.Start(req))
+	if text != " middle" || result.Err != nil || !result.Continued {
+		t.Fatalf("text = %q, result = %+v", text, result)
+	}
+	history := llm.DoGetChatHistory()
+	if len(history) != 2 || history[0].Content != "Hi" || history[1].Content != "cut off in the middle" {
+		t.Errorf("history = %+v", history)
+	}
+}
+
+func TestError(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "partial response", Error: "connection reset", ErrorAfter: 1})
+
+	text, result := drain(t, &c, c.Start(newRequest(llm, "Hi")))
+	var streamErr models.StreamError
+	if !errors.As(result.Err, &streamErr) || !streamErr.Partial || result.Cancelled {
+		t.Fatalf("result = %+v", result)
+	}
+	if text != "partial " {
+		t.Errorf("text = %q", text)
+	}
+}
+
+func TestContextError(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "unreachable"})
+	llm.Script.ContextWindow = 1100 // leaves 76 tokens for the request
+	req := newRequest(llm, strings.Repeat("too long ", 200))
+	req.ContextStrategy = models.ContextStrategyRefuse
+
+	_, result := drain(t, &c, c.Start(req))
+	if models.KindOf(result.Err) != models.ErrorContextTooLong {
+		t.Fatalf("result = %+v", result)
+	}
+}
+
+// panickingLLM panics after streaming a chunk.
+type panickingLLM struct{ *mock.Model }
+
+func (llm panickingLLM) DoStreamPromptCompletion(_ context.Context, _ models.Message, _ bool, _ *uint8, responseChan chan models.StreamChunk) error {
+	defer close(responseChan)
+	responseChan <- models.StreamChunk{Content: "boom"}
+	panic("provider bug")
+}
+
+func TestPanic(t *testing.T) {
+	var c Controller
+	text, result := drain(t, &c, c.Start(newRequest(panickingLLM{newLLM(t, 0, mock.Response{})}, "Hi")))
+	if result.Err == nil || !strings.Contains(result.Err.Error(), "provider bug") {
+		t.Fatalf("result = %+v", result)
+	}
+	if text != "boom" {
+		t.Errorf("text = %q", text)
+	}
+}
+
+func TestVisionUnsupported(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "unreachable"})
+	req := newRequest(llm, "What is this?")
+	req.Prompt.Parts = []models.Part{{Kind: models.PartImage, Name: "cat.png", MediaType: "image/png", Data: []byte("\x89PNG")}}
+
+	_, result := drain(t, &c, c.Start(req))
+	if !errors.Is(result.Err, models.ErrVisionUnsupported) {
+		t.Fatalf("result = %+v", result)
+	}
+	if len(llm.DoGetChatHistory()) != 0 {
+		t.Errorf("history = %+v", llm.DoGetChatHistory())
+	}
+}
+
+func TestNoDocumentText(t *testing.T) {
+	var c Controller
+	llm := newLLM(t, 0, mock.Response{Text: "unreachable"})
+	scan := models.Part{Kind: models.PartDocument, Name: "scan.pdf", MediaType: models.DocumentMediaType, Data: []byte("%PDF"), Pages: 1}
+	req := newRequest(llm, "What does this say?")
+	req.Prompt.Parts = []models.Part{scan}
+
+	_, result := drain(t, &c, c.Start(req))
+	if !errors.Is(result.Err, models.ErrNoDocumentText) {
+		t.Fatalf("result = %+v", result)
+	}
+
+	llm.Script.Documents = true // read natively, so the missing text doesn't matter
+	req = newRequest(llm, "What does this say?")
+	req.Prompt.Parts = []models.Part{scan}
+	_, result = drain(t, &c, c.Start(req))
+	if result.Err != nil {
+		t.Fatalf("result = %+v", result)
+	}
+}
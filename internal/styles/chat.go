@@ -5,13 +5,42 @@ import "charm.land/lipgloss/v2"
 
 // ChatStylesStruct defines styles for the text in the main viewport of the application (chat history).
 type ChatStylesStruct struct {
-	PromptText lipgloss.Style
+	PromptText,
+	QueuedPromptText,
+	Attachment,
+	Hint,
+	FindMatch,
+	FindMatchSelected lipgloss.Style
 }
 
 var ChatStyles = ChatStylesStruct{
 	PromptText: lipgloss.NewStyle().
 		Foreground(lipgloss.Color("#32cd32")), // green
 
+	// prompts waiting for the current response to complete
+	QueuedPromptText: lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#32cd32")).
+		Faint(true),
+
+	// names of the files attached to a prompt
+	Attachment: lipgloss.NewStyle().
+		Foreground(lipgloss.Color("#32cd32")).
+		Faint(true),
+
+	// keybinds for the queue and the last response
+	Hint: lipgloss.NewStyle().
+		Faint(true).
+		PaddingLeft(H_PADDING * 2), // aligned with responses
+
+	// text found with ctrl+s, and the match that was jumped to
+	FindMatch: lipgloss.NewStyle().
+		Reverse(true),
+
+	FindMatchSelected: lipgloss.NewStyle().
+		Foreground(lipgloss.Color("0")).
+		Background(ColorPrimary).
+		Bold(true),
+
 	// TODO: have reasoning use its own markdown renderer?
 	// ReasoningText: lipgloss.NewStyle().
 	// Foreground(lipgloss.Color("#a9a9a9")).
@@ -12,6 +12,10 @@ const (
 	TEXTAREA_HEIGHT_COLLAPSED int = 1
 	TEXTAREA_HEIGHT_NORMAL    int = 3
 
+	// the sidebar of open and recent sessions, which is hidden while the chat would be narrower than SIDEBAR_MIN_CHAT_WIDTH
+	SIDEBAR_WIDTH          int = 28
+	SIDEBAR_MIN_CHAT_WIDTH int = 50
+
 	// spacing between the main viewport and the textarea.
 	VP_TA_SPACING      string = "\n"
 	VP_TA_SPACING_SIZE int    = len(VP_TA_SPACING)
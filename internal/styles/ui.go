@@ -7,6 +7,13 @@ type TUIStylesStruct struct {
 	TitleBar,
 	PromptText,
 	Spinner,
+	AttachmentChip,
+	Completer,
+	CompleterSelected,
+	SearchMatch,
+	Sidebar,
+	SidebarHeading,
+	SidebarBadge,
 	TextAreaCursor lipgloss.Style
 }
 
@@ -29,5 +36,41 @@ var TUIStyles = TUIStylesStruct{
 	Spinner: lipgloss.NewStyle().
 		Foreground(ColorPrimary),
 
+	// files attached to the prompt being typed
+	AttachmentChip: lipgloss.NewStyle().
+		Foreground(ColorSecondary).
+		Faint(true).
+		PaddingLeft(2), // aligned with the text of the textarea
+
+	// file path completions for an @ mention
+	Completer: lipgloss.NewStyle().
+		Faint(true),
+
+	CompleterSelected: lipgloss.NewStyle().
+		Foreground(ColorPrimary).
+		Bold(true),
+
+	// the query's terms in the snippets of /search results
+	SearchMatch: lipgloss.NewStyle().
+		Foreground(ColorPrimary).
+		Underline(true),
+
+	// the sidebar of open and recent sessions, see Sidebar*
+	Sidebar: lipgloss.NewStyle().
+		BorderStyle(lipgloss.NormalBorder()).
+		BorderRight(true).
+		BorderForeground(lipgloss.Color("240")). // gray
+		Padding(0, H_PADDING),
+
+	SidebarHeading: lipgloss.NewStyle().
+		Foreground(ColorPrimary).
+		Faint(true).
+		Bold(true),
+
+	// marks conversations that are streaming, or whose response completed in the background
+	SidebarBadge: lipgloss.NewStyle().
+		Foreground(ColorSecondary).
+		Bold(true),
+
 	TextAreaCursor: lipgloss.NewStyle(),
 }
@@ -3,20 +3,25 @@ package internal
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
+	"time"
 
 	"charm.land/bubbles/v2/spinner"
 	"charm.land/bubbles/v2/textarea"
 	"charm.land/bubbles/v2/viewport"
 	tea "charm.land/bubbletea/v2"
 	"charm.land/lipgloss/v2"
-	"github.com/atotto/clipboard"
+	"github.com/gregriff/ducky/internal/attachments"
 	"github.com/gregriff/ducky/internal/chat"
 	"github.com/gregriff/ducky/internal/math"
 	"github.com/gregriff/ducky/internal/models"
 	"github.com/gregriff/ducky/internal/models/anthropic"
+	"github.com/gregriff/ducky/internal/models/mock"
 	"github.com/gregriff/ducky/internal/models/openai"
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/gregriff/ducky/internal/stream"
 	styles "github.com/gregriff/ducky/internal/styles"
 	zone "github.com/lrstanley/bubblezone/v2"
 	"github.com/muesli/reflow/wordwrap"
@@ -24,13 +29,16 @@ import (
 
 // model defines the TUI application state.
 type model struct {
-	// user args TODO: combine these into a PromptContext struct (and add a context._), along with isStreaming + isReasoning?
-	llm             models.LLM
+	// user args TODO: combine these into a PromptContext struct
+	modelName       string
 	systemPrompt    string
 	maxTokens       int
 	enableReasoning bool
 	reasoningEffort *uint8
 	initialPrompt   string // if stdin is a pipe and --force-interactive is used
+	contextStrategy models.ContextStrategy
+	summarizer      models.LLM // condenses old history if contextStrategy is summarize
+	retryPolicy     models.RetryPolicy
 
 	// UI state
 	ready      bool
@@ -39,32 +47,103 @@ type model struct {
 	spinner    spinner.Model
 	windowSize tea.WindowSizeMsg
 
-	// Chat state
-	chat *chat.Model
-	isStreaming,
-	isReasoning bool
-	responseChan chan models.StreamChunk
-
-	preventScrollToBottom bool
+	// Chat state: the conversation shown, and the others open in the sidebar, see conversation.go and sidebar.go
+	*conversation
+	conversations []*conversation
+	glamourStyle  string // of each conversation's chat.Model
+	showSidebar   bool
+	recent        []*session.Session // the most recently updated saved sessions, listed in the sidebar
 
 	// rendering
 	contentBuilder,
 	headerBuilder strings.Builder
 	lastWidth          int
+	lastPosition       string // the entry being read when the header was rendered, see jump.go
 	forceHeaderRefresh bool
 
-	streamContext context.Context
-	stopStreaming context.CancelFunc
+	// files attached to the prompt being typed, see attach.go and mentions.go
+	workDir         string // where mentioned files are looked up
+	attachments     []attachments.File
+	attachError     string            // shown with the attachments when a file couldn't be attached
+	completer       *mentionCompleter // open while an @ mention is typed
+	clipboardImages int               // images pasted so far, to name the next one
+
+	pastes []pastedText // large pastes collapsed into placeholders in the textarea, see paste.go
+	notice string       // shown with the attachments until the prompt is next edited, e.g. the result of a command
+	search *searchView  // the results of /search, see search.go
+	find   *findBar     // finding text in the chat with ctrl+s, see find.go
+
+	sessionStore *session.Store // where each conversation's session is saved, if set, see sessions.go
+
+	// live token count of the prompt being typed
+	tokenCountID int // incremented on each edit, so that stale counts are discarded
+	tokenCount   *models.TokenCount
 }
 
 // Bubbletea messages.
 type (
 	makeInitialPrompt struct{}
-	streamComplete    struct{}
+
+	// retryCountdownTick updates the retry countdown in the header every second.
+	retryCountdownTick struct{}
+
+	// tokenCountDue is sent once the user has stopped typing for tokenCountDebounce.
+	tokenCountDue    struct{ id int }
+	tokenCountResult struct {
+		id    int
+		count models.TokenCount
+		err   error
+	}
+)
+
+const (
+	// how long to wait after the last edit of the prompt before counting its tokens.
+	tokenCountDebounce = 400 * time.Millisecond
+	tokenCountTimeout  = 5 * time.Second
 )
 
+// Option configures optional behavior of the TUI application.
+type Option func(*model)
+
+// WithContextStrategy sets how the chat history is shrunk when it outgrows the model's context window. summarizer is the
+// model used by models.ContextStrategySummarize, and may be nil for other strategies.
+func WithContextStrategy(strategy models.ContextStrategy, summarizer models.LLM) Option {
+	return func(m *model) {
+		m.contextStrategy = strategy
+		m.summarizer = summarizer
+	}
+}
+
+// WithRetryPolicy sets how prompts that fail with transient API errors are retried.
+func WithRetryPolicy(policy models.RetryPolicy) Option {
+	return func(m *model) {
+		m.retryPolicy = policy
+	}
+}
+
+// WithSessionStore saves each conversation to store as it progresses.
+func WithSessionStore(store *session.Store) Option {
+	return func(m *model) {
+		m.sessionStore = store
+	}
+}
+
+// WithSession resumes a saved session, showing its history and continuing the conversation with the model.
+func WithSession(s *session.Session) Option {
+	return func(m *model) {
+		m.resumeSession(s)
+	}
+}
+
+// WithSidebar shows the sidebar of open and recent sessions from the start. It can be toggled with alt+s.
+func WithSidebar() Option {
+	return func(m *model) {
+		m.showSidebar = true
+	}
+}
+
 // NewTUI creates the TUI application with default state.
-func NewTUI(systemPrompt string, modelName string, enableReasoning bool, reasoningEffort *uint8, maxTokens int, glamourStyle string) *model {
+func NewTUI(systemPrompt string, modelName string, enableReasoning bool, reasoningEffort *uint8, maxTokens int, glamourStyle string, opts ...Option) (*model, error) {
 	// create and style textarea
 	ta := textarea.New()
 	ta.ShowLineNumbers = false
@@ -88,19 +167,29 @@ func NewTUI(systemPrompt string, modelName string, enableReasoning bool, reasoni
 
 	t := &model{
 		systemPrompt:    systemPrompt,
+		modelName:       modelName,
 		maxTokens:       maxTokens,
 		enableReasoning: enableReasoning,
 		reasoningEffort: reasoningEffort,
+		contextStrategy: models.ContextStrategyTruncate,
+		retryPolicy:     models.DefaultRetryPolicy,
+		workDir:         ".",
 
-		textarea: ta,
-		spinner:  s,
-
-		chat:         chat.NewChatModel(glamourStyle),
-		responseChan: make(chan models.StreamChunk),
+		textarea:     ta,
+		spinner:      s,
+		glamourStyle: glamourStyle,
 	}
 
-	t.llm = InitLLMClient(modelName, systemPrompt, maxTokens)
-	return t
+	c, err := t.newConversation()
+	if err != nil {
+		return nil, err
+	}
+	t.conversation = c
+	t.conversations = []*conversation{t.conversation}
+	for _, opt := range opts {
+		opt(t)
+	}
+	return t, nil
 }
 
 // Start begins the TUI application.
@@ -114,7 +203,7 @@ func (m *model) Start(initialPrompt string) {
 
 // Init performs initial IO.
 func (m *model) Init() tea.Cmd {
-	cmds := []tea.Cmd{m.textarea.Focus()}
+	cmds := []tea.Cmd{m.textarea.Focus(), m.loadRecent()}
 
 	if len(m.initialPrompt) > 0 {
 		cmds = append(cmds, func() tea.Msg {
@@ -134,12 +223,58 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	switch msg := msg.(type) {
 	case tea.KeyPressMsg:
 		keyString := msg.String()
+		if handled, cmd := m.handleFindKey(msg); handled {
+			return m, cmd
+		}
+		if handled, cmd := m.handleSearchKey(keyString); handled {
+			return m, cmd
+		}
+		if handled, cmd := m.handleCompleterKey(keyString); handled {
+			return m, cmd
+		}
 
 		switch keyString {
 		case "ctrl+d":
 			return m, tea.Quit
 		case "ctrl+c":
 			return m.handleCtrlC()
+		case "ctrl+x":
+			return m.removeQueuedPrompt()
+		case "ctrl+o":
+			return m.editQueuedPrompt()
+		case "ctrl+g":
+			return m.continueResponse()
+		case "ctrl+v":
+			return m, m.pasteImage()
+		case "alt+up":
+			m.jumpToPrompt(false)
+			return m, nil
+		case "alt+down":
+			m.jumpToPrompt(true)
+			return m, nil
+		case "alt+r":
+			m.jumpToResponse()
+			return m, nil
+		case "alt+g":
+			m.viewport.GotoTop()
+			return m, nil
+		case "alt+G", "alt+shift+g":
+			m.viewport.GotoBottom()
+			return m, nil
+		case "alt+s":
+			return m, m.toggleSidebar()
+		case "alt+n":
+			return m, m.newChat()
+		case "alt+w":
+			return m, m.closeChat()
+		case "alt+1", "alt+2", "alt+3", "alt+4", "alt+5", "alt+6", "alt+7", "alt+8", "alt+9":
+			return m, m.selectSidebarItem(int(keyString[len(keyString)-1] - '1'))
+		case "ctrl+s": // ctrl+f is the textarea's cursor key
+			return m, m.openFind()
+		case "/":
+			if !m.textarea.Focused() { // like less, while reading the chat
+				return m, m.openFind()
+			}
 		case "esc":
 			return m.handleEscape()
 		case "up", "down":
@@ -150,30 +285,21 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 			return m.triggerScrollback(msg)
 		}
 
-		// while streaming, anything below this will not be accessible
-		if m.isStreaming {
-			break
-		}
-
 		switch keyString {
 		case "enter":
 			return m.handleEnter()
+		case "backspace":
+			if m.textarea.Focused() && m.textarea.Length() == 0 && len(m.attachments) > 0 {
+				m.removeAttachment()
+				return m, m.scheduleTokenCount()
+			}
 		}
 	case tea.PasteMsg:
-		if m.isStreaming { // don't allow paste while streaming
-			return m, nil
+		if m.dropFiles(msg.Content) {
+			return m, m.scheduleTokenCount()
 		}
-		// here we grab the paste message before textarea gets it, in order to increase the height of the textarea if
-		// the pasted text has many lines
-		content, _ := clipboard.ReadAll()
-		wrappedLineCount := m.getNumLines(content)
-		if wrappedLineCount > m.textarea.Height() {
-			newHeight := math.Clamp(wrappedLineCount, styles.TEXTAREA_HEIGHT_NORMAL, m.textarea.MaxHeight)
-			windowHeight, windowWidth := m.windowSize.Height, m.windowSize.Width
-			viewportHeight, textAreaWidth := m.getResizeParams(windowHeight, windowWidth, &newHeight)
-
-			m.textarea.SetHeight(newHeight) // this func clamps
-			m.resizeComponents(windowWidth, textAreaWidth, viewportHeight)
+		if m.textarea.Focused() {
+			return m.paste(msg.Content)
 		}
 	case tea.MouseMsg:
 		var (
@@ -186,7 +312,13 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		switch msg := msg.(type) {
 		case tea.MouseClickMsg:
 			// TODO: add right-click functionality
-			if m.isStreaming || msg.Button != tea.MouseLeft {
+			if msg.Button != tea.MouseLeft {
+				return m, nil
+			}
+			if i, ok := m.sidebarItemAt(msg); ok {
+				return m, m.selectSidebarItem(i)
+			}
+			if m.stream.Active() {
 				return m, nil
 			}
 
@@ -212,7 +344,7 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 				// if time.Since(m.lastManualGoToBottom) < 800*time.Millisecond {
 				// return m, nil
 				// }
-				if m.isStreaming { // allow user to scroll up during streaming and keep their position
+				if m.stream.Active() { // allow user to scroll up during streaming and keep their position
 					m.preventScrollToBottom = true
 				}
 				triggerScroll, scrollKey = true, tea.KeyPressMsg{Code: tea.KeyUp}
@@ -250,39 +382,83 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case tea.FocusMsg:
 		return m, m.textarea.Focus()
 
+	case clipboardImage:
+		if msg.text != "" {
+			return m.Update(tea.PasteMsg{Content: msg.text})
+		}
+		return m, m.attachClipboardImage(msg)
+
+	case sessionSaved:
+		if msg.err != nil {
+			m.setNotice(msg.err.Error())
+		}
+		return m, m.loadRecent()
+
+	case recentSessions:
+		m.showRecent(msg)
+		return m, nil
+
+	case searchResults:
+		m.showSearchResults(msg)
+		return m, nil
+
+	case sessionExported:
+		if msg.err != nil {
+			m.setNotice(msg.err.Error())
+		} else {
+			m.setNotice("exported to " + msg.path)
+		}
+		return m, nil
+
 	case makeInitialPrompt:
-		return m.promptLLM(m.initialPrompt)
+		return m.promptLLM(m.initialPrompt, nil)
 
-	case models.StreamChunk:
-		m.isReasoning = msg.Reasoning
-		m.chat.AccumulateStream(msg.Content, msg.Reasoning, false)
+	case stream.Chunk:
+		if !m.stream.Accept(msg) {
+			return m, m.updateInBackground(msg) // from another conversation, or a request that was replaced
+		}
+		if m.stream.State() != stream.Cancelling {
+			m.chat.AccumulateStream(msg.Content, msg.Reasoning, false)
+			m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+			if !m.preventScrollToBottom {
+				m.viewport.GotoBottom()
+			}
+		}
+		return m, tea.Batch(m.stream.Wait(), m.clearRetryStatus())
 
-		m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
-		if !m.preventScrollToBottom {
-			m.viewport.GotoBottom()
+	case stream.Retry:
+		if !m.stream.Accept(msg) {
+			return m, m.updateInBackground(msg)
 		}
-		return m, m.waitForNextChunk
+		m.retryStatus = &msg.RetryStatus
+		return m, tea.Batch(m.stream.Wait(), m.tickRetryCountdown(), m.redraw()) // the header may wrap onto a second line
 
 	// TODO: include usage data by having DoStreamPromptCompletion return this with fields?
-	case streamComplete: // responseChan guaranteed to be empty here
-		return m.handleStreamComplete()
-
-	case models.StreamError:
-		var errMsg string
+	case stream.Result:
+		if !m.stream.Accept(msg) {
+			return m, m.updateInBackground(msg)
+		}
+		return m.handleStreamComplete(msg)
 
-		// if stream has not been canceled and we indeed have an error
-		if m.streamContext.Err() == nil {
-			errMsg = fmt.Sprintf("**Error:** %v", msg.ErrMsg)
-		} else {
-			// TODO: after 1 cancel, any subsequent error will probably show this message. reset the Context
-			errMsg = ">Stream Cancelled"
+	case retryCountdownTick:
+		if m.retryStatus == nil || !m.stream.Active() {
+			return m, nil
 		}
+		return m, m.tickRetryCountdown()
 
-		m.chat.AccumulateStream(errMsg, false, true)
-		return m, m.waitForNextChunk // ensure last chunk is read and let chunk and complete messages handle state
+	case tokenCountDue:
+		return m, m.countTokens(msg.id)
+
+	case tokenCountResult:
+		if msg.id != m.tokenCountID || msg.err != nil {
+			return m, nil
+		}
+		m.tokenCount = &msg.count
+		m.forceHeaderRefresh = true
+		return m, nil
 
 	case spinner.TickMsg:
-		if m.isStreaming {
+		if m.stream.Active() {
 			m.spinner, spCmd = m.spinner.Update(msg)
 		}
 		return m, spCmd
@@ -312,9 +488,13 @@ func (m *model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
-// redraw initiates the Window resize handler. Use it after changing the dimensions of a component to make the others update.
-func (m *model) redraw() tea.Msg {
-	return m.windowSize
+// redraw returns a command that initiates the Window resize handler. Use it after changing the dimensions of a component to
+// make the others update. The size is read now, since commands run outside of the Update loop.
+func (m *model) redraw() tea.Cmd {
+	size := m.windowSize
+	return func() tea.Msg {
+		return size
+	}
 }
 
 // resizeComponents sets size properties on the viewport and textarea
@@ -328,6 +508,15 @@ func (m *model) resizeComponents(windowWidth, textAreaWidth, viewportHeight int)
 	m.viewport.SetContent(m.chat.Render(windowWidth))
 }
 
+// resizeTextarea sets the height of the textarea, resizing the viewport to fit first to prevent visual glitching.
+func (m *model) resizeTextarea(height int) {
+	windowHeight, windowWidth := m.windowSize.Height, m.chatWidth(m.windowSize.Width)
+	viewportHeight, textAreaWidth := m.getResizeParams(windowHeight, windowWidth, &height)
+
+	m.textarea.SetHeight(height) // this func clamps
+	m.resizeComponents(windowWidth, textAreaWidth, viewportHeight)
+}
+
 // getResizeParams returns size dimensions of on-screen components needed during redrawing or resizing.
 func (m *model) getResizeParams(windowHeight, windowWidth int, taHeight *int) (viewportHeight int, textAreaWidth int) {
 	var textAreaHeight int
@@ -338,7 +527,7 @@ func (m *model) getResizeParams(windowHeight, windowWidth int, taHeight *int) (v
 	}
 
 	headerHeight := lipgloss.Height(m.headerView(m.viewport.Width()))
-	verticalMarginHeight := headerHeight + textAreaHeight + styles.VP_TA_SPACING_SIZE
+	verticalMarginHeight := headerHeight + textAreaHeight + styles.VP_TA_SPACING_SIZE + m.accessoryHeight()
 
 	viewportHeight = windowHeight - verticalMarginHeight
 	textAreaWidth = windowWidth - styles.H_PADDING
@@ -347,7 +536,7 @@ func (m *model) getResizeParams(windowHeight, windowWidth int, taHeight *int) (v
 
 func (m *model) handleWindowResize(msg tea.WindowSizeMsg) (tea.Model, tea.Cmd) {
 	m.windowSize = msg
-	windowHeight, windowWidth := msg.Height, msg.Width
+	windowHeight, windowWidth := msg.Height, m.chatWidth(msg.Width)
 	viewportHeight, textAreaWidth := m.getResizeParams(windowHeight, windowWidth, nil)
 
 	var taCmd, vpCmd tea.Cmd
@@ -382,61 +571,90 @@ func (m *model) getNumLines(text string) int {
 	return len(lines)
 }
 
-// promptLLM makes the LLM API request, handles TUI state and begins listening for the response stream.
-func (m *model) promptLLM(prompt string) (tea.Model, tea.Cmd) {
-	m.responseChan = make(chan models.StreamChunk)
-	m.isStreaming = true
-	if m.enableReasoning && m.llm.DoesSupportReasoning() {
-		m.isReasoning = true
+// promptLLM makes the LLM API request, handles TUI state and begins listening for the response stream. The attached files are
+// sent before the prompt.
+func (m *model) promptLLM(prompt string, files []attachments.File) (tea.Model, tea.Cmd) {
+	waitCmd := m.startRequest(prompt, files)
+	if waitCmd == nil {
+		return m, nil // a request is still active
 	}
 
-	if m.textarea.Focused() {
-		m.textarea.Blur()
+	if m.textarea.Length() == 0 { // the user may be typing the next prompt if this one was queued
+		if m.ready {
+			m.resizeTextarea(styles.TEXTAREA_HEIGHT_COLLAPSED)
+		} else {
+			m.textarea.SetHeight(styles.TEXTAREA_HEIGHT_COLLAPSED) // sized along with the viewport once the window size is known
+		}
 	}
-
-	m.chat.AddPrompt(prompt)
 	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
 	m.viewport.GotoBottom()
-	m.textarea.SetHeight(styles.TEXTAREA_HEIGHT_COLLAPSED)
 
-	m.streamContext, m.stopStreaming = context.WithCancel(context.Background())
-	beginStreaming := func() tea.Msg {
-		return models.StreamPromptCompletion(m.streamContext, m.llm, prompt, m.enableReasoning, m.reasoningEffort, m.responseChan)
-	}
+	return m, tea.Batch(m.spinner.Tick, waitCmd)
+}
 
-	return m, tea.Batch(
-		m.spinner.Tick,
-		m.redraw, // recalculate view because we've changed the textarea height
-		beginStreaming,
-		m.waitForNextChunk,
-	)
+// startRequest sends the prompt and the files attached to it to the model, and adds them to the chat history and the session.
+// It returns the command that waits for the response, or nil if a request is still active.
+func (m *model) startRequest(prompt string, files []attachments.File) tea.Cmd {
+	waitCmd := m.stream.Start(m.newRequest(attachments.Message(prompt, files)))
+	if waitCmd == nil {
+		return nil
+	}
+	m.chat.AddPrompt(prompt, files)
+	m.recordPrompt(prompt, files)
+	return waitCmd
 }
 
-// waitForNextChunk notifies the Update function when a response chunk arrives, and also when the response is completed.
-func (m *model) waitForNextChunk() tea.Msg {
-	select {
-	case <-m.streamContext.Done():
-		return models.StreamError{ErrMsg: m.streamContext.Err().Error()}
-	case chunk, ok := <-m.responseChan:
-		if ok {
-			return chunk
-		}
-		return streamComplete{}
+// continueResponse asks the model to resume the last response, which was cut off by the max-tokens limit. The continuation
+// is streamed onto the end of the response.
+func (m *model) continueResponse() (tea.Model, tea.Cmd) {
+	if m.stream.Active() || !m.chat.CanContinue() {
+		return m, nil
+	}
+	req := m.newRequest(models.Message{})
+	req.Continue = true
+	waitCmd := m.stream.Start(req)
 
+	m.chat.ContinueResponse()
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	m.viewport.GotoBottom()
+	return m, tea.Batch(m.spinner.Tick, waitCmd)
+}
+
+// newRequest returns a request for the prompt with the user's settings.
+func (m *model) newRequest(prompt models.Message) stream.Request {
+	return stream.Request{
+		LLM:             m.llm,
+		Prompt:          prompt,
+		EnableReasoning: m.enableReasoning,
+		ReasoningEffort: m.reasoningEffort,
+		ContextStrategy: m.contextStrategy,
+		Summarizer:      m.summarizer,
+		RetryPolicy:     m.retryPolicy,
 	}
 }
 
-// handleStreamComplete updates TUI state when a LLM response has been fully received.
-func (m *model) handleStreamComplete() (tea.Model, tea.Cmd) {
-	// if a StreamError occurs before response streaming begins, two waitForNextChunks will return streamComplete
-	if !m.isStreaming {
-		return m, nil
+// tickRetryCountdown schedules the next update of the retry countdown shown in the header.
+func (m *model) tickRetryCountdown() tea.Cmd {
+	return tea.Tick(time.Second, func(time.Time) tea.Msg {
+		return retryCountdownTick{}
+	})
+}
+
+// clearRetryStatus removes the retry countdown from the header once the request is retried, returning the command that
+// resizes the viewport to the header's new height.
+func (m *model) clearRetryStatus() tea.Cmd {
+	if m.retryStatus == nil {
+		return nil
 	}
-	m.isStreaming = false
-	m.isReasoning = false
-	m.forceHeaderRefresh = true
+	m.retryStatus = nil
+	return m.redraw()
+}
 
-	m.chat.AddResponse()
+// handleStreamComplete updates TUI state when a LLM request has returned, rendering its error if it failed or was cancelled.
+func (m *model) handleStreamComplete(result stream.Result) (tea.Model, tea.Cmd) {
+	redrawCmd := m.clearRetryStatus()
+	saveCmd := m.finishResponse(result)
+	m.forceHeaderRefresh = true
 	curLineCount := m.viewport.TotalLineCount()
 
 	// prepends the chat history to the screen
@@ -453,13 +671,57 @@ func (m *model) handleStreamComplete() (tea.Model, tea.Cmd) {
 		m.viewport.SetYOffset(newLineCount - curLineCount + yOffset)
 	}
 	m.preventScrollToBottom = false
+	cmds := []tea.Cmd{redrawCmd, saveCmd}
+	if m.textarea.Length() > 0 { // the user typed during streaming, so the count is out of date
+		cmds = append(cmds, m.scheduleTokenCount())
+	}
 	if !m.textarea.Focused() {
 		// TODO: should check here that terminal has focus,
 		// (user has changed windows since stream began)
 		// otherwise Blink{} messages will continue to loop
-		return m, m.textarea.Focus()
+		cmds = append(cmds, m.textarea.Focus())
 	}
-	return m, nil
+	if result.Err == nil && !result.Cancelled {
+		if queued, files, ok := m.chat.Dequeue(); ok {
+			_, promptCmd := m.promptLLM(queued, files)
+			cmds = append(cmds, promptCmd)
+		}
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// finishResponse adds the response that just completed to the chat history and the session, with its error if it failed or
+// was cancelled, and returns the command that saves the session.
+func (m *model) finishResponse(result stream.Result) tea.Cmd {
+	var streamErr models.StreamError
+	partial := errors.As(result.Err, &streamErr) && streamErr.Partial
+
+	var stopReason models.StopReason
+	switch {
+	case result.Err == nil:
+		stopReason = m.llm.DoGetLastResponseInfo().StopReason
+	case result.Continued && !partial:
+		stopReason = models.StopReasonMaxTokens // nothing was added, so it can be continued again
+	}
+
+	switch {
+	case result.Cancelled:
+		m.chat.AccumulateStream(chat.FormatError(context.Canceled), false, true)
+	case result.Err != nil:
+		errMsg := chat.FormatError(result.Err)
+		if partial {
+			errMsg += "\n\n*The response above is incomplete.*"
+		}
+		if m.chat.QueueLen() > 0 {
+			errMsg += "\n\n*Queued prompts were not sent. Press enter to send the next one.*"
+		}
+		m.chat.AccumulateStream(errMsg, false, true)
+	}
+	m.contextUsage = models.ContextUsage(m.llm)
+	m.chatCost = models.GetCostOfCurrentChat(m.llm)
+
+	m.chat.AddResponse(stopReason)
+	return m.recordResponse(result, stopReason)
 }
 
 func (m *model) handleEscape() (tea.Model, tea.Cmd) {
@@ -469,10 +731,10 @@ func (m *model) handleEscape() (tea.Model, tea.Cmd) {
 		if m.textarea.Length() > 0 && m.chat.HistoryLen() > 0 {
 			m.textarea.Blur()
 			// TODO: if height is > normal, set height to normal
-			return m, m.redraw
+			return m, m.redraw()
 		}
-	} else if !m.isStreaming {
-		return m, tea.Batch(m.textarea.Focus(), m.redraw)
+	} else {
+		return m, tea.Batch(m.textarea.Focus(), m.redraw())
 		// if numLines > curHeight:
 		// 		if numLines > normal, set height to min(numLines, maxHeight)
 		// 		else set height to normal
@@ -481,18 +743,17 @@ func (m *model) handleEscape() (tea.Model, tea.Cmd) {
 }
 
 func (m *model) handleCtrlC() (tea.Model, tea.Cmd) {
-	if m.isStreaming {
-		m.stopStreaming()
+	if m.stream.Active() {
+		m.stream.Cancel()
+		m.chat.ClearQueue() // the user wants to stop, not to move on to the next prompt
+		m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
 		return m, nil
 	}
 
 	if m.chat.HistoryLen() == 0 {
 		return m, tea.Quit
 	}
-	m.chat.Clear() // print something
-	m.llm.DoClearChatHistory()
-	m.forceHeaderRefresh = true
-	m.chat.Scrollback.Reset()
+	m.clearChat()
 	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
 	if !m.textarea.Focused() {
 		return m, m.textarea.Focus()
@@ -500,17 +761,95 @@ func (m *model) handleCtrlC() (tea.Model, tea.Cmd) {
 	return m, nil
 }
 
+// clearChat forgets the chat history, so that the next prompt starts a new session.
+func (m *model) clearChat() {
+	m.closeFind()
+	m.chat.Clear() // print something
+	m.llm.DoClearChatHistory()
+	m.session = nil
+	m.forceHeaderRefresh = true
+	m.contextUsage = 0
+	m.chatCost = ""
+	m.chat.Scrollback.Reset()
+}
+
 func (m *model) handleEnter() (tea.Model, tea.Cmd) {
-	input := strings.TrimSpace(m.textarea.Value())
+	input := strings.TrimSpace(m.expandPastes(m.textarea.Value()))
+	files := m.attachments
 	m.textarea.Reset()
+	m.pastes = nil
+	m.clearAttachments()
 	m.chat.Scrollback.Reset()
+	countCmd := m.scheduleTokenCount()
+
+	_, cmd := m.send(input, files)
+	return m, tea.Batch(countCmd, cmd)
+}
 
-	if input == "" {
+// send runs a command, queues a prompt while a response streams, or prompts the LLM with the input taken from the textarea.
+func (m *model) send(input string, files []attachments.File) (tea.Model, tea.Cmd) {
+	if len(files) == 0 {
+		if ok, cmd := m.runCommand(input); ok {
+			return m, cmd
+		}
+	}
+
+	if m.stream.Active() {
+		if input == "" && len(files) == 0 {
+			return m, nil
+		}
+		m.chat.Enqueue(input, files)
+		m.resizeTextarea(styles.TEXTAREA_HEIGHT_COLLAPSED)
+		m.refreshQueue()
+		return m, nil
+	}
+
+	if input == "" && len(files) == 0 {
+		// prompts stay queued after a request fails, until the user sends them
+		if queued, queuedFiles, ok := m.chat.Dequeue(); ok {
+			return m.promptLLM(queued, queuedFiles)
+		}
 		return m, nil
 	}
 
 	// Start LLM streaming
-	return m.promptLLM(input)
+	return m.promptLLM(input, files)
+}
+
+// removeQueuedPrompt discards the most recently queued prompt.
+func (m *model) removeQueuedPrompt() (tea.Model, tea.Cmd) {
+	if _, _, ok := m.chat.Unqueue(); ok {
+		m.refreshQueue()
+	}
+	return m, nil
+}
+
+// editQueuedPrompt moves the most recently queued prompt and its attachments back into the textarea, if the user isn't typing
+// another one.
+func (m *model) editQueuedPrompt() (tea.Model, tea.Cmd) {
+	if m.textarea.Length() > 0 || len(m.attachments) > 0 {
+		return m, nil
+	}
+	prompt, files, ok := m.chat.Unqueue()
+	if !ok {
+		return m, nil
+	}
+	m.refreshQueue()
+	m.setPrompt(prompt)
+	m.attachments = files
+	cmds := []tea.Cmd{m.scheduleTokenCount(), m.redraw()}
+	if !m.textarea.Focused() {
+		cmds = append(cmds, m.textarea.Focus())
+	}
+	return m, tea.Batch(cmds...)
+}
+
+// refreshQueue re-renders the viewport after the queued prompts have changed.
+func (m *model) refreshQueue() {
+	m.viewport.SetContent(m.chat.Render(m.viewport.Width()))
+	if !m.preventScrollToBottom {
+		m.viewport.GotoBottom()
+	}
 }
 
 // allowScrollback checks the cursor position in the textarea and returns whether triggering a scrollback action can take place.
@@ -570,22 +909,57 @@ func (m *model) updateTextarea(msg tea.Msg) (tea.Model, tea.Cmd) {
 		newHeight = collapsed
 	}
 
-	// set height of textarea, updating viewport first to prevent visual glitching
 	if newHeight != 0 {
-		windowHeight, windowWidth := m.windowSize.Height, m.windowSize.Width
-		viewportHeight, textAreaWidth := m.getResizeParams(windowHeight, windowWidth, &newHeight)
-
-		m.textarea.SetHeight(newHeight)
-		m.resizeComponents(windowWidth, textAreaWidth, viewportHeight)
+		m.resizeTextarea(newHeight)
 	}
 
 	// This runs when the textarea is focused and not being resized.
 	// NOTE: this prevents messages from reaching the viewport, which may not be desirable
 	// ensure we aren't returning nil above these lines and therefore blocking messages to these models
+	prevValue := m.textarea.Value()
 	m.textarea, taCmd = m.textarea.Update(msg)
+	if m.textarea.Value() != prevValue {
+		prevHeight := m.accessoryHeight()
+		m.notice, m.search = "", nil
+		m.updateMention()
+		m.fitAccessories(prevHeight)
+		return m, tea.Batch(taCmd, m.scheduleTokenCount())
+	}
 	return m, taCmd
 }
 
+// scheduleTokenCount debounces counting the tokens of the prompt being typed. It should be called whenever the textarea's
+// value changes.
+func (m *model) scheduleTokenCount() tea.Cmd {
+	m.tokenCountID++
+	if m.textarea.Length() == 0 && len(m.attachments) == 0 {
+		m.tokenCount = nil
+		m.forceHeaderRefresh = true
+		return nil
+	}
+	id := m.tokenCountID
+	return tea.Tick(tokenCountDebounce, func(time.Time) tea.Msg {
+		return tokenCountDue{id: id}
+	})
+}
+
+// countTokens counts the tokens of the prompt being typed in the background. The system prompt and history are copied first,
+// so that counting doesn't race with requests or commands that change them. Counting is skipped while a response streams,
+// since the history is about to change.
+func (m *model) countTokens(id int) tea.Cmd {
+	if id != m.tokenCountID || m.stream.Active() {
+		return nil
+	}
+	prompt := attachments.Message(strings.TrimSpace(m.expandPastes(m.textarea.Value())), m.attachments)
+	counter := models.SnapshotTokenCounter(m.llm)
+	return func() tea.Msg {
+		ctx, cancel := context.WithTimeout(context.Background(), tokenCountTimeout)
+		defer cancel()
+		count, err := counter(ctx, prompt)
+		return tokenCountResult{id: id, count: count, err: err}
+	}
+}
+
 // triggerScrollback makes the textarea go forward or backward in history to display a different prompt.
 func (m *model) triggerScrollback(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 	var (
@@ -594,7 +968,7 @@ func (m *model) triggerScrollback(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 		taCmd           tea.Cmd
 	)
 
-	curPrompt := strings.TrimSpace(m.textarea.Value())
+	curPrompt := strings.TrimSpace(m.expandPastes(m.textarea.Value()))
 	if msg.String() == "up" {
 		retrievedPrompt, exists = m.chat.Scrollback.PrevPrompt(curPrompt)
 	} else {
@@ -606,9 +980,9 @@ func (m *model) triggerScrollback(msg tea.KeyPressMsg) (tea.Model, tea.Cmd) {
 		return m, nil
 	}
 
-	m.textarea.SetValue(retrievedPrompt)
+	m.setPrompt(retrievedPrompt)
 	m.textarea, taCmd = m.textarea.Update(msg)
-	return m, taCmd
+	return m, tea.Batch(taCmd, m.scheduleTokenCount())
 }
 
 // View renders the TUI into a string.
@@ -624,15 +998,22 @@ func (m *model) View() tea.View {
 		return v
 	}
 
+	promptInput := styles.VP_TA_SPACING
+	if accessories := m.accessoryView(m.viewport.Width()); accessories != "" {
+		promptInput += accessories + "\n"
+	}
+	promptInput += m.textarea.View()
+
+	content := fmt.Sprintf("%s\n%s\n%s",
+		m.headerView(m.viewport.Width()),
+		zone.Mark("chatViewport", m.viewport.View()),
+		zone.Mark("promptInput", promptInput),
+	)
+	if m.sidebarShown() {
+		content = lipgloss.JoinHorizontal(lipgloss.Top, m.sidebarView(m.windowSize.Height), content)
+	}
 	m.contentBuilder.Reset()
-	m.contentBuilder.WriteString(
-		zone.Scan(
-			fmt.Sprintf("%s\n%s\n%s",
-				m.headerView(m.viewport.Width()),
-				zone.Mark("chatViewport", m.viewport.View()),
-				zone.Mark("promptInput", styles.VP_TA_SPACING+m.textarea.View()),
-			),
-		))
+	m.contentBuilder.WriteString(zone.Scan(content))
 	v.SetContent(m.contentBuilder.String())
 	return v
 }
@@ -640,14 +1021,33 @@ func (m *model) View() tea.View {
 // headerView returns the formatted header, reusing the last computed headerView result if the width hasn't changed and the spinner doesn't
 // need to be updated.
 func (m *model) headerView(width int) string {
-	var leftText string
-	if !m.isStreaming {
-		if width == m.lastWidth && !m.forceHeaderRefresh {
+	var leftText, title string
+	position := m.entryPosition()
+	if !m.stream.Active() {
+		if width == m.lastWidth && !m.forceHeaderRefresh && position == m.lastPosition {
 			return m.headerBuilder.String()
 		}
+		m.lastPosition = position
 		leftText = "ducky"
+		if position != "" {
+			leftText += "  " + position
+		}
+		title = leftText
+		if count := m.tokenCount; count != nil {
+			leftText += fmt.Sprintf("  prompt ~%s · request %s tokens",
+				models.FormatTokens(count.Prompt),
+				models.FormatTokens(count.Request),
+			)
+			if cost := models.FormatCost(models.EstimateInputCost(m.llm, count.Request)); cost != "" {
+				leftText += " (" + cost + ")"
+			}
+		}
 	} else {
 		leftText = m.spinner.View()
+		if status := m.retryStatus; status != nil {
+			leftText += fmt.Sprintf(" retrying in %s (attempt %d/%d)",
+				max(0, time.Until(status.At).Round(time.Second)), status.Attempt, status.MaxAttempts)
+		}
 	}
 	m.headerBuilder.Reset()
 	m.lastWidth = width
@@ -657,14 +1057,28 @@ func (m *model) headerView(width int) string {
 	}
 
 	rightText := models.GetModelId(m.llm)
-	if cost := models.GetCostOfCurrentChat(m.llm); cost != "" {
-		rightText += " (" + cost + ")"
+	if m.chatCost != "" {
+		rightText += " (" + m.chatCost + ")"
+	}
+	if m.contextUsage > 0 {
+		rightText += fmt.Sprintf(" %d%% ctx", int(m.contextUsage*100))
 	}
 	titleTextWidth := lipgloss.Width(leftText) +
 		lipgloss.Width(rightText) +
 		styles.H_PADDING*2 + // the left and right padding defined in TUIStyles.TitleBar
 		2 // the two border chars
 
+	// drop the token count, then the entry position, rather than wrapping the header onto a second line
+	if !m.stream.Active() {
+		for _, shorter := range []string{title, "ducky"} {
+			if titleTextWidth+5 <= width {
+				break
+			}
+			titleTextWidth -= lipgloss.Width(leftText) - lipgloss.Width(shorter)
+			leftText = shorter
+		}
+	}
+
 	// TODO: should we be using termWidth or viewportWidth?
 	width = max(0, width)
 	style := styles.TUIStyles.TitleBar.Width(width)
@@ -678,28 +1092,29 @@ func (m *model) headerView(width int) string {
 
 // InitLLMClient creates an LLM Client given a modelName. It is called at TUI init, and can be called any time later
 // in order to switch between LLMs while preserving message history.
-func InitLLMClient(modelName, systemPrompt string, maxTokens int) (newModel models.LLM) {
+func InitLLMClient(modelName, systemPrompt string, maxTokens int) (models.LLM, error) {
 	// var pastMessages []models.Message
 	// if t.model != nil {
 	// 	pastMessages = t.model.DoGetChatHistory()
 	// }
 
+	if mock.IsMockModel(modelName) {
+		llm, err := mock.NewModel(systemPrompt, maxTokens, modelName, nil)
+		if err != nil {
+			return nil, err //nolint:wrapcheck // already names the script
+		}
+		return llm, nil
+	}
+
 	anthropicErr := anthropic.ValidateModelName(modelName)
 	openAIErr := openai.ValidateModelName(modelName)
 
 	switch {
-	case anthropicErr != nil && openAIErr != nil:
-		newModel = nil
 	case anthropicErr != nil && openAIErr == nil:
-		newModel = openai.NewModel(systemPrompt, maxTokens, modelName, nil)
+		return openai.NewModel(systemPrompt, maxTokens, modelName, nil), nil
 	case openAIErr != nil && anthropicErr == nil:
-		newModel = anthropic.NewModel(systemPrompt, maxTokens, modelName, nil)
-	default:
-		// This shouldn't happen if validation functions are implemented correctly
-		newModel = nil
-	}
-	if newModel == nil {
-		panic(fmt.Sprintf("Error initializing model:\nantErr: %v\nopenAIerr: %v", anthropicErr, openAIErr))
+		return anthropic.NewModel(systemPrompt, maxTokens, modelName, nil), nil
 	}
-	return newModel
+	// the model is unknown, or both providers claim it if the validation functions are wrong
+	return nil, fmt.Errorf("error initializing model %s:\nantErr: %v\nopenAIerr: %v", modelName, anthropicErr, openAIErr)
 }
@@ -0,0 +1,856 @@
+package internal
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"image"
+	stdpng "image/png"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"charm.land/bubbles/v2/spinner"
+	tea "charm.land/bubbletea/v2"
+	"github.com/charmbracelet/x/ansi"
+	"github.com/gregriff/ducky/internal/models/mock"
+	"github.com/gregriff/ducky/internal/session"
+	"github.com/gregriff/ducky/internal/stream"
+	styles "github.com/gregriff/ducky/internal/styles"
+	zone "github.com/lrstanley/bubblezone/v2"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TUI tests with the current output")
+
+const settleTimeout = 10 * time.Second
+
+func TestMain(m *testing.M) {
+	zone.NewGlobal()
+	os.Exit(m.Run())
+}
+
+// harness drives the TUI model the way bubbletea's runtime does: each command runs in its own goroutine and the messages they
+// return are passed to Update one at a time. Spinner and cursor-blink ticks are dropped so that output is deterministic.
+type harness struct {
+	t *testing.T
+	m *model
+
+	msgs    chan tea.Msg
+	running atomic.Int32 // commands that have not returned a message yet
+	quit    bool
+
+	snapshots strings.Builder
+}
+
+// newHarness creates a TUI that uses the mock script testdata/mock/<script>.json, sized to width x height.
+func newHarness(t *testing.T, script string, width, height int, opts ...Option) *harness {
+	t.Helper()
+	effort := uint8(2)
+	m, err := NewTUI("", "mock:"+filepath.Join("testdata", "mock", script+".json"), true, &effort, 1024, "notty", opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// a static cursor doesn't need blink ticks
+	taStyles := m.textarea.Styles()
+	taStyles.Cursor.Blink = false
+	m.textarea.SetStyles(taStyles)
+
+	h := &harness{t: t, m: m, msgs: make(chan tea.Msg, 1024)}
+	h.run(m.Init())
+	h.send(tea.WindowSizeMsg{Width: width, Height: height})
+	return h
+}
+
+// run executes a command in the background, as bubbletea does.
+func (h *harness) run(cmd tea.Cmd) {
+	if cmd == nil {
+		return
+	}
+	h.running.Add(1)
+	go func() {
+		msg := cmd()
+		h.msgs <- msg
+		h.running.Add(-1)
+	}()
+}
+
+// handle passes a message to Update, and runs the command it returns.
+func (h *harness) handle(msg tea.Msg) {
+	switch msg := msg.(type) {
+	case nil, spinner.TickMsg:
+		return
+	case tea.BatchMsg:
+		for _, cmd := range msg {
+			h.run(cmd)
+		}
+		return
+	case tea.QuitMsg:
+		h.quit = true
+		return
+	}
+	if reflect.TypeOf(msg).PkgPath() == "charm.land/bubbles/v2/cursor" {
+		return
+	}
+	_, cmd := h.m.Update(msg)
+	h.run(cmd)
+}
+
+// send passes a message to Update, then processes messages until the TUI is idle.
+func (h *harness) send(msgs ...tea.Msg) {
+	h.t.Helper()
+	for _, msg := range msgs {
+		h.handle(msg)
+	}
+	h.settle()
+}
+
+// settle processes messages until no commands are running and no messages are queued.
+func (h *harness) settle() {
+	h.t.Helper()
+	h.runUntil(func(tea.Msg) bool { return false })
+}
+
+// runUntil processes messages until done returns true for a message that was just handled, or the TUI is idle.
+func (h *harness) runUntil(done func(tea.Msg) bool) {
+	h.t.Helper()
+	deadline := time.After(settleTimeout)
+	for {
+		select {
+		case msg := <-h.msgs:
+			h.handle(msg)
+			if done(msg) {
+				return
+			}
+		case <-deadline:
+			h.t.Fatalf("TUI did not settle within %v", settleTimeout)
+		case <-time.After(time.Millisecond):
+			if h.running.Load() == 0 && len(h.msgs) == 0 {
+				return
+			}
+		}
+	}
+}
+
+// runChunks processes messages until n more response chunks have been handled.
+func (h *harness) runChunks(n int) {
+	h.t.Helper()
+	h.runUntil(func(msg tea.Msg) bool {
+		if _, ok := msg.(stream.Chunk); ok {
+			n--
+		}
+		return n == 0
+	})
+}
+
+// typeText sends a key press for each rune of text.
+func (h *harness) typeText(text string) {
+	h.t.Helper()
+	for _, r := range text {
+		h.handle(tea.KeyPressMsg{Code: r, Text: string(r)})
+	}
+	h.settle()
+}
+
+// typeWhileStreaming sends a key press for each rune of text without waiting for the TUI to settle, which it doesn't until the
+// response completes.
+func (h *harness) typeWhileStreaming(text string) {
+	for _, r := range text {
+		h.handle(tea.KeyPressMsg{Code: r, Text: string(r)})
+	}
+}
+
+// key sends a special key press, such as tea.KeyEnter, optionally with modifiers.
+func (h *harness) key(code rune, mod ...tea.KeyMod) tea.KeyPressMsg {
+	msg := tea.KeyPressMsg{Code: code}
+	for _, m := range mod {
+		msg.Mod |= m
+	}
+	return msg
+}
+
+// snapshot records the current view under a heading.
+func (h *harness) snapshot(name string) {
+	h.m.View()
+	lines := strings.Split(ansi.Strip(h.m.contentBuilder.String()), "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " ")
+	}
+	fmt.Fprintf(&h.snapshots, "── %s (%dx%d) ──\n%s\n\n", name, h.m.windowSize.Width, h.m.windowSize.Height, strings.Join(lines, "\n"))
+}
+
+// assertGolden compares all snapshots with testdata/golden/<test name>.golden, or rewrites it when run with -update.
+func (h *harness) assertGolden() {
+	h.t.Helper()
+	path := filepath.Join("testdata", "golden", h.t.Name()+".golden")
+	got := h.snapshots.String()
+	if *update {
+		if err := os.WriteFile(path, []byte(got), 0o600); err != nil {
+			h.t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(path) //nolint:gosec // test fixture
+	if err != nil {
+		h.t.Fatalf("%v (run with -update to create it)", err)
+	}
+	if got != string(want) {
+		h.t.Errorf("view does not match %s (run with -update to accept the changes)\n\ngot:\n%s", path, got)
+	}
+}
+
+func TestPromptAndStream(t *testing.T) {
+	h := newHarness(t, "stream", 60, 24)
+	h.snapshot("empty chat")
+
+	h.typeText("Tell me about ducks")
+	h.snapshot("prompt typed")
+
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(3)
+	h.snapshot("reasoning streamed")
+
+	h.runChunks(12)
+	h.snapshot("response streaming")
+
+	h.settle()
+	if h.m.stream.Active() {
+		t.Fatal("still streaming after the response completed")
+	}
+	h.snapshot("response complete")
+
+	h.typeText("And geese?")
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("second response")
+
+	h.assertGolden()
+}
+
+func TestCancelAndClear(t *testing.T) {
+	h := newHarness(t, "hang", 60, 20)
+
+	h.typeText("Hi")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(1)
+	h.snapshot("streaming")
+
+	h.send(h.key('c', tea.ModCtrl))
+	if h.m.stream.Active() {
+		t.Fatal("still streaming after ctrl+c")
+	}
+	h.snapshot("cancelled")
+
+	h.send(h.key('c', tea.ModCtrl))
+	if h.m.chat.HistoryLen() != 0 || len(h.m.llm.DoGetChatHistory()) != 0 {
+		t.Fatal("ctrl+c did not clear the chat")
+	}
+	h.snapshot("cleared")
+
+	h.send(h.key('c', tea.ModCtrl))
+	if !h.quit {
+		t.Fatal("ctrl+c on an empty chat did not quit")
+	}
+
+	h.assertGolden()
+}
+
+func TestCancelThenPrompt(t *testing.T) {
+	h := newHarness(t, "hang", 60, 20)
+
+	h.typeText("Hi")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(1)
+	h.send(h.key('c', tea.ModCtrl))
+
+	h.typeText("Again")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(1)
+	if !h.m.stream.Active() {
+		t.Fatal("second prompt is not streaming")
+	}
+	h.snapshot("second prompt streaming")
+
+	h.send(h.key('c', tea.ModCtrl))
+	h.snapshot("second prompt cancelled")
+
+	h.assertGolden()
+}
+
+func TestQueue(t *testing.T) {
+	h := newHarness(t, "stream", 60, 30)
+
+	h.typeText("Tell me about ducks")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(3)
+
+	h.typeWhileStreaming("And geese?")
+	h.handle(h.key(tea.KeyEnter))
+	h.typeWhileStreaming("And swans?")
+	h.handle(h.key(tea.KeyEnter))
+	h.typeWhileStreaming("And gulls?")
+	h.handle(h.key(tea.KeyEnter))
+	if h.m.chat.QueueLen() != 3 || h.m.textarea.Value() != "" {
+		t.Fatalf("queued %d prompts, textarea = %q", h.m.chat.QueueLen(), h.m.textarea.Value())
+	}
+	h.snapshot("prompts queued")
+
+	h.handle(h.key('x', tea.ModCtrl))
+	h.handle(h.key('o', tea.ModCtrl))
+	if got := h.m.textarea.Value(); got != "And swans?" || h.m.chat.QueueLen() != 1 {
+		t.Fatalf("edited %q, %d still queued", got, h.m.chat.QueueLen())
+	}
+	h.typeWhileStreaming(" And pelicans?")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(2)
+	h.snapshot("queue edited")
+
+	h.settle()
+	if h.m.stream.Active() || h.m.chat.QueueLen() != 0 || h.m.chat.HistoryLen() != 3 {
+		t.Fatalf("queue not sent: %d queued, %d entries", h.m.chat.QueueLen(), h.m.chat.HistoryLen())
+	}
+	h.snapshot("queue sent")
+
+	h.assertGolden()
+}
+
+func TestCancelClearsQueue(t *testing.T) {
+	h := newHarness(t, "hang", 60, 20)
+
+	h.typeText("Hi")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(1)
+	h.typeWhileStreaming("Queued")
+	h.handle(h.key(tea.KeyEnter))
+
+	h.send(h.key('c', tea.ModCtrl))
+	if h.m.stream.Active() || h.m.chat.QueueLen() != 0 || h.m.chat.HistoryLen() != 1 {
+		t.Fatalf("after ctrl+c: active = %v, %d queued, %d entries", h.m.stream.Active(), h.m.chat.QueueLen(), h.m.chat.HistoryLen())
+	}
+}
+
+func TestContinue(t *testing.T) {
+	h := newHarness(t, "max_tokens", 60, 20)
+
+	h.typeText("Tell me about ducks")
+	h.send(h.key(tea.KeyEnter))
+	if !h.m.chat.CanContinue() {
+		t.Fatal("response cut off by max_tokens can't be continued")
+	}
+	h.snapshot("cut off")
+
+	h.send(h.key('g', tea.ModCtrl))
+	if h.m.chat.CanContinue() || h.m.chat.HistoryLen() != 1 {
+		t.Fatalf("after continuing: %d entries, can continue = %v", h.m.chat.HistoryLen(), h.m.chat.CanContinue())
+	}
+	history := h.m.llm.DoGetChatHistory()
+	if len(history) != 2 || !strings.HasSuffix(history[1].Content, "of their brain stays alert for predators.\n") {
+		t.Errorf("history = %+v", history)
+	}
+	h.snapshot("continued")
+
+	h.assertGolden()
+}
+
+func TestStreamError(t *testing.T) {
+	h := newHarness(t, "error", 60, 20)
+
+	h.typeText("Hi")
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("error rendered")
+
+	h.assertGolden()
+}
+
+func TestAuthError(t *testing.T) {
+	h := newHarness(t, "auth", 60, 20)
+
+	h.typeText("Hi")
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("guidance rendered")
+
+	h.assertGolden()
+}
+
+func TestRetry(t *testing.T) {
+	h := newHarness(t, "retry", 60, 20)
+
+	h.typeText("Hi")
+	h.handle(h.key(tea.KeyEnter))
+	h.runUntil(func(msg tea.Msg) bool {
+		_, ok := msg.(stream.Retry)
+		return ok
+	})
+	h.runUntil(func(msg tea.Msg) bool { // the redraw for the taller header
+		_, ok := msg.(tea.WindowSizeMsg)
+		return ok
+	})
+	h.snapshot("waiting to retry")
+
+	h.settle()
+	if h.m.retryStatus != nil {
+		t.Error("retry countdown still shown after the response completed")
+	}
+	h.snapshot("retried")
+
+	h.assertGolden()
+}
+
+func TestScrollback(t *testing.T) {
+	h := newHarness(t, "stream", 60, 24)
+
+	h.typeText("first prompt")
+	h.send(h.key(tea.KeyEnter))
+	h.typeText("second prompt")
+	h.send(h.key(tea.KeyEnter))
+
+	h.send(h.key(tea.KeyUp))
+	if got := h.m.textarea.Value(); got != "second prompt" {
+		t.Errorf("up recalled %q", got)
+	}
+	h.send(h.key(tea.KeyUp))
+	if got := h.m.textarea.Value(); got != "first prompt" {
+		t.Errorf("up recalled %q", got)
+	}
+	h.snapshot("first prompt recalled")
+
+	h.send(h.key(tea.KeyDown))
+	if got := h.m.textarea.Value(); got != "second prompt" {
+		t.Errorf("down recalled %q", got)
+	}
+
+	h.send(tea.MouseWheelMsg{Button: tea.MouseWheelUp}, tea.MouseWheelMsg{Button: tea.MouseWheelUp})
+	h.snapshot("scrolled up")
+
+	h.assertGolden()
+}
+
+func TestTextareaResize(t *testing.T) {
+	h := newHarness(t, "stream", 40, 24)
+	h.snapshot("collapsed")
+
+	h.typeText("a")
+	h.snapshot("expanded")
+
+	h.typeText(strings.Repeat("long words wrap ", 12))
+	h.snapshot("grown to fit wrapped text")
+
+	h.send(tea.PasteMsg{Content: "pasted\ntext"})
+	h.snapshot("pasted")
+
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("collapsed after submit")
+
+	h.assertGolden()
+}
+
+func TestWindowResizeMidStream(t *testing.T) {
+	h := newHarness(t, "stream", 80, 24)
+
+	h.typeText("Tell me about ducks")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(15)
+	h.snapshot("streaming")
+
+	h.handle(tea.WindowSizeMsg{Width: 40, Height: 30})
+	h.runChunks(2)
+	h.snapshot("narrowed mid-stream")
+
+	h.settle()
+	h.snapshot("complete after resize")
+
+	h.send(tea.WindowSizeMsg{Width: 100, Height: 20})
+	h.snapshot("widened")
+
+	h.assertGolden()
+}
+
+func TestMention(t *testing.T) {
+	dir := t.TempDir()
+	for name, content := range map[string]string{
+		".gitignore":  "*.log\n",
+		"main.go":     "package main\n\nfunc main() {}\n",
+		"go.mod":      "module example\n",
+		"debug.log":   "ignored\n",
+		"pkg/util.go": "package pkg\n",
+	} {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	h := newHarness(t, "stream", 60, 24)
+	h.m.workDir = dir
+
+	h.typeText("Explain @")
+	h.snapshot("completer open")
+
+	h.typeText("debug")
+	if h.m.completer == nil || len(h.m.completer.matches) != 0 {
+		t.Fatalf("ignored file was suggested: %+v", h.m.completer)
+	}
+	for range len("debug") {
+		h.handle(h.key(tea.KeyBackspace))
+	}
+	h.typeText("ma")
+	h.send(h.key(tea.KeyTab))
+	h.typeText("@util")
+	h.send(h.key(tea.KeyEnter))
+	if got := h.m.textarea.Value(); got != "Explain " || len(h.m.attachments) != 2 {
+		t.Fatalf("textarea = %q, %d attachments", got, len(h.m.attachments))
+	}
+	h.typeText("these files")
+	h.snapshot("files attached")
+
+	h.send(h.key(tea.KeyEnter))
+	sent := h.m.llm.DoGetChatHistory()[0].Content
+	if !strings.Contains(sent, "`main.go`:\n```go\n") || !strings.Contains(sent, "`pkg/util.go`:\n```go\n") ||
+		!strings.HasSuffix(sent, "Explain these files") {
+		t.Fatalf("sent %q", sent)
+	}
+	if len(h.m.attachments) != 0 {
+		t.Fatal("attachments were not cleared after sending")
+	}
+	h.snapshot("response complete")
+
+	h.assertGolden()
+}
+
+func TestDropImage(t *testing.T) {
+	dir := t.TempDir()
+	var png bytes.Buffer
+	if err := stdpng.Encode(&png, image.NewGray(image.Rect(0, 0, 100, 75))); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "cat.png"), png.Bytes(), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "my notes.txt"), []byte("meow\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, "stream", 60, 20)
+	h.m.workDir = dir
+
+	h.send(tea.PasteMsg{Content: "'" + filepath.Join(dir, "cat.png") + "'"})
+	if len(h.m.attachments) != 0 {
+		t.Fatal("image attached to a model without vision")
+	}
+	h.snapshot("vision unsupported")
+
+	h.m.llm.(*mock.Model).Script.Vision = true
+	h.send(tea.PasteMsg{Content: filepath.Join(dir, "cat.png") + " " + strings.ReplaceAll(filepath.Join(dir, "my notes.txt"), " ", `\ `)})
+	h.typeText("What is this?")
+	h.snapshot("files dropped")
+
+	h.send(h.key(tea.KeyEnter))
+	sent := h.m.llm.DoGetChatHistory()[0]
+	if len(sent.Parts) != 1 || sent.Parts[0].MediaType != "image/png" || !strings.HasPrefix(sent.Content, "`my notes.txt`:\n") {
+		t.Fatalf("sent %+v", sent)
+	}
+	h.snapshot("response complete")
+
+	h.assertGolden()
+}
+
+func TestPaste(t *testing.T) {
+	h := newHarness(t, "stream", 60, 20)
+
+	h.send(tea.PasteMsg{Content: "\x1b[1mSELECT\x1b[0m *\r\nFROM ducks;"})
+	if got := h.m.textarea.Value(); got != "SELECT *\nFROM ducks;" {
+		t.Fatalf("small paste = %q", got)
+	}
+	h.m.textarea.Reset()
+
+	// ctrl+v pastes the clipboard's text if it has no image, like the textarea's own paste key
+	h.send(clipboardImage{text: "\x1b[1mSELECT\x1b[0m *\r\nFROM ducks;"})
+	if got := h.m.textarea.Value(); got != "SELECT *\nFROM ducks;" || len(h.m.attachments) != 0 || h.m.attachError != "" {
+		t.Fatalf("clipboard text paste = %q, attachments %v, error %q", got, h.m.attachments, h.m.attachError)
+	}
+	h.m.textarea.Reset()
+
+	var query strings.Builder
+	for i := range 420 {
+		fmt.Fprintf(&query, "INSERT INTO ducks VALUES (%d, 'mallard');\r\n", i)
+	}
+	h.send(tea.PasteMsg{Content: query.String()})
+	h.typeText(" Why is this slow?")
+	if got := h.m.textarea.Value(); got != "[pasted 420 lines] Why is this slow?" {
+		t.Fatalf("large paste = %q", got)
+	}
+	h.snapshot("large paste collapsed")
+
+	h.send(h.key(tea.KeyEnter))
+	sent := h.m.llm.DoGetChatHistory()[0].Content
+	if want := strings.ReplaceAll(query.String(), "\r\n", "\n") + " Why is this slow?"; sent != want {
+		t.Fatalf("sent %d bytes, want %d", len(sent), len(want))
+	}
+	if h.m.textarea.Value() != "" || len(h.m.pastes) != 0 {
+		t.Fatalf("textarea = %q, pastes = %d", h.m.textarea.Value(), len(h.m.pastes))
+	}
+
+	h.send(h.key(tea.KeyUp)) // the whole prompt is collapsed, as it is too large for the textarea
+	if got := h.m.textarea.Value(); got != "[pasted 421 lines]" || h.m.expandPastes(got) != sent {
+		t.Fatalf("scrollback = %q", got)
+	}
+	h.snapshot("scrollback collapsed")
+
+	h.assertGolden()
+}
+
+func TestExport(t *testing.T) {
+	dir := t.TempDir()
+	h := newHarness(t, "stream", 60, 20)
+	h.m.workDir = dir
+	h.m.sessionStore = &session.Store{Dir: filepath.Join(dir, "sessions")}
+
+	h.typeText("/export")
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("nothing to export")
+
+	h.typeText("Tell me about ducks")
+	h.send(h.key(tea.KeyEnter))
+	saved, err := h.m.sessionStore.Load(session.LastRef)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Entries) != 1 || !strings.HasPrefix(saved.Entries[0].Response, "## Ducks") || saved.Model != h.m.modelName {
+		t.Fatalf("saved %+v", saved)
+	}
+
+	h.typeText("/export html notes.html")
+	h.send(h.key(tea.KeyEnter))
+	page, err := os.ReadFile(filepath.Join(dir, "notes.html"))
+	if err != nil || !strings.Contains(string(page), "<h2>Ducks</h2>") {
+		t.Fatalf("exported %s, %v", page, err)
+	}
+	if h.m.chat.HistoryLen() != 1 {
+		t.Fatal("the command was sent to the model")
+	}
+	h.snapshot("exported")
+
+	h.assertGolden()
+}
+
+func TestResume(t *testing.T) {
+	imported, err := session.Import(filepath.Join("session", "testdata", "claude.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, "stream", 60, 24, WithSession(imported[0]))
+	h.m.sessionStore = &session.Store{Dir: t.TempDir()}
+	h.snapshot("resumed")
+
+	h.typeText("Tell me about ducks")
+	h.send(h.key(tea.KeyEnter))
+	if history := h.m.llm.DoGetChatHistory(); len(history) != 6 || history[1].Content != "It sets the default model to sonnet." {
+		t.Fatalf("history = %+v", history)
+	}
+	saved, err := h.m.sessionStore.Load(imported[0].ID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(saved.Entries) != 4 || saved.Source != imported[0].Source || saved.Model != h.m.modelName {
+		t.Fatalf("saved %+v", saved)
+	}
+	h.snapshot("continued")
+
+	h.assertGolden()
+}
+
+func TestSearch(t *testing.T) {
+	h := newHarness(t, "stream", 60, 14)
+	h.m.sessionStore = &session.Store{Dir: t.TempDir()}
+	for _, name := range []string{"chatgpt", "claude"} {
+		imported, err := session.Import(filepath.Join("session", "testdata", name+".json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := h.m.sessionStore.Save(imported[0]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	h.typeText("/search nothing")
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("no results")
+
+	h.typeText("/search -r the")
+	h.send(h.key(tea.KeyEnter))
+	if h.m.search == nil || len(h.m.search.results) != 2 {
+		t.Fatalf("search = %+v", h.m.search)
+	}
+	h.send(h.key(tea.KeyDown))
+	h.snapshot("results")
+	h.send(h.key(tea.KeyEscape))
+
+	// the second entry of the ChatGPT conversation matches, so the chat is scrolled to it
+	h.typeText("/search trace")
+	h.send(h.key(tea.KeyEnter))
+	h.send(h.key(tea.KeyEnter))
+	if h.m.search != nil || h.m.chat.HistoryLen() != 3 || h.m.session.Source != "chatgpt:6712ab34-0000-8000-9000-chatgpt00001" {
+		t.Fatalf("opened %+v", h.m.session)
+	}
+	h.snapshot("opened")
+
+	h.assertGolden()
+}
+
+func TestFind(t *testing.T) {
+	h := newHarness(t, "stream", 60, 16)
+	h.typeText("Tell me about ducks")
+	h.send(h.key(tea.KeyEnter))
+	h.typeText("And more ducks?")
+	h.send(h.key(tea.KeyEnter))
+
+	// ctrl+f is left to the textarea, which moves the cursor with it
+	h.send(h.key('f', tea.ModCtrl))
+	if h.m.find != nil {
+		t.Fatal("ctrl+f opened the find bar")
+	}
+	h.send(h.key('s', tea.ModCtrl))
+	h.typeText("DUCK")
+	matches := h.m.chat.FindMatches()
+	if len(matches) != 6 {
+		t.Fatalf("found %d matches, want 6: %+v", len(matches), matches)
+	}
+	h.snapshot("typed")
+
+	// n and N jump between the matches once the query is entered, scrolling to those out of view
+	h.send(h.key(tea.KeyEnter))
+	for range 3 {
+		h.send(h.key('n'))
+	}
+	selected := matches[h.m.chat.SelectedMatch()]
+	if top := h.m.viewport.YOffset(); selected.Line < top || selected.Line >= top+h.m.viewport.Height() {
+		t.Errorf("match on line %d is out of view at offset %d", selected.Line, top)
+	}
+	h.snapshot("next")
+	h.send(h.key('N', tea.ModShift))
+	if h.m.chat.SelectedMatch() != 1 || h.m.textarea.Value() != "" {
+		t.Errorf("selected match %d, textarea %q", h.m.chat.SelectedMatch(), h.m.textarea.Value())
+	}
+
+	h.send(h.key(tea.KeyEscape))
+	if h.m.find != nil || len(h.m.chat.FindMatches()) != 0 || !h.m.textarea.Focused() {
+		t.Error("esc didn't close the find bar")
+	}
+	h.assertGolden()
+}
+
+func TestJump(t *testing.T) {
+	h := newHarness(t, "stream", 80, 16)
+	for _, prompt := range []string{"Tell me about ducks", "And more ducks?", "One last thing"} {
+		h.typeText(prompt)
+		h.send(h.key(tea.KeyEnter))
+	}
+
+	line := func(get func(int) (int, bool), i int) int {
+		t.Helper()
+		l, ok := get(i)
+		if !ok {
+			t.Fatalf("no line for entry %d", i)
+		}
+		return l
+	}
+
+	// from the bottom, alt+up goes to the start of the last prompt, then to the ones before it
+	h.send(h.key(tea.KeyUp, tea.ModAlt))
+	if got, want := h.m.viewport.YOffset(), line(h.m.chat.EntryLine, 2); got != want {
+		t.Errorf("offset %d, want the last prompt at %d", got, want)
+	}
+	h.send(h.key(tea.KeyUp, tea.ModAlt))
+	if got, want := h.m.viewport.YOffset(), line(h.m.chat.EntryLine, 1); got != want {
+		t.Errorf("offset %d, want the second prompt at %d", got, want)
+	}
+	h.snapshot("previous prompt")
+
+	h.send(h.key('r', tea.ModAlt))
+	if got, want := h.m.viewport.YOffset(), line(h.m.chat.ResponseLine, 1); got != want {
+		t.Errorf("offset %d, want the second response at %d", got, want)
+	}
+	h.snapshot("response")
+
+	h.send(h.key(tea.KeyDown, tea.ModAlt))
+	if got, want := h.m.viewport.YOffset(), line(h.m.chat.EntryLine, 2); got != want {
+		t.Errorf("offset %d, want the last prompt at %d", got, want)
+	}
+	h.send(h.key('g', tea.ModAlt))
+	if h.m.viewport.YOffset() != 0 || h.m.entryPosition() != "entry 1/3" {
+		t.Errorf("offset %d at %q, want the top", h.m.viewport.YOffset(), h.m.entryPosition())
+	}
+	h.send(h.key('g', tea.ModAlt, tea.ModShift))
+	if !h.m.viewport.AtBottom() || h.m.entryPosition() != "entry 3/3" {
+		t.Errorf("at %q, want the bottom", h.m.entryPosition())
+	}
+	h.assertGolden()
+}
+
+func TestSidebar(t *testing.T) {
+	store := &session.Store{Dir: t.TempDir()}
+	imported, err := session.Import(filepath.Join("session", "testdata", "claude.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Save(imported[0]); err != nil {
+		t.Fatal(err)
+	}
+	h := newHarness(t, "stream", 90, 20, WithSessionStore(store), WithSidebar())
+	if h.m.viewport.Width() != 90-styles.SIDEBAR_WIDTH {
+		t.Fatalf("viewport width %d next to the sidebar", h.m.viewport.Width())
+	}
+
+	// the first chat keeps streaming in the background while a new one is started
+	h.typeText("Tell me about ducks")
+	h.handle(h.key(tea.KeyEnter))
+	h.runChunks(3)
+	first := h.m.conversation
+	h.handle(h.key('n', tea.ModAlt))
+	if h.m.conversation == first || !first.stream.Active() {
+		t.Fatal("the new chat isn't shown, or the first one stopped streaming")
+	}
+	h.snapshot("new chat")
+
+	h.typeText("And geese?")
+	if first.stream.Active() || !first.unseen || first.chat.HistoryLen() != 1 {
+		t.Fatal("the first chat's response didn't complete in the background")
+	}
+	if response, _, _ := first.chat.LastResponse(); !strings.HasSuffix(response, "That is all there is to know about ducks.\n") {
+		t.Errorf("response completed in the background = %q", response)
+	}
+	h.send(h.key(tea.KeyEnter))
+	h.snapshot("finished in the background")
+
+	h.send(h.key('1', tea.ModAlt))
+	if h.m.conversation != first || first.unseen {
+		t.Fatal("alt+1 didn't show the first chat")
+	}
+	h.snapshot("switched back")
+
+	// a recent session is resumed in a new chat, and closing it shows the next one
+	h.send(h.key('3', tea.ModAlt))
+	if len(h.m.conversations) != 3 || h.m.session == nil || h.m.session.ID != imported[0].ID {
+		t.Fatalf("alt+3 didn't open the saved session, %d chats are open", len(h.m.conversations))
+	}
+	h.send(h.key('w', tea.ModAlt))
+	if len(h.m.conversations) != 2 {
+		t.Fatalf("%d chats are open after closing one", len(h.m.conversations))
+	}
+	for _, c := range h.m.conversations {
+		saved, err := store.Load(c.session.ID)
+		if err != nil || len(saved.Entries) != 1 {
+			t.Errorf("session of %q wasn't saved: %v", c.title(), err)
+		}
+	}
+
+	h.send(h.key('s', tea.ModAlt))
+	if h.m.viewport.Width() != 90 {
+		t.Errorf("viewport width %d without the sidebar", h.m.viewport.Width())
+	}
+	h.assertGolden()
+}